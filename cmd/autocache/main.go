@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"autocache/internal/config"
+	"autocache/internal/history"
 	"autocache/internal/server"
 
 	"github.com/sirupsen/logrus"
@@ -40,8 +41,14 @@ func main() {
 	// Print configuration
 	cfg.PrintConfig(logger)
 
+	// Open savings history store
+	store, err := history.NewStore(cfg)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to open savings history store")
+	}
+
 	// Create handler
-	handler := server.NewAutocacheHandler(cfg, logger)
+	handler := server.NewAutocacheHandlerWithStore(cfg, logger, store)
 
 	// Setup routes
 	mux := handler.SetupRoutes()
@@ -82,6 +89,10 @@ func main() {
 
 	// Wait for interrupt signal to gracefully shutdown
 	waitForShutdown(httpServer, logger)
+
+	if err := store.Close(); err != nil {
+		logger.WithError(err).Error("Failed to close savings history store")
+	}
 }
 
 // printStartupBanner prints the startup banner
@@ -147,14 +158,38 @@ ENVIRONMENT VARIABLES:
     PORT                     Server port (default: 8080)
     HOST                     Server host (default: 0.0.0.0)
     ANTHROPIC_API_KEY        Your Anthropic API key
+    AUTH_MODE                How credentials are sent upstream: auto|api-key|bearer (default: auto)
     ANTHROPIC_API_URL        Anthropic API URL (default: https://api.anthropic.com)
-    CACHE_STRATEGY           Cache strategy: conservative|moderate|aggressive (default: moderate)
+    ANTHROPIC_VERSION        anthropic-version sent when the client sends none (default: 2023-06-01)
+    UPSTREAM_DIALECT         Upstream request format: anthropic|bedrock|vertex (default: anthropic)
+    AWS_REGION               Bedrock region (default: us-east-1)
+    AWS_ACCESS_KEY_ID        AWS credentials Bedrock requests are signed with
+    AWS_SECRET_ACCESS_KEY    AWS credentials Bedrock requests are signed with
+    AWS_SESSION_TOKEN        Session token of temporary AWS credentials
+    VERTEX_PROJECT_ID        Google Cloud project of Vertex AI requests
+    VERTEX_REGION            Vertex AI region, or global (default: us-east5)
+    VERTEX_ACCESS_TOKEN      Google access token for Vertex AI (default: the client's bearer token)
+    UPSTREAM_MAX_RETRIES     Retries of failed upstream calls, 0 disables retries (default: 2)
+    UPSTREAM_RETRY_BASE_DELAY  Backoff before the first retry, doubled on every retry (default: 500ms)
+    UPSTREAM_RETRY_MAX_DELAY   Longest wait before a retry (default: 30s)
+    CIRCUIT_BREAKER_THRESHOLD  Consecutive upstream failures that open the circuit breaker, 0 disables it (default: 5)
+    CIRCUIT_BREAKER_COOLDOWN   Time the circuit breaker stays open (default: 30s)
+    CACHE_STRATEGY           Cache strategy: conservative|moderate|aggressive|conversation or a custom name (default: moderate)
+    STRATEGIES_FILE          YAML or JSON file defining custom strategies
+    CLIENT_CACHE_CONTROL     Existing cache_control markers: respect|augment|override (default: augment)
     LOG_LEVEL                Log level: trace|debug|info|warn|error (default: info)
     LOG_JSON                 Use JSON logging: true|false (default: false)
     ENABLE_METRICS           Enable metrics endpoint: true|false (default: true)
     ENABLE_DETAILED_ROI      Enable detailed ROI calculation: true|false (default: true)
-    MAX_CACHE_BREAKPOINTS    Maximum cache breakpoints: 1-4 (default: 4)
-    TOKEN_MULTIPLIER         Token count multiplier for caching threshold (default: 1.0)
+    MAX_CACHE_BREAKPOINTS    Cap on the strategy's cache breakpoints: 1-4 (default: 4)
+    TOKEN_MULTIPLIER         Multiplier applied to the strategy's caching threshold (default: 1.0)
+    PREFIX_TRACKING          Track prefixes across requests to predict cache hits: true|false (default: true)
+    TTL_COLD_START           TTL until a prefix's request timing is known: strategy|heuristic|5m|1h (default: strategy)
+    COUNT_TOKENS_MODE        How /v1/messages/count_tokens is answered: upstream|local|compare (default: upstream)
+    SAVINGS_HISTORY_SIZE     Requests kept for /savings, 0 disables history (default: 100; no cap for a file store with a retention)
+    SAVINGS_STORE            Savings history store: memory|file (default: memory)
+    SAVINGS_STORE_PATH       History file for the file store (default: data/savings-history.jsonl)
+    SAVINGS_RETENTION        Maximum age of history entries, e.g. 720h (default: 0, no age limit)
 
 EXAMPLES:
     # Start with default configuration
@@ -169,7 +204,8 @@ EXAMPLES:
 ENDPOINTS:
     POST /v1/messages    Main API endpoint (drop-in replacement for Anthropic API)
     GET  /health         Health check endpoint
-    GET  /metrics        Metrics and configuration endpoint
+    GET  /metrics        Prometheus metrics (JSON summary with ?format=json or /metrics/json)
+    GET  /savings        Savings analytics (?since=, ?until=, ?limit=)
 
 CACHE HEADERS:
     The proxy adds these headers to responses with cache information:
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"time"
+
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// InjectBatchWithStrategy injects cache control into every item of a Message Batches request.
+// Items of the same model with the same tools and system prompt get identical markers on that
+// shared head, planned once per group, so they write and read the same cache entries however
+// Anthropic schedules them; the rest of each item is planned on its own in the slots left.
+// Items that carry their own markers are handled like single requests. ROI figures include the
+// batch discount.
+func (ci *CacheInjector) InjectBatchWithStrategy(items []*types.AnthropicRequest, strategy types.CacheStrategy, strategyConfig types.StrategyConfig) ([]*types.CacheMetadata, error) {
+	scoped := *ci
+	scoped.strategy = strategy
+	scoped.strategyConfig = strategyConfig
+
+	// Group items by head, in the order the heads first appear
+	var heads []string
+	groups := make(map[string][]int)
+	for i, item := range items {
+		if len(ci.ExistingBreakpoints(item)) > 0 {
+			continue
+		}
+		if head := headFingerprint(item); head != "" {
+			if _, seen := groups[head]; !seen {
+				heads = append(heads, head)
+			}
+			groups[head] = append(groups[head], i)
+		}
+	}
+
+	metadata := make([]*types.CacheMetadata, len(items))
+	for _, head := range heads {
+		members := groups[head]
+		if len(members) < 2 {
+			continue
+		}
+
+		shared, err := scoped.planSharedHead(items[members[0]])
+		if err != nil {
+			return nil, err
+		}
+		if len(shared) == 0 {
+			continue
+		}
+
+		for _, i := range members {
+			metadata[i], err = scoped.injectWithHead(items[i], shared)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ci.logger.WithFields(logrus.Fields{
+			"items":       len(members),
+			"breakpoints": formatPositions(shared),
+		}).Debug("Placed shared head breakpoints across batch items")
+	}
+
+	for i, item := range items {
+		if metadata[i] != nil {
+			continue
+		}
+		var err error
+		if metadata[i], err = scoped.InjectCacheControl(item); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, item := range items {
+		metadata[i].ROI, _ = ci.pricing.CalculateBatchROI(item.Model, metadata[i].TotalTokens, metadata[i].CachedTokens, metadata[i].Breakpoints)
+	}
+
+	return metadata, nil
+}
+
+// planSharedHead places breakpoints on the tools and system prompt of a request alone,
+// keeping a slot for each item's own content, and returns them. The request is not modified,
+// and the plan is not recorded as a sighting of the prefixes.
+func (ci *CacheInjector) planSharedHead(req *types.AnthropicRequest) ([]types.CacheBreakpoint, error) {
+	head := &types.AnthropicRequest{
+		Model:        req.Model,
+		System:       req.System,
+		SystemBlocks: append([]types.ContentBlock(nil), req.SystemBlocks...),
+		Tools:        append([]types.ToolDefinition(nil), req.Tools...),
+	}
+
+	planner := *ci
+	planner.prefixes = nil
+	if planner.strategyConfig.MaxBreakpoints > 1 {
+		planner.strategyConfig.MaxBreakpoints--
+	}
+
+	metadata, err := planner.InjectCacheControl(head)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.Breakpoints, nil
+}
+
+// injectWithHead marks the head breakpoints on req and plans the rest of it around them. The
+// breakpoints planned on the rest only cover the tokens after the last head marker, so the
+// head is counted once.
+func (ci *CacheInjector) injectWithHead(req *types.AnthropicRequest, head []types.CacheBreakpoint) (*types.CacheMetadata, error) {
+	startTime := time.Now()
+	existing := ci.ExistingBreakpoints(req)
+	for _, bp := range head {
+		cacheControl := &types.CacheControl{Type: "ephemeral", TTL: bp.TTL}
+		switch bp.Position {
+		case "tools":
+			ci.applyCacheControlToContent(&req.Tools, cacheControl)
+		case "system", "system_blocks":
+			ci.applyCacheControlToContent(req, cacheControl)
+		}
+	}
+
+	// The head markers are ours: keep them like client markers, whatever the client mode, so
+	// the rest is segmented from them
+	planner := *ci
+	planner.clientMode = types.ClientCacheControlAugment
+	metadata, err := planner.InjectCacheControl(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if ci.prefixes != nil {
+		fingerprints := prefixFingerprints(req)
+		for _, bp := range head {
+			position := bp.Position
+			if position == "system" {
+				position = "system_blocks" // The string system prompt was converted to carry the marker
+			}
+			ci.prefixes.RecordBreakpoint(fingerprints[position], ttlDuration(bp.TTL))
+		}
+	}
+
+	breakpoints := append(append([]types.CacheBreakpoint(nil), head...), metadata.Breakpoints...)
+	combined := ci.calculateMetadata(req, breakpoints, startTime)
+	combined.SkippedBreakpoints = metadata.SkippedBreakpoints
+	combined.PlacementDecisions = metadata.PlacementDecisions
+	if len(existing) > 0 {
+		combined.ExistingBreakpoints = existing
+		combined.ClientCacheControl = string(ci.clientMode)
+	}
+	return combined, nil
+}
+
+// headFingerprint fingerprints the model, tools and system prompt of a request, or returns ""
+// when it has neither tools nor a system prompt
+func headFingerprint(req *types.AnthropicRequest) string {
+	fingerprints := prefixFingerprints(&types.AnthropicRequest{
+		Model:        req.Model,
+		System:       req.System,
+		SystemBlocks: req.SystemBlocks,
+		Tools:        req.Tools,
+	})
+	for _, position := range []string{"system_blocks", "system", "tools"} {
+		if fingerprint, ok := fingerprints[position]; ok {
+			return fingerprint
+		}
+	}
+	return ""
+}
+
+// formatPositions lists the positions of breakpoints, for logging
+func formatPositions(breakpoints []types.CacheBreakpoint) []string {
+	positions := make([]string, len(breakpoints))
+	for i, bp := range breakpoints {
+		positions[i] = bp.Position
+	}
+	return positions
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestInjectBatchSharedHead(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	system := strings.Repeat("You grade answers against a long rubric. ", 300)
+	tools := []types.ToolDefinition{{Name: "lookup", Description: strings.Repeat("Looks up reference answers. ", 200)}}
+	item := func(system, question string) *types.AnthropicRequest {
+		return &types.AnthropicRequest{
+			Model:     "claude-3-5-sonnet-20241022",
+			MaxTokens: 100,
+			System:    system,
+			Tools:     append([]types.ToolDefinition(nil), tools...),
+			Messages:  []types.Message{{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: question}}}},
+		}
+	}
+
+	items := []*types.AnthropicRequest{
+		item(system, "Short question"),
+		// A long item whose own content is worth a breakpoint
+		item(system, strings.Repeat("A long transcript to grade. ", 400)),
+		item(system, "Another short question"),
+		item("A different, short system prompt", "Unrelated"),
+	}
+	items[3].Tools = nil
+	marked := item(system, "Client marked")
+	marked.Messages[0].Content[0].CacheControl = &types.CacheControl{Type: "ephemeral"}
+	items = append(items, marked)
+
+	for _, mode := range []types.ClientCacheControlMode{types.ClientCacheControlAugment, types.ClientCacheControlRespect, types.ClientCacheControlOverride} {
+		t.Run(string(mode), func(t *testing.T) {
+			injector := NewCacheInjector(types.StrategyModerate, "", "", logger)
+			injector.clientMode = mode
+
+			batch := make([]*types.AnthropicRequest, len(items))
+			for i, req := range items {
+				data, _ := json.Marshal(req)
+				batch[i] = &types.AnthropicRequest{}
+				if err := json.Unmarshal(data, batch[i]); err != nil {
+					t.Fatalf("Failed to copy item %d: %v", i, err)
+				}
+			}
+
+			metadata, err := injector.InjectBatchWithStrategy(batch, types.StrategyModerate, injector.GetStrategyConfig())
+			if err != nil {
+				t.Fatalf("InjectBatchWithStrategy failed: %v", err)
+			}
+			if len(metadata) != len(batch) {
+				t.Fatalf("Expected metadata for %d items, got %d", len(batch), len(metadata))
+			}
+
+			// The items sharing tools and system prompt carry identical markers on them
+			head := func(req *types.AnthropicRequest) string {
+				data, _ := json.Marshal(struct {
+					Tools  []types.ToolDefinition `json:"tools"`
+					System []types.ContentBlock   `json:"system"`
+				}{req.Tools, req.SystemBlocks})
+				return string(data)
+			}
+			if head(batch[0]) != head(batch[1]) || head(batch[0]) != head(batch[2]) {
+				t.Errorf("Expected identical head markers across items:\n%s\n%s\n%s", head(batch[0]), head(batch[1]), head(batch[2]))
+			}
+			if len(injector.ExistingBreakpoints(batch[0])) == 0 {
+				t.Error("Expected the shared head to be marked")
+			}
+
+			// The long item still gets a breakpoint of its own, within the limit, which writes
+			// its message only: the shared head markers cache what comes before it
+			if batch[1].Messages[0].Content[0].CacheControl == nil {
+				t.Errorf("Expected the long item's content to be marked, got %+v", metadata[1].Breakpoints)
+			}
+			messageTokens := injector.GetTokenizer().CountTokens(batch[1].Messages[0].Content[0].Text)
+			for _, bp := range metadata[1].Breakpoints {
+				if bp.Position == "message_0_block_0" && bp.Tokens != messageTokens {
+					t.Errorf("Expected the long item's breakpoint to cover %d tokens, got %d", messageTokens, bp.Tokens)
+				}
+			}
+			for i, req := range batch {
+				if n := len(injector.ExistingBreakpoints(req)); n > 4 {
+					t.Errorf("Item %d has %d breakpoints, more than Anthropic allows", i, n)
+				}
+			}
+
+			// The item with a different head is planned on its own
+			if len(metadata[3].Breakpoints) != 0 {
+				t.Errorf("Expected no breakpoints on the small item, got %+v", metadata[3].Breakpoints)
+			}
+
+			// Items with client markers follow the client cache_control mode
+			if metadata[4].ClientCacheControl != string(mode) || len(metadata[4].ExistingBreakpoints) != 1 {
+				t.Errorf("Expected the client marker to be handled in %s mode, got %+v", mode, metadata[4])
+			}
+			if mode == types.ClientCacheControlRespect && len(injector.ExistingBreakpoints(batch[4])) != 1 {
+				t.Error("Expected the client-marked item to be left untouched")
+			}
+
+			// ROI figures include the batch discount
+			for i, m := range metadata {
+				if m.CachedTokens > m.TotalTokens {
+					t.Errorf("Expected item %d cached tokens (%d) not to exceed total tokens (%d)", i, m.CachedTokens, m.TotalTokens)
+				}
+				standard, _ := injector.GetPricing().CalculateROI(batch[i].Model, m.TotalTokens, m.CachedTokens, m.Breakpoints)
+				if math.Abs(m.ROI.FirstRequestCost-standard.FirstRequestCost/2) > 1e-12 || m.ROI.PercentSavings != standard.PercentSavings {
+					t.Errorf("Expected item %d ROI at the batch discount, got %+v (standard %+v)", i, m.ROI, standard)
+				}
+			}
+		})
+	}
+}
+
+func TestHeadFingerprint(t *testing.T) {
+	base := types.AnthropicRequest{Model: "claude-3-5-sonnet-20241022", System: "Be helpful"}
+
+	other := base
+	other.Messages = []types.Message{{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: "Hi"}}}}
+	if headFingerprint(&base) != headFingerprint(&other) {
+		t.Error("Expected messages not to change the head")
+	}
+
+	other = base
+	other.Model = "claude-3-5-haiku-20241022"
+	if headFingerprint(&base) == headFingerprint(&other) {
+		t.Error("Expected the model to change the head")
+	}
+
+	if headFingerprint(&types.AnthropicRequest{Model: base.Model}) != "" {
+		t.Error("Expected no head without tools or system prompt")
+	}
+}
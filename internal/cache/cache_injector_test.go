@@ -1,10 +1,16 @@
 package cache
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
 	"strings"
 	"testing"
 	"time"
 
+	"autocache/internal/config"
 	"autocache/internal/types"
 
 	"github.com/sirupsen/logrus"
@@ -45,7 +51,7 @@ func TestInjectCacheControl(t *testing.T) {
 				},
 			},
 			expectInjected:    true,
-			expectBreakpoints: 1,
+			expectBreakpoints: 2, // System, and the short message that ends a long enough prefix
 			minCacheRatio:     0.5,
 		},
 		{
@@ -59,15 +65,7 @@ func TestInjectCacheControl(t *testing.T) {
 					{
 						Name:        "calculator",
 						Description: strings.Repeat("A tool for calculations. ", 50),
-						InputSchema: map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"expression": map[string]interface{}{
-									"type":        "string",
-									"description": "Mathematical expression to evaluate",
-								},
-							},
-						},
+						InputSchema: json.RawMessage(`{"type":"object","properties":{"expression":{"type":"string","description":"Mathematical expression to evaluate"}}}`),
 					},
 				},
 				Messages: []types.Message{
@@ -75,7 +73,7 @@ func TestInjectCacheControl(t *testing.T) {
 				},
 			},
 			expectInjected:    true,
-			expectBreakpoints: 2,
+			expectBreakpoints: 3,
 			minCacheRatio:     0.6,
 		},
 		{
@@ -105,7 +103,7 @@ func TestInjectCacheControl(t *testing.T) {
 				},
 			},
 			expectInjected:    true,
-			expectBreakpoints: 1,
+			expectBreakpoints: 2,
 			minCacheRatio:     0.8,
 		},
 		{
@@ -202,19 +200,35 @@ func TestCollectCacheCandidates(t *testing.T) {
 		t.Errorf("Expected at least 3 cache candidates, got %d", len(candidates))
 	}
 
-	// Check that all candidates meet minimum token requirements
+	// Check that all candidates end a prefix that meets minimum token requirements,
+	// in render order: tools, then system, then messages
+	previous := 0
 	for _, candidate := range candidates {
-		if candidate.Tokens < adjustedMinimum {
-			t.Errorf("Candidate %s has %d tokens, below minimum %d",
-				candidate.Position, candidate.Tokens, adjustedMinimum)
+		if candidate.Cumulative < adjustedMinimum {
+			t.Errorf("Candidate %s ends a prefix of %d tokens, below minimum %d",
+				candidate.Position, candidate.Cumulative, adjustedMinimum)
+		}
+		if candidate.Cumulative != previous+candidate.Tokens {
+			t.Errorf("Candidate %s: expected cumulative %d, got %d",
+				candidate.Position, previous+candidate.Tokens, candidate.Cumulative)
 		}
+		previous = candidate.Cumulative
+	}
+	if len(candidates) > 0 && candidates[0].Position != "tools" {
+		t.Errorf("Expected tools to come first in render order, got %s", candidates[0].Position)
 	}
 
-	// Check that ROI scores are calculated
+	// The small message qualifies through the prefix before it
+	last := candidates[len(candidates)-1]
+	if last.Position != "message_0_block_1" || last.Tokens >= adjustedMinimum {
+		t.Errorf("Expected the small message to be a candidate, got %s with %d tokens", last.Position, last.Tokens)
+	}
+
+	// Check that candidates are priced
 	for _, candidate := range candidates {
-		if candidate.ROIScore <= 0 {
-			t.Errorf("Candidate %s has invalid ROI score: %.2f",
-				candidate.Position, candidate.ROIScore)
+		if candidate.ReadSavings <= 0 {
+			t.Errorf("Candidate %s has invalid read savings: %f",
+				candidate.Position, candidate.ReadSavings)
 		}
 	}
 }
@@ -231,7 +245,6 @@ func TestCreateCandidate(t *testing.T) {
 		contentType string
 		ttl         string
 		model       string
-		expectHighROI bool
 	}{
 		{
 			name:        "System prompt",
@@ -240,7 +253,6 @@ func TestCreateCandidate(t *testing.T) {
 			contentType: "system",
 			ttl:         "1h",
 			model:       "claude-3-5-sonnet-20241022",
-			expectHighROI: true,
 		},
 		{
 			name:        "Tools",
@@ -249,7 +261,6 @@ func TestCreateCandidate(t *testing.T) {
 			contentType: "tools",
 			ttl:         "1h",
 			model:       "claude-3-5-sonnet-20241022",
-			expectHighROI: true,
 		},
 		{
 			name:        "Small content",
@@ -258,7 +269,6 @@ func TestCreateCandidate(t *testing.T) {
 			contentType: "content",
 			ttl:         "5m",
 			model:       "claude-3-5-sonnet-20241022",
-			expectHighROI: false,
 		},
 		{
 			name:        "Large content",
@@ -267,7 +277,6 @@ func TestCreateCandidate(t *testing.T) {
 			contentType: "content",
 			ttl:         "5m",
 			model:       "claude-3-5-sonnet-20241022",
-			expectHighROI: true,
 		},
 	}
 
@@ -300,91 +309,6 @@ func TestCreateCandidate(t *testing.T) {
 			if candidate.BreakEven <= 0 {
 				t.Error("Break even should be positive")
 			}
-
-			// System and tools should have higher ROI scores than regular content (> 0.5)
-			if tt.expectHighROI && candidate.ROIScore < 0.5 {
-				t.Errorf("Expected high ROI score for %s, got %.2f", tt.contentType, candidate.ROIScore)
-			}
-		})
-	}
-}
-
-func TestCalculateROIScore(t *testing.T) {
-	logger := logrus.New()
-	logger.SetLevel(logrus.ErrorLevel)
-	injector := NewCacheInjector(types.StrategyModerate, "https://api.anthropic.com", "test-key", logger)
-
-	tests := []struct {
-		name           string
-		tokens         int
-		writeCost      float64
-		readSavings    float64
-		breakEven      int
-		contentType    string
-		expectedHigher bool // Whether this should score higher than baseline
-	}{
-		{
-			name:           "System content",
-			tokens:         2000,
-			writeCost:      0.01,
-			readSavings:    0.005,
-			breakEven:      2,
-			contentType:    "system",
-			expectedHigher: true,
-		},
-		{
-			name:           "Tools content",
-			tokens:         1500,
-			writeCost:      0.008,
-			readSavings:    0.004,
-			breakEven:      2,
-			contentType:    "tools",
-			expectedHigher: true,
-		},
-		{
-			name:           "Regular content",
-			tokens:         1000,
-			writeCost:      0.005,
-			readSavings:    0.0025,
-			breakEven:      2,
-			contentType:    "content",
-			expectedHigher: false,
-		},
-		{
-			name:           "Large content",
-			tokens:         5000,
-			writeCost:      0.02,
-			readSavings:    0.01,
-			breakEven:      2,
-			contentType:    "content",
-			expectedHigher: true,
-		},
-		{
-			name:           "High break-even content",
-			tokens:         1000,
-			writeCost:      0.01,
-			readSavings:    0.001,
-			breakEven:      15,
-			contentType:    "content",
-			expectedHigher: false,
-		},
-	}
-
-	baselineScore := injector.CalculateROIScore(1000, 0.005, 0.0025, 2, "content")
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			score := injector.CalculateROIScore(
-				tt.tokens, tt.writeCost, tt.readSavings, tt.breakEven, tt.contentType)
-
-			if score <= 0 {
-				t.Error("ROI score should be positive")
-			}
-
-			if tt.expectedHigher && score <= baselineScore {
-				t.Errorf("Expected score %.2f to be higher than baseline %.2f",
-					score, baselineScore)
-			}
 		})
 	}
 }
@@ -438,7 +362,7 @@ func TestApplyCacheControl(t *testing.T) {
 	injector := NewCacheInjector(types.StrategyModerate, "https://api.anthropic.com", "test-key", logger)
 
 	// Create test content blocks
-	systemContent := "System instructions"
+	request := &types.AnthropicRequest{System: "System instructions"}
 	contentBlock := types.ContentBlock{Type: "text", Text: "User message"}
 	toolDef := types.ToolDefinition{Name: "test_tool", Description: "Test tool"}
 
@@ -450,7 +374,7 @@ func TestApplyCacheControl(t *testing.T) {
 			TTL:         "1h",
 			WriteCost:   0.01,
 			ReadSavings: 0.005,
-			Content:     &systemContent,
+			Content:     request,
 		},
 		{
 			Position:    "message_0_block_0",
@@ -493,6 +417,15 @@ func TestApplyCacheControl(t *testing.T) {
 		}
 	}
 
+	// Check that the string system prompt was converted into a marked block
+	if request.System != "" || len(request.SystemBlocks) != 1 {
+		t.Fatalf("Expected system to be converted to 1 block, got system=%q blocks=%d",
+			request.System, len(request.SystemBlocks))
+	}
+	if request.SystemBlocks[0].Text != "System instructions" || request.SystemBlocks[0].CacheControl == nil {
+		t.Errorf("Expected system block to keep its text and carry cache control, got %+v", request.SystemBlocks[0])
+	}
+
 	// Check that cache control was actually applied to the content block
 	if contentBlock.CacheControl == nil {
 		t.Error("Cache control was not applied to content block")
@@ -602,23 +535,26 @@ func TestStrategyBehavior(t *testing.T) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.ErrorLevel)
 
-	// Create a request with multiple cacheable elements
-	request := &types.AnthropicRequest{
-		Model:     "claude-3-5-sonnet-20241022",
-		MaxTokens: 100,
-		System:    strings.Repeat("System instructions. ", 100),
-		Tools: []types.ToolDefinition{
-			{Name: "tool1", Description: strings.Repeat("Tool description. ", 50)},
-		},
-		Messages: []types.Message{
-			{
-				Role: "user",
-				Content: []types.ContentBlock{
-					{Type: "text", Text: strings.Repeat("Large content block 1. ", 100)},
-					{Type: "text", Text: strings.Repeat("Large content block 2. ", 100)},
+	// Create a request with multiple cacheable elements. Each strategy gets a fresh copy:
+	// markers placed by one run would otherwise count as client markers in the next.
+	newRequest := func() *types.AnthropicRequest {
+		return &types.AnthropicRequest{
+			Model:     "claude-3-5-sonnet-20241022",
+			MaxTokens: 100,
+			System:    strings.Repeat("System instructions. ", 100),
+			Tools: []types.ToolDefinition{
+				{Name: "tool1", Description: strings.Repeat("Tool description. ", 50)},
+			},
+			Messages: []types.Message{
+				{
+					Role: "user",
+					Content: []types.ContentBlock{
+						{Type: "text", Text: strings.Repeat("Large content block 1. ", 100)},
+						{Type: "text", Text: strings.Repeat("Large content block 2. ", 100)},
+					},
 				},
 			},
-		},
+		}
 	}
 
 	strategies := []types.CacheStrategy{types.StrategyConservative, types.StrategyModerate, types.StrategyAggressive}
@@ -626,7 +562,7 @@ func TestStrategyBehavior(t *testing.T) {
 
 	for _, strategy := range strategies {
 		injector := NewCacheInjector(strategy, "https://api.anthropic.com", "test-key", logger)
-		metadata, err := injector.InjectCacheControl(request)
+		metadata, err := injector.InjectCacheControl(newRequest())
 		if err != nil {
 			t.Errorf("Strategy %s failed: %v", strategy, err)
 			continue
@@ -649,4 +585,304 @@ func TestStrategyBehavior(t *testing.T) {
 		t.Errorf("Moderate strategy used more breakpoints (%d) than aggressive (%d)",
 			results[1], results[2])
 	}
-}
\ No newline at end of file
+}
+func TestApplyCacheControlIgnoresUnsupportedContent(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	injector := NewCacheInjector(types.StrategyModerate, "https://api.anthropic.com", "test-key", logger)
+
+	// A bare string cannot carry a marker, so it must not be reported as a breakpoint
+	systemContent := "System instructions"
+	breakpoints := injector.ApplyCacheControl([]CacheCandidate{
+		{Position: "system", Tokens: 1000, ContentType: "system", TTL: "1h", Content: &systemContent},
+	})
+
+	if len(breakpoints) != 0 {
+		t.Errorf("Expected no breakpoints for unsupported content, got %d", len(breakpoints))
+	}
+}
+
+func TestInjectCacheControlSystemBlocks(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	injector := NewCacheInjector(types.StrategyModerate, "https://api.anthropic.com", "test-key", logger)
+
+	var request types.AnthropicRequest
+	body := `{
+		"model": "claude-3-5-sonnet-20241022",
+		"max_tokens": 100,
+		"system": [
+			{"type": "text", "text": "You are a support agent."},
+			{"type": "text", "text": "` + strings.Repeat("Product manual section. ", 300) + `"}
+		],
+		"messages": [{"role": "user", "content": "Hello"}]
+	}`
+	if err := json.Unmarshal([]byte(body), &request); err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+
+	if _, err := injector.InjectCacheControl(&request); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := json.Marshal(&request)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	var wire struct {
+		System []map[string]interface{} `json:"system"`
+	}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		t.Fatalf("Expected system to be serialized as blocks: %v\n%s", err, data)
+	}
+	if len(wire.System) != 2 {
+		t.Fatalf("Expected 2 system blocks on the wire, got %d", len(wire.System))
+	}
+	if _, ok := wire.System[0]["cache_control"]; ok {
+		t.Error("Expected no cache_control on the first system block")
+	}
+	if _, ok := wire.System[1]["cache_control"]; !ok {
+		t.Errorf("Expected cache_control on the last system block, got %s", data)
+	}
+}
+
+func TestCollectCacheCandidatesToolBlocks(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	injector := NewCacheInjector(types.StrategyAggressive, "https://api.anthropic.com", "test-key", logger)
+
+	fileContents := strings.Repeat("func handler(w http.ResponseWriter, r *http.Request) { serve(w, r) }\n", 150)
+
+	var request types.AnthropicRequest
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":      "claude-3-5-sonnet-20241022",
+		"max_tokens": 100,
+		"messages": []interface{}{
+			map[string]interface{}{"role": "user", "content": "Refactor the handlers"},
+			map[string]interface{}{"role": "assistant", "content": []interface{}{
+				map[string]interface{}{"type": "tool_use", "id": "toolu_1", "name": "write_file", "input": map[string]interface{}{
+					"path": "server.go", "contents": fileContents,
+				}},
+			}},
+			map[string]interface{}{"role": "user", "content": []interface{}{
+				map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_1", "content": fileContents},
+				map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_2", "content": []interface{}{
+					map[string]interface{}{"type": "text", "text": fileContents},
+				}},
+				map[string]interface{}{"type": "document", "title": "Style guide", "source": map[string]interface{}{
+					"type": "text", "media_type": "text/plain", "data": fileContents,
+				}},
+				map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_3", "content": "ok"},
+			}},
+		},
+	})
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+
+	config := types.GetStrategyConfig(types.StrategyAggressive)
+	candidates := injector.CollectCacheCandidates(&request, 1024, config)
+
+	var positions []string
+	for _, candidate := range candidates {
+		positions = append(positions, candidate.Position)
+		if candidate.ContentType != "content" || candidate.TTL != config.ContentTTL {
+			t.Errorf("Candidate %s: unexpected type %s or TTL %s", candidate.Position, candidate.ContentType, candidate.TTL)
+		}
+	}
+	expected := "message_1_block_0,message_2_block_0,message_2_block_1,message_2_block_2,message_2_block_3"
+	if strings.Join(positions, ",") != expected {
+		t.Fatalf("Expected candidates %s, got %v", expected, positions)
+	}
+
+	// The marker goes on the block itself, not inside its content
+	injector.ApplyCacheControl(candidates[1:2])
+	data, err := json.Marshal(request.Messages[2])
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	var wire struct {
+		Content []map[string]interface{} `json:"content"`
+	}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		t.Fatalf("Failed to decode message: %v", err)
+	}
+	if _, ok := wire.Content[0]["cache_control"]; !ok {
+		t.Errorf("Expected cache_control on the tool_result block, got %s", data)
+	}
+	if wire.Content[0]["content"] != fileContents {
+		t.Error("Expected tool_result content to be forwarded unchanged")
+	}
+}
+
+func TestCollectCacheCandidatesImages(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	injector := NewCacheInjector(types.StrategyModerate, "https://api.anthropic.com", "test-key", logger)
+
+	encode := func(width, height int) string {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+			t.Fatalf("Failed to encode image: %v", err)
+		}
+		return base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+
+	request := &types.AnthropicRequest{
+		Model:     "claude-3-5-sonnet-20241022",
+		MaxTokens: 100,
+		Messages: []types.Message{
+			{
+				Role: "user",
+				Content: []types.ContentBlock{
+					{Type: "image", Source: &types.ImageSource{Type: "base64", MediaType: "image/png", Data: encode(1092, 1092)}},
+					{Type: "image", Source: &types.ImageSource{Type: "base64", MediaType: "image/png", Data: encode(64, 64)}},
+					{Type: "text", Text: "What changed between these screenshots?"},
+				},
+			},
+		},
+	}
+
+	config := types.GetStrategyConfig(types.StrategyModerate)
+	candidates := injector.CollectCacheCandidates(request, 1024, config)
+
+	// The screenshot alone reaches the minimum, so every block after it ends a cacheable prefix
+	var positions []string
+	for _, candidate := range candidates {
+		positions = append(positions, candidate.Position)
+	}
+	if strings.Join(positions, ",") != "message_0_block_0,message_0_block_1,message_0_block_2" {
+		t.Fatalf("Expected the screenshot and the blocks after it as candidates, got %v", positions)
+	}
+	if candidates[1].Tokens > 10 {
+		t.Errorf("Expected the small image to be estimated from its dimensions, got %d tokens", candidates[1].Tokens)
+	}
+	if candidates[0].Tokens < 1590 {
+		t.Errorf("Expected the screenshot to be estimated from its dimensions, got %d tokens", candidates[0].Tokens)
+	}
+}
+
+func TestInjectCacheControlCustomStrategy(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	team := types.GetStrategyConfig(types.StrategyModerate)
+	team.Priority = []string{"content", "system"}
+	team.Weights.Content = 4.0
+
+	cfg := &config.Config{
+		TokenizerMode:       "heuristic",
+		MaxCacheBreakpoints: 1,
+		TokenMultiplier:     1.0,
+		Strategies:          map[string]types.StrategyConfig{"team": team},
+	}
+	injector := NewCacheInjectorWithConfig("team", cfg, logger)
+
+	if got := injector.GetStrategyConfig().MaxBreakpoints; got != 1 {
+		t.Errorf("Expected MAX_CACHE_BREAKPOINTS to cap the strategy at 1, got %d", got)
+	}
+
+	request := &types.AnthropicRequest{
+		Model:     "claude-3-5-sonnet-20241022",
+		MaxTokens: 100,
+		System:    strings.Repeat("System prompt with detailed instructions. ", 150),
+		Messages: []types.Message{
+			{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: strings.Repeat("Large user message with context. ", 150)}}},
+		},
+	}
+
+	metadata, err := injector.InjectCacheControl(request)
+	if err != nil {
+		t.Fatalf("InjectCacheControl failed: %v", err)
+	}
+	if len(metadata.Breakpoints) != 1 || metadata.Breakpoints[0].Position != "message_0_block_0" {
+		t.Errorf("Expected the single breakpoint on the heavily weighted content, got %+v", metadata.Breakpoints)
+	}
+	if metadata.Strategy != "team" {
+		t.Errorf("Expected strategy name in metadata, got %s", metadata.Strategy)
+	}
+}
+
+func TestInjectCacheControlZeroWeight(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	// A weight of 0 turns its factor off instead of falling back to the default
+	noContent := types.GetStrategyConfig(types.StrategyModerate)
+	noContent.Weights.Content = 0
+
+	injector := NewCacheInjector(types.StrategyModerate, "", "", logger)
+	request := &types.AnthropicRequest{
+		Model:     "claude-3-5-sonnet-20241022",
+		MaxTokens: 100,
+		System:    strings.Repeat("System prompt with detailed instructions. ", 150),
+		Messages: []types.Message{
+			{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: strings.Repeat("Large user message with context. ", 150)}}},
+			{Role: "assistant", Content: []types.ContentBlock{{Type: "text", Text: "Noted."}}},
+			{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: "Summarize it."}}},
+		},
+	}
+
+	metadata, err := injector.InjectCacheControlWithStrategy(request, "no-content", noContent)
+	if err != nil {
+		t.Fatalf("InjectCacheControl failed: %v", err)
+	}
+	for _, bp := range metadata.Breakpoints {
+		if bp.Type == "content" {
+			t.Errorf("Expected no content breakpoints with a content weight of 0, got %+v", metadata.Breakpoints)
+		}
+	}
+	if len(metadata.Breakpoints) == 0 {
+		t.Error("Expected the system prompt to keep its breakpoint")
+	}
+}
+
+func TestInjectCacheControlCumulativePrefix(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	injector := NewCacheInjector(types.StrategyModerate, "https://api.anthropic.com", "test-key", logger)
+
+	// 40 turns of about 200 tokens: no single block reaches the minimum
+	request := &types.AnthropicRequest{
+		Model:     "claude-3-5-sonnet-20241022",
+		MaxTokens: 100,
+		System:    "You are helpful.",
+	}
+	for i := 0; i < 40; i++ {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		request.Messages = append(request.Messages, types.Message{
+			Role:    role,
+			Content: []types.ContentBlock{{Type: "text", Text: strings.Repeat("A turn of ordinary conversation. ", 10)}},
+		})
+	}
+	if tokens := injector.GetTokenizer().CountTokens(request.Messages[0].Content[0].Text); tokens >= 1024 {
+		t.Fatalf("Expected turns below the minimum, got %d tokens", tokens)
+	}
+
+	metadata, err := injector.InjectCacheControl(request)
+	if err != nil {
+		t.Fatalf("InjectCacheControl failed: %v", err)
+	}
+	if !metadata.CacheInjected {
+		t.Fatal("Expected the conversation to be cached once its prefix is long enough")
+	}
+
+	minimum := injector.GetTokenizer().GetModelMinimumTokens(request.Model)
+	segments := 0
+	for _, bp := range metadata.Breakpoints {
+		if bp.Cumulative < minimum {
+			t.Errorf("Breakpoint %s ends a prefix of %d tokens, below the minimum", bp.Position, bp.Cumulative)
+		}
+		segments += bp.Tokens
+		if bp.Cumulative != segments {
+			t.Errorf("Breakpoint %s: expected segments to add up to %d, got %d", bp.Position, bp.Cumulative, segments)
+		}
+	}
+	if metadata.CachedTokens != segments {
+		t.Errorf("Expected cached tokens %d to equal the covered prefix %d", metadata.CachedTokens, segments)
+	}
+}
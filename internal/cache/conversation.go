@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+
+	"autocache/internal/types"
+)
+
+// conversationPoint is a position in the rendered prompt where a conversation breakpoint can go
+type conversationPoint struct {
+	position    string
+	contentType string
+	ttl         string
+	cumulative  int         // Tokens from the start of the prompt up to and including this position
+	content     interface{} // Target for applyCacheControlToContent
+}
+
+// CollectConversationCandidates places breakpoints for multi-turn conversations such as agent loops.
+//
+// Anthropic caches whole prefixes, so a breakpoint on the latest user turn covers the entire
+// conversation so far. Each request therefore gets, in order of priority:
+//   - the stable head: the end of the system prompt (or of the tools when there is none)
+//   - the latest user turn, written now so the next request can read it
+//   - the previous user turn, which the previous request wrote, so this request reads it
+//   - the end of the tools, so a changing system prompt still leaves the tools cached
+//
+// Positions the client already marked are left to the client, and at most maxBreakpoints
+// are returned, so lower priorities are dropped here rather than by the selection.
+//
+// Thresholds apply to the cumulative prefix, in the order Anthropic renders the prompt
+// (tools → system → messages). Candidates are returned in that order, and each reports the
+// tokens added since the breakpoint before it, so their sum is the cached prefix.
+func (ci *CacheInjector) CollectConversationCandidates(req *types.AnthropicRequest, minTokens, maxBreakpoints int, strategyConfig types.StrategyConfig) []CacheCandidate {
+	cumulative := 0
+
+	var tools, head *conversationPoint
+	if len(req.Tools) > 0 {
+		for _, tool := range req.Tools {
+			cumulative += ci.tokenizer.CountToolTokens(tool)
+		}
+		tools = &conversationPoint{"tools", "tools", ci.coldStartTTL("tools", "", strategyConfig), cumulative, &req.Tools}
+		head = tools
+	}
+
+	if req.System != "" {
+		cumulative += ci.tokenizer.CountSystemTokens(req.System)
+		head = &conversationPoint{"system", "system", ci.coldStartTTL("system", "", strategyConfig), cumulative, req}
+	} else if len(req.SystemBlocks) > 0 {
+		cumulative += ci.tokenizer.CountSystemBlocksTokens(req.SystemBlocks)
+		head = &conversationPoint{"system_blocks", "system", ci.coldStartTTL("system", "", strategyConfig), cumulative, &req.SystemBlocks}
+	}
+
+	// The end of every user message, latest last
+	var turns []conversationPoint
+	for msgIdx, message := range req.Messages {
+		cumulative += ci.tokenizer.CountMessageTokens(message)
+		if message.Role != "user" || len(message.Content) == 0 {
+			continue
+		}
+		blockIdx := len(message.Content) - 1
+		turns = append(turns, conversationPoint{
+			position:    fmt.Sprintf("message_%d_block_%d", msgIdx, blockIdx),
+			contentType: "content",
+			ttl:         ci.coldStartTTL("content", "", strategyConfig),
+			cumulative:  cumulative,
+			content:     &req.Messages[msgIdx].Content[blockIdx],
+		})
+	}
+
+	var prioritized []*conversationPoint
+	prioritized = append(prioritized, head)
+	if len(turns) > 0 {
+		prioritized = append(prioritized, &turns[len(turns)-1])
+	}
+	if len(turns) > 1 {
+		prioritized = append(prioritized, &turns[len(turns)-2])
+	}
+	if tools != head {
+		prioritized = append(prioritized, tools)
+	}
+
+	var points []conversationPoint
+	for _, point := range prioritized {
+		if point == nil || point.cumulative < minTokens || hasCacheControl(point.content) {
+			continue
+		}
+		if len(points) == maxBreakpoints {
+			break
+		}
+		points = append(points, *point)
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].cumulative < points[j].cumulative
+	})
+
+	candidates := make([]CacheCandidate, 0, len(points))
+	previous := 0
+	for _, point := range points {
+		candidate := ci.CreateCandidate(point.position, point.cumulative-previous, point.contentType, point.ttl, req.Model, point.content)
+		candidate.Cumulative = point.cumulative
+		candidates = append(candidates, candidate)
+		previous = point.cumulative
+	}
+
+	return candidates
+}
@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+func loadAgentTurn(t *testing.T, turn int) *types.AnthropicRequest {
+	t.Helper()
+
+	data, err := os.ReadFile(fmt.Sprintf("../../test_data/agent_conversation/turn_%d_request.json", turn))
+	if err != nil {
+		t.Fatalf("Failed to read turn %d: %v", turn, err)
+	}
+
+	var req types.AnthropicRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatalf("Failed to parse turn %d: %v", turn, err)
+	}
+	return &req
+}
+
+func TestConversationStrategyReplay(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	injector := NewCacheInjector(types.StrategyConversation, "", "", logger)
+	clock := &fakeClock{now: time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)}
+	injector.prefixes.now = clock.Now
+
+	// Simulated Anthropic cache: prefixes written by earlier requests
+	cached := make(map[string]bool)
+
+	// Turns a minute apart: once three gaps of the head are known, its 1h TTL drops to 5m
+	tests := []struct {
+		turn    int
+		reads   []string
+		writes  []string
+		headTTL string
+	}{
+		{1, nil, []string{"tools", "system", "message_0_block_0"}, "1h"},
+		{2, []string{"tools", "system", "message_0_block_0"}, []string{"message_2_block_0"}, "1h"},
+		{3, []string{"tools", "system", "message_2_block_0"}, []string{"message_4_block_0"}, "1h"},
+		{4, []string{"tools", "system", "message_4_block_0"}, []string{"message_6_block_0"}, "1h"},
+		{5, []string{"tools", "system", "message_6_block_0"}, []string{"message_8_block_0"}, "5m"},
+	}
+
+	var headTokens int
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("turn_%d", tt.turn), func(t *testing.T) {
+			clock.Advance(time.Minute)
+			req := loadAgentTurn(t, tt.turn)
+			fingerprints := prefixFingerprints(req)
+
+			metadata, err := injector.InjectCacheControl(req)
+			if err != nil {
+				t.Fatalf("InjectCacheControl failed: %v", err)
+			}
+
+			var reads, writes []string
+			var readTokens, writeTokens int
+			for _, bp := range metadata.Breakpoints {
+				fingerprint := fingerprints[bp.Position]
+				if cached[fingerprint] {
+					reads = append(reads, bp.Position)
+					readTokens += bp.Tokens
+				} else {
+					writes = append(writes, bp.Position)
+					writeTokens += bp.Tokens
+				}
+
+				// The prefix registry sees the same cache as the simulation
+				if bp.Warm != cached[fingerprint] {
+					t.Errorf("Breakpoint %s: expected warm=%v, got %v", bp.Position, cached[fingerprint], bp.Warm)
+				}
+			}
+			for _, bp := range metadata.Breakpoints {
+				cached[fingerprints[bp.Position]] = true
+			}
+
+			if strings.Join(reads, ",") != strings.Join(tt.reads, ",") {
+				t.Errorf("Expected reads %v, got %v", tt.reads, reads)
+			}
+			if strings.Join(writes, ",") != strings.Join(tt.writes, ",") {
+				t.Errorf("Expected writes %v, got %v", tt.writes, writes)
+			}
+
+			// Only the turns added since the previous request are written
+			if tt.turn > 1 && writeTokens >= readTokens {
+				t.Errorf("Expected writes (%d tokens) to be smaller than reads (%d tokens)", writeTokens, readTokens)
+			}
+			if metadata.CachedTokens != readTokens+writeTokens {
+				t.Errorf("Expected cached tokens %d to equal the covered prefix %d", metadata.CachedTokens, readTokens+writeTokens)
+			}
+
+			// The head is identical in every turn
+			head := metadata.Breakpoints[0].Tokens + metadata.Breakpoints[1].Tokens
+			if headTokens == 0 {
+				headTokens = head
+			} else if head != headTokens {
+				t.Errorf("Expected stable head of %d tokens, got %d", headTokens, head)
+			}
+
+			for _, bp := range metadata.Breakpoints {
+				if bp.Type == "content" && bp.TTL != "5m" || bp.Type != "content" && bp.TTL != tt.headTTL {
+					t.Errorf("Unexpected TTL %s for %s breakpoint %s", bp.TTL, bp.Type, bp.Position)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectConversationCandidates(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	injector := NewCacheInjector(types.StrategyConversation, "", "", logger)
+
+	longText := strings.Repeat("Detailed context for the task at hand. ", 200)
+	message := func(role, text string) types.Message {
+		return types.Message{Role: role, Content: []types.ContentBlock{{Type: "text", Text: text}}}
+	}
+
+	tests := []struct {
+		name      string
+		req       *types.AnthropicRequest
+		maxBreaks int
+		expected  []string
+	}{
+		{
+			name: "short head reaches the minimum through the conversation",
+			req: &types.AnthropicRequest{
+				Model:  "claude-3-5-sonnet-20241022",
+				System: "You are helpful.",
+				Messages: []types.Message{
+					message("user", longText),
+					message("assistant", "Sure."),
+					message("user", "Next question"),
+				},
+			},
+			maxBreaks: 4,
+			expected:  []string{"message_0_block_0", "message_2_block_0"},
+		},
+		{
+			name: "last block of a multi-block user turn",
+			req: &types.AnthropicRequest{
+				Model:        "claude-3-5-sonnet-20241022",
+				SystemBlocks: []types.ContentBlock{{Type: "text", Text: longText}},
+				Messages: []types.Message{
+					{Role: "user", Content: []types.ContentBlock{
+						{Type: "tool_result", ToolUseID: "toolu_1", Content: json.RawMessage(`"ok"`)},
+						{Type: "text", Text: "Continue"},
+					}},
+				},
+			},
+			maxBreaks: 4,
+			expected:  []string{"system_blocks", "message_0_block_1"},
+		},
+		{
+			name: "breakpoint cap keeps head and latest turn",
+			req: &types.AnthropicRequest{
+				Model:  "claude-3-5-sonnet-20241022",
+				System: longText,
+				Messages: []types.Message{
+					message("user", "First"),
+					message("assistant", "Reply"),
+					message("user", "Second"),
+					message("assistant", "Reply"),
+					message("user", "Third"),
+				},
+			},
+			maxBreaks: 2,
+			expected:  []string{"system", "message_4_block_0"},
+		},
+		{
+			name: "positions the client marked leave the cap to later turns",
+			req: &types.AnthropicRequest{
+				Model:        "claude-3-5-sonnet-20241022",
+				SystemBlocks: []types.ContentBlock{{Type: "text", Text: longText, CacheControl: &types.CacheControl{Type: "ephemeral"}}},
+				Messages: []types.Message{
+					message("user", "First"),
+					message("assistant", "Reply"),
+					message("user", "Second"),
+				},
+			},
+			maxBreaks: 2,
+			expected:  []string{"message_0_block_0", "message_2_block_0"},
+		},
+		{
+			name: "nothing when the whole prompt is below the minimum",
+			req: &types.AnthropicRequest{
+				Model:    "claude-3-5-sonnet-20241022",
+				System:   "You are helpful.",
+				Messages: []types.Message{message("user", "Hello")},
+			},
+			maxBreaks: 4,
+			expected:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategyConfig := types.GetStrategyConfig(types.StrategyConversation)
+
+			candidates := injector.CollectConversationCandidates(tt.req, 1024, tt.maxBreaks, strategyConfig)
+
+			var positions []string
+			for _, c := range candidates {
+				positions = append(positions, c.Position)
+			}
+			if strings.Join(positions, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, positions)
+			}
+		})
+	}
+}
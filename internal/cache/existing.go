@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"fmt"
+
+	"autocache/internal/types"
+)
+
+// ExistingBreakpoints lists the cache_control markers the client already set, in render order
+// (tools → system → messages). Positions name the marked item: tool_N, system_block_N,
+// message_N_block_M or, inside tool_result content, message_N_block_M_content_K. Each
+// breakpoint's Tokens are those of the marked item and Cumulative those of the prefix it ends.
+func (ci *CacheInjector) ExistingBreakpoints(req *types.AnthropicRequest) []types.CacheBreakpoint {
+	var existing []types.CacheBreakpoint
+	cumulative := 0
+
+	add := func(position string, tokens, cumulative int, contentType string, cacheControl *types.CacheControl) {
+		ttl := cacheControl.TTL
+		if ttl == "" {
+			ttl = "5m" // Anthropic's default
+		}
+		candidate := ci.CreateCandidate(position, tokens, contentType, ttl, req.Model, nil)
+		candidate.Cumulative = cumulative
+		existing = append(existing, candidateBreakpoint(candidate))
+	}
+
+	for i, tool := range req.Tools {
+		tokens := ci.tokenizer.CountToolTokens(tool)
+		cumulative += tokens
+		if tool.CacheControl != nil {
+			add(fmt.Sprintf("tool_%d", i), tokens, cumulative, "tools", tool.CacheControl)
+		}
+	}
+
+	if req.System != "" {
+		cumulative += ci.tokenizer.CountSystemTokens(req.System)
+	}
+	for i, block := range req.SystemBlocks {
+		tokens := ci.tokenizer.CountSystemBlocksTokens([]types.ContentBlock{block})
+		cumulative += tokens
+		if block.CacheControl != nil {
+			add(fmt.Sprintf("system_block_%d", i), tokens, cumulative, "system", block.CacheControl)
+		}
+	}
+
+	for msgIdx, message := range req.Messages {
+		for blockIdx, block := range message.Content {
+			nestedCumulative := cumulative
+			for nestedIdx, nested := range nestedBlocks(block) {
+				tokens := ci.tokenizer.CountContentBlockTokens(nested)
+				nestedCumulative += tokens
+				if nested.CacheControl != nil {
+					position := fmt.Sprintf("message_%d_block_%d_content_%d", msgIdx, blockIdx, nestedIdx)
+					add(position, tokens, nestedCumulative, "content", nested.CacheControl)
+				}
+			}
+			tokens := ci.tokenizer.CountContentBlockTokens(block)
+			cumulative += tokens
+			if block.CacheControl != nil {
+				add(fmt.Sprintf("message_%d_block_%d", msgIdx, blockIdx), tokens, cumulative, "content", block.CacheControl)
+			}
+		}
+	}
+
+	return existing
+}
+
+// segmentStart returns where the segment cached by a breakpoint ending a prefix of cumulative
+// tokens starts: at the breakpoint before it, previous, or at a client marker kept after that
+func segmentStart(cumulative, previous int, kept []types.CacheBreakpoint) int {
+	start := previous
+	for _, bp := range kept {
+		if bp.Cumulative < cumulative {
+			start = max(start, bp.Cumulative)
+		}
+	}
+	return start
+}
+
+// StripCacheControl removes every cache_control marker from the request
+func StripCacheControl(req *types.AnthropicRequest) {
+	for i := range req.Tools {
+		req.Tools[i].CacheControl = nil
+	}
+	for i := range req.SystemBlocks {
+		req.SystemBlocks[i].CacheControl = nil
+	}
+	for msgIdx := range req.Messages {
+		for blockIdx := range req.Messages[msgIdx].Content {
+			block := &req.Messages[msgIdx].Content[blockIdx]
+			block.CacheControl = nil
+
+			// Nested content is only re-encoded when it carries a marker
+			nested := nestedBlocks(*block)
+			marked := false
+			for i := range nested {
+				marked = marked || nested[i].CacheControl != nil
+				nested[i].CacheControl = nil
+			}
+			if marked {
+				_ = block.SetNestedBlocks(nested) // Blocks that decoded encode again
+			}
+		}
+	}
+}
+
+// nestedBlocks returns the content blocks nested in a tool_result, nil for string content
+// and other block types
+func nestedBlocks(block types.ContentBlock) []types.ContentBlock {
+	if block.Type != "tool_result" {
+		return nil
+	}
+	_, blocks, err := block.NestedContent()
+	if err != nil {
+		return nil
+	}
+	return blocks
+}
+
+// unmarkedCandidates drops candidates whose target already carries a client marker
+func unmarkedCandidates(candidates []CacheCandidate) []CacheCandidate {
+	kept := make([]CacheCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if !hasCacheControl(candidate.Content) {
+			kept = append(kept, candidate)
+		}
+	}
+	return kept
+}
+
+// hasCacheControl reports whether the item applyCacheControlToContent would mark has a marker
+func hasCacheControl(content interface{}) bool {
+	switch v := content.(type) {
+	case *types.AnthropicRequest:
+		return len(v.SystemBlocks) > 0 && v.SystemBlocks[len(v.SystemBlocks)-1].CacheControl != nil
+	case *[]types.ContentBlock:
+		return len(*v) > 0 && (*v)[len(*v)-1].CacheControl != nil
+	case *[]types.ToolDefinition:
+		return len(*v) > 0 && (*v)[len(*v)-1].CacheControl != nil
+	case *types.ContentBlock:
+		return v.CacheControl != nil
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+package cache
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestClientCacheControlModes(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	longText := strings.Repeat("Reference material that is worth caching. ", 200)
+	newRequest := func() *types.AnthropicRequest {
+		req := &types.AnthropicRequest{
+			Model:        "claude-3-5-sonnet-20241022",
+			SystemBlocks: []types.ContentBlock{{Type: "text", Text: longText}},
+		}
+		for i := 0; i < 4; i++ {
+			req.Messages = append(req.Messages, types.Message{
+				Role:    "user",
+				Content: []types.ContentBlock{{Type: "text", Text: longText}},
+			})
+		}
+		// The client already marked the first two messages
+		req.Messages[0].Content[0].CacheControl = &types.CacheControl{Type: "ephemeral"}
+		req.Messages[1].Content[0].CacheControl = &types.CacheControl{Type: "ephemeral", TTL: "1h"}
+		return req
+	}
+
+	tests := []struct {
+		mode            types.ClientCacheControlMode
+		expectInjected  []string
+		expectMarked    []string
+		expectClientTTL string
+	}{
+		{
+			mode:            types.ClientCacheControlRespect,
+			expectInjected:  nil,
+			expectMarked:    []string{"message_0_block_0", "message_1_block_0"},
+			expectClientTTL: "1h",
+		},
+		{
+			mode:            types.ClientCacheControlAugment,
+			expectInjected:  []string{"system_blocks", "message_2_block_0"},
+			expectMarked:    []string{"system_block_0", "message_0_block_0", "message_1_block_0", "message_2_block_0"},
+			expectClientTTL: "1h",
+		},
+		{
+			// Four slots for five prefixes: the shortest history segment is the one left out
+			mode:           types.ClientCacheControlOverride,
+			expectInjected: []string{"system_blocks", "message_0_block_0", "message_2_block_0", "message_3_block_0"},
+			expectMarked:   []string{"system_block_0", "message_0_block_0", "message_2_block_0", "message_3_block_0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			injector := NewCacheInjector(types.StrategyAggressive, "", "", logger)
+			injector.clientMode = tt.mode
+
+			req := newRequest()
+			metadata, err := injector.InjectCacheControl(req)
+			if err != nil {
+				t.Fatalf("InjectCacheControl failed: %v", err)
+			}
+
+			var injected []string
+			for _, bp := range metadata.Breakpoints {
+				injected = append(injected, bp.Position)
+			}
+			if strings.Join(injected, ",") != strings.Join(tt.expectInjected, ",") {
+				t.Errorf("Expected injected %v, got %v", tt.expectInjected, injected)
+			}
+
+			// Existing markers are reported separately, whatever the mode did with them
+			var existing []string
+			for _, bp := range metadata.ExistingBreakpoints {
+				existing = append(existing, bp.Position)
+			}
+			if strings.Join(existing, ",") != "message_0_block_0,message_1_block_0" {
+				t.Errorf("Expected existing markers on the first two messages, got %v", existing)
+			}
+			if metadata.ExistingBreakpoints[0].TTL != "5m" || metadata.ExistingBreakpoints[1].TTL != "1h" {
+				t.Errorf("Expected existing TTLs 5m and 1h, got %+v", metadata.ExistingBreakpoints)
+			}
+			if metadata.ClientCacheControl != string(tt.mode) {
+				t.Errorf("Expected mode %s in metadata, got %s", tt.mode, metadata.ClientCacheControl)
+			}
+
+			// What is sent upstream never exceeds Anthropic's limit
+			var marked []string
+			for _, bp := range injector.ExistingBreakpoints(req) {
+				marked = append(marked, bp.Position)
+			}
+			if strings.Join(marked, ",") != strings.Join(tt.expectMarked, ",") {
+				t.Errorf("Expected markers %v in the request, got %v", tt.expectMarked, marked)
+			}
+			if tt.expectClientTTL != "" && req.Messages[1].Content[0].CacheControl.TTL != tt.expectClientTTL {
+				t.Errorf("Expected the client's marker to be kept, got %+v", req.Messages[1].Content[0].CacheControl)
+			}
+		})
+	}
+}
+
+func TestAugmentWithFullClientMarkers(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	injector := NewCacheInjector(types.StrategyAggressive, "", "", logger)
+
+	longText := strings.Repeat("Reference material that is worth caching. ", 200)
+	req := &types.AnthropicRequest{
+		Model:        "claude-3-5-sonnet-20241022",
+		SystemBlocks: []types.ContentBlock{{Type: "text", Text: longText}},
+	}
+	for i := 0; i < 4; i++ {
+		req.Messages = append(req.Messages, types.Message{
+			Role: "user",
+			Content: []types.ContentBlock{{
+				Type:         "text",
+				Text:         longText,
+				CacheControl: &types.CacheControl{Type: "ephemeral"},
+			}},
+		})
+	}
+
+	metadata, err := injector.InjectCacheControl(req)
+	if err != nil {
+		t.Fatalf("InjectCacheControl failed: %v", err)
+	}
+	if metadata.CacheInjected || len(metadata.Breakpoints) != 0 {
+		t.Errorf("Expected no slots left for injection, got %+v", metadata.Breakpoints)
+	}
+	if req.SystemBlocks[0].CacheControl != nil {
+		t.Error("Expected the system prompt to stay unmarked")
+	}
+	if len(metadata.ExistingBreakpoints) != 4 {
+		t.Errorf("Expected 4 existing markers, got %d", len(metadata.ExistingBreakpoints))
+	}
+}
+
+func TestAugmentSegmentsAfterClientMarkers(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	injector := NewCacheInjector(types.StrategyAggressive, "", "", logger)
+
+	longText := strings.Repeat("Reference material that is worth caching. ", 200)
+	req := &types.AnthropicRequest{
+		Model: "claude-3-5-sonnet-20241022",
+		SystemBlocks: []types.ContentBlock{{
+			Type:         "text",
+			Text:         longText,
+			CacheControl: &types.CacheControl{Type: "ephemeral"},
+		}},
+	}
+	for i := 0; i < 2; i++ {
+		req.Messages = append(req.Messages, types.Message{
+			Role:    "user",
+			Content: []types.ContentBlock{{Type: "text", Text: longText}},
+		})
+	}
+
+	metadata, err := injector.InjectCacheControl(req)
+	if err != nil {
+		t.Fatalf("InjectCacheControl failed: %v", err)
+	}
+	if len(metadata.Breakpoints) != 2 {
+		t.Fatalf("Expected both messages to be marked, got %+v", metadata.Breakpoints)
+	}
+
+	// The client's system marker already caches the system prompt: each injected breakpoint
+	// only writes its own message
+	messageTokens := injector.GetTokenizer().CountTokens(longText)
+	for _, bp := range metadata.Breakpoints {
+		if bp.Tokens != messageTokens {
+			t.Errorf("Expected %s to cover %d tokens, got %d", bp.Position, messageTokens, bp.Tokens)
+		}
+	}
+	if metadata.CachedTokens > metadata.TotalTokens {
+		t.Errorf("Expected cached tokens (%d) not to exceed total tokens (%d)", metadata.CachedTokens, metadata.TotalTokens)
+	}
+}
+
+func TestStripCacheControl(t *testing.T) {
+	marker := &types.CacheControl{Type: "ephemeral"}
+	req := &types.AnthropicRequest{
+		Tools:        []types.ToolDefinition{{Name: "search", CacheControl: marker}},
+		SystemBlocks: []types.ContentBlock{{Type: "text", Text: "System", CacheControl: marker}},
+		Messages: []types.Message{
+			{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: "Hi", CacheControl: marker}}},
+		},
+	}
+
+	StripCacheControl(req)
+
+	if req.Tools[0].CacheControl != nil || req.SystemBlocks[0].CacheControl != nil || req.Messages[0].Content[0].CacheControl != nil {
+		t.Errorf("Expected all markers to be removed, got %+v", req)
+	}
+}
+
+func TestNestedToolResultMarkers(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	longText := strings.Repeat("Reference material that is worth caching. ", 200)
+	newRequest := func(t *testing.T) *types.AnthropicRequest {
+		marker := &types.CacheControl{Type: "ephemeral"}
+		result := types.ContentBlock{Type: "tool_result", ToolUseID: "toolu_1"}
+		if err := result.SetNestedBlocks([]types.ContentBlock{
+			{Type: "text", Text: longText, CacheControl: marker},
+			{Type: "text", Text: longText, CacheControl: marker},
+		}); err != nil {
+			t.Fatalf("Failed to encode tool_result content: %v", err)
+		}
+
+		req := &types.AnthropicRequest{
+			Model:        "claude-3-5-sonnet-20241022",
+			SystemBlocks: []types.ContentBlock{{Type: "text", Text: longText}},
+			Messages: []types.Message{
+				{Role: "user", Content: []types.ContentBlock{result}},
+				{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: longText, CacheControl: marker}}},
+			},
+		}
+		for i := 0; i < 2; i++ {
+			req.Messages = append(req.Messages, types.Message{
+				Role:    "user",
+				Content: []types.ContentBlock{{Type: "text", Text: longText}},
+			})
+		}
+		return req
+	}
+
+	t.Run("augment counts them against the limit", func(t *testing.T) {
+		injector := NewCacheInjector(types.StrategyAggressive, "", "", logger)
+		req := newRequest(t)
+
+		metadata, err := injector.InjectCacheControl(req)
+		if err != nil {
+			t.Fatalf("InjectCacheControl failed: %v", err)
+		}
+
+		var existing []string
+		for _, bp := range metadata.ExistingBreakpoints {
+			existing = append(existing, bp.Position)
+		}
+		expected := "message_0_block_0_content_0,message_0_block_0_content_1,message_1_block_0"
+		if strings.Join(existing, ",") != expected {
+			t.Errorf("Expected existing markers %s, got %v", expected, existing)
+		}
+		if len(metadata.Breakpoints)+len(existing) > 4 {
+			t.Errorf("Expected at most 4 markers in total, got %d injected next to %d", len(metadata.Breakpoints), len(existing))
+		}
+	})
+
+	t.Run("override strips them", func(t *testing.T) {
+		injector := NewCacheInjector(types.StrategyAggressive, "", "", logger)
+		injector.clientMode = types.ClientCacheControlOverride
+		req := newRequest(t)
+
+		if _, err := injector.InjectCacheControl(req); err != nil {
+			t.Fatalf("InjectCacheControl failed: %v", err)
+		}
+
+		_, nested, err := req.Messages[0].Content[0].NestedContent()
+		if err != nil || len(nested) != 2 {
+			t.Fatalf("Expected two nested blocks, got %d (%v)", len(nested), err)
+		}
+		for i, block := range nested {
+			if block.CacheControl != nil {
+				t.Errorf("Expected the marker of nested block %d to be removed", i)
+			}
+		}
+
+		// Only injected markers remain
+		data, _ := json.Marshal(req)
+		if count := strings.Count(string(data), `"cache_control"`); count > 4 {
+			t.Errorf("Expected at most 4 markers after override, got %d", count)
+		}
+	})
+}
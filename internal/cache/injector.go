@@ -14,10 +14,14 @@ import (
 
 // CacheInjector handles intelligent cache control injection
 type CacheInjector struct {
-	tokenizer tokenizer.Tokenizer
-	pricing   *pricing.PricingCalculator
-	strategy  types.CacheStrategy
-	logger    *logrus.Logger
+	tokenizer      tokenizer.Tokenizer
+	pricing        *pricing.PricingCalculator
+	strategy       types.CacheStrategy
+	strategyConfig types.StrategyConfig // Resolved settings of strategy
+	prefixes       *PrefixRegistry      // nil when prefix tracking is disabled
+	clientMode     types.ClientCacheControlMode
+	coldStart      types.TTLColdStart // TTL of prefixes whose inter-arrival times are not known yet
+	logger         *logrus.Logger
 }
 
 // NewCacheInjector creates a new cache injector
@@ -27,10 +31,14 @@ func NewCacheInjector(strategy types.CacheStrategy, anthropicURL, apiKey string,
 	tk := tokenizer.NewAnthropicTokenizer()
 
 	return &CacheInjector{
-		tokenizer: tk,
-		pricing:   pricing.NewPricingCalculator(),
-		strategy:  strategy,
-		logger:    logger,
+		tokenizer:      tk,
+		pricing:        pricing.NewPricingCalculator(),
+		strategy:       strategy,
+		strategyConfig: types.GetStrategyConfig(strategy),
+		prefixes:       NewPrefixRegistry(defaultPrefixRegistrySize),
+		clientMode:     types.ClientCacheControlAugment,
+		coldStart:      types.TTLColdStartStrategy,
+		logger:         logger,
 	}
 }
 
@@ -77,11 +85,37 @@ func NewCacheInjectorWithConfig(strategy types.CacheStrategy, cfg *config.Config
 		tk = tokenizer.NewAnthropicTokenizer()
 	}
 
+	var prefixes *PrefixRegistry
+	if cfg.PrefixTracking {
+		prefixes = NewPrefixRegistry(defaultPrefixRegistrySize)
+	}
+
+	// Custom strategies from STRATEGIES_FILE, with the MAX_CACHE_BREAKPOINTS and TOKEN_MULTIPLIER overrides
+	strategyConfig, ok := cfg.ResolveStrategy(string(strategy))
+	if !ok {
+		logger.WithField("strategy", strategy).Warn("Unknown cache strategy, using moderate")
+		strategyConfig, _ = cfg.ResolveStrategy(string(types.StrategyModerate))
+	}
+
+	clientMode := types.ClientCacheControlMode(cfg.ClientCacheControl)
+	if clientMode == "" {
+		clientMode = types.ClientCacheControlAugment
+	}
+
+	coldStart := types.TTLColdStart(cfg.TTLColdStart)
+	if coldStart == "" {
+		coldStart = types.TTLColdStartStrategy
+	}
+
 	return &CacheInjector{
-		tokenizer: tk,
-		pricing:   pricing.NewPricingCalculator(),
-		strategy:  strategy,
-		logger:    logger,
+		tokenizer:      tk,
+		pricing:        pricing.NewPricingCalculator(),
+		strategy:       strategy,
+		strategyConfig: strategyConfig,
+		prefixes:       prefixes,
+		clientMode:     clientMode,
+		coldStart:      coldStart,
+		logger:         logger,
 	}
 }
 
@@ -95,17 +129,42 @@ func (ci *CacheInjector) GetPricing() *pricing.PricingCalculator {
 	return ci.pricing
 }
 
+// GetStrategyConfig returns the resolved settings of the injector's strategy
+func (ci *CacheInjector) GetStrategyConfig() types.StrategyConfig {
+	return ci.strategyConfig
+}
+
+// GetPrefixRegistry returns the prefix registry, or nil when prefix tracking is disabled
+func (ci *CacheInjector) GetPrefixRegistry() *PrefixRegistry {
+	return ci.prefixes
+}
+
 // CacheCandidate represents a potential cache breakpoint
 type CacheCandidate struct {
-	Position     string  // "system", "tools", "message_0_block_1", etc.
-	Tokens       int     // Token count
-	ContentType  string  // "system", "tools", "content"
-	TTL          string  // "5m" or "1h"
-	ROIScore     float64 // ROI score for prioritization
-	WriteCost    float64 // Cost to write cache
-	ReadSavings  float64 // Savings per read
-	BreakEven    int     // Requests to break even
-	Content      interface{} // Reference to the actual content (for modification)
+	Position    string      // "system", "tools", "message_0_block_1", etc.
+	Tokens      int         // Token count
+	ContentType string      // "system", "tools", "content"
+	TTL         string      // "5m" or "1h"
+	WriteCost   float64     // Cost to write cache
+	ReadSavings float64     // Savings per read
+	BreakEven   int         // Requests to break even
+	Cumulative  int         // Tokens from the start of the prompt up to and including this position
+	Content     interface{} // Reference to the actual content (for modification)
+
+	Prefix      string      // Fingerprint of the request content up to this breakpoint
+	PrefixStats PrefixStats // What earlier requests tell about this prefix
+
+	ExpectedCost map[string]float64 // Expected cost per request under each TTL, once inter-arrival times are known
+}
+
+// InjectCacheControlWithStrategy injects cache control using the given strategy instead of the
+// injector's own, e.g. when a request overrides the policy through headers. The tokenizer,
+// pricing and prefix registry are shared with the injector.
+func (ci *CacheInjector) InjectCacheControlWithStrategy(req *types.AnthropicRequest, strategy types.CacheStrategy, strategyConfig types.StrategyConfig) (*types.CacheMetadata, error) {
+	scoped := *ci
+	scoped.strategy = strategy
+	scoped.strategyConfig = strategyConfig
+	return scoped.InjectCacheControl(req)
 }
 
 // InjectCacheControl analyzes a request and injects optimal cache control
@@ -117,28 +176,86 @@ func (ci *CacheInjector) InjectCacheControl(req *types.AnthropicRequest) (*types
 		"strategy": ci.strategy,
 	}).Debug("Starting cache injection analysis")
 
-	// Get strategy configuration
-	strategyConfig := types.GetStrategyConfig(ci.strategy)
+	// Get strategy configuration; an explicit token threshold replaces the model
+	// minimum scaled by the multiplier
+	strategyConfig := ci.strategyConfig
 	minimumTokens := ci.tokenizer.GetModelMinimumTokens(req.Model)
 	adjustedMinimum := int(float64(minimumTokens) * strategyConfig.MinTokensMultiplier)
+	if strategyConfig.MinTokens > 0 {
+		adjustedMinimum = strategyConfig.MinTokens
+	}
+
+	// Markers the client set count against Anthropic's limit of 4 per request
+	existing := ci.ExistingBreakpoints(req)
+	maxBreakpoints := strategyConfig.MaxBreakpoints
+	if len(existing) > 0 {
+		ci.logger.WithFields(logrus.Fields{
+			"existing_breakpoints": len(existing),
+			"mode":                 ci.clientMode,
+		}).Debug("Request already carries cache_control markers")
+
+		switch ci.clientMode {
+		case types.ClientCacheControlRespect:
+			metadata := ci.calculateMetadata(req, nil, startTime)
+			metadata.ExistingBreakpoints = existing
+			metadata.ClientCacheControl = string(ci.clientMode)
+			return metadata, nil
+		case types.ClientCacheControlOverride:
+			StripCacheControl(req)
+		default:
+			maxBreakpoints = max(maxBreakpoints-len(existing), 0)
+		}
+	}
 
 	// Collect all cache candidates in deterministic order (system → tools → messages)
-	candidates := ci.CollectCacheCandidates(req, adjustedMinimum, strategyConfig)
+	var candidates []CacheCandidate
+	if strategyConfig.Placement == types.PlacementConversation {
+		candidates = ci.CollectConversationCandidates(req, adjustedMinimum, maxBreakpoints, strategyConfig)
+	} else {
+		candidates = ci.CollectCacheCandidates(req, adjustedMinimum, strategyConfig)
+	}
 
 	// Candidates are already in deterministic order, no sorting needed
 	// This ensures consistent breakpoint placement: system → tools → messages
 
-	// Select top candidates respecting breakpoint limit
-	maxBreakpoints := strategyConfig.MaxBreakpoints
-	if len(candidates) > maxBreakpoints {
-		candidates = candidates[:maxBreakpoints]
+	// Items the client already marked keep their marker
+	if len(existing) > 0 && ci.clientMode == types.ClientCacheControlAugment {
+		candidates = unmarkedCandidates(candidates)
 	}
 
+	// Use what earlier requests tell about each prefix: drop writes that would expire unused
+	var skipped []types.CacheBreakpoint
+	if ci.prefixes != nil {
+		candidates, skipped = ci.ApplyPrefixHistory(req, candidates)
+	}
+
+	// Select the breakpoints with the highest expected net savings and make them follow
+	// Anthropic's placement rules, next to the client markers kept, then measure what each adds
+	var kept []types.CacheBreakpoint
+	if ci.clientMode == types.ClientCacheControlAugment {
+		kept = existing
+	}
+	selected := ci.SelectBreakpoints(req, candidates, kept, maxBreakpoints, strategyConfig)
+	selected, decisions := ci.ValidatePlacement(req, candidates, selected, kept, maxBreakpoints)
+	candidates = ci.SegmentCandidates(selected, kept, req.Model)
+
 	// Apply cache control to selected candidates
 	breakpoints := ci.ApplyCacheControl(candidates)
 
+	if ci.prefixes != nil {
+		for _, candidate := range candidates {
+			ci.prefixes.RecordBreakpoint(candidate.Prefix, ttlDuration(candidate.TTL))
+		}
+	}
+
 	// Calculate metadata
 	metadata := ci.calculateMetadata(req, breakpoints, startTime)
+	metadata.SkippedBreakpoints = skipped
+	metadata.PlacementDecisions = decisions
+	if len(existing) > 0 {
+		metadata.ExistingBreakpoints = existing
+		metadata.ClientCacheControl = string(ci.clientMode)
+	}
 
 	ci.logger.WithFields(logrus.Fields{
 		"total_tokens":   metadata.TotalTokens,
@@ -152,24 +269,61 @@ func (ci *CacheInjector) InjectCacheControl(req *types.AnthropicRequest) (*types
 	return metadata, nil
 }
 
-// CollectCacheCandidates finds all potential cache breakpoints
-func (ci *CacheInjector) CollectCacheCandidates(req *types.AnthropicRequest, minTokens int, strategyConfig types.StrategyConfig) []CacheCandidate {
-	var candidates []CacheCandidate
+// ApplyPrefixHistory looks up every candidate's prefix in the registry, records this request's
+// sightings and chooses TTLs from observed inter-arrival times. Candidates that are not warm
+// and whose prefix is reused within the TTL too rarely to pay back the write are returned as
+// skipped.
+func (ci *CacheInjector) ApplyPrefixHistory(req *types.AnthropicRequest, candidates []CacheCandidate) ([]CacheCandidate, []types.CacheBreakpoint) {
+	fingerprints := prefixFingerprints(req)
 
-	// Check system content
-	if req.System != "" {
-		tokens := ci.tokenizer.CountSystemTokens(req.System)
-		if tokens >= minTokens {
-			candidate := ci.CreateCandidate("system", tokens, "system", strategyConfig.SystemTTL, req.Model, &req.System)
-			candidates = append(candidates, candidate)
+	kept := make([]CacheCandidate, 0, len(candidates))
+	var skipped []types.CacheBreakpoint
+	ttls := make(map[string]string, len(candidates))
+
+	for _, candidate := range candidates {
+		candidate.Prefix = fingerprints[candidate.Position]
+		candidate.PrefixStats = ci.prefixes.Lookup(candidate.Prefix)
+		ci.applyAdaptiveTTL(&candidate, req.Model, ci.strategyConfig)
+		stats := candidate.PrefixStats
+		ttls[candidate.Position] = candidate.TTL
+
+		// A write pays back after BreakEven-1 reads
+		if !stats.Warm && stats.Known() && stats.ExpectedReads() < float64(candidate.BreakEven-1) {
+			ci.logger.WithFields(logrus.Fields{
+				"position":       candidate.Position,
+				"tokens":         candidate.Tokens,
+				"hit_rate":       fmt.Sprintf("%.2f", stats.HitRate),
+				"expected_reads": fmt.Sprintf("%.2f", stats.ExpectedReads()),
+				"break_even":     candidate.BreakEven,
+			}).Debug("Skipping breakpoint whose prefix is rarely reused before expiring")
+			skipped = append(skipped, candidateBreakpoint(candidate))
+		} else {
+			kept = append(kept, candidate)
 		}
 	}
 
-	// Check system blocks
-	if len(req.SystemBlocks) > 0 {
-		tokens := ci.tokenizer.CountSystemBlocksTokens(req.SystemBlocks)
-		if tokens >= minTokens {
-			candidate := ci.CreateCandidate("system_blocks", tokens, "system", strategyConfig.SystemTTL, req.Model, &req.SystemBlocks)
+	// Record sightings after all lookups so a request does not count as its own reuse
+	for _, candidate := range candidates {
+		ci.prefixes.Observe(fingerprints[candidate.Position], ttlDuration(ttls[candidate.Position]))
+	}
+
+	return kept, skipped
+}
+
+// CollectCacheCandidates finds all potential cache breakpoints. Anthropic's minimum cacheable
+// length applies to the whole prefix up to a breakpoint, so positions are walked in the order
+// the prompt is rendered (tools → system → messages) and a position qualifies once the
+// cumulative prefix reaches minTokens, however small its own block is. Each candidate's Tokens
+// are those of its own segment and Cumulative those of the prefix it ends.
+func (ci *CacheInjector) CollectCacheCandidates(req *types.AnthropicRequest, minTokens int, strategyConfig types.StrategyConfig) []CacheCandidate {
+	var candidates []CacheCandidate
+	cumulative := 0
+
+	add := func(position string, tokens int, contentType, ttl string, content interface{}) {
+		cumulative += tokens
+		if cumulative >= minTokens {
+			candidate := ci.CreateCandidate(position, tokens, contentType, ttl, req.Model, content)
+			candidate.Cumulative = cumulative
 			candidates = append(candidates, candidate)
 		}
 	}
@@ -180,26 +334,42 @@ func (ci *CacheInjector) CollectCacheCandidates(req *types.AnthropicRequest, min
 		for _, tool := range req.Tools {
 			totalToolTokens += ci.tokenizer.CountToolTokens(tool)
 		}
-		if totalToolTokens >= minTokens {
-			candidate := ci.CreateCandidate("tools", totalToolTokens, "tools", strategyConfig.ToolsTTL, req.Model, &req.Tools)
-			candidates = append(candidates, candidate)
-		}
+		add("tools", totalToolTokens, "tools", ci.coldStartTTL("tools", "", strategyConfig), &req.Tools)
+	}
+
+	// Check system content
+	if req.System != "" {
+		// The request itself is the target: a string system is converted to a block when marked
+		add("system", ci.tokenizer.CountSystemTokens(req.System), "system", ci.coldStartTTL("system", "", strategyConfig), req)
+	}
+
+	// Check system blocks
+	if len(req.SystemBlocks) > 0 {
+		tokens := ci.tokenizer.CountSystemBlocksTokens(req.SystemBlocks)
+		add("system_blocks", tokens, "system", ci.coldStartTTL("system", "", strategyConfig), &req.SystemBlocks)
 	}
 
 	// Check message content blocks
 	for msgIdx, message := range req.Messages {
 		for blockIdx, block := range message.Content {
-			if block.Type == "text" && block.Text != "" {
-				tokens := ci.tokenizer.CountTokens(block.Text)
-				if tokens >= minTokens {
-					position := fmt.Sprintf("message_%d_block_%d", msgIdx, blockIdx)
-
-					// Determine TTL based on content characteristics
-					ttl := ci.DetermineTTLForContent(block.Text, strategyConfig)
+			position := fmt.Sprintf("message_%d_block_%d", msgIdx, blockIdx)
+			content := &req.Messages[msgIdx].Content[blockIdx]
 
-					candidate := ci.CreateCandidate(position, tokens, "content", ttl, req.Model, &req.Messages[msgIdx].Content[blockIdx])
-					candidates = append(candidates, candidate)
+			switch block.Type {
+			case "text":
+				if block.Text == "" {
+					continue
 				}
+				ttl := ci.coldStartTTL("content", block.Text, strategyConfig)
+				add(position, ci.tokenizer.CountTokens(block.Text), "content", ttl, content)
+
+			case "tool_result", "tool_use", "document", "image":
+				// Tool outputs, tool inputs, documents and images: often the largest payloads in agent traffic
+				add(position, ci.tokenizer.CountContentBlockTokens(block), "content", ci.coldStartTTL("content", "", strategyConfig), content)
+
+			default:
+				// Blocks that cannot carry a marker still belong to the prefix
+				cumulative += ci.tokenizer.CountContentBlockTokens(block)
 			}
 		}
 	}
@@ -207,19 +377,34 @@ func (ci *CacheInjector) CollectCacheCandidates(req *types.AnthropicRequest, min
 	return candidates
 }
 
+// SegmentCandidates sets each selected candidate's Tokens to the prefix it adds to the one
+// before it, selected or a client marker kept, which is what its cache write covers, and
+// prices it accordingly. Candidates must be in render order.
+func (ci *CacheInjector) SegmentCandidates(candidates []CacheCandidate, kept []types.CacheBreakpoint, model string) []CacheCandidate {
+	previous := 0
+	for i := range candidates {
+		candidate := &candidates[i]
+		if candidate.Cumulative == 0 {
+			continue // Not part of a cumulative walk
+		}
+
+		candidate.Tokens = candidate.Cumulative - segmentStart(candidate.Cumulative, previous, kept)
+		candidate.WriteCost, candidate.ReadSavings, candidate.BreakEven, _ = ci.pricing.EstimateBreakpointROI(model, candidate.Tokens, candidate.TTL)
+		candidate.ExpectedCost = ci.ExpectedTTLCosts(*candidate, model)
+		previous = candidate.Cumulative
+	}
+	return candidates
+}
+
 // CreateCandidate creates a cache candidate with ROI calculation
 func (ci *CacheInjector) CreateCandidate(position string, tokens int, contentType, ttl, model string, content interface{}) CacheCandidate {
 	writeCost, readSavings, breakEven, _ := ci.pricing.EstimateBreakpointROI(model, tokens, ttl)
 
-	// Calculate ROI score for prioritization
-	roiScore := ci.CalculateROIScore(tokens, writeCost, readSavings, breakEven, contentType)
-
 	return CacheCandidate{
 		Position:    position,
 		Tokens:      tokens,
 		ContentType: contentType,
 		TTL:         ttl,
-		ROIScore:    roiScore,
 		WriteCost:   writeCost,
 		ReadSavings: readSavings,
 		BreakEven:   breakEven,
@@ -227,45 +412,6 @@ func (ci *CacheInjector) CreateCandidate(position string, tokens int, contentTyp
 	}
 }
 
-// CalculateROIScore calculates a score for prioritizing cache candidates
-func (ci *CacheInjector) CalculateROIScore(tokens int, writeCost, readSavings float64, breakEven int, contentType string) float64 {
-	// Base score from savings potential
-	score := readSavings * 100 // Scale up for easier comparison
-
-	// Bonus for larger content (more likely to be reused)
-	if tokens > 2048 {
-		score *= 1.2
-	}
-	if tokens > 5000 {
-		score *= 1.3
-	}
-
-	// Content type bonuses (stable content is preferred)
-	switch contentType {
-	case "system":
-		score *= 2.0 // Highest priority - very stable
-	case "tools":
-		score *= 1.5 // High priority - fairly stable
-	case "content":
-		// Content score depends on break-even point
-		if breakEven <= 2 {
-			score *= 1.3 // Quick break-even
-		} else if breakEven <= 5 {
-			score *= 1.1 // Reasonable break-even
-		}
-		// No bonus for high break-even content
-	}
-
-	// Penalty for very high break-even points
-	if breakEven > 10 {
-		score *= 0.5
-	} else if breakEven > 20 {
-		score *= 0.2
-	}
-
-	return score
-}
-
 // DetermineTTLForContent determines appropriate TTL based on content characteristics
 func (ci *CacheInjector) DetermineTTLForContent(text string, strategyConfig types.StrategyConfig) string {
 	// Check for stable patterns that might benefit from longer caching
@@ -299,16 +445,7 @@ func (ci *CacheInjector) ApplyCacheControl(candidates []CacheCandidate) []types.
 		applied := ci.applyCacheControlToContent(candidate.Content, cacheControl)
 
 		if applied {
-			breakpoint := types.CacheBreakpoint{
-				Position:    candidate.Position,
-				Tokens:      candidate.Tokens,
-				TTL:         candidate.TTL,
-				Type:        candidate.ContentType,
-				WritePrice:  candidate.WriteCost,
-				ReadSavings: candidate.ReadSavings,
-				Timestamp:   time.Now(),
-			}
-			breakpoints = append(breakpoints, breakpoint)
+			breakpoints = append(breakpoints, candidateBreakpoint(candidate))
 
 			ci.logger.WithFields(logrus.Fields{
 				"position":      candidate.Position,
@@ -324,13 +461,38 @@ func (ci *CacheInjector) ApplyCacheControl(candidates []CacheCandidate) []types.
 	return breakpoints
 }
 
+// candidateBreakpoint describes a candidate as a breakpoint for metadata
+func candidateBreakpoint(candidate CacheCandidate) types.CacheBreakpoint {
+	breakpoint := types.CacheBreakpoint{
+		Position:    candidate.Position,
+		Tokens:      candidate.Tokens,
+		Cumulative:  candidate.Cumulative,
+		TTL:         candidate.TTL,
+		Type:        candidate.ContentType,
+		WritePrice:  candidate.WriteCost,
+		ReadSavings: candidate.ReadSavings,
+		Timestamp:   time.Now(),
+		Warm:        candidate.PrefixStats.Warm,
+
+		ExpectedCost: candidate.ExpectedCost,
+	}
+	if candidate.PrefixStats.Observations > 0 {
+		hitRate := candidate.PrefixStats.HitRate
+		breakpoint.ObservedHitRate = &hitRate
+	}
+	return breakpoint
+}
+
 // applyCacheControlToContent applies cache control to the actual content structures
 func (ci *CacheInjector) applyCacheControlToContent(content interface{}, cacheControl *types.CacheControl) bool {
 	switch v := content.(type) {
-	case *string:
-		// For system strings, we can't directly add cache control
-		// This would need to be handled at the request level
-		return true
+	case *types.AnthropicRequest:
+		// String system prompt: convert to a single text block so the marker is serialized
+		v.ConvertSystemToBlocks()
+		if len(v.SystemBlocks) > 0 {
+			v.SystemBlocks[len(v.SystemBlocks)-1].CacheControl = cacheControl
+			return true
+		}
 
 	case *[]types.ContentBlock:
 		// Add cache control to the last block
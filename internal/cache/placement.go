@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lookbackBlocks is how many blocks before a breakpoint Anthropic checks for a cached prefix
+const lookbackBlocks = 20
+
+// Placement rules and the actions taken to comply with them, as recorded in metadata
+const (
+	ruleTTLOrder = "ttl_order"
+	ruleLookback = "lookback"
+
+	actionTTLDowngraded   = "ttl_downgraded"
+	actionTTLUpgraded     = "ttl_upgraded"
+	actionBreakpointAdded = "breakpoint_added"
+	actionUnreachable     = "unreachable"
+)
+
+// placedBreakpoint is a breakpoint in the rendered prompt: a selected candidate, or a marker
+// the client set, which is never changed
+type placedBreakpoint struct {
+	block     int
+	position  string
+	ttl       string
+	candidate int // Index into the selected candidates, -1 for client markers
+}
+
+// ValidatePlacement makes the selected breakpoints follow Anthropic's placement rules, given
+// the client markers kept in the request, and reports each adjustment:
+//
+//   - Lookback: a cached prefix is found at a breakpoint or up to 20 blocks before it, so one
+//     that ends further back than that and after the previous breakpoint, such as the end of
+//     the previous request in an agent loop, is never read. When consecutive breakpoints are
+//     further apart and slots remain, the earliest candidate within reach of the later one is
+//     added; otherwise the gap is reported as unreachable.
+//   - TTL order: 1h breakpoints must come before 5m ones. A 1h breakpoint after a 5m one is
+//     downgraded, which is cheaper than upgrading everything before it; a 5m breakpoint before
+//     a client's 1h marker is upgraded.
+//
+// Both candidates and selected must be in render order; the result is too.
+func (ci *CacheInjector) ValidatePlacement(req *types.AnthropicRequest, candidates, selected []CacheCandidate, existing []types.CacheBreakpoint, maxBreakpoints int) ([]CacheCandidate, []types.PlacementDecision) {
+	blocks := blockIndexes(req)
+	var decisions []types.PlacementDecision
+
+	points := make([]placedBreakpoint, 0, len(selected)+len(existing))
+	for _, bp := range existing {
+		points = append(points, placedBreakpoint{block: blocks[bp.Position], position: bp.Position, ttl: bp.TTL, candidate: -1})
+	}
+	for i, candidate := range selected {
+		points = append(points, placedBreakpoint{block: blocks[candidate.Position], position: candidate.Position, ttl: candidate.TTL, candidate: i})
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].block < points[j].block })
+
+	// Bridge gaps longer than the lookback, from the end of the prompt backwards
+	slots := maxBreakpoints - len(selected)
+	chosen := make(map[string]bool, len(selected))
+	for _, candidate := range selected {
+		chosen[candidate.Position] = true
+	}
+	for i := len(points) - 1; i > 0; i-- {
+		for points[i].block-points[i-1].block > lookbackBlocks {
+			bridge := -1
+			for j, candidate := range candidates {
+				block := blocks[candidate.Position]
+				if !chosen[candidate.Position] && block > points[i-1].block && block < points[i].block && points[i].block-block <= lookbackBlocks {
+					bridge = j
+					break
+				}
+			}
+
+			if slots <= 0 || bridge < 0 {
+				decisions = append(decisions, types.PlacementDecision{
+					Position: points[i].position,
+					Rule:     ruleLookback,
+					Action:   actionUnreachable,
+					Detail: fmt.Sprintf("%d blocks after the previous breakpoint %s; prefixes ending more than %d blocks back are not read",
+						points[i].block-points[i-1].block, points[i-1].position, lookbackBlocks),
+				})
+				break
+			}
+
+			candidate := candidates[bridge]
+			selected = append(selected, candidate)
+			chosen[candidate.Position] = true
+			slots--
+			bridged := placedBreakpoint{block: blocks[candidate.Position], position: candidate.Position, ttl: candidate.TTL, candidate: len(selected) - 1}
+			decisions = append(decisions, types.PlacementDecision{
+				Position: candidate.Position,
+				Rule:     ruleLookback,
+				Action:   actionBreakpointAdded,
+				Detail: fmt.Sprintf("%s is %d blocks after %s, beyond the %d-block lookback",
+					points[i].position, points[i].block-points[i-1].block, points[i-1].position, lookbackBlocks),
+			})
+
+			// The new breakpoint takes index i, so the gap before it is checked next
+			points = append(points[:i], append([]placedBreakpoint{bridged}, points[i:]...)...)
+		}
+	}
+
+	// Enforce 1h before 5m; client markers are fixed and constrain the others
+	laterClient1h := make([]bool, len(points))
+	for i := len(points) - 2; i >= 0; i-- {
+		next := points[i+1]
+		laterClient1h[i] = laterClient1h[i+1] || next.candidate < 0 && next.ttl == "1h"
+	}
+	seen5m := false
+	for i := range points {
+		point := &points[i]
+		if point.candidate >= 0 {
+			switch {
+			case seen5m && point.ttl == "1h":
+				point.ttl = "5m"
+				decisions = append(decisions, types.PlacementDecision{
+					Position: point.position,
+					Rule:     ruleTTLOrder,
+					Action:   actionTTLDowngraded,
+					Detail:   "1h breakpoint after a 5m breakpoint; 1h breakpoints must come first",
+				})
+			case !seen5m && point.ttl == "5m" && laterClient1h[i]:
+				point.ttl = "1h"
+				decisions = append(decisions, types.PlacementDecision{
+					Position: point.position,
+					Rule:     ruleTTLOrder,
+					Action:   actionTTLUpgraded,
+					Detail:   "5m breakpoint before a client 1h marker; 1h breakpoints must come first",
+				})
+			}
+			selected[point.candidate].TTL = point.ttl
+		}
+		if point.ttl == "5m" {
+			seen5m = true
+		}
+	}
+
+	for _, decision := range decisions {
+		ci.logger.WithFields(logrus.Fields{
+			"position": decision.Position,
+			"rule":     decision.Rule,
+			"action":   decision.Action,
+		}).Debug(decision.Detail)
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool { return blocks[selected[i].Position] < blocks[selected[j].Position] })
+	return selected, decisions
+}
+
+// blockIndexes numbers the blocks of the rendered prompt (each tool, the system prompt or each
+// system block, each message content block) and maps every breakpoint position to the block
+// its marker is on
+func blockIndexes(req *types.AnthropicRequest) map[string]int {
+	blocks := make(map[string]int)
+	block := 0
+
+	for i := range req.Tools {
+		blocks[fmt.Sprintf("tool_%d", i)] = block
+		blocks["tools"] = block
+		block++
+	}
+
+	if req.System != "" {
+		blocks["system"] = block
+		block++
+	}
+	for i := range req.SystemBlocks {
+		blocks[fmt.Sprintf("system_block_%d", i)] = block
+		blocks["system_blocks"] = block
+		block++
+	}
+
+	for msgIdx, message := range req.Messages {
+		for blockIdx, content := range message.Content {
+			blocks[fmt.Sprintf("message_%d_block_%d", msgIdx, blockIdx)] = block
+			// Client markers inside tool_result content sit on their enclosing block
+			for nestedIdx := range nestedBlocks(content) {
+				blocks[fmt.Sprintf("message_%d_block_%d_content_%d", msgIdx, blockIdx, nestedIdx)] = block
+			}
+			block++
+		}
+	}
+
+	return blocks
+}
@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestInjectCacheControlTTLOrder(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	longText := strings.Repeat("Reference material that is worth caching. ", 200)
+	stableText := "You are reviewing the following contract. " + longText
+
+	tests := []struct {
+		name          string
+		coldStart     types.TTLColdStart
+		configure     func(*types.StrategyConfig)
+		request       func() *types.AnthropicRequest
+		expectTTLs    map[string]string
+		expectActions map[string]string
+	}{
+		{
+			name:      "System after 5m tools is downgraded",
+			configure: func(s *types.StrategyConfig) { s.ToolsTTL = "5m" },
+			request: func() *types.AnthropicRequest {
+				return &types.AnthropicRequest{
+					Model:    "claude-3-5-sonnet-20241022",
+					Tools:    []types.ToolDefinition{{Name: "search", Description: longText}},
+					System:   longText,
+					Messages: []types.Message{{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: "Hi"}}}},
+				}
+			},
+			expectTTLs:    map[string]string{"tools": "5m", "system": "5m"},
+			expectActions: map[string]string{"system": actionTTLDowngraded},
+		},
+		{
+			name:      "Stable message after 5m content is downgraded",
+			coldStart: types.TTLColdStartHeuristic,
+			request: func() *types.AnthropicRequest {
+				return &types.AnthropicRequest{
+					Model: "claude-3-5-sonnet-20241022",
+					Messages: []types.Message{
+						{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: longText}}},
+						{Role: "assistant", Content: []types.ContentBlock{{Type: "text", Text: "Noted."}}},
+						{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: stableText}}},
+					},
+				}
+			},
+			expectTTLs:    map[string]string{"message_0_block_0": "5m", "message_2_block_0": "5m"},
+			expectActions: map[string]string{"message_2_block_0": actionTTLDowngraded},
+		},
+		{
+			name: "5m content before a client 1h marker is upgraded",
+			request: func() *types.AnthropicRequest {
+				return &types.AnthropicRequest{
+					Model: "claude-3-5-sonnet-20241022",
+					Messages: []types.Message{
+						{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: longText}}},
+						{Role: "user", Content: []types.ContentBlock{{
+							Type:         "text",
+							Text:         longText,
+							CacheControl: &types.CacheControl{Type: "ephemeral", TTL: "1h"},
+						}}},
+					},
+				}
+			},
+			expectTTLs:    map[string]string{"message_0_block_0": "1h"},
+			expectActions: map[string]string{"message_0_block_0": actionTTLUpgraded},
+		},
+		{
+			name: "Compliant requests are left alone",
+			request: func() *types.AnthropicRequest {
+				return &types.AnthropicRequest{
+					Model:    "claude-3-5-sonnet-20241022",
+					System:   longText,
+					Messages: []types.Message{{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: longText}}}},
+				}
+			},
+			expectTTLs: map[string]string{"system": "1h", "message_0_block_0": "5m"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector := NewCacheInjector(types.StrategyAggressive, "", "", logger)
+			if tt.coldStart != "" {
+				injector.coldStart = tt.coldStart
+			}
+			strategyConfig := injector.GetStrategyConfig()
+			if tt.configure != nil {
+				tt.configure(&strategyConfig)
+			}
+
+			req := tt.request()
+			metadata, err := injector.InjectCacheControlWithStrategy(req, types.StrategyAggressive, strategyConfig)
+			if err != nil {
+				t.Fatalf("InjectCacheControl failed: %v", err)
+			}
+
+			ttls := make(map[string]string)
+			for _, bp := range metadata.Breakpoints {
+				ttls[bp.Position] = bp.TTL
+			}
+			for position, ttl := range tt.expectTTLs {
+				if ttls[position] != ttl {
+					t.Errorf("Expected %s to get TTL %s, got breakpoints %v", position, ttl, ttls)
+				}
+			}
+
+			actions := make(map[string]string)
+			for _, decision := range metadata.PlacementDecisions {
+				if decision.Rule != ruleTTLOrder || decision.Detail == "" {
+					t.Errorf("Unexpected decision %+v", decision)
+				}
+				actions[decision.Position] = decision.Action
+			}
+			if fmt.Sprint(actions) != fmt.Sprint(tt.expectActions) {
+				t.Errorf("Expected decisions %v, got %+v", tt.expectActions, metadata.PlacementDecisions)
+			}
+
+			// What is sent upstream has every 1h marker before the first 5m one
+			seen5m := false
+			for _, bp := range injector.ExistingBreakpoints(req) {
+				if bp.TTL == "1h" && seen5m {
+					t.Errorf("1h marker %s follows a 5m marker", bp.Position)
+				}
+				seen5m = seen5m || bp.TTL == "5m"
+			}
+		})
+	}
+}
+
+func TestValidatePlacementLookback(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	injector := NewCacheInjector(types.StrategyAggressive, "", "", logger)
+
+	// A long agent loop: one block per message, so block N is message N
+	req := &types.AnthropicRequest{Model: "claude-3-5-sonnet-20241022"}
+	var candidates []CacheCandidate
+	for i := 0; i < 50; i++ {
+		req.Messages = append(req.Messages, types.Message{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: "Step"}}})
+		candidates = append(candidates, CacheCandidate{
+			Position:    fmt.Sprintf("message_%d_block_0", i),
+			ContentType: "content",
+			TTL:         "5m",
+			Cumulative:  2000 + i*10,
+		})
+	}
+	pick := func(indexes ...int) []CacheCandidate {
+		var selected []CacheCandidate
+		for _, i := range indexes {
+			selected = append(selected, candidates[i])
+		}
+		return selected
+	}
+
+	tests := []struct {
+		name           string
+		selected       []CacheCandidate
+		existing       []types.CacheBreakpoint
+		maxBreakpoints int
+		expected       string
+		expectActions  []string
+	}{
+		{
+			name:           "Gaps within the lookback are kept",
+			selected:       pick(10, 30, 49),
+			maxBreakpoints: 4,
+			expected:       "message_10_block_0,message_30_block_0,message_49_block_0",
+		},
+		{
+			name:           "Free slots bridge a long gap",
+			selected:       pick(0, 49),
+			maxBreakpoints: 4,
+			expected:       "message_0_block_0,message_9_block_0,message_29_block_0,message_49_block_0",
+			expectActions:  []string{actionBreakpointAdded, actionBreakpointAdded},
+		},
+		{
+			name:           "Gaps without slots are reported",
+			selected:       pick(0, 49),
+			maxBreakpoints: 3,
+			expected:       "message_0_block_0,message_29_block_0,message_49_block_0",
+			expectActions:  []string{actionBreakpointAdded, actionUnreachable},
+		},
+		{
+			name:           "Client markers count as breakpoints",
+			selected:       pick(49),
+			existing:       []types.CacheBreakpoint{{Position: "message_35_block_0", TTL: "5m"}},
+			maxBreakpoints: 3,
+			expected:       "message_49_block_0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, decisions := injector.ValidatePlacement(req, candidates, tt.selected, tt.existing, tt.maxBreakpoints)
+
+			var positions []string
+			for _, candidate := range selected {
+				positions = append(positions, candidate.Position)
+			}
+			if strings.Join(positions, ",") != tt.expected {
+				t.Errorf("Expected breakpoints %s, got %v", tt.expected, positions)
+			}
+
+			var actions []string
+			for _, decision := range decisions {
+				if decision.Rule != ruleLookback {
+					t.Errorf("Unexpected decision %+v", decision)
+				}
+				actions = append(actions, decision.Action)
+			}
+			if strings.Join(actions, ",") != strings.Join(tt.expectActions, ",") {
+				t.Errorf("Expected actions %v, got %+v", tt.expectActions, decisions)
+			}
+		})
+	}
+}
+
+func TestBlockIndexes(t *testing.T) {
+	req := &types.AnthropicRequest{
+		Tools:        []types.ToolDefinition{{Name: "a"}, {Name: "b"}},
+		SystemBlocks: []types.ContentBlock{{Type: "text", Text: "One"}, {Type: "text", Text: "Two"}},
+		Messages: []types.Message{
+			{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: "Hi"}, {Type: "text", Text: "There"}}},
+			{Role: "assistant", Content: []types.ContentBlock{{Type: "text", Text: "Hello"}}},
+		},
+	}
+
+	blocks := blockIndexes(req)
+	expected := map[string]int{
+		"tool_0": 0, "tool_1": 1, "tools": 1,
+		"system_block_0": 2, "system_block_1": 3, "system_blocks": 3,
+		"message_0_block_0": 4, "message_0_block_1": 5, "message_1_block_0": 6,
+	}
+	for position, block := range expected {
+		if blocks[position] != block {
+			t.Errorf("Expected %s on block %d, got %d", position, block, blocks[position])
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"autocache/internal/types"
+)
+
+const (
+	// defaultPrefixRegistrySize bounds how many prefixes are remembered
+	defaultPrefixRegistrySize = 10000
+
+	// minPrefixObservations is how many resolved sightings are needed before the
+	// observed hit rate overrides the heuristic and a write can be skipped
+	minPrefixObservations = 3
+
+	// maxArrivalGaps is how many recent times between sightings are kept per prefix
+	maxArrivalGaps = 20
+)
+
+// PrefixStats describes what the registry knows about a prefix
+type PrefixStats struct {
+	Warm         bool    // A breakpoint was placed on this prefix and its TTL has not expired
+	Observations int     // Sightings whose TTL window has closed, either reused or expired
+	HitRate      float64 // Fraction of those sightings followed by another one within the TTL
+
+	Gaps []time.Duration // Recent times between consecutive sightings, oldest first
+}
+
+// Known reports whether there are enough observations to trust the hit rate
+func (ps PrefixStats) Known() bool {
+	return ps.Observations >= minPrefixObservations
+}
+
+// GapsKnown reports whether enough inter-arrival times were seen to choose a TTL from them
+func (ps PrefixStats) GapsKnown() bool {
+	return len(ps.Gaps) >= minPrefixObservations
+}
+
+// ReuseWithin is the fraction of observed gaps no longer than ttl, i.e. the chance that a
+// cache entry written with that TTL is read before it expires
+func (ps PrefixStats) ReuseWithin(ttl time.Duration) float64 {
+	if len(ps.Gaps) == 0 {
+		return 0
+	}
+	within := 0
+	for _, gap := range ps.Gaps {
+		if gap <= ttl {
+			within++
+		}
+	}
+	return float64(within) / float64(len(ps.Gaps))
+}
+
+// ExpectedReads estimates how many reads a write gets before expiring, treating
+// each reuse within the TTL as an independent event with the observed hit rate
+func (ps PrefixStats) ExpectedReads() float64 {
+	if ps.HitRate >= 1 {
+		return math.Inf(1)
+	}
+	return ps.HitRate / (1 - ps.HitRate)
+}
+
+// prefixEntry tracks one fingerprint across requests
+type prefixEntry struct {
+	lastSeen  time.Time
+	lastTTL   time.Duration
+	hits      int       // Sightings followed by another sighting within the TTL
+	misses    int       // Sightings whose TTL expired before the prefix was seen again
+	expiresAt time.Time // When the cache entry written for this prefix expires
+
+	gaps []time.Duration // Recent times between sightings, at most maxArrivalGaps
+}
+
+// PrefixRegistry remembers request prefixes across requests: when each was last seen,
+// whether a cache entry written for it is still alive, and how often it is reused
+// within its TTL. It is safe for concurrent use.
+type PrefixRegistry struct {
+	mu         sync.Mutex
+	entries    map[string]*prefixEntry
+	maxEntries int
+	now        func() time.Time
+}
+
+// NewPrefixRegistry creates a registry that remembers at most maxEntries prefixes
+func NewPrefixRegistry(maxEntries int) *PrefixRegistry {
+	if maxEntries <= 0 {
+		maxEntries = defaultPrefixRegistrySize
+	}
+
+	return &PrefixRegistry{
+		entries:    make(map[string]*prefixEntry),
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+// Lookup returns what is known about a prefix without recording a sighting
+func (pr *PrefixRegistry) Lookup(fingerprint string) PrefixStats {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	entry, ok := pr.entries[fingerprint]
+	if !ok {
+		return PrefixStats{}
+	}
+
+	now := pr.now()
+	hits, misses := entry.hits, entry.misses
+	if now.Sub(entry.lastSeen) > entry.lastTTL {
+		misses++ // The latest sighting already expired unused
+	}
+
+	stats := PrefixStats{
+		Warm:         now.Before(entry.expiresAt),
+		Observations: hits + misses,
+		Gaps:         append([]time.Duration(nil), entry.gaps...),
+	}
+	if stats.Observations > 0 {
+		stats.HitRate = float64(hits) / float64(stats.Observations)
+	}
+	return stats
+}
+
+// Observe records that a request contained the prefix, whether or not it was cached
+func (pr *PrefixRegistry) Observe(fingerprint string, ttl time.Duration) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	now := pr.now()
+	entry, ok := pr.entries[fingerprint]
+	if !ok {
+		pr.evictIfFull()
+		entry = &prefixEntry{}
+		pr.entries[fingerprint] = entry
+	} else {
+		gap := now.Sub(entry.lastSeen)
+		if gap <= entry.lastTTL {
+			entry.hits++
+		} else {
+			entry.misses++
+		}
+		entry.gaps = append(entry.gaps, gap)
+		if len(entry.gaps) > maxArrivalGaps {
+			entry.gaps = entry.gaps[len(entry.gaps)-maxArrivalGaps:]
+		}
+	}
+
+	entry.lastSeen = now
+	entry.lastTTL = ttl
+}
+
+// RecordBreakpoint records that a breakpoint was placed on the prefix. It returns true
+// when the cache entry was still alive (a read), false when it has to be written.
+// Either way the entry lives for another TTL, since reads refresh it.
+func (pr *PrefixRegistry) RecordBreakpoint(fingerprint string, ttl time.Duration) bool {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	now := pr.now()
+	entry, ok := pr.entries[fingerprint]
+	if !ok {
+		pr.evictIfFull()
+		entry = &prefixEntry{lastSeen: now, lastTTL: ttl}
+		pr.entries[fingerprint] = entry
+	}
+
+	read := now.Before(entry.expiresAt)
+	if expiresAt := now.Add(ttl); expiresAt.After(entry.expiresAt) {
+		entry.expiresAt = expiresAt
+	}
+	return read
+}
+
+// Len returns the number of prefixes remembered
+func (pr *PrefixRegistry) Len() int {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return len(pr.entries)
+}
+
+// evictIfFull drops the least recently seen tenth of the entries when the registry is full.
+// Callers must hold the lock.
+func (pr *PrefixRegistry) evictIfFull() {
+	if len(pr.entries) < pr.maxEntries {
+		return
+	}
+
+	type seen struct {
+		fingerprint string
+		lastSeen    time.Time
+	}
+	all := make([]seen, 0, len(pr.entries))
+	for fingerprint, entry := range pr.entries {
+		all = append(all, seen{fingerprint, entry.lastSeen})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].lastSeen.Before(all[j].lastSeen)
+	})
+
+	evict := max(len(all)/10, 1)
+	for _, s := range all[:evict] {
+		delete(pr.entries, s.fingerprint)
+	}
+}
+
+// prefixFingerprints fingerprints the cumulative content of a request up to every position a
+// breakpoint can be placed at, keyed like CacheCandidate.Position. Content is chained in the
+// order Anthropic renders the prompt (tools → system → messages) and includes the model, since
+// caches are per model. Cache control markers are ignored so placement does not change the key.
+func prefixFingerprints(req *types.AnthropicRequest) map[string]string {
+	fingerprints := make(map[string]string)
+	chain := newPrefixChain(req.Model)
+
+	for _, tool := range req.Tools {
+		tool.CacheControl = nil
+		chain.add(tool)
+	}
+	if len(req.Tools) > 0 {
+		fingerprints["tools"] = chain.fingerprint()
+	}
+
+	if req.System != "" {
+		chain.add(req.System)
+		fingerprints["system"] = chain.fingerprint()
+	}
+	for _, block := range req.SystemBlocks {
+		block.CacheControl = nil
+		chain.add(block)
+	}
+	if len(req.SystemBlocks) > 0 {
+		fingerprints["system_blocks"] = chain.fingerprint()
+	}
+
+	for msgIdx, message := range req.Messages {
+		chain.add("role:" + message.Role)
+		for blockIdx, block := range message.Content {
+			block.CacheControl = nil
+			chain.add(block)
+			fingerprints[fmt.Sprintf("message_%d_block_%d", msgIdx, blockIdx)] = chain.fingerprint()
+		}
+	}
+
+	return fingerprints
+}
+
+// prefixChain hashes a sequence of values so each step commits to everything before it
+type prefixChain struct {
+	sum [sha256.Size]byte
+}
+
+func newPrefixChain(model string) *prefixChain {
+	return &prefixChain{sum: sha256.Sum256([]byte("model:" + model))}
+}
+
+func (pc *prefixChain) add(value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data = []byte(fmt.Sprintf("%v", value))
+	}
+	pc.sum = sha256.Sum256(append(pc.sum[:], data...))
+}
+
+func (pc *prefixChain) fingerprint() string {
+	return hex.EncodeToString(pc.sum[:16])
+}
+
+// ttlDuration converts a cache TTL ("5m" or "1h") to a duration, defaulting to 5 minutes
+func ttlDuration(ttl string) time.Duration {
+	if d, err := time.ParseDuration(ttl); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeClock is a controllable time source for the prefix registry
+type fakeClock struct {
+	now time.Time
+}
+
+func (fc *fakeClock) Now() time.Time { return fc.now }
+
+func (fc *fakeClock) Advance(d time.Duration) { fc.now = fc.now.Add(d) }
+
+func newTestRegistry() (*PrefixRegistry, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)}
+	registry := NewPrefixRegistry(100)
+	registry.now = clock.Now
+	return registry, clock
+}
+
+func TestPrefixRegistryWarmth(t *testing.T) {
+	registry, clock := newTestRegistry()
+	ttl := 5 * time.Minute
+
+	if stats := registry.Lookup("abc"); stats.Warm || stats.Observations != 0 {
+		t.Errorf("Expected unknown prefix to be cold with no observations, got %+v", stats)
+	}
+
+	if read := registry.RecordBreakpoint("abc", ttl); read {
+		t.Error("Expected first breakpoint to be a write")
+	}
+
+	clock.Advance(4 * time.Minute)
+	if !registry.Lookup("abc").Warm {
+		t.Error("Expected prefix to be warm within its TTL")
+	}
+	if read := registry.RecordBreakpoint("abc", ttl); !read {
+		t.Error("Expected breakpoint within the TTL to be a read")
+	}
+
+	// The read refreshed the entry, so it is still warm 8 minutes after the write
+	clock.Advance(4 * time.Minute)
+	if !registry.Lookup("abc").Warm {
+		t.Error("Expected read to refresh the TTL")
+	}
+
+	clock.Advance(6 * time.Minute)
+	if registry.Lookup("abc").Warm {
+		t.Error("Expected prefix to be cold after the TTL expired")
+	}
+}
+
+func TestPrefixRegistryHitRate(t *testing.T) {
+	registry, clock := newTestRegistry()
+	ttl := 5 * time.Minute
+
+	// Seen again after 1m (hit), 2m (hit), 10m (miss)
+	registry.Observe("abc", ttl)
+	for _, gap := range []time.Duration{time.Minute, 2 * time.Minute, 10 * time.Minute} {
+		clock.Advance(gap)
+		registry.Observe("abc", ttl)
+	}
+
+	stats := registry.Lookup("abc")
+	if stats.Observations != 3 || stats.HitRate < 0.66 || stats.HitRate > 0.67 {
+		t.Errorf("Expected 2 hits out of 3 observations, got %+v", stats)
+	}
+	if !stats.Known() {
+		t.Error("Expected hit rate to be known after 3 observations")
+	}
+
+	// Inter-arrival times are kept whatever the TTL was
+	if !stats.GapsKnown() || len(stats.Gaps) != 3 || stats.Gaps[2] != 10*time.Minute {
+		t.Errorf("Expected the 3 gaps to be recorded, got %v", stats.Gaps)
+	}
+	if reuse := stats.ReuseWithin(time.Hour); reuse != 1 {
+		t.Errorf("Expected every gap to fit in 1h, got %f", reuse)
+	}
+
+	// Once the latest sighting expires unused it counts as a miss
+	clock.Advance(6 * time.Minute)
+	stats = registry.Lookup("abc")
+	if stats.Observations != 4 || stats.HitRate != 0.5 {
+		t.Errorf("Expected pending expiry to count as a miss, got %+v", stats)
+	}
+}
+
+func TestPrefixRegistryEviction(t *testing.T) {
+	registry, clock := newTestRegistry()
+	registry.maxEntries = 10
+
+	for i := 0; i < 25; i++ {
+		clock.Advance(time.Second)
+		registry.Observe(strings.Repeat("x", i+1), time.Minute)
+	}
+
+	if registry.Len() > 10 {
+		t.Errorf("Expected at most 10 entries, got %d", registry.Len())
+	}
+	if _, ok := registry.entries[strings.Repeat("x", 25)]; !ok {
+		t.Error("Expected most recent entry to be kept")
+	}
+	if _, ok := registry.entries["x"]; ok {
+		t.Error("Expected least recently seen entry to be evicted")
+	}
+}
+
+func TestPrefixFingerprints(t *testing.T) {
+	base := func() *types.AnthropicRequest {
+		return &types.AnthropicRequest{
+			Model:  "claude-3-5-sonnet-20241022",
+			System: "You are helpful.",
+			Tools:  []types.ToolDefinition{{Name: "search", Description: "Search"}},
+			Messages: []types.Message{
+				{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: "First"}, {Type: "text", Text: "Second"}}},
+				{Role: "assistant", Content: []types.ContentBlock{{Type: "text", Text: "Reply"}}},
+			},
+		}
+	}
+
+	reference := prefixFingerprints(base())
+	for _, position := range []string{"tools", "system", "message_0_block_0", "message_0_block_1", "message_1_block_0"} {
+		if reference[position] == "" {
+			t.Errorf("Expected a fingerprint for %s", position)
+		}
+	}
+
+	// Cache control markers do not change the prefix
+	marked := base()
+	marked.Tools[0].CacheControl = &types.CacheControl{Type: "ephemeral"}
+	marked.Messages[0].Content[1].CacheControl = &types.CacheControl{Type: "ephemeral", TTL: "5m"}
+	if fingerprints := prefixFingerprints(marked); fingerprints["message_1_block_0"] != reference["message_1_block_0"] {
+		t.Error("Expected cache_control to be ignored")
+	}
+
+	// A later change leaves earlier prefixes untouched
+	later := base()
+	later.Messages[1].Content[0].Text = "Different reply"
+	fingerprints := prefixFingerprints(later)
+	if fingerprints["message_0_block_1"] != reference["message_0_block_1"] {
+		t.Error("Expected earlier prefix to be unchanged by a later edit")
+	}
+	if fingerprints["message_1_block_0"] == reference["message_1_block_0"] {
+		t.Error("Expected edited prefix to change")
+	}
+
+	// An earlier change (tools) changes every later prefix, including system
+	earlier := base()
+	earlier.Tools[0].Description = "Search the web"
+	fingerprints = prefixFingerprints(earlier)
+	for _, position := range []string{"tools", "system", "message_0_block_0"} {
+		if fingerprints[position] == reference[position] {
+			t.Errorf("Expected %s fingerprint to change when tools change", position)
+		}
+	}
+
+	// Caches are per model
+	otherModel := base()
+	otherModel.Model = "claude-3-haiku-20240307"
+	if prefixFingerprints(otherModel)["tools"] == reference["tools"] {
+		t.Error("Expected fingerprints to depend on the model")
+	}
+}
+
+func TestInjectCacheControlSkipsPrefixesThatExpireUnused(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	injector := NewCacheInjector(types.StrategyModerate, "", "", logger)
+	clock := &fakeClock{now: time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)}
+	injector.prefixes.now = clock.Now
+
+	newRequest := func() *types.AnthropicRequest {
+		return &types.AnthropicRequest{
+			Model:     "claude-3-5-sonnet-20241022",
+			MaxTokens: 100,
+			System:    strings.Repeat("You are a helpful assistant with detailed instructions and context. ", 100),
+			Messages: []types.Message{
+				{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: "Hello"}}},
+			},
+		}
+	}
+
+	// The same prefix arrives every two hours, longer than even the 1h TTL. Both the system
+	// prompt and the short message after it end a prefix long enough to cache.
+	for i := 0; i < 3; i++ {
+		metadata, _ := injector.InjectCacheControl(newRequest())
+		if len(metadata.Breakpoints) != 2 {
+			t.Fatalf("Request %d: expected a breakpoint while the hit rate is unknown, got %d", i, len(metadata.Breakpoints))
+		}
+		clock.Advance(2 * time.Hour)
+	}
+
+	request := newRequest()
+	metadata, _ := injector.InjectCacheControl(request)
+	if len(metadata.Breakpoints) != 0 {
+		t.Errorf("Expected write to be skipped once the prefix is known to expire unused, got %d breakpoints", len(metadata.Breakpoints))
+	}
+	if len(metadata.SkippedBreakpoints) != 2 || metadata.SkippedBreakpoints[0].Position != "system" {
+		t.Fatalf("Expected the system breakpoint to be reported as skipped, got %+v", metadata.SkippedBreakpoints)
+	}
+	if rate := metadata.SkippedBreakpoints[0].ObservedHitRate; rate == nil || *rate != 0 {
+		t.Errorf("Expected an observed hit rate of 0, got %v", rate)
+	}
+	if len(request.SystemBlocks) > 0 {
+		t.Error("Expected the request to be left unmarked")
+	}
+
+	// Requests arriving every minute raise the hit rate until the write pays back again
+	for i := 0; i < 10 && len(metadata.Breakpoints) == 0; i++ {
+		clock.Advance(time.Minute)
+		metadata, _ = injector.InjectCacheControl(newRequest())
+	}
+	if len(metadata.Breakpoints) == 0 {
+		t.Fatal("Expected breakpoints once the prefix is reused")
+	}
+	if metadata.Breakpoints[0].Warm {
+		t.Error("Expected the first breakpoint after skipping to be a write")
+	}
+
+	for i := 0; i < 10; i++ {
+		clock.Advance(time.Minute)
+		metadata, _ = injector.InjectCacheControl(newRequest())
+	}
+	if len(metadata.Breakpoints) != 2 || !metadata.Breakpoints[0].Warm || !metadata.Breakpoints[1].Warm {
+		t.Errorf("Expected warm breakpoints on the system prompt and the message, got %+v", metadata.Breakpoints)
+	}
+
+	// Gaps of a minute make the 1h premium on the system prompt pointless
+	if metadata.Breakpoints[0].TTL != "5m" {
+		t.Errorf("Expected the system TTL to follow the inter-arrival times, got %s", metadata.Breakpoints[0].TTL)
+	}
+}
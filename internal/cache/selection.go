@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"autocache/internal/types"
+)
+
+// maxExpectedReads caps the reads expected from one write, so a prefix that has always been
+// reused does not make every other consideration irrelevant
+const maxExpectedReads = 20.0
+
+// tieTolerance is the relative difference below which two sets are worth the same; splitting a
+// segment changes the floating point sum by a rounding error only
+const tieTolerance = 1e-9
+
+// Chance that a segment is unchanged in the next request, used until prefix history is known
+const (
+	stableSegmentReuse  = 0.95 // Tools and system prompts
+	historySegmentReuse = 0.99 // Earlier messages: conversations only grow at the end
+	latestSegmentReuse  = 0.8  // The latest message: the next request may ask something else
+)
+
+// SelectBreakpoints picks the set of at most maxBreakpoints candidates that maximizes expected
+// net savings, and returns it in render order. Candidates must be in render order with their
+// Cumulative tokens set.
+//
+// A breakpoint caches the segment between the previous selected breakpoint, or a client marker
+// kept after it, and itself, so its value depends on the whole set: segment tokens times the
+// expected reads of the prefix it ends times the per-token read savings, minus the per-token
+// write premium of its TTL (no premium when the prefix is warm, which also earns a read now).
+// Breakpoints that do not pay back are left out even when slots remain.
+//
+// A prefix is reused only if every segment in it is unchanged, so its reuse probability is the
+// product of its segments' probabilities and shrinks along the prompt; that is what makes an
+// intermediate breakpoint worth a slot. Expected reads follow from that probability, scaled by
+// the strategy's weight for the content type, or from the observed hit rate once known.
+//
+// The search is exhaustive over ordered subsets (dynamic programming over the last selected
+// candidate). Splitting a prefix costs nothing, so ties go to more breakpoints, which give later
+// requests more prefixes to hit, then to the strategy's priority order for the last content
+// type, then to the earlier end in render order, so identical requests always get identical
+// markers.
+func (ci *CacheInjector) SelectBreakpoints(req *types.AnthropicRequest, candidates []CacheCandidate, kept []types.CacheBreakpoint, maxBreakpoints int, strategyConfig types.StrategyConfig) []CacheCandidate {
+	n := len(candidates)
+	if n == 0 || maxBreakpoints <= 0 {
+		return nil
+	}
+
+	rates := ci.savingsRates(req, candidates, strategyConfig)
+
+	// best[m][j]: highest value of m+1 breakpoints, the last one on candidate j
+	// from[m][j]: the breakpoint before j in that set, -1 when j is the first
+	best := make([][]float64, maxBreakpoints)
+	from := make([][]int, maxBreakpoints)
+	for m := range best {
+		best[m] = make([]float64, n)
+		from[m] = make([]int, n)
+		for j := 0; j < n; j++ {
+			best[m][j] = math.Inf(-1)
+			from[m][j] = -1
+
+			if m == 0 {
+				segment := candidates[j].Cumulative - segmentStart(candidates[j].Cumulative, 0, kept)
+				best[m][j] = float64(segment) * rates[j]
+				continue
+			}
+			for i := 0; i < j; i++ {
+				if math.IsInf(best[m-1][i], -1) {
+					continue
+				}
+				segment := candidates[j].Cumulative - segmentStart(candidates[j].Cumulative, candidates[i].Cumulative, kept)
+				if value := best[m-1][i] + float64(segment)*rates[j]; value > best[m][j] {
+					best[m][j] = value
+					from[m][j] = i
+				}
+			}
+		}
+	}
+
+	// Nothing is better than a set that loses money
+	bestValue, bestM, bestJ := 0.0, -1, -1
+	for m := range best {
+		for j := 0; j < n; j++ {
+			value := best[m][j]
+			if value <= 0 {
+				continue
+			}
+			tied := math.Abs(value-bestValue) <= tieTolerance*bestValue
+			if !tied && value > bestValue || tied && (m > bestM ||
+				m == bestM && prefersEnd(candidates[j], candidates[bestJ], strategyConfig.Priority)) {
+				bestValue, bestM, bestJ = value, m, j
+			}
+		}
+	}
+	if bestM < 0 {
+		return nil
+	}
+
+	selected := make([]CacheCandidate, bestM+1)
+	for m, j := bestM, bestJ; m >= 0; m-- {
+		selected[m] = candidates[j]
+		j = from[m][j]
+	}
+	return selected
+}
+
+// savingsRates returns the expected net savings per token of a segment ending on each candidate
+func (ci *CacheInjector) savingsRates(req *types.AnthropicRequest, candidates []CacheCandidate, strategyConfig types.StrategyConfig) []float64 {
+	latest := fmt.Sprintf("message_%d_", len(req.Messages)-1)
+	weights := strategyConfig.Weights
+
+	rates := make([]float64, len(candidates))
+	reuse := 1.0
+	for i, candidate := range candidates {
+		reuse *= segmentReuse(candidate, latest)
+		expectedReads := reuse / (1 - reuse) * contentWeight(weights, candidate.ContentType)
+		if candidate.PrefixStats.Known() {
+			expectedReads = candidate.PrefixStats.ExpectedReads()
+		}
+		rates[i] = ci.netSavingsRate(candidate, math.Min(expectedReads, maxExpectedReads), req.Model)
+	}
+	return rates
+}
+
+// prefersEnd breaks a tie between two sets by the content type they end on, in priority order
+func prefersEnd(a, b CacheCandidate, priority []string) bool {
+	rank := func(contentType string) int {
+		for i, p := range priority {
+			if p == contentType {
+				return i
+			}
+		}
+		return len(priority)
+	}
+	return rank(a.ContentType) < rank(b.ContentType)
+}
+
+// segmentReuse is the prior chance that the candidate's own segment is unchanged next time
+func segmentReuse(candidate CacheCandidate, latestPrefix string) float64 {
+	switch {
+	case candidate.ContentType != "content":
+		return stableSegmentReuse
+	case strings.HasPrefix(candidate.Position, latestPrefix):
+		return latestSegmentReuse
+	}
+	return historySegmentReuse
+}
+
+// contentWeight is the strategy's multiplier on the expected reads of a content type
+func contentWeight(weights types.ScoreWeights, contentType string) float64 {
+	switch contentType {
+	case "system":
+		return weights.System
+	case "tools":
+		return weights.Tools
+	}
+	return weights.Content
+}
+
+// netSavingsRate is the expected net savings per token of a segment ending on the candidate
+func (ci *CacheInjector) netSavingsRate(candidate CacheCandidate, expectedReads float64, model string) float64 {
+	// Unknown models are priced like Claude 3.5 Sonnet, as everywhere else
+	pricing, _ := ci.pricing.GetModelPricing(model)
+	write := pricing.CacheWrite5m
+	if candidate.TTL == "1h" {
+		write = pricing.CacheWrite1h
+	}
+
+	// Per million tokens; only the ratio between sets matters
+	readSavings := pricing.InputTokens - pricing.CacheRead
+	writePremium := write - pricing.InputTokens
+
+	if candidate.PrefixStats.Warm {
+		// Already cached: this request reads it and refreshes the TTL for free
+		return readSavings * (1 + expectedReads)
+	}
+	return readSavings*expectedReads - writePremium
+}
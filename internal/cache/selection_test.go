@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+func loadScenario(t *testing.T, name string) *types.AnthropicRequest {
+	t.Helper()
+
+	data, err := os.ReadFile("../../test_data/scenarios/" + name + "/input.json")
+	if err != nil {
+		t.Fatalf("Failed to read scenario %s: %v", name, err)
+	}
+
+	var req types.AnthropicRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatalf("Failed to parse scenario %s: %v", name, err)
+	}
+	return &req
+}
+
+// setValue is the expected net savings of a set of breakpoints, given by candidate index
+func setValue(candidates []CacheCandidate, rates []float64, set []int) float64 {
+	value, previous := 0.0, 0
+	for _, i := range set {
+		value += float64(candidates[i].Cumulative-previous) * rates[i]
+		previous = candidates[i].Cumulative
+	}
+	return value
+}
+
+// bruteForceBest tries every set of at most maxBreakpoints candidates
+func bruteForceBest(candidates []CacheCandidate, rates []float64, maxBreakpoints int) float64 {
+	best := 0.0
+	for mask := 1; mask < 1<<len(candidates); mask++ {
+		var set []int
+		for i := range candidates {
+			if mask&(1<<i) != 0 {
+				set = append(set, i)
+			}
+		}
+		if len(set) <= maxBreakpoints {
+			best = math.Max(best, setValue(candidates, rates, set))
+		}
+	}
+	return best
+}
+
+func TestSelectBreakpointsScenarios(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	tests := []struct {
+		scenario       string
+		strategy       types.CacheStrategy
+		maxBreakpoints int
+		expected       []string
+	}{
+		{"maximum_breakpoints", types.StrategyAggressive, 4, []string{"tools", "system", "message_0_block_0", "message_2_block_0"}},
+		// With fewer slots the stable history beats the latest message, which may change
+		{"maximum_breakpoints", types.StrategyAggressive, 2, []string{"system", "message_1_block_0"}},
+		{"maximum_breakpoints", types.StrategyModerate, 4, []string{"tools", "system", "message_0_block_0", "message_2_block_0"}},
+		{"progressive_content", types.StrategyAggressive, 4, []string{"message_3_block_0", "message_4_block_0"}},
+		{"progressive_content", types.StrategyAggressive, 1, []string{"message_3_block_0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s/%d", tt.scenario, tt.strategy, tt.maxBreakpoints), func(t *testing.T) {
+			injector := NewCacheInjector(tt.strategy, "", "", logger)
+			strategyConfig := injector.GetStrategyConfig()
+
+			req := loadScenario(t, tt.scenario)
+			minimum := int(float64(injector.tokenizer.GetModelMinimumTokens(req.Model)) * strategyConfig.MinTokensMultiplier)
+			candidates := injector.CollectCacheCandidates(req, minimum, strategyConfig)
+
+			selected := injector.SelectBreakpoints(req, candidates, nil, tt.maxBreakpoints, strategyConfig)
+
+			var positions []string
+			var set []int
+			for _, s := range selected {
+				positions = append(positions, s.Position)
+				for i, c := range candidates {
+					if c.Position == s.Position {
+						set = append(set, i)
+					}
+				}
+				if s.Cumulative < minimum {
+					t.Errorf("Breakpoint %s ends a prefix of %d tokens, below the minimum %d", s.Position, s.Cumulative, minimum)
+				}
+			}
+			if strings.Join(positions, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected breakpoints %v, got %v", tt.expected, positions)
+			}
+
+			// No other set of breakpoints is worth more
+			rates := injector.savingsRates(req, candidates, strategyConfig)
+			got, best := setValue(candidates, rates, set), bruteForceBest(candidates, rates, tt.maxBreakpoints)
+			if math.Abs(got-best) > 1e-9*best {
+				t.Errorf("Expected the optimal value %f, got %f", best, got)
+			}
+		})
+	}
+}
+
+func TestSelectBreakpointsDeterministic(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	for _, scenario := range []string{"maximum_breakpoints", "progressive_content"} {
+		t.Run(scenario, func(t *testing.T) {
+			var outputs []string
+			for i := 0; i < 5; i++ {
+				// A fresh injector each time: prefix history would otherwise change the plan
+				injector := NewCacheInjector(types.StrategyAggressive, "", "", logger)
+				req := loadScenario(t, scenario)
+				if _, err := injector.InjectCacheControl(req); err != nil {
+					t.Fatalf("InjectCacheControl failed: %v", err)
+				}
+				data, err := json.Marshal(req)
+				if err != nil {
+					t.Fatalf("Failed to marshal request: %v", err)
+				}
+				outputs = append(outputs, string(data))
+			}
+			for i := 1; i < len(outputs); i++ {
+				if outputs[i] != outputs[0] {
+					t.Fatalf("Expected identical markers for identical requests, run %d differs", i)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectBreakpointsPrefersWarm(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	injector := NewCacheInjector(types.StrategyModerate, "", "", logger)
+
+	req := &types.AnthropicRequest{Model: "claude-3-5-sonnet-20241022", Messages: make([]types.Message, 3)}
+	candidates := []CacheCandidate{
+		{Position: "system", ContentType: "system", TTL: "1h", Cumulative: 2000},
+		{Position: "message_0_block_0", ContentType: "content", TTL: "5m", Cumulative: 3000},
+		{Position: "message_1_block_0", ContentType: "content", TTL: "5m", Cumulative: 3100, PrefixStats: PrefixStats{Warm: true}},
+		{Position: "message_2_block_0", ContentType: "content", TTL: "5m", Cumulative: 3200},
+	}
+
+	selected := injector.SelectBreakpoints(req, candidates, nil, 2, injector.GetStrategyConfig())
+
+	var positions []string
+	for _, c := range selected {
+		positions = append(positions, c.Position)
+	}
+	if strings.Join(positions, ",") != "system,message_1_block_0" {
+		t.Errorf("Expected the warm prefix to be kept in render order, got %v", positions)
+	}
+
+	if len(injector.SelectBreakpoints(req, candidates, nil, 4, injector.GetStrategyConfig())) != 4 {
+		t.Error("Expected all candidates when under the limit")
+	}
+}
+
+func TestSelectBreakpointsSkipsLosingWrites(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	injector := NewCacheInjector(types.StrategyModerate, "", "", logger)
+
+	req := &types.AnthropicRequest{Model: "claude-3-5-sonnet-20241022", Messages: make([]types.Message, 1)}
+	never := PrefixStats{Observations: 5, HitRate: 0}
+	candidates := []CacheCandidate{
+		{Position: "system", ContentType: "system", TTL: "1h", Cumulative: 2000, PrefixStats: never},
+		{Position: "message_0_block_0", ContentType: "content", TTL: "5m", Cumulative: 3000, PrefixStats: never},
+	}
+
+	if selected := injector.SelectBreakpoints(req, candidates, nil, 4, injector.GetStrategyConfig()); len(selected) != 0 {
+		t.Errorf("Expected no breakpoints when no write pays back, got %+v", selected)
+	}
+}
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"sort"
+	"time"
+
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// cacheTTLs are the TTLs Anthropic offers, cheapest write first
+var cacheTTLs = []string{"5m", "1h"}
+
+// coldStartTTL is the TTL of a candidate of the content type before anything is known about
+// how often its prefix recurs; text is the block's text when it is message text
+func (ci *CacheInjector) coldStartTTL(contentType, text string, strategyConfig types.StrategyConfig) string {
+	configured := strategyConfig.TTL(contentType)
+	if strategyConfig.TTLFixed(contentType) {
+		return configured
+	}
+
+	switch ci.coldStart {
+	case types.TTLColdStart5m, types.TTLColdStart1h:
+		return string(ci.coldStart)
+	case types.TTLColdStartHeuristic:
+		if text != "" {
+			return ci.DetermineTTLForContent(text, strategyConfig)
+		}
+	}
+	return configured
+}
+
+// ExpectedTTLCosts returns the expected cost per request of caching the candidate's tokens with
+// each TTL: a read when the prefix recurs before the entry expires, a write otherwise. It is nil
+// until enough inter-arrival times of the prefix were observed.
+func (ci *CacheInjector) ExpectedTTLCosts(candidate CacheCandidate, model string) map[string]float64 {
+	if !candidate.PrefixStats.GapsKnown() {
+		return nil
+	}
+
+	// Unknown models are priced like Claude 3.5 Sonnet, as everywhere else
+	pricing, _ := ci.pricing.GetModelPricing(model)
+	millions := float64(candidate.Tokens) / 1_000_000
+
+	costs := make(map[string]float64, len(cacheTTLs))
+	for _, ttl := range cacheTTLs {
+		write := pricing.CacheWrite5m
+		if ttl == "1h" {
+			write = pricing.CacheWrite1h
+		}
+		reuse := candidate.PrefixStats.ReuseWithin(ttlDuration(ttl))
+		costs[ttl] = millions * (reuse*pricing.CacheRead + (1-reuse)*write)
+	}
+	return costs
+}
+
+// applyAdaptiveTTL gives the candidate the TTL with the lowest expected cost once its prefix's
+// inter-arrival times are known: the 1h premium only pays when gaps regularly exceed 5 minutes
+// but stay under an hour. Content types with fixed TTLs keep theirs; the costs are reported
+// either way, and the hit rate becomes the share of gaps within the TTL used.
+func (ci *CacheInjector) applyAdaptiveTTL(candidate *CacheCandidate, model string, strategyConfig types.StrategyConfig) {
+	candidate.ExpectedCost = ci.ExpectedTTLCosts(*candidate, model)
+	if candidate.ExpectedCost == nil {
+		return
+	}
+
+	ttl := cacheTTLs[0]
+	for _, option := range cacheTTLs[1:] {
+		if candidate.ExpectedCost[option] < candidate.ExpectedCost[ttl] {
+			ttl = option
+		}
+	}
+	if !strategyConfig.TTLFixed(candidate.ContentType) && ttl != candidate.TTL {
+		ci.setTTL(candidate, ttl, model)
+	}
+	candidate.PrefixStats.HitRate = candidate.PrefixStats.ReuseWithin(ttlDuration(candidate.TTL))
+}
+
+// setTTL changes the candidate's TTL and re-prices it
+func (ci *CacheInjector) setTTL(candidate *CacheCandidate, ttl, model string) {
+	ci.logger.WithFields(logrus.Fields{
+		"position":   candidate.Position,
+		"from":       candidate.TTL,
+		"to":         ttl,
+		"median_gap": medianGap(candidate.PrefixStats.Gaps).String(),
+		"cost_5m":    candidate.ExpectedCost["5m"],
+		"cost_1h":    candidate.ExpectedCost["1h"],
+	}).Debug("Choosing TTL from observed inter-arrival times")
+
+	candidate.TTL = ttl
+	candidate.WriteCost, candidate.ReadSavings, candidate.BreakEven, _ = ci.pricing.EstimateBreakpointROI(model, candidate.Tokens, ttl)
+}
+
+// medianGap returns the median of the gaps, for logging
+func medianGap(gaps []time.Duration) time.Duration {
+	if len(gaps) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), gaps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
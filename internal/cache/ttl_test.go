@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAdaptiveTTL(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	system := strings.Repeat("You are a helpful assistant with detailed instructions and context. ", 100)
+
+	tests := []struct {
+		name       string
+		gap        time.Duration
+		fixedTTL   bool
+		coldStart  types.TTLColdStart
+		expectCold string // System TTL before the gaps are known
+		expectTTL  string // System TTL once they are
+	}{
+		{name: "Frequent requests only need 5m", gap: time.Minute, expectCold: "1h", expectTTL: "5m"},
+		{name: "Gaps between 5m and 1h pay for 1h", gap: 20 * time.Minute, coldStart: types.TTLColdStart5m, expectCold: "5m", expectTTL: "1h"},
+		{name: "Gaps beyond 1h fall back to the cheaper write", gap: 2 * time.Hour, expectCold: "1h", expectTTL: "5m"},
+		{name: "Fixed TTLs are kept", gap: time.Minute, fixedTTL: true, coldStart: types.TTLColdStart5m, expectCold: "1h", expectTTL: "1h"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector := NewCacheInjector(types.StrategyModerate, "", "", logger)
+			clock := &fakeClock{now: time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)}
+			injector.prefixes.now = clock.Now
+			if tt.coldStart != "" {
+				injector.coldStart = tt.coldStart
+			}
+			strategyConfig := injector.GetStrategyConfig()
+			strategyConfig.FixedTTL = tt.fixedTTL
+
+			// The system prompt alone, so only its own breakpoint is considered
+			newRequest := func() *types.AnthropicRequest {
+				return &types.AnthropicRequest{
+					Model:    "claude-3-5-sonnet-20241022",
+					System:   system,
+					Messages: []types.Message{{Role: "user", Content: []types.ContentBlock{{Type: "text", Text: "Hi"}}}},
+				}
+			}
+			// Breakpoints that never pay back are skipped, but still get a TTL and costs
+			systemBreakpoint := func(metadata *types.CacheMetadata) *types.CacheBreakpoint {
+				for _, breakpoints := range [][]types.CacheBreakpoint{metadata.Breakpoints, metadata.SkippedBreakpoints} {
+					for i := range breakpoints {
+						if breakpoints[i].Position == "system" {
+							return &breakpoints[i]
+						}
+					}
+				}
+				return nil
+			}
+
+			// Each request after the first adds a gap; the one after that many gaps uses them
+			for i := 0; i <= minPrefixObservations+1; i++ {
+				if i > 0 {
+					clock.Advance(tt.gap)
+				}
+				metadata, _ := injector.InjectCacheControlWithStrategy(newRequest(), types.StrategyModerate, strategyConfig)
+				bp := systemBreakpoint(metadata)
+				if bp == nil {
+					t.Fatalf("Request %d: expected a system breakpoint, got %+v", i, metadata.Breakpoints)
+				}
+
+				// Until enough gaps are known the cold-start TTL applies and no costs are reported
+				if i <= minPrefixObservations {
+					if bp.TTL != tt.expectCold || bp.ExpectedCost != nil {
+						t.Fatalf("Request %d: expected a %s system breakpoint without costs, got %+v", i, tt.expectCold, bp)
+					}
+					continue
+				}
+
+				if bp.TTL != tt.expectTTL {
+					t.Errorf("Expected TTL %s, got %s (costs %v)", tt.expectTTL, bp.TTL, bp.ExpectedCost)
+				}
+				if len(bp.ExpectedCost) != 2 {
+					t.Fatalf("Expected costs for both TTLs, got %v", bp.ExpectedCost)
+				}
+				if !tt.fixedTTL && bp.ExpectedCost[bp.TTL] > bp.ExpectedCost[otherTTL(bp.TTL)] {
+					t.Errorf("Expected the cheaper TTL to be chosen, got %s with costs %v", bp.TTL, bp.ExpectedCost)
+				}
+			}
+		})
+	}
+}
+
+func otherTTL(ttl string) string {
+	if ttl == "5m" {
+		return "1h"
+	}
+	return "5m"
+}
+
+func TestColdStartTTL(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	instructions := "You are reviewing the following contract. " + strings.Repeat("Clause text. ", 100)
+
+	tests := []struct {
+		coldStart types.TTLColdStart
+		fixedTTL  bool
+		text      string
+		expected  string
+	}{
+		{types.TTLColdStartStrategy, false, instructions, "5m"},
+		{types.TTLColdStartHeuristic, false, instructions, "1h"},
+		{types.TTLColdStartHeuristic, false, "Short question", "5m"},
+		{types.TTLColdStart1h, false, "Short question", "1h"},
+		{types.TTLColdStart1h, true, "Short question", "5m"},
+	}
+
+	for _, tt := range tests {
+		injector := NewCacheInjector(types.StrategyModerate, "", "", logger)
+		injector.coldStart = tt.coldStart
+		strategyConfig := injector.GetStrategyConfig()
+		strategyConfig.FixedTTL = tt.fixedTTL
+
+		if got := injector.coldStartTTL("content", tt.text, strategyConfig); got != tt.expected {
+			t.Errorf("%s (fixed=%v): expected %s, got %s", tt.coldStart, tt.fixedTTL, tt.expected, got)
+		}
+	}
+}
+
+func TestExpectedTTLCosts(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	injector := NewCacheInjector(types.StrategyModerate, "", "", logger)
+
+	candidate := CacheCandidate{Tokens: 1_000_000}
+	if injector.ExpectedTTLCosts(candidate, "claude-3-5-sonnet-20241022") != nil {
+		t.Error("Expected no costs before inter-arrival times are known")
+	}
+
+	// Half the gaps within 5m, all within 1h: 5m costs 0.5*0.30 + 0.5*3.75, 1h costs 0.30
+	candidate.PrefixStats.Gaps = []time.Duration{time.Minute, 2 * time.Minute, 30 * time.Minute, 40 * time.Minute}
+	costs := injector.ExpectedTTLCosts(candidate, "claude-3-5-sonnet-20241022")
+	if costs["5m"] < 2.02 || costs["5m"] > 2.03 || costs["1h"] < 0.29 || costs["1h"] > 0.31 {
+		t.Errorf("Unexpected costs %v", costs)
+	}
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"compress/gzip"
 	"encoding/json"
 	"fmt"
@@ -10,15 +11,26 @@ import (
 	"strings"
 	"time"
 
+	"autocache/internal/config"
 	"autocache/internal/types"
 
 	"github.com/sirupsen/logrus"
 )
 
+// DefaultAnthropicVersion is the anthropic-version sent when neither the client nor the
+// configuration sets one
+const DefaultAnthropicVersion = "2023-06-01"
+
 // ProxyClient handles communication with the Anthropic API
 type ProxyClient struct {
 	httpClient  *http.Client
 	anthropicURL string
+	anthropicVersion string // Sent when the client sends no anthropic-version
+	dialect     Dialect // Request format of the upstream
+	retry       RetryPolicy
+	breaker     *circuitBreaker // nil when disabled
+	onRetry     func(model, reason string)
+	sleep       func(ctx context.Context, d time.Duration) error // Waits between retries
 	logger      *logrus.Logger
 }
 
@@ -29,59 +41,81 @@ func NewProxyClient(anthropicURL string, logger *logrus.Logger) *ProxyClient {
 			Timeout: 300 * time.Second, // 5 minute timeout for long requests
 		},
 		anthropicURL: anthropicURL,
+		anthropicVersion: DefaultAnthropicVersion,
+		dialect:      AnthropicDialect{},
+		sleep:        sleepContext,
 		logger:       logger,
 	}
 }
 
-// ForwardRequest forwards a request to the Anthropic API
-func (pc *ProxyClient) ForwardRequest(req *types.AnthropicRequest, headers map[string]string) (*http.Response, error) {
-	// Serialize the request
-	requestBody, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+// NewProxyClientWithConfig creates a new proxy client for the configured upstream
+func NewProxyClientWithConfig(cfg *config.Config, logger *logrus.Logger) *ProxyClient {
+	pc := NewProxyClient(cfg.AnthropicURL, logger)
+	if cfg.AnthropicVersion != "" {
+		pc.anthropicVersion = cfg.AnthropicVersion
 	}
+	pc.dialect = NewDialect(cfg)
+	pc.retry = RetryPolicy{
+		MaxRetries: cfg.UpstreamMaxRetries,
+		BaseDelay:  cfg.UpstreamRetryBaseDelay,
+		MaxDelay:   cfg.UpstreamRetryMaxDelay,
+	}
+	pc.breaker = newCircuitBreaker(cfg.CircuitBreakerThreshold, cfg.CircuitBreakerCooldown)
+	return pc
+}
 
-	pc.logger.WithFields(logrus.Fields{
-		"model":     req.Model,
-		"url":       pc.anthropicURL + "/v1/messages",
-		"body_size": len(requestBody),
-	}).Debug("Forwarding request to Anthropic API")
-
-	// Create HTTP request
-	httpReq, err := http.NewRequest("POST", pc.anthropicURL+"/v1/messages", bytes.NewBuffer(requestBody))
+// newMessagesRequest builds the upstream request of a Messages API request in the upstream's
+// dialect, with the headers to forward
+func (pc *ProxyClient) newMessagesRequest(req *types.AnthropicRequest, headers http.Header, streaming bool) (*http.Request, error) {
+	httpReq, err := http.NewRequest("POST", pc.dialect.MessagesURL(pc.anthropicURL, req.Model, streaming), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
-	// Set headers
+	// Forward original headers (especially Authorization) with all their values
+	pc.setHeaders(httpReq, headers)
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("anthropic-version", "2023-06-01") // Required by Anthropic API
-
-	// Forward original headers (especially Authorization)
-	for key, value := range headers {
-		// Skip headers that might interfere
-		if !shouldSkipHeader(key) {
-			httpReq.Header.Set(key, value)
-			if strings.ToLower(key) == "x-api-key" {
-				pc.logger.WithFields(logrus.Fields{
-					"header_key":      key,
-					"api_key_preview": maskAPIKey(value),
-				}).Debug("Setting x-api-key header for Anthropic request")
-			}
-		}
+
+	requestBody, err := pc.dialect.EncodeBody(req, httpReq.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpReq.Body = io.NopCloser(bytes.NewReader(requestBody))
+	httpReq.ContentLength = int64(len(requestBody))
+	httpReq.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(requestBody)), nil
+	}
+
+	if err := pc.dialect.Authenticate(httpReq, requestBody); err != nil {
+		return nil, fmt.Errorf("failed to authenticate request for the %s upstream: %w", pc.dialect.Name(), err)
 	}
 
-	// Debug: Check if x-api-key was actually set
 	actualAPIKey := httpReq.Header.Get("x-api-key")
 	pc.logger.WithFields(logrus.Fields{
+		"model":             req.Model,
+		"streaming":         streaming,
+		"dialect":           pc.dialect.Name(),
+		"url":               httpReq.URL.String(),
+		"body_size":         len(requestBody),
 		"x-api-key_present": actualAPIKey != "",
 		"api_key_preview":   maskAPIKey(actualAPIKey),
-	}).Debug("Final headers before sending to Anthropic")
+	}).Debug("Forwarding request to Anthropic API")
 
-	// Make the request
-	resp, err := pc.httpClient.Do(httpReq)
+	return httpReq, nil
+}
+
+// ForwardRequest forwards a request to the Anthropic API. Retries stop when ctx is done.
+func (pc *ProxyClient) ForwardRequest(ctx context.Context, req *types.AnthropicRequest, headers http.Header) (*http.Response, error) {
+	// Make the request, retrying failed attempts
+	resp, err := pc.do(ctx, req.Model, shouldRetry, func() (*http.Request, error) {
+		return pc.newMessagesRequest(req, headers, false)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request to Anthropic API: %w", err)
+		return nil, err
+	}
+	if err := pc.dialect.DecodeResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
 	}
 
 	pc.logger.WithFields(logrus.Fields{
@@ -92,56 +126,63 @@ func (pc *ProxyClient) ForwardRequest(req *types.AnthropicRequest, headers map[s
 	return resp, nil
 }
 
-// ForwardStreamingRequest forwards a streaming request to the Anthropic API
-func (pc *ProxyClient) ForwardStreamingRequest(req *types.AnthropicRequest, headers map[string]string, responseWriter http.ResponseWriter) error {
-	// Serialize the request
-	requestBody, err := json.Marshal(req)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+// ForwardBatchRequest forwards a Message Batches create request to the Anthropic API
+func (pc *ProxyClient) ForwardBatchRequest(ctx context.Context, batch *types.BatchRequest, headers http.Header) (*http.Response, error) {
+	if err := pc.requireAnthropicDialect(); err != nil {
+		return nil, err
 	}
 
-	pc.logger.WithFields(logrus.Fields{
-		"model":     req.Model,
-		"streaming": true,
-		"url":       pc.anthropicURL + "/v1/messages",
-	}).Debug("Forwarding streaming request to Anthropic API")
-
-	// Create HTTP request
-	httpReq, err := http.NewRequest("POST", pc.anthropicURL+"/v1/messages", bytes.NewBuffer(requestBody))
+	requestBody, err := json.Marshal(batch)
 	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+		return nil, fmt.Errorf("failed to marshal batch request: %w", err)
 	}
 
-	// Set headers
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("anthropic-version", "2023-06-01") // Required by Anthropic API
-
-	// Forward original headers
-	for key, value := range headers {
-		if !shouldSkipHeader(key) {
-			httpReq.Header.Set(key, value)
-			if strings.ToLower(key) == "x-api-key" {
-				pc.logger.WithFields(logrus.Fields{
-					"header_key":      key,
-					"api_key_preview": maskAPIKey(value),
-				}).Debug("Setting x-api-key header for Anthropic streaming request")
-			}
+	url := pc.anthropicURL + "/v1/messages/batches"
+	pc.logger.WithFields(logrus.Fields{
+		"items":     len(batch.Requests),
+		"url":       url,
+		"body_size": len(requestBody),
+	}).Debug("Forwarding batch request to Anthropic API")
+
+	// Creating a batch twice would run it twice
+	resp, err := pc.do(ctx, "", shouldRetryNonIdempotent, func() (*http.Request, error) {
+		httpReq, err := http.NewRequest("POST", url, bytes.NewReader(requestBody))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 		}
+		pc.setHeaders(httpReq, headers)
+		httpReq.Header.Set("Content-Type", "application/json")
+		return httpReq, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Debug: Check if x-api-key was actually set
-	actualAPIKey := httpReq.Header.Get("x-api-key")
 	pc.logger.WithFields(logrus.Fields{
-		"x-api-key_present": actualAPIKey != "",
-		"api_key_preview":   maskAPIKey(actualAPIKey),
-	}).Debug("Final headers before sending streaming request to Anthropic")
+		"status_code":    resp.StatusCode,
+		"content_length": resp.ContentLength,
+	}).Debug("Received batch response from Anthropic API")
+
+	return resp, nil
+}
 
-	// Make the request
-	resp, err := pc.httpClient.Do(httpReq)
+// ForwardStreamingRequest forwards a streaming request to the Anthropic API.
+// Events are relayed to the client as they arrive, and the usage reported in the stream
+// is returned (nil when the stream carried none, e.g. on upstream errors). Retries and the
+// stream stop when ctx is done.
+func (pc *ProxyClient) ForwardStreamingRequest(ctx context.Context, req *types.AnthropicRequest, headers http.Header, responseWriter http.ResponseWriter) (*types.Usage, error) {
+	// Make the request. Attempts are only retried until a response is relayed, so before the
+	// first byte reaches the client.
+	resp, err := pc.do(ctx, req.Model, shouldRetry, func() (*http.Request, error) {
+		return pc.newMessagesRequest(req, headers, true)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to make request to Anthropic API: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
+	if err := pc.dialect.DecodeResponse(resp); err != nil {
+		return nil, err
+	}
 
 	// Copy response headers
 	for key, values := range resp.Header {
@@ -153,16 +194,117 @@ func (pc *ProxyClient) ForwardStreamingRequest(req *types.AnthropicRequest, head
 	// Set status code
 	responseWriter.WriteHeader(resp.StatusCode)
 
-	// Stream the response
-	_, err = io.Copy(responseWriter, resp.Body)
-	if err != nil {
+	// Compressed streams cannot be parsed, so relay them untouched
+	if resp.Header.Get("Content-Encoding") != "" {
+		pc.logger.WithField("content_encoding", resp.Header.Get("Content-Encoding")).Debug("Streaming response is encoded, usage will not be captured")
+		_, err = io.Copy(responseWriter, resp.Body)
+		if err != nil {
+			pc.logger.WithError(err).Error("Failed to stream response")
+			return nil, fmt.Errorf("failed to stream response: %w", err)
+		}
+		return nil, nil
+	}
+
+	// Stream the response event by event while capturing usage
+	tee := NewStreamUsageTee(responseWriter)
+	if err := tee.Copy(resp.Body); err != nil {
 		pc.logger.WithError(err).Error("Failed to stream response")
-		return fmt.Errorf("failed to stream response: %w", err)
+		return tee.Usage(), fmt.Errorf("failed to stream response: %w", err)
+	}
+
+	usage := tee.Usage()
+	if usage != nil {
+		pc.logger.WithFields(logrus.Fields{
+			"input_tokens":   usage.InputTokens,
+			"output_tokens":  usage.OutputTokens,
+			"cache_creation": usage.CacheCreationInputTokens,
+			"cache_read":     usage.CacheReadInputTokens,
+		}).Info("Captured usage from streaming response")
+	}
+
+	return usage, nil
+}
+
+// ForwardRaw forwards a request for any other endpoint to the same path on the Anthropic API.
+// The body is streamed as-is in both directions: the caller relays the response and closes it.
+// Only requests without a body are retried, since a streamed body cannot be sent twice.
+func (pc *ProxyClient) ForwardRaw(r *http.Request, headers http.Header) (*http.Response, error) {
+	if err := pc.requireAnthropicDialect(); err != nil {
+		return nil, err
 	}
 
+	url := pc.anthropicURL + r.URL.RequestURI()
+
+	pc.logger.WithFields(logrus.Fields{
+		"method": r.Method,
+		"url":    url,
+	}).Debug("Passing request through to Anthropic API")
+
+	var retryable func(*http.Response, error) bool
+	if r.ContentLength == 0 {
+		retryable = shouldRetry
+	}
+	resp, err := pc.do(r.Context(), "", retryable, func() (*http.Request, error) {
+		httpReq, err := http.NewRequest(r.Method, url, r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		httpReq.ContentLength = r.ContentLength
+		if r.ContentLength == 0 {
+			httpReq.Body = http.NoBody
+		}
+		pc.setHeaders(httpReq, headers)
+		return httpReq, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pc.logger.WithFields(logrus.Fields{
+		"status_code":    resp.StatusCode,
+		"content_length": resp.ContentLength,
+	}).Debug("Received passthrough response from Anthropic API")
+
+	return resp, nil
+}
+
+// DialectName returns the UPSTREAM_DIALECT of the upstream
+func (pc *ProxyClient) DialectName() string {
+	return pc.dialect.Name()
+}
+
+// requireAnthropicDialect fails with ErrUnsupportedEndpoint on upstreams that only serve the
+// Messages API
+func (pc *ProxyClient) requireAnthropicDialect() error {
+	if name := pc.dialect.Name(); name != DialectAnthropic {
+		return fmt.Errorf("%w: %s", ErrUnsupportedEndpoint, name)
+	}
 	return nil
 }
 
+// setHeaders copies the headers to forward onto the upstream request. The client's
+// anthropic-version is kept; the configured one is only sent when it set none.
+func (pc *ProxyClient) setHeaders(httpReq *http.Request, headers http.Header) {
+	for key, values := range headers {
+		if shouldSkipHeader(key) {
+			continue
+		}
+		for _, value := range values {
+			httpReq.Header.Add(key, value)
+		}
+	}
+
+	if httpReq.Header.Get("anthropic-version") == "" {
+		httpReq.Header.Set("anthropic-version", pc.anthropicVersion) // Required by Anthropic API
+	}
+
+	pc.logger.WithFields(logrus.Fields{
+		"api_key_preview":   maskAPIKey(httpReq.Header.Get("x-api-key")),
+		"anthropic_version": httpReq.Header.Get("anthropic-version"),
+		"anthropic_beta":    httpReq.Header.Values("anthropic-beta"),
+	}).Debug("Setting headers for Anthropic request")
+}
+
 // ReadAndParseResponse reads and parses a non-streaming response
 func (pc *ProxyClient) ReadAndParseResponse(resp *http.Response) (*types.AnthropicResponse, []byte, error) {
 	defer resp.Body.Close()
@@ -256,70 +398,24 @@ func (pc *ProxyClient) ValidateRequest(req *types.AnthropicRequest) error {
 	return nil
 }
 
-// ExtractAPIKey extracts the API key from request headers
+// ExtractAPIKey extracts the API key from request headers, stripping a "Bearer " prefix.
+// Use ExtractCredentials to keep OAuth bearer tokens in Authorization.
 func ExtractAPIKey(headers http.Header, logger *logrus.Logger) string {
-	// Log all headers for debugging
-	headerList := make([]string, 0)
-	for key := range headers {
-		headerList = append(headerList, key)
-	}
-	logger.WithFields(logrus.Fields{
-		"headers_present": headerList,
-	}).Debug("Extracting API key from headers")
-
-	// Check Authorization header
-	auth := headers.Get("Authorization")
-	if auth != "" {
-		logger.Debug("Found API key in Authorization header")
-		// Remove "Bearer " prefix if present
-		if strings.HasPrefix(auth, "Bearer ") {
-			return strings.TrimPrefix(auth, "Bearer ")
-		}
-		return auth
-	}
-
-	// Check x-api-key header
-	apiKey := headers.Get("x-api-key")
-	if apiKey != "" {
-		logger.WithFields(logrus.Fields{
-			"api_key_preview": maskAPIKey(apiKey),
-		}).Debug("Found API key in x-api-key header")
-		return apiKey
-	}
-
-	// Check anthropic-api-key header
-	anthropicKey := headers.Get("anthropic-api-key")
-	if anthropicKey != "" {
-		logger.Debug("Found API key in anthropic-api-key header")
-		return anthropicKey
-	}
-
-	logger.Debug("No API key found in request headers")
-	return ""
+	return ExtractCredentials(headers, AuthModeAPIKey, logger).Token
 }
 
 // SetupAuthHeader sets up the authorization header for the Anthropic API
-func SetupAuthHeader(headers map[string]string, apiKey string, logger *logrus.Logger) {
+func SetupAuthHeader(headers http.Header, apiKey string, logger *logrus.Logger) {
 	if apiKey != "" {
 		logger.WithFields(logrus.Fields{
 			"api_key_preview": maskAPIKey(apiKey),
 		}).Debug("Setting up x-api-key header for Anthropic API")
 
 		// Remove all possible variations of API key headers (case-insensitive)
-		headersToRemove := []string{}
-		for key := range headers {
-			lowerKey := strings.ToLower(key)
-			if lowerKey == "authorization" || lowerKey == "x-api-key" || lowerKey == "anthropic-api-key" {
-				headersToRemove = append(headersToRemove, key)
-			}
-		}
-		for _, key := range headersToRemove {
-			delete(headers, key)
-			logger.WithField("removed_header", key).Debug("Removed existing auth header")
-		}
+		removeAuthHeaders(headers, logger)
 
 		// Anthropic expects the API key in the x-api-key header
-		headers["x-api-key"] = apiKey
+		headers.Set("x-api-key", apiKey)
 	}
 }
 
@@ -348,39 +444,72 @@ func shouldSkipHeader(header string) bool {
 		"host":              true,
 	}
 
-	return skipHeaders[strings.ToLower(header)]
+	header = strings.ToLower(header)
+
+	// X-Autocache-* headers control the proxy and are not meant for Anthropic
+	return skipHeaders[header] || strings.HasPrefix(header, "x-autocache-")
 }
 
-// CreateHeadersMap creates a map of headers to forward
-func CreateHeadersMap(reqHeaders http.Header, apiKey string, logger *logrus.Logger) map[string]string {
-	headers := make(map[string]string)
+// CreateHeadersMap creates the headers to forward, with all their values, authenticated with creds
+func CreateHeadersMap(reqHeaders http.Header, creds Credentials, logger *logrus.Logger) http.Header {
+	headers := make(http.Header)
 
 	// Copy relevant headers
 	for key, values := range reqHeaders {
 		if !shouldSkipHeader(key) && len(values) > 0 {
-			headers[key] = values[0] // Take first value
+			headers[key] = append([]string(nil), values...)
 		}
 	}
 
 	logger.WithFields(logrus.Fields{
 		"headers_before_auth": len(headers),
-		"has_api_key":         apiKey != "",
-		"api_key_preview":     maskAPIKey(apiKey),
+		"has_api_key":         creds.Token != "",
+		"api_key_preview":     maskAPIKey(creds.Token),
+		"auth_scheme":         creds.Scheme,
 	}).Debug("Headers before authentication setup")
 
 	// Setup authentication
-	if apiKey != "" {
-		SetupAuthHeader(headers, apiKey, logger)
-	}
+	SetupAuth(headers, creds, logger)
 
 	logger.WithFields(logrus.Fields{
 		"headers_after_auth": len(headers),
-		"x-api-key_set":      headers["x-api-key"] != "",
+		"x-api-key_set":      headers.Get("x-api-key") != "",
+		"bearer_set":         headers.Get("Authorization") != "",
 	}).Debug("Headers after authentication setup")
 
 	return headers
 }
 
+// ExtendedCacheTTLBeta is the beta flag that enables 1h cache TTLs
+const ExtendedCacheTTLBeta = "extended-cache-ttl-2025-04-11"
+
+// extendedTTLModels are the model families that accept 1h cache TTLs without the beta flag
+var extendedTTLModels = []string{"claude-sonnet-4-5", "claude-haiku-4-5"}
+
+// RequiresExtendedTTLBeta reports whether 1h cache TTLs need ExtendedCacheTTLBeta on the model
+func RequiresExtendedTTLBeta(model string) bool {
+	for _, prefix := range extendedTTLModels {
+		if strings.HasPrefix(model, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddBetaFlag adds a flag to the anthropic-beta header unless the client already sent it.
+// Flags are appended to the client's, which may be comma-separated or repeated headers.
+func AddBetaFlag(headers http.Header, flag string) bool {
+	for _, value := range headers.Values("anthropic-beta") {
+		for _, existing := range strings.Split(value, ",") {
+			if strings.TrimSpace(existing) == flag {
+				return false
+			}
+		}
+	}
+	headers.Add("anthropic-beta", flag)
+	return true
+}
+
 // IsStreamingRequest checks if the request is for streaming
 func IsStreamingRequest(req *types.AnthropicRequest) bool {
 	return req.Stream != nil && *req.Stream
@@ -393,6 +522,7 @@ func (pc *ProxyClient) LogRequestSummary(req *types.AnthropicRequest) {
 		"max_tokens":    req.MaxTokens,
 		"messages":      len(req.Messages),
 		"system_length": len(req.System),
+		"system_blocks": len(req.SystemBlocks),
 		"tools":         len(req.Tools),
 		"temperature":   req.Temperature,
 		"streaming":     IsStreamingRequest(req),
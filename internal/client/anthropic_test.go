@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"autocache/internal/config"
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestForwardRequestHeaders(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	var seen http.Header
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockServer.Close()
+
+	tests := []struct {
+		name          string
+		version       string
+		headers       http.Header
+		expectVersion string
+		expectBeta    []string
+	}{
+		{
+			name:          "Default version when the client sends none",
+			headers:       http.Header{},
+			expectVersion: DefaultAnthropicVersion,
+		},
+		{
+			name:          "Configured default version",
+			version:       "2024-01-01",
+			headers:       http.Header{},
+			expectVersion: "2024-01-01",
+		},
+		{
+			name:          "Client version is respected",
+			version:       "2024-01-01",
+			headers:       http.Header{"Anthropic-Version": {"2025-02-02"}},
+			expectVersion: "2025-02-02",
+		},
+		{
+			name:          "Every beta value is forwarded",
+			headers:       http.Header{"Anthropic-Beta": {"oauth-2025-04-20", "context-1m-2025-08-07,interleaved-thinking-2025-05-14"}},
+			expectVersion: DefaultAnthropicVersion,
+			expectBeta:    []string{"oauth-2025-04-20", "context-1m-2025-08-07,interleaved-thinking-2025-05-14"},
+		},
+	}
+
+	req := &types.AnthropicRequest{Model: "claude-3-5-sonnet-20241022", MaxTokens: 10}
+	for _, tt := range tests {
+		for _, streaming := range []bool{false, true} {
+			t.Run(tt.name, func(t *testing.T) {
+				pc := NewProxyClientWithConfig(&config.Config{AnthropicURL: mockServer.URL, AnthropicVersion: tt.version}, logger)
+
+				seen = nil
+				if streaming {
+					if _, err := pc.ForwardStreamingRequest(context.Background(), req, tt.headers, httptest.NewRecorder()); err != nil {
+						t.Fatalf("ForwardStreamingRequest failed: %v", err)
+					}
+				} else {
+					resp, err := pc.ForwardRequest(context.Background(), req, tt.headers)
+					if err != nil {
+						t.Fatalf("ForwardRequest failed: %v", err)
+					}
+					resp.Body.Close()
+				}
+
+				if got := seen.Get("anthropic-version"); got != tt.expectVersion {
+					t.Errorf("Expected anthropic-version %q, got %q", tt.expectVersion, got)
+				}
+				if got := seen.Values("anthropic-beta"); !reflect.DeepEqual(got, tt.expectBeta) {
+					t.Errorf("Expected anthropic-beta %q, got %q", tt.expectBeta, got)
+				}
+				if got := seen.Get("Content-Type"); got != "application/json" {
+					t.Errorf("Expected Content-Type application/json, got %q", got)
+				}
+			})
+		}
+	}
+}
+
+func TestCreateHeadersMapKeepsAllValues(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	reqHeaders := http.Header{
+		"Anthropic-Beta":     {"oauth-2025-04-20", "files-api-2025-04-14"},
+		"X-Autocache-Bypass": {"true"},
+		"Connection":         {"keep-alive"},
+	}
+
+	headers := CreateHeadersMap(reqHeaders, Credentials{}, logger)
+
+	if got := headers.Values("anthropic-beta"); strings.Join(got, ";") != "oauth-2025-04-20;files-api-2025-04-14" {
+		t.Errorf("Expected both anthropic-beta values, got %q", got)
+	}
+	if headers.Get("X-Autocache-Bypass") != "" || headers.Get("Connection") != "" {
+		t.Errorf("Expected control and hop-by-hop headers to be dropped, got %v", headers)
+	}
+
+	// The request's headers are not modified through the copy
+	headers.Add("anthropic-beta", ExtendedCacheTTLBeta)
+	if len(reqHeaders.Values("anthropic-beta")) != 2 {
+		t.Errorf("Expected the request headers to be left alone, got %v", reqHeaders)
+	}
+}
+
+func TestAddBetaFlag(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		added    bool
+		expected []string
+	}{
+		{"No beta header", nil, true, []string{ExtendedCacheTTLBeta}},
+		{"Other flags are kept", []string{"oauth-2025-04-20"}, true, []string{"oauth-2025-04-20", ExtendedCacheTTLBeta}},
+		{"Already sent", []string{ExtendedCacheTTLBeta}, false, []string{ExtendedCacheTTLBeta}},
+		{"Already sent in a list", []string{"oauth-2025-04-20, " + ExtendedCacheTTLBeta}, false, []string{"oauth-2025-04-20, " + ExtendedCacheTTLBeta}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			for _, value := range tt.existing {
+				headers.Add("anthropic-beta", value)
+			}
+
+			if added := AddBetaFlag(headers, ExtendedCacheTTLBeta); added != tt.added {
+				t.Errorf("Expected added=%v, got %v", tt.added, added)
+			}
+			if got := headers.Values("anthropic-beta"); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected anthropic-beta %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRequiresExtendedTTLBeta(t *testing.T) {
+	tests := map[string]bool{
+		"claude-3-5-sonnet-20241022": true,
+		"claude-opus-4-1-20250805":   true,
+		"claude-sonnet-4-5-20250929": false,
+		"claude-haiku-4-5":           false,
+	}
+
+	for model, expected := range tests {
+		if got := RequiresExtendedTTLBeta(model); got != expected {
+			t.Errorf("RequiresExtendedTTLBeta(%s) = %v, expected %v", model, got, expected)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Auth modes decide how a request's credential is presented to Anthropic
+const (
+	AuthModeAuto   = "auto"    // Bearer tokens stay in Authorization unless they are API keys
+	AuthModeAPIKey = "api-key" // Every credential is sent as x-api-key
+	AuthModeBearer = "bearer"  // Authorization: Bearer is forwarded as-is, whatever the token
+)
+
+// AuthScheme is the header a credential is sent in
+type AuthScheme string
+
+const (
+	AuthSchemeAPIKey AuthScheme = "x-api-key" // x-api-key: <key>
+	AuthSchemeBearer AuthScheme = "bearer"    // Authorization: Bearer <token>, e.g. OAuth access tokens
+)
+
+// Token prefixes Anthropic issues
+const (
+	apiKeyPrefix     = "sk-ant-api"
+	oauthTokenPrefix = "sk-ant-oat"
+)
+
+// Credentials is the credential a request is forwarded with
+type Credentials struct {
+	Token  string
+	Scheme AuthScheme
+}
+
+// ExtractCredentials returns the credential of the request and the scheme to forward it with.
+// Authorization is checked first, then x-api-key and anthropic-api-key. The zero value is
+// returned when the request carries none.
+func ExtractCredentials(headers http.Header, mode string, logger *logrus.Logger) Credentials {
+	if auth := headers.Get("Authorization"); auth != "" {
+		token, bearer := strings.CutPrefix(auth, "Bearer ")
+		scheme := DetectAuthScheme(token, bearer, mode)
+		logger.WithFields(logrus.Fields{
+			"scheme":        scheme,
+			"token_preview": maskAPIKey(token),
+		}).Debug("Found credential in Authorization header")
+		return Credentials{Token: token, Scheme: scheme}
+	}
+
+	for _, header := range []string{"x-api-key", "anthropic-api-key"} {
+		if token := headers.Get(header); token != "" {
+			scheme := DetectAuthScheme(token, false, mode)
+			logger.WithFields(logrus.Fields{
+				"header":        header,
+				"scheme":        scheme,
+				"token_preview": maskAPIKey(token),
+			}).Debug("Found credential in API key header")
+			return Credentials{Token: token, Scheme: scheme}
+		}
+	}
+
+	logger.Debug("No credential found in request headers")
+	return Credentials{}
+}
+
+// DetectAuthScheme decides how to send a token, given whether the client sent it as a bearer
+// token. In auto mode bearer tokens stay bearer tokens unless they are API keys, and tokens
+// sent in an API key header move to Authorization only when they are OAuth access tokens.
+func DetectAuthScheme(token string, bearer bool, mode string) AuthScheme {
+	switch mode {
+	case AuthModeAPIKey:
+		return AuthSchemeAPIKey
+	case AuthModeBearer:
+		if bearer {
+			return AuthSchemeBearer
+		}
+		return AuthSchemeAPIKey
+	}
+
+	if bearer && !strings.HasPrefix(token, apiKeyPrefix) || strings.HasPrefix(token, oauthTokenPrefix) {
+		return AuthSchemeBearer
+	}
+	return AuthSchemeAPIKey
+}
+
+// SetupAuth replaces whatever auth headers the client sent with the credential in its scheme
+func SetupAuth(headers http.Header, creds Credentials, logger *logrus.Logger) {
+	if creds.Token == "" {
+		return
+	}
+	if creds.Scheme != AuthSchemeBearer {
+		SetupAuthHeader(headers, creds.Token, logger)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"token_preview": maskAPIKey(creds.Token),
+	}).Debug("Setting up bearer Authorization header for Anthropic API")
+
+	removeAuthHeaders(headers, logger)
+	headers.Set("Authorization", "Bearer "+creds.Token)
+}
+
+// removeAuthHeaders deletes every variation of the auth headers (case-insensitive)
+func removeAuthHeaders(headers http.Header, logger *logrus.Logger) {
+	for key := range headers {
+		switch strings.ToLower(key) {
+		case "authorization", "x-api-key", "anthropic-api-key":
+			delete(headers, key)
+			logger.WithField("removed_header", key).Debug("Removed existing auth header")
+		}
+	}
+}
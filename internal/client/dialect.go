@@ -0,0 +1,308 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"autocache/internal/config"
+	"autocache/internal/types"
+)
+
+// Upstream dialects
+const (
+	DialectAnthropic = "anthropic"
+	DialectBedrock   = "bedrock"
+	DialectVertex    = "vertex"
+)
+
+// ErrUnsupportedEndpoint is returned for endpoints other than Messages on upstreams that only
+// serve Messages (Bedrock, Vertex AI)
+var ErrUnsupportedEndpoint = errors.New("endpoint not supported by the upstream dialect")
+
+// Dialect adapts Messages API requests to an upstream's request format, and its responses
+// back to the Messages API format
+type Dialect interface {
+	// Name is the dialect's UPSTREAM_DIALECT value
+	Name() string
+
+	// MessagesURL returns the URL of a Messages API call for the model
+	MessagesURL(baseURL, model string, streaming bool) string
+
+	// EncodeBody returns the request body the upstream expects. Headers the upstream takes in
+	// the body instead are moved out of header.
+	EncodeBody(req *types.AnthropicRequest, header http.Header) ([]byte, error)
+
+	// Authenticate sets the upstream's credentials on the request, whose body is body
+	Authenticate(req *http.Request, body []byte) error
+
+	// DecodeResponse rewrites a response into the Messages API format: error bodies, and the
+	// framing of event streams
+	DecodeResponse(resp *http.Response) error
+}
+
+// Signer authenticates upstream requests, e.g. with AWS Signature Version 4
+type Signer interface {
+	Sign(req *http.Request, body []byte) error
+}
+
+// NewDialect returns the configured upstream dialect
+func NewDialect(cfg *config.Config) Dialect {
+	switch cfg.UpstreamDialect {
+	case DialectBedrock:
+		return &BedrockDialect{Signer: &SigV4Signer{
+			AccessKeyID:     cfg.AWSAccessKeyID,
+			SecretAccessKey: cfg.AWSSecretAccessKey,
+			SessionToken:    cfg.AWSSessionToken,
+			Region:          cfg.AWSRegion,
+			Service:         "bedrock",
+		}}
+	case DialectVertex:
+		return &VertexDialect{
+			ProjectID: cfg.VertexProjectID,
+			Region:    cfg.VertexRegion,
+			Signer:    &BearerTokenSigner{Token: cfg.VertexAccessToken},
+		}
+	default:
+		return AnthropicDialect{}
+	}
+}
+
+// AnthropicDialect is the first-party API: the model is in the body and the API version in
+// the anthropic-version header, and requests carry the client's or configured credentials
+type AnthropicDialect struct{}
+
+func (AnthropicDialect) Name() string { return DialectAnthropic }
+
+func (AnthropicDialect) MessagesURL(baseURL, model string, streaming bool) string {
+	return baseURL + "/v1/messages"
+}
+
+func (AnthropicDialect) EncodeBody(req *types.AnthropicRequest, header http.Header) ([]byte, error) {
+	return json.Marshal(req)
+}
+
+func (AnthropicDialect) Authenticate(req *http.Request, body []byte) error { return nil }
+
+func (AnthropicDialect) DecodeResponse(resp *http.Response) error { return nil }
+
+// BedrockAnthropicVersion is the anthropic_version of requests to Amazon Bedrock
+const BedrockAnthropicVersion = "bedrock-2023-05-31"
+
+// BedrockDialect is Amazon Bedrock's InvokeModel API: the model is in the path, streaming is
+// a separate operation framed as an AWS event stream, the API version and beta flags are in
+// the body, and requests are signed with AWS credentials
+type BedrockDialect struct {
+	Signer Signer
+}
+
+func (d *BedrockDialect) Name() string { return DialectBedrock }
+
+func (d *BedrockDialect) MessagesURL(baseURL, model string, streaming bool) string {
+	operation := "invoke"
+	if streaming {
+		operation = "invoke-with-response-stream"
+	}
+	// Model IDs contain ":", which AWS expects escaped like every reserved character
+	return baseURL + "/model/" + awsURIEncode(model) + "/" + operation
+}
+
+func (d *BedrockDialect) EncodeBody(req *types.AnthropicRequest, header http.Header) ([]byte, error) {
+	body, err := bodyFields(req)
+	if err != nil {
+		return nil, err
+	}
+	delete(body, "model")
+	delete(body, "stream")
+	body["anthropic_version"], _ = json.Marshal(BedrockAnthropicVersion)
+
+	if betas := betaFlags(header); len(betas) > 0 {
+		body["anthropic_beta"], _ = json.Marshal(betas)
+	}
+	header.Del("anthropic-version")
+	header.Del("anthropic-beta")
+
+	return json.Marshal(body)
+}
+
+func (d *BedrockDialect) Authenticate(req *http.Request, body []byte) error {
+	for _, key := range []string{"Authorization", "X-Api-Key", "Anthropic-Api-Key"} {
+		req.Header.Del(key) // Replaced by the signature
+	}
+	return d.Signer.Sign(req, body)
+}
+
+func (d *BedrockDialect) DecodeResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		return normalizeErrorBody(resp)
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/vnd.amazon.eventstream") {
+		resp.Body = newEventStreamDecoder(resp.Body)
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+		resp.Header.Set("Content-Type", "text/event-stream")
+	}
+	return nil
+}
+
+// VertexAnthropicVersion is the anthropic_version of requests to Vertex AI
+const VertexAnthropicVersion = "vertex-2023-10-16"
+
+// VertexDialect is Vertex AI's rawPredict API: the project, region and model are in the path,
+// the API version is in the body, and requests carry a Google OAuth access token. Event
+// streams use the first-party framing.
+type VertexDialect struct {
+	ProjectID string
+	Region    string
+	Signer    Signer
+}
+
+func (d *VertexDialect) Name() string { return DialectVertex }
+
+func (d *VertexDialect) MessagesURL(baseURL, model string, streaming bool) string {
+	method := "rawPredict"
+	if streaming {
+		method = "streamRawPredict"
+	}
+	return fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/anthropic/models/%s:%s",
+		baseURL, url.PathEscape(d.ProjectID), url.PathEscape(d.Region), url.PathEscape(model), method)
+}
+
+func (d *VertexDialect) EncodeBody(req *types.AnthropicRequest, header http.Header) ([]byte, error) {
+	body, err := bodyFields(req)
+	if err != nil {
+		return nil, err
+	}
+	delete(body, "model")
+	body["anthropic_version"], _ = json.Marshal(VertexAnthropicVersion)
+	header.Del("anthropic-version")
+
+	return json.Marshal(body)
+}
+
+func (d *VertexDialect) Authenticate(req *http.Request, body []byte) error {
+	req.Header.Del("x-api-key") // Anthropic API keys mean nothing to Google
+	return d.Signer.Sign(req, body)
+}
+
+func (d *VertexDialect) DecodeResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		return normalizeErrorBody(resp)
+	}
+	return nil
+}
+
+// BearerTokenSigner authenticates requests with a static bearer token. Without a token, the
+// request's own Authorization header is kept.
+type BearerTokenSigner struct {
+	Token string
+}
+
+func (s *BearerTokenSigner) Sign(req *http.Request, body []byte) error {
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		return fmt.Errorf("no bearer token for the upstream request")
+	}
+	return nil
+}
+
+// bodyFields returns the top-level fields of a request body
+func bodyFields(req *types.AnthropicRequest) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// betaFlags returns the flags of every anthropic-beta header, which may be comma-separated
+func betaFlags(header http.Header) []string {
+	var flags []string
+	for _, value := range header.Values("anthropic-beta") {
+		for _, flag := range strings.Split(value, ",") {
+			if flag = strings.TrimSpace(flag); flag != "" {
+				flags = append(flags, flag)
+			}
+		}
+	}
+	return flags
+}
+
+// normalizeErrorBody rewrites an upstream error body (AWS's {"message": ...} or Google's
+// {"error": {"message": ...}}) into a Messages API error, typed by status code. Bodies that
+// already are Messages API errors, or are compressed, are left as they are.
+func normalizeErrorBody(resp *http.Response) error {
+	if resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read error response: %w", err)
+	}
+
+	var upstream struct {
+		Type    string          `json:"type"`
+		Message string          `json:"message"`
+		Error   json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(data, &upstream) == nil && upstream.Type != "error" {
+		message := upstream.Message
+		var googleError struct {
+			Message string `json:"message"`
+		}
+		if message == "" && json.Unmarshal(upstream.Error, &googleError) == nil {
+			message = googleError.Message
+		}
+		if message == "" {
+			message = strings.TrimSpace(string(data))
+		}
+		data, _ = json.Marshal(map[string]interface{}{
+			"type": "error",
+			"error": map[string]string{
+				"type":    errorTypeForStatus(resp.StatusCode),
+				"message": message,
+			},
+		})
+		resp.Header.Set("Content-Type", "application/json")
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.Header.Del("Content-Length")
+	return nil
+}
+
+// errorTypeForStatus returns the Messages API error type of an HTTP status code
+func errorTypeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable, 529:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
@@ -0,0 +1,250 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"autocache/internal/config"
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stubSigner records the body it signs
+type stubSigner struct {
+	signed []byte
+}
+
+func (s *stubSigner) Sign(req *http.Request, body []byte) error {
+	s.signed = body
+	req.Header.Set("Authorization", "signed")
+	return nil
+}
+
+func newDialectClient(url string, dialect Dialect) *ProxyClient {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	pc := NewProxyClient(url, logger)
+	pc.dialect = dialect
+	return pc
+}
+
+func TestNewDialect(t *testing.T) {
+	tests := []struct {
+		dialect  string
+		expected string
+	}{
+		{"", DialectAnthropic},
+		{DialectAnthropic, DialectAnthropic},
+		{DialectBedrock, DialectBedrock},
+		{DialectVertex, DialectVertex},
+	}
+	for _, tt := range tests {
+		if got := NewDialect(&config.Config{UpstreamDialect: tt.dialect}).Name(); got != tt.expected {
+			t.Errorf("NewDialect(%q) = %s, expected %s", tt.dialect, got, tt.expected)
+		}
+	}
+}
+
+func TestBedrockDialect(t *testing.T) {
+	var seen *http.Request
+	var forwarded map[string]interface{}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+		body, _ := io.ReadAll(r.Body)
+		forwarded = nil
+		_ = json.Unmarshal(body, &forwarded)
+
+		if strings.HasSuffix(r.URL.Path, "/invoke-with-response-stream") {
+			w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+			_, _ = w.Write(encodeChunk(`{"type":"message_start","message":{"usage":{"input_tokens":10,"cache_read_input_tokens":3000}}}`))
+			_, _ = w.Write(encodeChunk(`{"type":"message_delta","usage":{"output_tokens":5}}`))
+			_, _ = w.Write(encodeChunk(`{"type":"message_stop"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"msg_1","type":"message","usage":{"input_tokens":10,"output_tokens":5}}`)
+	}))
+	defer mockServer.Close()
+
+	signer := &stubSigner{}
+	pc := newDialectClient(mockServer.URL, &BedrockDialect{Signer: signer})
+	req := &types.AnthropicRequest{Model: "anthropic.claude-3-5-sonnet-20241022-v2:0", MaxTokens: 10}
+	headers := http.Header{
+		"X-Api-Key":      {"sk-ant-api03-client"},
+		"Anthropic-Beta": {"context-1m-2025-08-07,extended-cache-ttl-2025-04-11"},
+	}
+
+	checkRequest := func(t *testing.T, operation string) {
+		t.Helper()
+		if seen.URL.EscapedPath() != "/model/anthropic.claude-3-5-sonnet-20241022-v2%3A0/"+operation {
+			t.Errorf("Unexpected path %s", seen.URL.EscapedPath())
+		}
+		if _, ok := forwarded["model"]; ok {
+			t.Error("Expected the model to be moved to the path")
+		}
+		if _, ok := forwarded["stream"]; ok {
+			t.Error("Expected no stream field")
+		}
+		if forwarded["anthropic_version"] != BedrockAnthropicVersion {
+			t.Errorf("Expected anthropic_version %s, got %v", BedrockAnthropicVersion, forwarded["anthropic_version"])
+		}
+		betas, _ := json.Marshal(forwarded["anthropic_beta"])
+		if string(betas) != `["context-1m-2025-08-07","extended-cache-ttl-2025-04-11"]` {
+			t.Errorf("Expected the beta flags in the body, got %s", betas)
+		}
+		if seen.Header.Get("Authorization") != "signed" || seen.Header.Get("x-api-key") != "" ||
+			seen.Header.Get("anthropic-version") != "" || seen.Header.Get("anthropic-beta") != "" {
+			t.Errorf("Unexpected headers: %v", seen.Header)
+		}
+		if !strings.Contains(string(signer.signed), `"anthropic_version"`) {
+			t.Errorf("Expected the encoded body to be signed, got %s", signer.signed)
+		}
+	}
+
+	t.Run("Non-streaming", func(t *testing.T) {
+		resp, err := pc.ForwardRequest(context.Background(), req, headers)
+		if err != nil {
+			t.Fatalf("ForwardRequest failed: %v", err)
+		}
+		parsed, _, err := pc.ReadAndParseResponse(resp)
+		if err != nil || parsed.Usage.OutputTokens != 5 {
+			t.Fatalf("Unexpected response %+v: %v", parsed, err)
+		}
+		checkRequest(t, "invoke")
+	})
+
+	t.Run("Streaming", func(t *testing.T) {
+		stream := true
+		streamReq := *req
+		streamReq.Stream = &stream
+		w := httptest.NewRecorder()
+		usage, err := pc.ForwardStreamingRequest(context.Background(), &streamReq, headers, w)
+		if err != nil {
+			t.Fatalf("ForwardStreamingRequest failed: %v", err)
+		}
+		checkRequest(t, "invoke-with-response-stream")
+
+		if w.Header().Get("Content-Type") != "text/event-stream" {
+			t.Errorf("Expected an event stream, got %v", w.Header())
+		}
+		if !strings.HasPrefix(w.Body.String(), "event: message_start\ndata: ") || !strings.HasSuffix(w.Body.String(), "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n") {
+			t.Errorf("Expected server-sent events, got %q", w.Body.String())
+		}
+		if usage == nil || usage.CacheReadInputTokens != 3000 || usage.OutputTokens != 5 {
+			t.Errorf("Expected the usage captured from the stream, got %+v", usage)
+		}
+	})
+
+	t.Run("Other endpoints", func(t *testing.T) {
+		_, err := pc.ForwardRaw(httptest.NewRequest("GET", "/v1/models", nil), http.Header{})
+		if !errors.Is(err, ErrUnsupportedEndpoint) {
+			t.Errorf("Expected ErrUnsupportedEndpoint, got %v", err)
+		}
+		_, err = pc.ForwardBatchRequest(context.Background(), &types.BatchRequest{}, http.Header{})
+		if !errors.Is(err, ErrUnsupportedEndpoint) {
+			t.Errorf("Expected ErrUnsupportedEndpoint, got %v", err)
+		}
+	})
+}
+
+func TestVertexDialect(t *testing.T) {
+	var seen *http.Request
+	var forwarded map[string]interface{}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &forwarded)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "event: message_start\n"+`data: {"type":"message_start","message":{"usage":{"input_tokens":10}}}`+"\n\n")
+	}))
+	defer mockServer.Close()
+
+	pc := newDialectClient(mockServer.URL, NewDialect(&config.Config{
+		UpstreamDialect:   DialectVertex,
+		VertexProjectID:   "my-project",
+		VertexRegion:      "us-east5",
+		VertexAccessToken: "ya29.token",
+	}))
+	stream := true
+	req := &types.AnthropicRequest{Model: "claude-sonnet-4-5@20250929", MaxTokens: 10, Stream: &stream}
+	usage, err := pc.ForwardStreamingRequest(context.Background(), req, http.Header{"X-Api-Key": {"sk-ant-api03-client"}}, httptest.NewRecorder())
+	if err != nil {
+		t.Fatalf("ForwardStreamingRequest failed: %v", err)
+	}
+
+	if seen.URL.Path != "/v1/projects/my-project/locations/us-east5/publishers/anthropic/models/claude-sonnet-4-5@20250929:streamRawPredict" {
+		t.Errorf("Unexpected path %s", seen.URL.Path)
+	}
+	if seen.Header.Get("Authorization") != "Bearer ya29.token" || seen.Header.Get("x-api-key") != "" {
+		t.Errorf("Unexpected headers: %v", seen.Header)
+	}
+	if _, ok := forwarded["model"]; ok || forwarded["anthropic_version"] != VertexAnthropicVersion || forwarded["stream"] != true {
+		t.Errorf("Unexpected body: %v", forwarded)
+	}
+	if usage == nil || usage.InputTokens != 10 {
+		t.Errorf("Expected the usage captured from the stream, got %+v", usage)
+	}
+
+	// Without a configured token, the client's bearer token is used
+	vertex := &VertexDialect{ProjectID: "p", Region: "global", Signer: &BearerTokenSigner{}}
+	httpReq := httptest.NewRequest("POST", "/", nil)
+	if err := vertex.Authenticate(httpReq, nil); err == nil {
+		t.Error("Expected an error without a bearer token")
+	}
+	httpReq.Header.Set("Authorization", "Bearer client-token")
+	if err := vertex.Authenticate(httpReq, nil); err != nil {
+		t.Errorf("Expected the client's token to be kept, got %v", err)
+	}
+}
+
+func TestNormalizeErrorBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		expected string
+	}{
+		{
+			name:     "AWS error",
+			status:   http.StatusTooManyRequests,
+			body:     `{"message":"Too many requests, please wait before trying again."}`,
+			expected: `{"error":{"message":"Too many requests, please wait before trying again.","type":"rate_limit_error"},"type":"error"}`,
+		},
+		{
+			name:     "Google error",
+			status:   http.StatusForbidden,
+			body:     `{"error":{"code":403,"message":"Permission denied","status":"PERMISSION_DENIED"}}`,
+			expected: `{"error":{"message":"Permission denied","type":"permission_error"},"type":"error"}`,
+		},
+		{
+			name:     "Anthropic error",
+			status:   529,
+			body:     `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			expected: `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+			if err := normalizeErrorBody(resp); err != nil {
+				t.Fatalf("normalizeErrorBody failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.expected || resp.ContentLength != int64(len(body)) {
+				t.Errorf("Expected %s, got %s (length %d)", tt.expected, body, resp.ContentLength)
+			}
+		})
+	}
+}
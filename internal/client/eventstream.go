@@ -0,0 +1,194 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// eventStreamDecoder reads an AWS event stream, as returned by Bedrock's
+// InvokeModelWithResponseStream, and produces the server-sent events of a Messages API stream.
+// Every chunk message carries one Messages API event, base64-encoded; exception messages
+// become error events.
+type eventStreamDecoder struct {
+	body    io.ReadCloser
+	reader  *bufio.Reader
+	pending bytes.Buffer // Decoded events not read yet
+	err     error
+}
+
+func newEventStreamDecoder(body io.ReadCloser) *eventStreamDecoder {
+	return &eventStreamDecoder{body: body, reader: bufio.NewReader(body)}
+}
+
+// Read returns decoded events, decoding the next message whenever the previous one is consumed
+func (d *eventStreamDecoder) Read(p []byte) (int, error) {
+	for d.pending.Len() == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.err = d.decodeMessage()
+	}
+	return d.pending.Read(p)
+}
+
+func (d *eventStreamDecoder) Close() error {
+	return d.body.Close()
+}
+
+// decodeMessage decodes one event stream message into pending. It returns io.EOF at the end
+// of the stream.
+func (d *eventStreamDecoder) decodeMessage() error {
+	// Prelude: total length, headers length and the prelude's CRC
+	prelude := make([]byte, 12)
+	if _, err := io.ReadFull(d.reader, prelude); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return fmt.Errorf("failed to read event stream message: %w", err)
+	}
+	totalLength := binary.BigEndian.Uint32(prelude[0:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[0:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return fmt.Errorf("event stream prelude checksum mismatch")
+	}
+	if totalLength < 16+headersLength || totalLength > 16<<20 {
+		return fmt.Errorf("invalid event stream message length %d", totalLength)
+	}
+
+	message := make([]byte, totalLength)
+	copy(message, prelude)
+	if _, err := io.ReadFull(d.reader, message[12:]); err != nil {
+		return fmt.Errorf("failed to read event stream message: %w", err)
+	}
+	if crc32.ChecksumIEEE(message[:totalLength-4]) != binary.BigEndian.Uint32(message[totalLength-4:]) {
+		return fmt.Errorf("event stream message checksum mismatch")
+	}
+
+	headers, err := parseEventStreamHeaders(message[12 : 12+headersLength])
+	if err != nil {
+		return err
+	}
+	payload := message[12+headersLength : totalLength-4]
+
+	switch headers[":message-type"] {
+	case "event":
+		if headers[":event-type"] != "chunk" {
+			return nil
+		}
+		var chunk struct {
+			Bytes string `json:"bytes"`
+		}
+		if err := json.Unmarshal(payload, &chunk); err != nil {
+			return fmt.Errorf("invalid event stream chunk: %w", err)
+		}
+		event, err := base64.StdEncoding.DecodeString(chunk.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid event stream chunk: %w", err)
+		}
+		var eventType struct {
+			Type string `json:"type"`
+		}
+		_ = json.Unmarshal(event, &eventType)
+		d.writeEvent(eventType.Type, event)
+
+	case "exception", "error":
+		var exception struct {
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(payload, &exception)
+		name := headers[":exception-type"]
+		if name == "" {
+			name = headers[":error-code"]
+		}
+		event, _ := json.Marshal(map[string]interface{}{
+			"type": "error",
+			"error": map[string]string{
+				"type":    exceptionErrorType(name),
+				"message": exception.Message,
+			},
+		})
+		d.writeEvent("error", event)
+	}
+
+	return nil
+}
+
+// writeEvent queues a server-sent event
+func (d *eventStreamDecoder) writeEvent(eventType string, data []byte) {
+	if eventType != "" {
+		d.pending.WriteString("event: " + eventType + "\n")
+	}
+	d.pending.WriteString("data: ")
+	d.pending.Write(data)
+	d.pending.WriteString("\n\n")
+}
+
+// parseEventStreamHeaders returns the string headers of an event stream message; headers of
+// other types are skipped
+func parseEventStreamHeaders(data []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	errTruncated := fmt.Errorf("truncated event stream headers")
+
+	for len(data) > 0 {
+		nameLength := int(data[0])
+		if len(data) < 2+nameLength {
+			return nil, errTruncated
+		}
+		name := string(data[1 : 1+nameLength])
+		valueType := data[1+nameLength]
+		data = data[2+nameLength:]
+
+		var size int
+		switch valueType {
+		case 0, 1: // true, false
+			size = 0
+		case 2: // byte
+			size = 1
+		case 3: // short
+			size = 2
+		case 4: // integer
+			size = 4
+		case 5, 8: // long, timestamp
+			size = 8
+		case 9: // UUID
+			size = 16
+		case 6, 7: // byte array, string
+			if len(data) < 2 {
+				return nil, errTruncated
+			}
+			size = 2 + int(binary.BigEndian.Uint16(data[0:2]))
+			if len(data) >= size && valueType == 7 {
+				headers[name] = string(data[2:size])
+			}
+		default:
+			return nil, fmt.Errorf("unknown event stream header type %d", valueType)
+		}
+		if len(data) < size {
+			return nil, errTruncated
+		}
+		data = data[size:]
+	}
+
+	return headers, nil
+}
+
+// exceptionErrorType returns the Messages API error type of a Bedrock stream exception
+func exceptionErrorType(exception string) string {
+	switch exception {
+	case "throttlingException":
+		return "rate_limit_error"
+	case "serviceUnavailableException":
+		return "overloaded_error"
+	case "validationException":
+		return "invalid_request_error"
+	default: // internalServerException, modelStreamErrorException, ...
+		return "api_error"
+	}
+}
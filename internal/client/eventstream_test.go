@@ -0,0 +1,92 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+)
+
+// encodeEventStreamMessage encodes an event stream message with string headers
+func encodeEventStreamMessage(headers map[string]string, payload []byte) []byte {
+	var encodedHeaders bytes.Buffer
+	for name, value := range headers {
+		encodedHeaders.WriteByte(byte(len(name)))
+		encodedHeaders.WriteString(name)
+		encodedHeaders.WriteByte(7)
+		_ = binary.Write(&encodedHeaders, binary.BigEndian, uint16(len(value)))
+		encodedHeaders.WriteString(value)
+	}
+
+	totalLength := 16 + encodedHeaders.Len() + len(payload)
+	message := make([]byte, 12, totalLength)
+	binary.BigEndian.PutUint32(message[0:4], uint32(totalLength))
+	binary.BigEndian.PutUint32(message[4:8], uint32(encodedHeaders.Len()))
+	binary.BigEndian.PutUint32(message[8:12], crc32.ChecksumIEEE(message[0:8]))
+	message = append(message, encodedHeaders.Bytes()...)
+	message = append(message, payload...)
+	return binary.BigEndian.AppendUint32(message, crc32.ChecksumIEEE(message))
+}
+
+// encodeChunk encodes a Messages API event as a Bedrock chunk message
+func encodeChunk(event string) []byte {
+	payload := `{"bytes":"` + base64.StdEncoding.EncodeToString([]byte(event)) + `"}`
+	return encodeEventStreamMessage(map[string]string{
+		":message-type": "event",
+		":event-type":   "chunk",
+		":content-type": "application/json",
+	}, []byte(payload))
+}
+
+func TestEventStreamDecoder(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(encodeChunk(`{"type":"message_start","message":{"usage":{"input_tokens":10}}}`))
+	stream.Write(encodeChunk(`{"type":"message_stop"}`))
+
+	data, err := io.ReadAll(newEventStreamDecoder(io.NopCloser(&stream)))
+	if err != nil {
+		t.Fatalf("Decoding failed: %v", err)
+	}
+
+	expected := "event: message_start\n" +
+		`data: {"type":"message_start","message":{"usage":{"input_tokens":10}}}` + "\n\n" +
+		"event: message_stop\n" +
+		`data: {"type":"message_stop"}` + "\n\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(data))
+	}
+}
+
+func TestEventStreamDecoderExceptions(t *testing.T) {
+	stream := encodeEventStreamMessage(map[string]string{
+		":message-type":   "exception",
+		":exception-type": "throttlingException",
+	}, []byte(`{"message":"Too many requests"}`))
+
+	data, err := io.ReadAll(newEventStreamDecoder(io.NopCloser(bytes.NewReader(stream))))
+	if err != nil {
+		t.Fatalf("Decoding failed: %v", err)
+	}
+	expected := "event: error\n" + `data: {"error":{"message":"Too many requests","type":"rate_limit_error"},"type":"error"}` + "\n\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(data))
+	}
+}
+
+func TestEventStreamDecoderCorruption(t *testing.T) {
+	message := encodeChunk(`{"type":"message_stop"}`)
+	message[len(message)-6] ^= 0xff
+
+	_, err := io.ReadAll(newEventStreamDecoder(io.NopCloser(bytes.NewReader(message))))
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Expected a checksum error, got %v", err)
+	}
+
+	_, err = io.ReadAll(newEventStreamDecoder(io.NopCloser(bytes.NewReader(message[:20]))))
+	if err == nil {
+		t.Error("Expected an error for a truncated message")
+	}
+}
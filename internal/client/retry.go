@@ -0,0 +1,319 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RetriesHeader reports how many times a request was retried upstream, on retried responses
+const RetriesHeader = "X-Autocache-Retries"
+
+// RetryPolicy decides how failed upstream calls are retried
+type RetryPolicy struct {
+	MaxRetries int           // Retries after the first attempt, 0 disables retries
+	BaseDelay  time.Duration // Backoff before the first retry, doubled on every retry
+	MaxDelay   time.Duration // Longest wait; a longer Retry-After gives up instead
+}
+
+// shouldRetry reports whether a failed attempt is worth retrying: connection errors, timeouts
+// (408), lock conflicts (409), rate limits (429) and server errors (5xx, 529 overloaded).
+// Anthropic's x-should-retry header overrides the status code.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.Header.Get("x-should-retry") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return resp.StatusCode >= 500
+}
+
+// shouldRetryNonIdempotent is shouldRetry for requests that must not run twice, such as batch
+// creation: only rate limits (429) and overloads (529), which the upstream rejects before
+// processing, are retried, or responses with x-should-retry: true. Connection errors and
+// other server errors may come after the request was processed.
+func shouldRetryNonIdempotent(resp *http.Response, err error) bool {
+	if err != nil {
+		return false
+	}
+	switch resp.Header.Get("x-should-retry") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == 529
+}
+
+// delay returns how long to wait before retry number attempt+1: what the upstream asked for
+// (Retry-After, or the reset of an exhausted rate limit on 429s), or a jittered exponential
+// backoff. It reports false when the upstream asks for a longer wait than MaxDelay.
+func (p RetryPolicy) delay(attempt int, resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp != nil {
+		if wait, ok := requestedDelay(resp, now); ok {
+			return wait, wait <= p.MaxDelay
+		}
+	}
+
+	backoff := p.BaseDelay << attempt
+	if backoff > p.MaxDelay || backoff <= 0 {
+		backoff = p.MaxDelay
+	}
+	// Up to 25% jitter so clients failing together do not retry together
+	return backoff - time.Duration(rand.Int64N(int64(backoff/4)+1)), true
+}
+
+// requestedDelay reads the wait the upstream asked for from retry-after-ms, Retry-After
+// (seconds or an HTTP date) or, on rate limited responses, the anthropic-ratelimit-*-reset
+// time of the limits with nothing remaining
+func requestedDelay(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if value := resp.Header.Get("retry-after-ms"); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil && ms >= 0 {
+			return time.Duration(ms * float64(time.Millisecond)), true
+		}
+	}
+
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds * float64(time.Second)), true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	var wait time.Duration
+	found := false
+	for _, limit := range []string{"requests", "tokens", "input-tokens", "output-tokens"} {
+		prefix := "anthropic-ratelimit-" + limit
+		if resp.Header.Get(prefix+"-remaining") != "0" {
+			continue
+		}
+		reset, err := time.Parse(time.RFC3339, resp.Header.Get(prefix+"-reset"))
+		if err != nil {
+			continue
+		}
+		wait = max(wait, reset.Sub(now))
+		found = true
+	}
+	return wait, found
+}
+
+// CircuitOpenError is returned without calling the upstream while the circuit breaker is open
+type CircuitOpenError struct {
+	RetryAfter time.Duration // Until the breaker lets a trial request through
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("upstream circuit breaker is open, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// circuitBreaker fails fast when the upstream keeps failing. After threshold consecutive
+// failures (connection errors and 5xx responses; rate limits mean the upstream is up) it opens
+// for cooldown, then lets one trial request through: success closes it, failure reopens it.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu         sync.Mutex
+	failures   int       // Consecutive failures
+	openedAt   time.Time // Last time failures reached the threshold
+	trial      bool      // A trial request is in flight
+	rejections uint64
+}
+
+// newCircuitBreaker returns a breaker, or nil (never open) when threshold is 0
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow returns a CircuitOpenError when the call must not be made
+func (cb *circuitBreaker) allow() error {
+	if cb == nil {
+		return nil
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.failures < cb.threshold {
+		return nil
+	}
+	if remaining := cb.cooldown - cb.now().Sub(cb.openedAt); remaining > 0 {
+		cb.rejections++
+		return &CircuitOpenError{RetryAfter: remaining}
+	}
+	if cb.trial {
+		cb.rejections++
+		return &CircuitOpenError{RetryAfter: time.Second}
+	}
+	cb.trial = true
+	return nil
+}
+
+// record counts the outcome of a call
+func (cb *circuitBreaker) record(success bool) {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.trial = false
+	if success {
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		cb.openedAt = cb.now()
+	}
+}
+
+// release ends a call without counting its outcome
+func (cb *circuitBreaker) release() {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.trial = false
+}
+
+// isOpen reports whether the breaker stopped letting calls through freely (open or half-open)
+func (cb *circuitBreaker) isOpen() bool {
+	if cb == nil {
+		return false
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.failures >= cb.threshold
+}
+
+// stats returns whether the breaker is open and how many calls it rejected
+func (cb *circuitBreaker) stats() (bool, uint64) {
+	if cb == nil {
+		return false, 0
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.failures >= cb.threshold, cb.rejections
+}
+
+// isUpstreamFailure reports whether a response counts against the circuit breaker
+func isUpstreamFailure(status int) bool {
+	return status >= 500
+}
+
+// SetRetryPolicy sets how failed upstream calls are retried
+func (pc *ProxyClient) SetRetryPolicy(policy RetryPolicy) {
+	pc.retry = policy
+}
+
+// SetCircuitBreaker opens the circuit after threshold consecutive upstream failures, for
+// cooldown. A threshold of 0 disables the breaker.
+func (pc *ProxyClient) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	pc.breaker = newCircuitBreaker(threshold, cooldown)
+}
+
+// SetRetryObserver sets a function called before every retry, with the request's model (empty
+// outside the Messages API) and the reason: the status code or "connection"
+func (pc *ProxyClient) SetRetryObserver(observer func(model, reason string)) {
+	pc.onRetry = observer
+}
+
+// CircuitStats returns whether the circuit breaker is open and how many calls it rejected
+func (pc *ProxyClient) CircuitStats() (bool, uint64) {
+	return pc.breaker.stats()
+}
+
+// do sends the request built by newRequest, retrying the failed attempts retryable accepts
+// (none when nil) as the retry policy allows. Attempts are rebuilt so bodies and signatures
+// are fresh. Retried responses carry RetriesHeader; the response of the last attempt is
+// returned either way.
+func (pc *ProxyClient) do(ctx context.Context, model string, retryable func(*http.Response, error) bool, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		httpReq, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		if err := pc.breaker.allow(); err != nil {
+			return nil, err
+		}
+
+		resp, err := pc.httpClient.Do(httpReq.WithContext(ctx))
+		if ctx.Err() != nil {
+			pc.breaker.release() // The client went away, which says nothing about the upstream
+		} else {
+			pc.breaker.record(err == nil && !isUpstreamFailure(resp.StatusCode))
+		}
+
+		wait, retry := time.Duration(0), retryable != nil && attempt < pc.retry.MaxRetries &&
+			ctx.Err() == nil && retryable(resp, err) && !pc.breaker.isOpen()
+		if retry {
+			wait, retry = pc.retry.delay(attempt, resp, time.Now())
+		}
+		if !retry {
+			if err != nil {
+				return nil, fmt.Errorf("failed to make request to Anthropic API: %w", err)
+			}
+			if attempt > 0 {
+				resp.Header.Set(RetriesHeader, strconv.Itoa(attempt))
+			}
+			return resp, nil
+		}
+
+		reason := "connection"
+		fields := logrus.Fields{"model": model, "attempt": attempt + 1, "delay": wait.String()}
+		if err != nil {
+			fields["error"] = err.Error()
+		} else {
+			reason = strconv.Itoa(resp.StatusCode)
+			// Drain a little of the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		fields["reason"] = reason
+		pc.logger.WithFields(fields).Warn("Upstream request failed, retrying")
+		if pc.onRetry != nil {
+			pc.onRetry(model, reason)
+		}
+
+		if err := pc.sleep(ctx, wait); err != nil {
+			return nil, fmt.Errorf("failed to make request to Anthropic API: %w", err)
+		}
+	}
+}
+
+// sleepContext waits for d, or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,393 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"autocache/internal/types"
+)
+
+func TestShouldRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		header   http.Header
+		err      error
+		expected bool
+	}{
+		{name: "Connection error", err: errors.New("connection refused"), expected: true},
+		{name: "Rate limited", status: http.StatusTooManyRequests, expected: true},
+		{name: "Overloaded", status: 529, expected: true},
+		{name: "Server error", status: http.StatusInternalServerError, expected: true},
+		{name: "Timeout", status: http.StatusRequestTimeout, expected: true},
+		{name: "Invalid request", status: http.StatusBadRequest, expected: false},
+		{name: "Authentication error", status: http.StatusUnauthorized, expected: false},
+		{name: "x-should-retry false", status: 529, header: http.Header{"X-Should-Retry": {"false"}}, expected: false},
+		{name: "x-should-retry true", status: http.StatusBadRequest, header: http.Header{"X-Should-Retry": {"true"}}, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status, Header: tt.header}
+				if resp.Header == nil {
+					resp.Header = http.Header{}
+				}
+			}
+			if got := shouldRetry(resp, tt.err); got != tt.expected {
+				t.Errorf("shouldRetry = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestShouldRetryNonIdempotent(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		header   http.Header
+		err      error
+		expected bool
+	}{
+		{name: "Connection error", err: errors.New("connection reset"), expected: false},
+		{name: "Rate limited", status: http.StatusTooManyRequests, expected: true},
+		{name: "Overloaded", status: 529, expected: true},
+		{name: "Server error", status: http.StatusInternalServerError, expected: false},
+		{name: "Timeout", status: http.StatusRequestTimeout, expected: false},
+		{name: "x-should-retry true", status: http.StatusInternalServerError, header: http.Header{"X-Should-Retry": {"true"}}, expected: true},
+		{name: "x-should-retry false", status: 529, header: http.Header{"X-Should-Retry": {"false"}}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status, Header: tt.header}
+				if resp.Header == nil {
+					resp.Header = http.Header{}
+				}
+			}
+			if got := shouldRetryNonIdempotent(resp, tt.err); got != tt.expected {
+				t.Errorf("shouldRetryNonIdempotent = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	policy := RetryPolicy{MaxRetries: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 10 * time.Second}
+	response := func(status int, header http.Header) *http.Response {
+		return &http.Response{StatusCode: status, Header: header}
+	}
+
+	tests := []struct {
+		name     string
+		resp     *http.Response
+		expected time.Duration
+		retry    bool
+	}{
+		{
+			name:     "retry-after-ms",
+			resp:     response(529, http.Header{"Retry-After-Ms": {"1500"}, "Retry-After": {"9"}}),
+			expected: 1500 * time.Millisecond,
+			retry:    true,
+		},
+		{
+			name:     "Retry-After in seconds",
+			resp:     response(429, http.Header{"Retry-After": {"3"}}),
+			expected: 3 * time.Second,
+			retry:    true,
+		},
+		{
+			name:     "Retry-After as a date",
+			resp:     response(503, http.Header{"Retry-After": {now.Add(5 * time.Second).Format(http.TimeFormat)}}),
+			expected: 5 * time.Second,
+			retry:    true,
+		},
+		{
+			name: "Reset of the exhausted rate limit",
+			resp: response(429, http.Header{
+				"Anthropic-Ratelimit-Requests-Remaining":     {"10"},
+				"Anthropic-Ratelimit-Requests-Reset":         {now.Add(time.Second).Format(time.RFC3339)},
+				"Anthropic-Ratelimit-Input-Tokens-Remaining": {"0"},
+				"Anthropic-Ratelimit-Input-Tokens-Reset":     {now.Add(4 * time.Second).Format(time.RFC3339)},
+			}),
+			expected: 4 * time.Second,
+			retry:    true,
+		},
+		{
+			name:     "Longer than the max delay",
+			resp:     response(429, http.Header{"Retry-After": {"60"}}),
+			expected: time.Minute,
+			retry:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, retry := policy.delay(0, tt.resp, now)
+			if wait != tt.expected || retry != tt.retry {
+				t.Errorf("delay = %s, %v; expected %s, %v", wait, retry, tt.expected, tt.retry)
+			}
+		})
+	}
+
+	// Without a requested delay, the backoff doubles with up to 25% jitter, up to the max delay
+	for attempt, expected := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		wait, retry := policy.delay(attempt, response(529, http.Header{}), now)
+		if !retry || wait > expected || wait < expected*3/4 {
+			t.Errorf("Attempt %d: expected a backoff between %s and %s, got %s", attempt, expected*3/4, expected, wait)
+		}
+	}
+	if wait, _ := policy.delay(20, nil, now); wait > policy.MaxDelay || wait < policy.MaxDelay*3/4 {
+		t.Errorf("Expected the backoff capped at %s, got %s", policy.MaxDelay, wait)
+	}
+}
+
+// newRetryClient returns a client for url that records its waits instead of sleeping
+func newRetryClient(url string, maxRetries int) (*ProxyClient, *[]time.Duration) {
+	pc := newDialectClient(url, AnthropicDialect{})
+	pc.SetRetryPolicy(RetryPolicy{MaxRetries: maxRetries, BaseDelay: 100 * time.Millisecond, MaxDelay: 10 * time.Second})
+	var waits []time.Duration
+	pc.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	return pc, &waits
+}
+
+func TestForwardRequestRetries(t *testing.T) {
+	var attempts atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"model":"claude-3-5-sonnet-20241022"`) {
+			t.Errorf("Expected the full body on every attempt, got %s", body)
+		}
+		if attempts.Add(1) <= 2 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(529)
+			_, _ = io.WriteString(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"msg_1","type":"message","usage":{"input_tokens":10,"output_tokens":5}}`)
+	}))
+	defer mockServer.Close()
+
+	pc, waits := newRetryClient(mockServer.URL, 2)
+	var reasons []string
+	pc.SetRetryObserver(func(model, reason string) { reasons = append(reasons, model+" "+reason) })
+
+	req := &types.AnthropicRequest{Model: "claude-3-5-sonnet-20241022", MaxTokens: 10}
+	resp, err := pc.ForwardRequest(context.Background(), req, http.Header{})
+	if err != nil {
+		t.Fatalf("ForwardRequest failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(RetriesHeader) != "2" {
+		t.Errorf("Expected success after 2 retries, got %d with %s=%q", resp.StatusCode, RetriesHeader, resp.Header.Get(RetriesHeader))
+	}
+	if len(*waits) != 2 || (*waits)[0] != 2*time.Second {
+		t.Errorf("Expected two waits of Retry-After, got %v", *waits)
+	}
+	if len(reasons) != 2 || reasons[0] != "claude-3-5-sonnet-20241022 529" {
+		t.Errorf("Expected the retries observed, got %v", reasons)
+	}
+
+	// Once retries are exhausted, the last response is returned
+	attempts.Store(-10)
+	resp, err = pc.ForwardRequest(context.Background(), req, http.Header{})
+	if err != nil {
+		t.Fatalf("ForwardRequest failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 529 || resp.Header.Get(RetriesHeader) != "2" || attempts.Load() != -7 {
+		t.Errorf("Expected the last 529 after 3 attempts, got %d after %d", resp.StatusCode, attempts.Load()+10)
+	}
+}
+
+func TestForwardRequestStopsWhenCanceled(t *testing.T) {
+	var attempts atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(529)
+	}))
+	defer mockServer.Close()
+
+	pc, _ := newRetryClient(mockServer.URL, 3)
+	pc.sleep = sleepContext
+
+	// The client goes away while the proxy waits to retry
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := pc.ForwardRequest(ctx, &types.AnthropicRequest{Model: "claude-3-5-sonnet-20241022", MaxTokens: 10}, http.Header{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the canceled context as error, got %v", err)
+	}
+	if attempts.Load() != 1 || time.Since(start) > 2*time.Second {
+		t.Errorf("Expected no retry after the client left, got %d attempts in %s", attempts.Load(), time.Since(start))
+	}
+}
+
+func TestForwardBatchRequestRetries(t *testing.T) {
+	var status atomic.Int32
+	var attempts atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(int(status.Load()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"msgbatch_1","type":"message_batch"}`)
+	}))
+	defer mockServer.Close()
+
+	pc, _ := newRetryClient(mockServer.URL, 2)
+
+	// A server error may come after the batch was created, so it is not sent again
+	for _, tt := range []struct {
+		status         int
+		expectAttempts int32
+	}{
+		{http.StatusInternalServerError, 1},
+		{529, 2},
+		{http.StatusTooManyRequests, 2},
+	} {
+		status.Store(int32(tt.status))
+		attempts.Store(0)
+		resp, err := pc.ForwardBatchRequest(context.Background(), &types.BatchRequest{}, http.Header{})
+		if err != nil {
+			t.Fatalf("ForwardBatchRequest failed: %v", err)
+		}
+		resp.Body.Close()
+		if attempts.Load() != tt.expectAttempts {
+			t.Errorf("Status %d: expected %d attempts, got %d", tt.status, tt.expectAttempts, attempts.Load())
+		}
+	}
+}
+
+func TestForwardStreamingRequestRetries(t *testing.T) {
+	var attempts atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch attempts.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			// The stream breaks after the first event
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "event: message_start\n"+`data: {"type":"message_start","message":{"usage":{"input_tokens":10}}}`+"\n\n")
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		default:
+			t.Error("Expected no retry once the stream started")
+		}
+	}))
+	defer mockServer.Close()
+
+	pc, waits := newRetryClient(mockServer.URL, 3)
+	w := httptest.NewRecorder()
+	_, _ = pc.ForwardStreamingRequest(context.Background(), &types.AnthropicRequest{Model: "claude-3-5-sonnet-20241022", MaxTokens: 10}, http.Header{}, w)
+
+	if attempts.Load() != 2 || len(*waits) != 1 {
+		t.Errorf("Expected one retry before the stream started, got %d attempts", attempts.Load())
+	}
+	if w.Code != http.StatusOK || w.Header().Get(RetriesHeader) != "1" || !strings.Contains(w.Body.String(), "message_start") {
+		t.Errorf("Expected the stream relayed with the retry count, got %d %v: %q", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	cb := newCircuitBreaker(2, 30*time.Second)
+	cb.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if err := cb.allow(); err != nil {
+			t.Fatalf("Expected calls while closed, got %v", err)
+		}
+		cb.record(false)
+	}
+
+	// Open: calls fail fast until the cooldown ends
+	var open *CircuitOpenError
+	if err := cb.allow(); !errors.As(err, &open) || open.RetryAfter != 30*time.Second {
+		t.Fatalf("Expected the breaker open for 30s, got %v", err)
+	}
+
+	// Half-open: one trial call, which reopens the breaker when it fails
+	now = now.Add(31 * time.Second)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("Expected a trial call after the cooldown, got %v", err)
+	}
+	if err := cb.allow(); err == nil {
+		t.Fatal("Expected a single trial call")
+	}
+	cb.record(false)
+	if err := cb.allow(); !errors.As(err, &open) || open.RetryAfter != 30*time.Second {
+		t.Fatalf("Expected the breaker reopened, got %v", err)
+	}
+
+	// A successful trial closes it
+	now = now.Add(31 * time.Second)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("Expected a trial call, got %v", err)
+	}
+	cb.record(true)
+	if err := cb.allow(); err != nil {
+		t.Errorf("Expected the breaker closed, got %v", err)
+	}
+	if isOpen, rejections := cb.stats(); isOpen || rejections != 3 {
+		t.Errorf("Expected a closed breaker with 3 rejections, got %v, %d", isOpen, rejections)
+	}
+
+	// A disabled breaker never opens
+	if newCircuitBreaker(0, time.Second) != nil {
+		t.Error("Expected no breaker with a threshold of 0")
+	}
+}
+
+func TestForwardRequestCircuitBreaker(t *testing.T) {
+	var attempts atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer mockServer.Close()
+
+	pc, _ := newRetryClient(mockServer.URL, 5)
+	pc.SetCircuitBreaker(3, time.Minute)
+	req := &types.AnthropicRequest{Model: "claude-3-5-sonnet-20241022", MaxTokens: 10}
+
+	// Retries stop once the breaker opens
+	resp, err := pc.ForwardRequest(context.Background(), req, http.Header{})
+	if err != nil {
+		t.Fatalf("ForwardRequest failed: %v", err)
+	}
+	resp.Body.Close()
+	if attempts.Load() != 3 || resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected 3 attempts before the breaker opened, got %d", attempts.Load())
+	}
+
+	// Then calls fail without reaching the upstream
+	var open *CircuitOpenError
+	if _, err := pc.ForwardRequest(context.Background(), req, http.Header{}); !errors.As(err, &open) {
+		t.Errorf("Expected a CircuitOpenError, got %v", err)
+	}
+	if attempts.Load() != 3 {
+		t.Errorf("Expected no call while the breaker is open, got %d", attempts.Load())
+	}
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigV4Signer(t *testing.T) {
+	// The get-vanilla case of the AWS Signature Version 4 test suite
+	signer := &SigV4Signer{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         "service",
+		now:             func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) },
+	}
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err := signer.Sign(req, nil); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("Expected X-Amz-Date 20150830T123600Z, got %s", got)
+	}
+
+	// Session tokens and the content type are signed too
+	signer.SessionToken = "session"
+	req, _ = http.NewRequest("POST", "https://bedrock-runtime.us-east-1.amazonaws.com/model/a%3A1/invoke", nil)
+	req.Header.Set("Content-Type", "application/json")
+	if err := signer.Sign(req, []byte("{}")); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if req.Header.Get("X-Amz-Security-Token") != "session" ||
+		!strings.Contains(req.Header.Get("Authorization"), "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("Unexpected headers: %v", req.Header)
+	}
+
+	// Without credentials nothing is sent
+	if err := (&SigV4Signer{Region: "us-east-1", Service: "bedrock"}).Sign(req, nil); err == nil {
+		t.Error("Expected an error without credentials")
+	}
+}
+
+func TestCanonicalURI(t *testing.T) {
+	req, _ := http.NewRequest("POST", "https://bedrock-runtime.us-east-1.amazonaws.com/model/"+awsURIEncode("anthropic.claude-3-5-sonnet-20241022-v2:0")+"/invoke", nil)
+
+	// The escaped path is encoded again
+	expected := "/model/anthropic.claude-3-5-sonnet-20241022-v2%253A0/invoke"
+	if got := canonicalURI(req); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
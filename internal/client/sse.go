@@ -0,0 +1,145 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"autocache/internal/types"
+)
+
+// sseUsage mirrors the usage object in streaming events.
+// Pointers distinguish fields that are absent from fields that are zero.
+type sseUsage struct {
+	InputTokens              *int `json:"input_tokens"`
+	OutputTokens             *int `json:"output_tokens"`
+	CacheCreationInputTokens *int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     *int `json:"cache_read_input_tokens"`
+
+	CacheCreation *types.CacheCreation `json:"cache_creation"`
+}
+
+// sseEvent holds the parts of a streaming event payload that carry usage
+type sseEvent struct {
+	Type    string `json:"type"`
+	Message *struct {
+		Usage *sseUsage `json:"usage"`
+	} `json:"message"`
+	Usage *sseUsage `json:"usage"`
+}
+
+// apply copies every field present in the event usage onto the accumulated usage
+func (u *sseUsage) apply(usage *types.Usage) {
+	if u == nil {
+		return
+	}
+	if u.InputTokens != nil {
+		usage.InputTokens = *u.InputTokens
+	}
+	if u.OutputTokens != nil {
+		usage.OutputTokens = *u.OutputTokens
+	}
+	if u.CacheCreationInputTokens != nil {
+		usage.CacheCreationInputTokens = *u.CacheCreationInputTokens
+	}
+	if u.CacheReadInputTokens != nil {
+		usage.CacheReadInputTokens = *u.CacheReadInputTokens
+	}
+	if u.CacheCreation != nil {
+		cacheCreation := *u.CacheCreation
+		usage.CacheCreation = &cacheCreation
+	}
+}
+
+// StreamUsageTee forwards a server-sent event stream to a client as it arrives
+// while reading the usage reported in message_start and message_delta events
+type StreamUsageTee struct {
+	writer  io.Writer
+	flusher http.Flusher
+	usage   types.Usage
+	seen    bool
+}
+
+// NewStreamUsageTee creates a tee that writes to w, flushing after every event when w supports it
+func NewStreamUsageTee(w io.Writer) *StreamUsageTee {
+	flusher, _ := w.(http.Flusher)
+	return &StreamUsageTee{
+		writer:  w,
+		flusher: flusher,
+	}
+}
+
+// Copy forwards the stream line by line until EOF, flushing at the end of each event
+func (t *StreamUsageTee) Copy(body io.Reader) error {
+	reader := bufio.NewReader(body)
+
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if _, err := t.writer.Write(line); err != nil {
+				return fmt.Errorf("failed to write stream to client: %w", err)
+			}
+
+			trimmed := bytes.TrimRight(line, "\r\n")
+			if len(trimmed) == 0 {
+				// Blank line terminates an event
+				t.flush()
+			} else if bytes.HasPrefix(trimmed, []byte("data:")) {
+				t.parseData(bytes.TrimSpace(trimmed[len("data:"):]))
+			}
+		}
+
+		if readErr == io.EOF {
+			t.flush()
+			return nil
+		}
+		if readErr != nil {
+			t.flush()
+			return fmt.Errorf("failed to read stream from upstream: %w", readErr)
+		}
+	}
+}
+
+// Usage returns the usage reported by the stream, or nil if no usage event was seen
+func (t *StreamUsageTee) Usage() *types.Usage {
+	if !t.seen {
+		return nil
+	}
+	usage := t.usage
+	return &usage
+}
+
+// parseData extracts usage from a single event payload; other events are ignored
+func (t *StreamUsageTee) parseData(data []byte) {
+	if !bytes.Contains(data, []byte(`"usage"`)) {
+		return
+	}
+
+	var event sseEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return
+	}
+
+	switch event.Type {
+	case "message_start":
+		if event.Message != nil && event.Message.Usage != nil {
+			event.Message.Usage.apply(&t.usage)
+			t.seen = true
+		}
+	case "message_delta":
+		if event.Usage != nil {
+			// Delta usage is cumulative, so it replaces what message_start reported
+			event.Usage.apply(&t.usage)
+			t.seen = true
+		}
+	}
+}
+
+func (t *StreamUsageTee) flush() {
+	if t.flusher != nil {
+		t.flusher.Flush()
+	}
+}
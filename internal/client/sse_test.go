@@ -0,0 +1,89 @@
+package client
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const sampleStream = "event: message_start\n" +
+	`data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude-sonnet-4-5-20250929","usage":{"input_tokens":12,"cache_creation_input_tokens":2048,"cache_read_input_tokens":0,"output_tokens":1}}}` + "\n\n" +
+	"event: content_block_start\n" +
+	`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello, usage"}}` + "\n\n" +
+	"event: ping\n" +
+	`data: {"type": "ping"}` + "\n\n" +
+	"event: message_delta\n" +
+	`data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":42}}` + "\n\n" +
+	"event: message_stop\n" +
+	`data: {"type":"message_stop"}` + "\n\n"
+
+func TestStreamUsageTeeForwardsAndCapturesUsage(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	tee := NewStreamUsageTee(recorder)
+
+	if err := tee.Copy(strings.NewReader(sampleStream)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if recorder.Body.String() != sampleStream {
+		t.Errorf("Stream was altered while forwarding:\n%s", recorder.Body.String())
+	}
+	if !recorder.Flushed {
+		t.Error("Expected the stream to be flushed to the client")
+	}
+
+	usage := tee.Usage()
+	if usage == nil {
+		t.Fatal("Expected usage to be captured")
+	}
+	if usage.InputTokens != 12 {
+		t.Errorf("Expected 12 input tokens, got %d", usage.InputTokens)
+	}
+	if usage.OutputTokens != 42 {
+		t.Errorf("Expected output tokens from message_delta (42), got %d", usage.OutputTokens)
+	}
+	if usage.CacheCreationInputTokens != 2048 {
+		t.Errorf("Expected 2048 cache creation tokens, got %d", usage.CacheCreationInputTokens)
+	}
+	if usage.CacheReadInputTokens != 0 {
+		t.Errorf("Expected 0 cache read tokens, got %d", usage.CacheReadInputTokens)
+	}
+}
+
+func TestStreamUsageTeeDeltaOverridesCacheFields(t *testing.T) {
+	stream := `data: {"type":"message_start","message":{"usage":{"input_tokens":5,"cache_read_input_tokens":100,"output_tokens":1}}}` + "\r\n\r\n" +
+		`data: {"type":"message_delta","usage":{"input_tokens":5,"cache_read_input_tokens":3000,"output_tokens":7}}` + "\r\n\r\n"
+
+	tee := NewStreamUsageTee(httptest.NewRecorder())
+	if err := tee.Copy(strings.NewReader(stream)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	usage := tee.Usage()
+	if usage == nil {
+		t.Fatal("Expected usage to be captured")
+	}
+	if usage.CacheReadInputTokens != 3000 || usage.OutputTokens != 7 {
+		t.Errorf("Expected cumulative delta usage to win, got %+v", *usage)
+	}
+}
+
+func TestStreamUsageTeeWithoutUsage(t *testing.T) {
+	stream := "event: error\n" +
+		`data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}` + "\n\n"
+
+	recorder := httptest.NewRecorder()
+	tee := NewStreamUsageTee(recorder)
+	if err := tee.Copy(strings.NewReader(stream)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if tee.Usage() != nil {
+		t.Error("Expected no usage for a stream without usage events")
+	}
+	if recorder.Body.String() != stream {
+		t.Error("Error events must be forwarded untouched")
+	}
+}
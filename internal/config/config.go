@@ -5,6 +5,9 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"autocache/internal/types"
 
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
@@ -19,9 +22,33 @@ type Config struct {
 	// Anthropic API configuration
 	AnthropicURL    string `json:"anthropic_url"`
 	AnthropicAPIKey string `json:"anthropic_api_key"`
+	AuthMode        string `json:"auth_mode"` // How credentials are sent upstream: "auto", "api-key" or "bearer"
+	AnthropicVersion string `json:"anthropic_version"` // anthropic-version sent when the client sends none
+
+	// Upstream request format: "anthropic" (first-party API), "bedrock" (Amazon Bedrock) or "vertex" (Vertex AI)
+	UpstreamDialect    string `json:"upstream_dialect"`
+	AWSRegion          string `json:"aws_region"`
+	AWSAccessKeyID     string `json:"-"`
+	AWSSecretAccessKey string `json:"-"`
+	AWSSessionToken    string `json:"-"`
+	VertexProjectID    string `json:"vertex_project_id"`
+	VertexRegion       string `json:"vertex_region"`
+	VertexAccessToken  string `json:"-"` // Without one, the client's bearer token is forwarded
+
+	// Upstream retries and circuit breaker
+	UpstreamMaxRetries      int           `json:"upstream_max_retries"`      // Retries of failed upstream calls, 0 disables them
+	UpstreamRetryBaseDelay  time.Duration `json:"upstream_retry_base_delay"` // First backoff delay, doubled on every retry
+	UpstreamRetryMaxDelay   time.Duration `json:"upstream_retry_max_delay"`  // Longest wait before a retry, including Retry-After
+	CircuitBreakerThreshold int           `json:"circuit_breaker_threshold"` // Consecutive upstream failures that open the breaker, 0 disables it
+	CircuitBreakerCooldown  time.Duration `json:"circuit_breaker_cooldown"`  // Time the breaker stays open before a trial request
 
 	// Cache configuration
-	CacheStrategy string `json:"cache_strategy"`
+	CacheStrategy  string                          `json:"cache_strategy"`
+	StrategiesFile string                          `json:"strategies_file"` // YAML or JSON file with custom strategies
+	Strategies     map[string]types.StrategyConfig `json:"strategies"`      // Custom strategies, by name
+
+	// What to do with cache_control markers set by the client: "respect", "augment" or "override"
+	ClientCacheControl string `json:"client_cache_control"`
 
 	// Logging configuration
 	LogLevel string `json:"log_level"`
@@ -35,6 +62,14 @@ type Config struct {
 	MaxCacheBreakpoints int     `json:"max_cache_breakpoints"`
 	TokenMultiplier     float64 `json:"token_multiplier"`
 	SavingsHistorySize  int     `json:"savings_history_size"`
+	PrefixTracking      bool    `json:"prefix_tracking"` // Track prefixes across requests to predict cache hits
+	TTLColdStart        string  `json:"ttl_cold_start"`  // TTL until a prefix's inter-arrival times are known: "strategy", "heuristic", "5m" or "1h"
+	CountTokensMode     string  `json:"count_tokens_mode"` // Who answers /v1/messages/count_tokens: "upstream", "local" or "compare"
+
+	// Savings history storage
+	SavingsStore     string        `json:"savings_store"`      // "memory" or "file"
+	SavingsStorePath string        `json:"savings_store_path"` // History file used by the "file" store
+	SavingsRetention time.Duration `json:"savings_retention"`  // Maximum age of history entries, 0 keeps them until the count limit
 
 	// Tokenizer configuration
 	TokenizerMode          string `json:"tokenizer_mode"`           // "anthropic", "offline", "heuristic", "hybrid"
@@ -47,15 +82,40 @@ func LoadConfig() (*Config, error) {
 	// Try to load .env file (ignore error if file doesn't exist)
 	_ = godotenv.Load()
 
+	// The upstream URL defaults to the dialect's endpoint in the configured region
+	dialect := getEnvWithDefault("UPSTREAM_DIALECT", "anthropic")
+	awsRegion := getEnvWithDefault("AWS_REGION", "us-east-1")
+	vertexRegion := getEnvWithDefault("VERTEX_REGION", "us-east5")
+
 	config := &Config{
 		// Default values
 		Port: getEnvWithDefault("PORT", "8080"),
 		Host: getEnvWithDefault("HOST", "0.0.0.0"),
 
-		AnthropicURL:    getEnvWithDefault("ANTHROPIC_API_URL", "https://api.anthropic.com"),
+		AnthropicURL:    getEnvWithDefault("ANTHROPIC_API_URL", DefaultUpstreamURL(dialect, awsRegion, vertexRegion)),
 		AnthropicAPIKey: os.Getenv("ANTHROPIC_API_KEY"),
+		AuthMode:        getEnvWithDefault("AUTH_MODE", "auto"),
+		AnthropicVersion: getEnvWithDefault("ANTHROPIC_VERSION", "2023-06-01"),
+
+		UpstreamDialect:    dialect,
+		AWSRegion:          awsRegion,
+		AWSAccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		AWSSecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		AWSSessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		VertexProjectID:    os.Getenv("VERTEX_PROJECT_ID"),
+		VertexRegion:       vertexRegion,
+		VertexAccessToken:  os.Getenv("VERTEX_ACCESS_TOKEN"),
 
-		CacheStrategy: getEnvWithDefault("CACHE_STRATEGY", "moderate"),
+		UpstreamMaxRetries:      getEnvInt("UPSTREAM_MAX_RETRIES", 2),
+		UpstreamRetryBaseDelay:  getEnvDuration("UPSTREAM_RETRY_BASE_DELAY", 500*time.Millisecond),
+		UpstreamRetryMaxDelay:   getEnvDuration("UPSTREAM_RETRY_MAX_DELAY", 30*time.Second),
+		CircuitBreakerThreshold: getEnvInt("CIRCUIT_BREAKER_THRESHOLD", 5),
+		CircuitBreakerCooldown:  getEnvDuration("CIRCUIT_BREAKER_COOLDOWN", 30*time.Second),
+
+		CacheStrategy:  getEnvWithDefault("CACHE_STRATEGY", "moderate"),
+		StrategiesFile: os.Getenv("STRATEGIES_FILE"),
+
+		ClientCacheControl: getEnvWithDefault("CLIENT_CACHE_CONTROL", "augment"),
 
 		LogLevel: getEnvWithDefault("LOG_LEVEL", "info"),
 		LogJSON:  getEnvBool("LOG_JSON", false),
@@ -66,12 +126,32 @@ func LoadConfig() (*Config, error) {
 		MaxCacheBreakpoints: getEnvInt("MAX_CACHE_BREAKPOINTS", 4),
 		TokenMultiplier:     getEnvFloat("TOKEN_MULTIPLIER", 1.0),
 		SavingsHistorySize:  getEnvInt("SAVINGS_HISTORY_SIZE", 100),
+		PrefixTracking:      getEnvBool("PREFIX_TRACKING", true),
+		TTLColdStart:        getEnvWithDefault("TTL_COLD_START", "strategy"),
+		CountTokensMode:     getEnvWithDefault("COUNT_TOKENS_MODE", "upstream"),
+
+		SavingsStore:     getEnvWithDefault("SAVINGS_STORE", "memory"),
+		SavingsStorePath: getEnvWithDefault("SAVINGS_STORE_PATH", "data/savings-history.jsonl"),
+		SavingsRetention: getEnvDuration("SAVINGS_RETENTION", 0),
 
 		TokenizerMode:         getEnvWithDefault("TOKENIZER_MODE", "offline"),
 		LogTokenizerFailures:  getEnvBool("LOG_TOKENIZER_FAILURES", true),
 		TokenizerPanicSamples: getEnvInt("TOKENIZER_PANIC_SAMPLES", 200),
 	}
 
+	// A file store with a retention keeps entries by age: no entry cap unless one is set
+	if config.SavingsStore == "file" && config.SavingsRetention > 0 && os.Getenv("SAVINGS_HISTORY_SIZE") == "" {
+		config.SavingsHistorySize = 0
+	}
+
+	if config.StrategiesFile != "" {
+		strategies, err := LoadStrategies(config.StrategiesFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Strategies = strategies
+	}
+
 	// Validate configuration
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
@@ -92,15 +172,112 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("anthropic URL cannot be empty")
 	}
 
-	// Validate cache strategy
-	validStrategies := map[string]bool{
-		"conservative": true,
-		"moderate":     true,
-		"aggressive":   true,
+	// Validate custom strategies, then the selected one (built-in or custom)
+	for _, name := range sortedNames(c.Strategies) {
+		if err := ValidateStrategy(c.Strategies[name]); err != nil {
+			return fmt.Errorf("invalid cache strategy %s: %w", name, err)
+		}
+	}
+
+	if _, ok := c.ResolveStrategy(c.CacheStrategy); !ok {
+		return fmt.Errorf("invalid cache strategy: %s (must be one of: %s)", c.CacheStrategy, strings.Join(c.StrategyNames(), ", "))
+	}
+
+	// Validate client cache_control handling (empty defaults to augment)
+	validClientModes := map[string]bool{
+		"":         true,
+		"respect":  true,
+		"augment":  true,
+		"override": true,
 	}
 
-	if !validStrategies[c.CacheStrategy] {
-		return fmt.Errorf("invalid cache strategy: %s (must be one of: conservative, moderate, aggressive)", c.CacheStrategy)
+	if !validClientModes[c.ClientCacheControl] {
+		return fmt.Errorf("invalid client cache control mode: %s (must be one of: respect, augment, override)", c.ClientCacheControl)
+	}
+
+	// Validate the auth mode (empty defaults to auto)
+	validAuthModes := map[string]bool{
+		"":        true,
+		"auto":    true,
+		"api-key": true,
+		"bearer":  true,
+	}
+
+	if !validAuthModes[c.AuthMode] {
+		return fmt.Errorf("invalid auth mode: %s (must be one of: auto, api-key, bearer)", c.AuthMode)
+	}
+
+	// Validate the default API version, a date (empty uses the client's built-in default)
+	if c.AnthropicVersion != "" {
+		if _, err := time.Parse("2006-01-02", c.AnthropicVersion); err != nil {
+			return fmt.Errorf("invalid anthropic version: %s (must be a date like 2023-06-01)", c.AnthropicVersion)
+		}
+	}
+
+	// Validate the upstream dialect and the settings it needs (empty defaults to anthropic)
+	switch c.UpstreamDialect {
+	case "", "anthropic":
+	case "bedrock":
+		if c.AWSRegion == "" {
+			return fmt.Errorf("AWS region cannot be empty with the bedrock upstream dialect")
+		}
+		if c.AWSAccessKeyID == "" || c.AWSSecretAccessKey == "" {
+			return fmt.Errorf("AWS access key ID and secret access key are required with the bedrock upstream dialect")
+		}
+	case "vertex":
+		if c.VertexProjectID == "" || c.VertexRegion == "" {
+			return fmt.Errorf("Vertex project ID and region are required with the vertex upstream dialect")
+		}
+	default:
+		return fmt.Errorf("invalid upstream dialect: %s (must be one of: anthropic, bedrock, vertex)", c.UpstreamDialect)
+	}
+
+	// Validate upstream retries (delays only matter when retrying)
+	if c.UpstreamMaxRetries < 0 || c.UpstreamMaxRetries > 10 {
+		return fmt.Errorf("upstream max retries must be between 0 and 10, got: %d", c.UpstreamMaxRetries)
+	}
+
+	if c.UpstreamMaxRetries > 0 {
+		if c.UpstreamRetryBaseDelay <= 0 {
+			return fmt.Errorf("upstream retry base delay must be positive, got: %s", c.UpstreamRetryBaseDelay)
+		}
+		if c.UpstreamRetryMaxDelay < c.UpstreamRetryBaseDelay {
+			return fmt.Errorf("upstream retry max delay (%s) cannot be shorter than the base delay (%s)", c.UpstreamRetryMaxDelay, c.UpstreamRetryBaseDelay)
+		}
+	}
+
+	// Validate the circuit breaker (a threshold of 0 disables it)
+	if c.CircuitBreakerThreshold < 0 {
+		return fmt.Errorf("circuit breaker threshold cannot be negative, got: %d", c.CircuitBreakerThreshold)
+	}
+
+	if c.CircuitBreakerThreshold > 0 && c.CircuitBreakerCooldown <= 0 {
+		return fmt.Errorf("circuit breaker cooldown must be positive, got: %s", c.CircuitBreakerCooldown)
+	}
+
+	// Validate the count_tokens mode (empty defaults to upstream)
+	validCountTokensModes := map[string]bool{
+		"":         true,
+		"upstream": true,
+		"local":    true,
+		"compare":  true,
+	}
+
+	if !validCountTokensModes[c.CountTokensMode] {
+		return fmt.Errorf("invalid count tokens mode: %s (must be one of: upstream, local, compare)", c.CountTokensMode)
+	}
+
+	// Validate the cold-start TTL (empty defaults to strategy)
+	validColdStarts := map[string]bool{
+		"":          true,
+		"strategy":  true,
+		"heuristic": true,
+		"5m":        true,
+		"1h":        true,
+	}
+
+	if !validColdStarts[c.TTLColdStart] {
+		return fmt.Errorf("invalid TTL cold start: %s (must be one of: strategy, heuristic, 5m, 1h)", c.TTLColdStart)
 	}
 
 	// Validate log level
@@ -133,6 +310,25 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("savings history size cannot be negative, got: %d", c.SavingsHistorySize)
 	}
 
+	// Validate savings store (empty defaults to memory)
+	validSavingsStores := map[string]bool{
+		"":       true,
+		"memory": true,
+		"file":   true,
+	}
+
+	if !validSavingsStores[c.SavingsStore] {
+		return fmt.Errorf("invalid savings store: %s (must be one of: memory, file)", c.SavingsStore)
+	}
+
+	if c.SavingsStore == "file" && c.SavingsStorePath == "" {
+		return fmt.Errorf("savings store path cannot be empty when using the file store")
+	}
+
+	if c.SavingsRetention < 0 {
+		return fmt.Errorf("savings retention cannot be negative, got: %s", c.SavingsRetention)
+	}
+
 	// Validate tokenizer mode
 	validTokenizerModes := map[string]bool{
 		"anthropic": true,
@@ -153,6 +349,21 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// DefaultUpstreamURL returns the API endpoint of an upstream dialect in the given region
+func DefaultUpstreamURL(dialect, awsRegion, vertexRegion string) string {
+	switch dialect {
+	case "bedrock":
+		return "https://bedrock-runtime." + awsRegion + ".amazonaws.com"
+	case "vertex":
+		if vertexRegion == "global" {
+			return "https://aiplatform.googleapis.com"
+		}
+		return "https://" + vertexRegion + "-aiplatform.googleapis.com"
+	default:
+		return "https://api.anthropic.com"
+	}
+}
+
 // GetServerAddress returns the full server address
 func (c *Config) GetServerAddress() string {
 	return c.Host + ":" + c.Port
@@ -184,6 +395,14 @@ func (c *Config) PrintConfig(logger *logrus.Logger) {
 		"anthropic_url":          c.AnthropicURL,
 		"anthropic_api_key":      apiKey,
 		"cache_strategy":         c.CacheStrategy,
+		"strategies_file":        c.StrategiesFile,
+		"client_cache_control":   c.ClientCacheControl,
+		"auth_mode":              c.AuthMode,
+		"anthropic_version":      c.AnthropicVersion,
+		"upstream_dialect":       c.UpstreamDialect,
+		"upstream_max_retries":   c.UpstreamMaxRetries,
+		"circuit_breaker":        c.CircuitBreakerThreshold,
+		"count_tokens_mode":      c.CountTokensMode,
 		"log_level":              c.LogLevel,
 		"log_json":               c.LogJSON,
 		"enable_metrics":         c.EnableMetrics,
@@ -191,6 +410,10 @@ func (c *Config) PrintConfig(logger *logrus.Logger) {
 		"max_cache_breakpoints":  c.MaxCacheBreakpoints,
 		"token_multiplier":       c.TokenMultiplier,
 		"savings_history_size":   c.SavingsHistorySize,
+		"prefix_tracking":        c.PrefixTracking,
+		"ttl_cold_start":         c.TTLColdStart,
+		"savings_store":          c.SavingsStore,
+		"savings_retention":      c.SavingsRetention.String(),
 		"tokenizer_mode":         c.TokenizerMode,
 		"log_tokenizer_failures": c.LogTokenizerFailures,
 	}).Info("Configuration loaded")
@@ -267,6 +490,15 @@ func getEnvFloat(key string, defaultValue float64) float64 {
 	return defaultValue
 }
 
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if durationValue, err := time.ParseDuration(value); err == nil {
+			return durationValue
+		}
+	}
+	return defaultValue
+}
+
 // ConfigSummary returns a summary of the current configuration for API responses
 func (c *Config) ConfigSummary() map[string]interface{} {
 	return map[string]interface{}{
@@ -279,6 +511,13 @@ func (c *Config) ConfigSummary() map[string]interface{} {
 	}
 }
 
+// SavingsHistoryEnabled reports whether request metadata is kept for /savings. A history size
+// of 0 turns history off, except for a file store with a retention, where it means no entry
+// cap and entries are only dropped by age.
+func (c *Config) SavingsHistoryEnabled() bool {
+	return c.SavingsHistorySize > 0 || (c.SavingsStore == "file" && c.SavingsRetention > 0)
+}
+
 // UpdateFromEnvironment updates configuration from current environment variables
 // This can be useful for runtime configuration updates
 func (c *Config) UpdateFromEnvironment() error {
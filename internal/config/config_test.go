@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -59,6 +60,9 @@ func TestLoadConfig(t *testing.T) {
 		if cfg.TokenMultiplier != 1.0 {
 			t.Errorf("Expected default token multiplier 1.0, got %f", cfg.TokenMultiplier)
 		}
+		if cfg.UpstreamMaxRetries != 2 || cfg.CircuitBreakerThreshold != 5 || cfg.CircuitBreakerCooldown != 30*time.Second {
+			t.Errorf("Unexpected default retry settings: %d retries, breaker %d/%s", cfg.UpstreamMaxRetries, cfg.CircuitBreakerThreshold, cfg.CircuitBreakerCooldown)
+		}
 	})
 
 	t.Run("Custom environment variables", func(t *testing.T) {
@@ -156,6 +160,188 @@ func TestConfigValidation(t *testing.T) {
 			expectError:   true,
 			errorContains: "anthropic URL cannot be empty",
 		},
+		{
+			name: "Conversation cache strategy",
+			config: &Config{
+				Port:                "8080",
+				AnthropicURL:        "https://api.anthropic.com",
+				CacheStrategy:       "conversation",
+				LogLevel:            "info",
+				MaxCacheBreakpoints: 4,
+				TokenMultiplier:     1.0,
+				TokenizerMode:       "offline",
+			},
+			expectError: false,
+		},
+		{
+			name: "Invalid client cache control mode",
+			config: &Config{
+				Port:                "8080",
+				AnthropicURL:        "https://api.anthropic.com",
+				CacheStrategy:       "moderate",
+				ClientCacheControl:  "merge",
+				LogLevel:            "info",
+				MaxCacheBreakpoints: 4,
+				TokenMultiplier:     1.0,
+				TokenizerMode:       "offline",
+			},
+			expectError:   true,
+			errorContains: "invalid client cache control mode",
+		},
+		{
+			name: "Invalid auth mode",
+			config: &Config{
+				Port:                "8080",
+				AnthropicURL:        "https://api.anthropic.com",
+				CacheStrategy:       "moderate",
+				AuthMode:            "oauth",
+				LogLevel:            "info",
+				MaxCacheBreakpoints: 4,
+				TokenMultiplier:     1.0,
+				TokenizerMode:       "offline",
+			},
+			expectError:   true,
+			errorContains: "invalid auth mode",
+		},
+		{
+			name: "Invalid anthropic version",
+			config: &Config{
+				Port:                "8080",
+				AnthropicURL:        "https://api.anthropic.com",
+				AnthropicVersion:    "latest",
+				CacheStrategy:       "moderate",
+				LogLevel:            "info",
+				MaxCacheBreakpoints: 4,
+				TokenMultiplier:     1.0,
+				TokenizerMode:       "offline",
+			},
+			expectError:   true,
+			errorContains: "invalid anthropic version",
+		},
+		{
+			name: "Invalid count tokens mode",
+			config: &Config{
+				Port:                "8080",
+				AnthropicURL:        "https://api.anthropic.com",
+				CacheStrategy:       "moderate",
+				CountTokensMode:     "offline",
+				LogLevel:            "info",
+				MaxCacheBreakpoints: 4,
+				TokenMultiplier:     1.0,
+				TokenizerMode:       "offline",
+			},
+			expectError:   true,
+			errorContains: "invalid count tokens mode",
+		},
+		{
+			name: "Invalid upstream dialect",
+			config: &Config{
+				Port:                "8080",
+				AnthropicURL:        "https://api.anthropic.com",
+				UpstreamDialect:     "azure",
+				CacheStrategy:       "moderate",
+				LogLevel:            "info",
+				MaxCacheBreakpoints: 4,
+				TokenMultiplier:     1.0,
+				TokenizerMode:       "offline",
+			},
+			expectError:   true,
+			errorContains: "invalid upstream dialect",
+		},
+		{
+			name: "Bedrock without AWS credentials",
+			config: &Config{
+				Port:                "8080",
+				AnthropicURL:        "https://bedrock-runtime.us-east-1.amazonaws.com",
+				UpstreamDialect:     "bedrock",
+				AWSRegion:           "us-east-1",
+				CacheStrategy:       "moderate",
+				LogLevel:            "info",
+				MaxCacheBreakpoints: 4,
+				TokenMultiplier:     1.0,
+				TokenizerMode:       "offline",
+			},
+			expectError:   true,
+			errorContains: "AWS access key ID and secret access key are required",
+		},
+		{
+			name: "Vertex without a project",
+			config: &Config{
+				Port:                "8080",
+				AnthropicURL:        "https://us-east5-aiplatform.googleapis.com",
+				UpstreamDialect:     "vertex",
+				VertexRegion:        "us-east5",
+				CacheStrategy:       "moderate",
+				LogLevel:            "info",
+				MaxCacheBreakpoints: 4,
+				TokenMultiplier:     1.0,
+				TokenizerMode:       "offline",
+			},
+			expectError:   true,
+			errorContains: "Vertex project ID and region are required",
+		},
+		{
+			name: "Too many upstream retries",
+			config: &Config{
+				Port:                "8080",
+				AnthropicURL:        "https://api.anthropic.com",
+				UpstreamMaxRetries:  11,
+				CacheStrategy:       "moderate",
+				LogLevel:            "info",
+				MaxCacheBreakpoints: 4,
+				TokenMultiplier:     1.0,
+				TokenizerMode:       "offline",
+			},
+			expectError:   true,
+			errorContains: "upstream max retries must be between 0 and 10",
+		},
+		{
+			name: "Retry max delay shorter than the base delay",
+			config: &Config{
+				Port:                   "8080",
+				AnthropicURL:           "https://api.anthropic.com",
+				UpstreamMaxRetries:     2,
+				UpstreamRetryBaseDelay: time.Second,
+				UpstreamRetryMaxDelay:  100 * time.Millisecond,
+				CacheStrategy:          "moderate",
+				LogLevel:               "info",
+				MaxCacheBreakpoints:    4,
+				TokenMultiplier:        1.0,
+				TokenizerMode:          "offline",
+			},
+			expectError:   true,
+			errorContains: "cannot be shorter than the base delay",
+		},
+		{
+			name: "Circuit breaker without a cooldown",
+			config: &Config{
+				Port:                    "8080",
+				AnthropicURL:            "https://api.anthropic.com",
+				CircuitBreakerThreshold: 5,
+				CacheStrategy:           "moderate",
+				LogLevel:                "info",
+				MaxCacheBreakpoints:     4,
+				TokenMultiplier:         1.0,
+				TokenizerMode:           "offline",
+			},
+			expectError:   true,
+			errorContains: "circuit breaker cooldown must be positive",
+		},
+		{
+			name: "Invalid TTL cold start",
+			config: &Config{
+				Port:                "8080",
+				AnthropicURL:        "https://api.anthropic.com",
+				CacheStrategy:       "moderate",
+				TTLColdStart:        "30m",
+				LogLevel:            "info",
+				MaxCacheBreakpoints: 4,
+				TokenMultiplier:     1.0,
+				TokenizerMode:       "offline",
+			},
+			expectError:   true,
+			errorContains: "invalid TTL cold start",
+		},
 		{
 			name: "Invalid cache strategy",
 			config: &Config{
@@ -247,6 +433,27 @@ func TestConfigValidation(t *testing.T) {
 	}
 }
 
+func TestDefaultUpstreamURL(t *testing.T) {
+	tests := []struct {
+		dialect  string
+		expected string
+	}{
+		{"anthropic", "https://api.anthropic.com"},
+		{"bedrock", "https://bedrock-runtime.eu-west-1.amazonaws.com"},
+		{"vertex", "https://europe-west1-aiplatform.googleapis.com"},
+	}
+
+	for _, tt := range tests {
+		if got := DefaultUpstreamURL(tt.dialect, "eu-west-1", "europe-west1"); got != tt.expected {
+			t.Errorf("DefaultUpstreamURL(%q) = %s, expected %s", tt.dialect, got, tt.expected)
+		}
+	}
+
+	if got := DefaultUpstreamURL("vertex", "", "global"); got != "https://aiplatform.googleapis.com" {
+		t.Errorf("Expected the global Vertex endpoint, got %s", got)
+	}
+}
+
 func TestGetServerAddress(t *testing.T) {
 	cfg := &Config{
 		Host: "127.0.0.1",
@@ -699,3 +906,85 @@ func TestConfigSummaryWithTokenizer(t *testing.T) {
 		}
 	}
 }
+
+// TestSavingsStoreValidation tests SAVINGS_STORE configuration
+func TestSavingsStoreValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		store       string
+		path        string
+		retention   time.Duration
+		expectError bool
+	}{
+		{"Default store", "", "", 0, false},
+		{"Memory store", "memory", "", 0, false},
+		{"File store", "file", "data/history.jsonl", 720 * time.Hour, false},
+		{"File store without path", "file", "", 0, true},
+		{"Unknown store", "redis", "", 0, true},
+		{"Negative retention", "memory", "", -time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Port:                "8080",
+				AnthropicURL:        "https://api.anthropic.com",
+				CacheStrategy:       "moderate",
+				LogLevel:            "info",
+				MaxCacheBreakpoints: 4,
+				TokenMultiplier:     1.0,
+				TokenizerMode:       "offline",
+				SavingsStore:        tt.store,
+				SavingsStorePath:    tt.path,
+				SavingsRetention:    tt.retention,
+			}
+
+			err := cfg.Validate()
+
+			if tt.expectError && err == nil {
+				t.Error("Expected validation error but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSavingsHistoryDefaults(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           map[string]string
+		expectSize    int
+		expectEnabled bool
+	}{
+		{"Memory store", map[string]string{}, 100, true},
+		{"Memory store disabled", map[string]string{"SAVINGS_HISTORY_SIZE": "0"}, 0, false},
+		{"File store without retention", map[string]string{"SAVINGS_STORE": "file"}, 100, true},
+		{"File store kept by age", map[string]string{"SAVINGS_STORE": "file", "SAVINGS_RETENTION": "720h"}, 0, true},
+		{"File store by age with explicit 0", map[string]string{"SAVINGS_STORE": "file", "SAVINGS_RETENTION": "720h", "SAVINGS_HISTORY_SIZE": "0"}, 0, true},
+		{"File store by age and count", map[string]string{"SAVINGS_STORE": "file", "SAVINGS_RETENTION": "720h", "SAVINGS_HISTORY_SIZE": "5000"}, 5000, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"SAVINGS_STORE", "SAVINGS_RETENTION", "SAVINGS_HISTORY_SIZE"} {
+				t.Setenv(key, tt.env[key])
+				if tt.env[key] == "" {
+					os.Unsetenv(key)
+				}
+			}
+
+			cfg, err := LoadConfig()
+			if err != nil {
+				t.Fatalf("LoadConfig failed: %v", err)
+			}
+			if cfg.SavingsHistorySize != tt.expectSize {
+				t.Errorf("Expected history size %d, got %d", tt.expectSize, cfg.SavingsHistorySize)
+			}
+			if cfg.SavingsHistoryEnabled() != tt.expectEnabled {
+				t.Errorf("Expected history enabled=%v", tt.expectEnabled)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"autocache/internal/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+// strategyDefinition is one entry of a strategies file. Fields left out are inherited
+// from the strategy named by extends (moderate by default).
+type strategyDefinition struct {
+	Extends string `json:"extends" yaml:"extends"`
+}
+
+// LoadStrategies reads named cache strategies from a YAML (.yaml, .yml) or JSON file:
+//
+//	strategies:
+//	  agents:
+//	    extends: conversation
+//	    content_ttl: 1h
+//	    weights:
+//	      tools: 3.0
+//
+// Unknown fields are rejected so typos do not silently fall back to defaults.
+func LoadStrategies(path string) (map[string]types.StrategyConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read strategies file: %w", err)
+	}
+
+	var raw map[string][]byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		raw, err = splitYAMLStrategies(data)
+	case ".json":
+		raw, err = splitJSONStrategies(data)
+	default:
+		return nil, fmt.Errorf("unsupported strategies file extension %q (use .yaml, .yml or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse strategies file: %w", err)
+	}
+
+	strategies := make(map[string]types.StrategyConfig, len(raw))
+	for _, name := range sortedNames(raw) {
+		strategy, err := decodeStrategy(path, raw[name])
+		if err != nil {
+			return nil, fmt.Errorf("strategy %s: %w", name, err)
+		}
+		strategies[name] = strategy
+	}
+
+	return strategies, nil
+}
+
+// splitJSONStrategies returns the raw definition of every strategy in a JSON file
+func splitJSONStrategies(data []byte) (map[string][]byte, error) {
+	var file struct {
+		Strategies map[string]json.RawMessage `json:"strategies"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string][]byte, len(file.Strategies))
+	for name, definition := range file.Strategies {
+		raw[name] = definition
+	}
+	return raw, nil
+}
+
+// splitYAMLStrategies returns the raw definition of every strategy in a YAML file
+func splitYAMLStrategies(data []byte) (map[string][]byte, error) {
+	var file struct {
+		Strategies map[string]yaml.Node `yaml:"strategies"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string][]byte, len(file.Strategies))
+	for name, node := range file.Strategies {
+		definition, err := yaml.Marshal(&node)
+		if err != nil {
+			return nil, err
+		}
+		raw[name] = definition
+	}
+	return raw, nil
+}
+
+// decodeStrategy applies a definition on top of the strategy it extends
+func decodeStrategy(path string, definition []byte) (types.StrategyConfig, error) {
+	// Both formats share the field names, so the definition can be decoded twice:
+	// once for extends, once onto the base strategy
+	var header strategyDefinition
+	var strategy struct {
+		types.StrategyConfig `yaml:",inline"`
+		Extends              string `json:"extends" yaml:"extends"`
+	}
+
+	isJSON := strings.EqualFold(filepath.Ext(path), ".json")
+	decode := func(target interface{}, strict bool) error {
+		if isJSON {
+			decoder := json.NewDecoder(bytes.NewReader(definition))
+			if strict {
+				decoder.DisallowUnknownFields()
+			}
+			return decoder.Decode(target)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(definition))
+		decoder.KnownFields(strict)
+		return decoder.Decode(target)
+	}
+
+	if err := decode(&header, false); err != nil {
+		return types.StrategyConfig{}, err
+	}
+
+	base := types.StrategyModerate
+	if header.Extends != "" {
+		base = types.CacheStrategy(header.Extends)
+		if !isBuiltinStrategy(header.Extends) {
+			return types.StrategyConfig{}, fmt.Errorf("cannot extend unknown strategy %s", header.Extends)
+		}
+	}
+
+	strategy.StrategyConfig = types.GetStrategyConfig(base)
+	if err := decode(&strategy, true); err != nil {
+		return types.StrategyConfig{}, err
+	}
+
+	return strategy.StrategyConfig, nil
+}
+
+// ValidateStrategy checks that a strategy can be used for cache injection
+func ValidateStrategy(strategy types.StrategyConfig) error {
+	if strategy.MaxBreakpoints < 1 || strategy.MaxBreakpoints > 4 {
+		return fmt.Errorf("max_breakpoints must be between 1 and 4, got: %d", strategy.MaxBreakpoints)
+	}
+
+	if strategy.MinTokensMultiplier <= 0 {
+		return fmt.Errorf("min_tokens_multiplier must be positive, got: %f", strategy.MinTokensMultiplier)
+	}
+
+	if strategy.MinTokens < 0 {
+		return fmt.Errorf("min_tokens cannot be negative, got: %d", strategy.MinTokens)
+	}
+
+	for field, ttl := range map[string]string{
+		"system_ttl":  strategy.SystemTTL,
+		"tools_ttl":   strategy.ToolsTTL,
+		"content_ttl": strategy.ContentTTL,
+	} {
+		if ttl != "5m" && ttl != "1h" {
+			return fmt.Errorf("%s must be 5m or 1h, got: %q", field, ttl)
+		}
+	}
+
+	validContentTypes := map[string]bool{
+		"system":  true,
+		"tools":   true,
+		"content": true,
+	}
+
+	for _, contentType := range strategy.Priority {
+		if !validContentTypes[contentType] {
+			return fmt.Errorf("invalid priority entry: %s (must be one of: system, tools, content)", contentType)
+		}
+	}
+
+	validPlacements := map[string]bool{
+		"":                          true,
+		types.PlacementBlocks:       true,
+		types.PlacementConversation: true,
+	}
+
+	if !validPlacements[strategy.Placement] {
+		return fmt.Errorf("invalid placement: %s (must be one of: blocks, conversation)", strategy.Placement)
+	}
+
+	weights := strategy.Weights
+	for field, weight := range map[string]float64{
+		"system":  weights.System,
+		"tools":   weights.Tools,
+		"content": weights.Content,
+	} {
+		if weight < 0 {
+			return fmt.Errorf("weight %s cannot be negative, got: %f", field, weight)
+		}
+	}
+
+	return nil
+}
+
+// StrategyNames lists the built-in strategies followed by the custom ones, sorted
+func (c *Config) StrategyNames() []string {
+	names := make([]string, 0, len(types.BuiltinStrategies)+len(c.Strategies))
+	for _, strategy := range types.BuiltinStrategies {
+		names = append(names, string(strategy))
+	}
+	for _, name := range sortedNames(c.Strategies) {
+		if !isBuiltinStrategy(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ResolveStrategy returns the named strategy, custom definitions taking precedence over
+// built-in ones, with MAX_CACHE_BREAKPOINTS as a cap on its breakpoints and
+// TOKEN_MULTIPLIER scaling its minimum token threshold
+func (c *Config) ResolveStrategy(name string) (types.StrategyConfig, bool) {
+	strategy, ok := c.Strategies[name]
+	if !ok {
+		if !isBuiltinStrategy(name) {
+			return types.StrategyConfig{}, false
+		}
+		strategy = types.GetStrategyConfig(types.CacheStrategy(name))
+	}
+
+	if c.MaxCacheBreakpoints > 0 && c.MaxCacheBreakpoints < strategy.MaxBreakpoints {
+		strategy.MaxBreakpoints = c.MaxCacheBreakpoints
+	}
+	if c.TokenMultiplier > 0 {
+		strategy.MinTokensMultiplier *= c.TokenMultiplier
+	}
+
+	return strategy, true
+}
+
+func isBuiltinStrategy(name string) bool {
+	for _, strategy := range types.BuiltinStrategies {
+		if string(strategy) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
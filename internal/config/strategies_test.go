@@ -0,0 +1,267 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"autocache/internal/types"
+)
+
+func writeStrategiesFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write strategies file: %v", err)
+	}
+	return path
+}
+
+func TestLoadStrategiesYAML(t *testing.T) {
+	path := writeStrategiesFile(t, "strategies.yaml", `
+strategies:
+  agents:
+    extends: conversation
+    content_ttl: 1h
+    weights:
+      tools: 3.0
+      content: 0
+  docs:
+    max_breakpoints: 2
+    min_tokens_multiplier: 1.5
+    priority: [content, system]
+`)
+
+	strategies, err := LoadStrategies(path)
+	if err != nil {
+		t.Fatalf("LoadStrategies failed: %v", err)
+	}
+
+	agents := strategies["agents"]
+	expected := types.GetStrategyConfig(types.StrategyConversation)
+	expected.ContentTTL = "1h"
+	expected.Weights.Tools = 3.0
+	expected.Weights.Content = 0 // Turned off, not replaced by the default
+	if !reflect.DeepEqual(agents, expected) {
+		t.Errorf("Expected agents to extend conversation:\n got  %+v\n want %+v", agents, expected)
+	}
+
+	docs := strategies["docs"]
+	if docs.MaxBreakpoints != 2 || docs.MinTokensMultiplier != 1.5 {
+		t.Errorf("Expected overridden cap and multiplier, got %+v", docs)
+	}
+	if strings.Join(docs.Priority, ",") != "content,system" {
+		t.Errorf("Expected priority to be replaced, got %v", docs.Priority)
+	}
+	// Unset fields come from moderate
+	if docs.SystemTTL != "1h" || docs.ContentTTL != "5m" || docs.Placement != types.PlacementBlocks {
+		t.Errorf("Expected moderate defaults for unset fields, got %+v", docs)
+	}
+	if docs.Weights != types.DefaultScoreWeights() {
+		t.Errorf("Expected default weights, got %+v", docs.Weights)
+	}
+}
+
+func TestLoadStrategiesJSON(t *testing.T) {
+	path := writeStrategiesFile(t, "strategies.json", `{
+		"strategies": {
+			"batch": {
+				"extends": "aggressive",
+				"system_ttl": "5m",
+				"weights": {"content": 0.5}
+			}
+		}
+	}`)
+
+	strategies, err := LoadStrategies(path)
+	if err != nil {
+		t.Fatalf("LoadStrategies failed: %v", err)
+	}
+
+	batch := strategies["batch"]
+	if batch.MaxBreakpoints != 4 || batch.MinTokensMultiplier != 0.8 || batch.SystemTTL != "5m" {
+		t.Errorf("Expected aggressive with a 5m system TTL, got %+v", batch)
+	}
+	if batch.Weights.Content != 0.5 || batch.Weights.System != 2.0 {
+		t.Errorf("Expected one overridden weight, got %+v", batch.Weights)
+	}
+}
+
+func TestLoadStrategiesErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		file          string
+		content       string
+		errorContains string
+	}{
+		{
+			name:          "Unknown field in YAML",
+			file:          "strategies.yaml",
+			content:       "strategies:\n  typo:\n    max_breakpoint: 2\n",
+			errorContains: "max_breakpoint",
+		},
+		{
+			name:          "Unknown field in JSON",
+			file:          "strategies.json",
+			content:       `{"strategies": {"typo": {"weights": {"systems": 2}}}}`,
+			errorContains: "systems",
+		},
+		{
+			name:          "Unknown base strategy",
+			file:          "strategies.yaml",
+			content:       "strategies:\n  child:\n    extends: parent\n",
+			errorContains: "cannot extend unknown strategy parent",
+		},
+		{
+			name:          "Unsupported extension",
+			file:          "strategies.toml",
+			content:       "",
+			errorContains: "unsupported strategies file extension",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadStrategies(writeStrategiesFile(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("Expected error containing %q, got %v", tt.errorContains, err)
+			}
+		})
+	}
+
+	if _, err := LoadStrategies(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
+
+func TestValidateStrategy(t *testing.T) {
+	valid := types.GetStrategyConfig(types.StrategyModerate)
+
+	tests := []struct {
+		name          string
+		modify        func(s *types.StrategyConfig)
+		errorContains string
+	}{
+		{"Valid", func(s *types.StrategyConfig) {}, ""},
+		{"Too many breakpoints", func(s *types.StrategyConfig) { s.MaxBreakpoints = 5 }, "max_breakpoints"},
+		{"Zero multiplier", func(s *types.StrategyConfig) { s.MinTokensMultiplier = 0 }, "min_tokens_multiplier"},
+		{"Negative min tokens", func(s *types.StrategyConfig) { s.MinTokens = -1 }, "min_tokens cannot be negative"},
+		{"Invalid TTL", func(s *types.StrategyConfig) { s.ToolsTTL = "10m" }, "tools_ttl"},
+		{"Invalid priority", func(s *types.StrategyConfig) { s.Priority = []string{"images"} }, "invalid priority entry"},
+		{"Invalid placement", func(s *types.StrategyConfig) { s.Placement = "tail" }, "invalid placement"},
+		{"Negative weight", func(s *types.StrategyConfig) { s.Weights.Tools = -1 }, "weight tools"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := valid
+			strategy.Priority = append([]string(nil), valid.Priority...)
+			tt.modify(&strategy)
+
+			err := ValidateStrategy(strategy)
+			if tt.errorContains == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("Expected error containing %q, got %v", tt.errorContains, err)
+			}
+		})
+	}
+}
+
+func TestResolveStrategy(t *testing.T) {
+	custom := types.GetStrategyConfig(types.StrategyModerate)
+	custom.MaxBreakpoints = 4
+	custom.ContentTTL = "1h"
+
+	cfg := &Config{
+		CacheStrategy:       "team",
+		MaxCacheBreakpoints: 2,
+		TokenMultiplier:     1.5,
+		Strategies:          map[string]types.StrategyConfig{"team": custom},
+	}
+
+	strategy, ok := cfg.ResolveStrategy("team")
+	if !ok {
+		t.Fatal("Expected custom strategy to resolve")
+	}
+	if strategy.MaxBreakpoints != 2 {
+		t.Errorf("Expected MAX_CACHE_BREAKPOINTS to cap breakpoints at 2, got %d", strategy.MaxBreakpoints)
+	}
+	if strategy.MinTokensMultiplier != 1.5 || strategy.ContentTTL != "1h" {
+		t.Errorf("Expected TOKEN_MULTIPLIER to scale the custom strategy, got %+v", strategy)
+	}
+	if cfg.Strategies["team"].MaxBreakpoints != 4 {
+		t.Error("Expected the stored strategy to be left unchanged")
+	}
+
+	// The cap only lowers the strategy's own limit
+	cfg.MaxCacheBreakpoints = 4
+	if conservative, _ := cfg.ResolveStrategy("conservative"); conservative.MaxBreakpoints != 2 || conservative.MinTokensMultiplier != 3.0 {
+		t.Errorf("Expected conservative with 2 breakpoints and a 3.0 multiplier, got %+v", conservative)
+	}
+
+	if _, ok := cfg.ResolveStrategy("unknown"); ok {
+		t.Error("Expected unknown strategy not to resolve")
+	}
+
+	if names := strings.Join(cfg.StrategyNames(), ","); names != "conservative,moderate,aggressive,conversation,team" {
+		t.Errorf("Unexpected strategy names: %s", names)
+	}
+}
+
+func TestValidateCustomStrategy(t *testing.T) {
+	base := func() *Config {
+		return &Config{
+			Port:                "8080",
+			AnthropicURL:        "https://api.anthropic.com",
+			CacheStrategy:       "team",
+			LogLevel:            "info",
+			MaxCacheBreakpoints: 4,
+			TokenMultiplier:     1.0,
+			TokenizerMode:       "offline",
+			Strategies:          map[string]types.StrategyConfig{"team": types.GetStrategyConfig(types.StrategyModerate)},
+		}
+	}
+
+	if err := base().Validate(); err != nil {
+		t.Errorf("Expected custom strategy to be accepted, got %v", err)
+	}
+
+	cfg := base()
+	cfg.CacheStrategy = "other"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "team") {
+		t.Errorf("Expected error listing the custom strategy, got %v", err)
+	}
+
+	cfg = base()
+	broken := cfg.Strategies["team"]
+	broken.SystemTTL = "2h"
+	cfg.Strategies["team"] = broken
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "invalid cache strategy team") {
+		t.Errorf("Expected invalid custom strategy to be rejected, got %v", err)
+	}
+}
+
+func TestLoadConfigStrategiesFile(t *testing.T) {
+	path := writeStrategiesFile(t, "strategies.yml", "strategies:\n  team:\n    max_breakpoints: 1\n")
+
+	t.Setenv("STRATEGIES_FILE", path)
+	t.Setenv("CACHE_STRATEGY", "team")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Strategies["team"].MaxBreakpoints != 1 {
+		t.Errorf("Expected strategies to be loaded from the file, got %+v", cfg.Strategies)
+	}
+
+	t.Setenv("STRATEGIES_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, err := LoadConfig(); err == nil {
+		t.Error("Expected LoadConfig to fail when the strategies file is missing")
+	}
+}
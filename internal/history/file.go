@@ -0,0 +1,212 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"autocache/internal/types"
+)
+
+// FileStore keeps history in an append-only JSON Lines file so it survives restarts.
+//
+// Every append is a single line followed by fsync, so a crash can at worst leave a
+// torn final line, which is skipped on the next open. Expired entries are removed by
+// rewriting the file to a temporary path and renaming it over the original.
+type FileStore struct {
+	mu        sync.RWMutex
+	path      string
+	file      *os.File
+	entries   []types.CacheMetadata // Retained entries, oldest first
+	lines     int                   // Entries written to the file, including ones no longer retained
+	retention Retention
+	now       func() time.Time
+}
+
+// OpenFileStore loads the history at path, creating the file if needed
+func OpenFileStore(path string, retention Retention) (*FileStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("savings store path cannot be empty")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create savings store directory: %w", err)
+	}
+
+	fs := &FileStore{
+		path:      path,
+		retention: retention,
+		now:       time.Now,
+	}
+
+	entries, err := readEntries(path)
+	if err != nil {
+		return nil, err
+	}
+	fs.entries = retention.apply(entries, fs.now())
+
+	// Start from a clean file: drops expired entries and any torn line left by a crash
+	if err := fs.compact(); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// readEntries decodes every complete line of the file, skipping lines that fail to parse
+func readEntries(path string) ([]types.CacheMetadata, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open savings store: %w", err)
+	}
+	defer file.Close()
+
+	var entries []types.CacheMetadata
+	reader := bufio.NewReader(file)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var entry types.CacheMetadata
+			if err := json.Unmarshal(line, &entry); err == nil {
+				entries = append(entries, entry)
+			}
+		}
+
+		if readErr == io.EOF {
+			return entries, nil
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read savings store: %w", readErr)
+		}
+	}
+}
+
+// Append writes the metadata to disk before making it visible to queries
+func (fs *FileStore) Append(metadata types.CacheMetadata) error {
+	line, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode history entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.file == nil {
+		return fmt.Errorf("savings store is closed")
+	}
+
+	if _, err := fs.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write history entry: %w", err)
+	}
+	if err := fs.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync savings store: %w", err)
+	}
+
+	fs.lines++
+	fs.entries = fs.retention.apply(append(fs.entries, metadata), fs.now())
+
+	// Rewrite once more than half of the file is stale, so compaction cost stays amortized
+	if fs.lines > 2*len(fs.entries) && fs.lines > 64 {
+		if err := fs.compact(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Query returns the unexpired entries within the range, oldest first
+func (fs *FileStore) Query(query Query) ([]types.CacheMetadata, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	now := fs.now()
+	result := make([]types.CacheMetadata, 0, len(fs.entries))
+	for _, entry := range fs.entries {
+		if fs.retention.expired(entry.Timestamp, now) || !query.Matches(entry.Timestamp) {
+			continue
+		}
+		result = append(result, entry)
+	}
+
+	return result, nil
+}
+
+// Close closes the underlying file
+func (fs *FileStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.file == nil {
+		return nil
+	}
+	err := fs.file.Close()
+	fs.file = nil
+	return err
+}
+
+// compact atomically replaces the file with the retained entries and reopens it for appending.
+// Callers must hold the write lock (or own the store exclusively).
+func (fs *FileStore) compact() error {
+	tmpPath := fs.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create savings store snapshot: %w", err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range fs.entries {
+		if err := encoder.Encode(entry); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to encode history entry: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write savings store snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync savings store snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close savings store snapshot: %w", err)
+	}
+
+	// On failure the previous file stays in place and open, so appends keep working
+	if err := os.Rename(tmpPath, fs.path); err != nil {
+		return fmt.Errorf("failed to replace savings store: %w", err)
+	}
+	syncDir(filepath.Dir(fs.path))
+
+	file, err := os.OpenFile(fs.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open savings store: %w", err)
+	}
+	if fs.file != nil {
+		_ = fs.file.Close()
+	}
+	fs.file = file
+	fs.lines = len(fs.entries)
+
+	return nil
+}
+
+// syncDir flushes a directory entry so a rename survives a crash (best effort)
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+}
@@ -0,0 +1,152 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"autocache/internal/config"
+)
+
+func TestFileStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history", "savings.jsonl")
+	base := time.Now().UTC()
+
+	store, err := OpenFileStore(path, Retention{MaxEntries: 100})
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := store.Append(entryAt(base.Add(time.Duration(i)*time.Second), i)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close store: %v", err)
+	}
+
+	reopened, err := OpenFileStore(path, Retention{MaxEntries: 100})
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer reopened.Close()
+
+	entries, _ := reopened.Query(Query{})
+	if got := totalTokens(entries); !equalInts(got, []int{1, 2, 3}) {
+		t.Fatalf("Expected entries to survive a reopen, got %v", got)
+	}
+	if !entries[0].Timestamp.Equal(base.Add(time.Second)) {
+		t.Errorf("Expected timestamp to round trip, got %v", entries[0].Timestamp)
+	}
+}
+
+func TestFileStoreSkipsTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "savings.jsonl")
+
+	store, err := OpenFileStore(path, Retention{})
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	_ = store.Append(entryAt(time.Now(), 1))
+	_ = store.Close()
+
+	// Simulate a crash in the middle of writing the second entry
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	_, _ = file.WriteString(`{"cache_injected":true,"total_tok`)
+	_ = file.Close()
+
+	reopened, err := OpenFileStore(path, Retention{})
+	if err != nil {
+		t.Fatalf("Expected torn line to be skipped, got error: %v", err)
+	}
+	_ = reopened.Append(entryAt(time.Now(), 2))
+	_ = reopened.Close()
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "total_tok\"") || strings.Count(string(data), "\n") != 2 {
+		t.Errorf("Expected the torn line to be removed on open, file is:\n%s", data)
+	}
+
+	final, _ := OpenFileStore(path, Retention{})
+	defer final.Close()
+	entries, _ := final.Query(Query{})
+	if got := totalTokens(entries); !equalInts(got, []int{1, 2}) {
+		t.Errorf("Expected entries before and after the crash, got %v", got)
+	}
+}
+
+func TestFileStoreRetentionCompactsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "savings.jsonl")
+	now := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	store, err := OpenFileStore(path, Retention{MaxEntries: 10, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	store.now = func() time.Time { return now }
+
+	_ = store.Append(entryAt(now.Add(-2*time.Hour), 0)) // Already expired
+	for i := 1; i <= 200; i++ {
+		_ = store.Append(entryAt(now.Add(-time.Duration(200-i)*time.Second), i))
+	}
+
+	entries, _ := store.Query(Query{})
+	if got := totalTokens(entries); !equalInts(got, []int{191, 192, 193, 194, 195, 196, 197, 198, 199, 200}) {
+		t.Errorf("Expected the 10 most recent entries, got %v", got)
+	}
+	_ = store.Close()
+
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines > 64 {
+		t.Errorf("Expected file to be compacted, it has %d lines", lines)
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	tests := []struct {
+		name        string
+		store       string
+		expectError bool
+		expectType  string
+	}{
+		{"Default", "", false, "memory"},
+		{"Memory", "memory", false, "memory"},
+		{"File", "file", false, "file"},
+		{"Unknown", "redis", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				SavingsHistorySize: 10,
+				SavingsStore:       tt.store,
+				SavingsStorePath:   filepath.Join(t.TempDir(), "savings.jsonl"),
+			}
+
+			store, err := NewStore(cfg)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer store.Close()
+
+			switch store.(type) {
+			case *MemoryStore:
+				if tt.expectType != "memory" {
+					t.Errorf("Expected %s store, got memory", tt.expectType)
+				}
+			case *FileStore:
+				if tt.expectType != "file" {
+					t.Errorf("Expected %s store, got file", tt.expectType)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package history
+
+import (
+	"sync"
+	"time"
+
+	"autocache/internal/types"
+)
+
+// MemoryStore keeps history in a fixed-size ring buffer. Nothing survives a restart.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	entries   []types.CacheMetadata
+	next      int // Slot for the next append once the buffer is full
+	retention Retention
+	now       func() time.Time
+}
+
+// NewMemoryStore creates an in-memory store. Without MaxEntries the buffer grows until entries expire.
+func NewMemoryStore(retention Retention) *MemoryStore {
+	return &MemoryStore{
+		entries:   make([]types.CacheMetadata, 0, max(retention.MaxEntries, 0)),
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+// Append records the metadata, overwriting the oldest entry when the buffer is full
+func (ms *MemoryStore) Append(metadata types.CacheMetadata) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.retention.MaxEntries > 0 && len(ms.entries) == ms.retention.MaxEntries {
+		ms.entries[ms.next] = metadata
+		ms.next = (ms.next + 1) % len(ms.entries)
+		return nil
+	}
+
+	ms.entries = append(ms.entries, metadata)
+	if ms.retention.MaxEntries <= 0 {
+		// Unbounded buffer: drop expired entries so it does not grow forever
+		ms.entries = ms.retention.apply(ms.entries, ms.now())
+	}
+	return nil
+}
+
+// Query returns the unexpired entries within the range, oldest first
+func (ms *MemoryStore) Query(query Query) ([]types.CacheMetadata, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	now := ms.now()
+	result := make([]types.CacheMetadata, 0, len(ms.entries))
+	for i := range ms.entries {
+		entry := ms.entries[(ms.next+i)%len(ms.entries)]
+		if ms.retention.expired(entry.Timestamp, now) || !query.Matches(entry.Timestamp) {
+			continue
+		}
+		result = append(result, entry)
+	}
+
+	return result, nil
+}
+
+// Close is a no-op for the in-memory store
+func (ms *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,105 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"autocache/internal/types"
+)
+
+func entryAt(timestamp time.Time, tokens int) types.CacheMetadata {
+	return types.CacheMetadata{
+		TotalTokens: tokens,
+		Model:       "claude-3-5-sonnet-20241022",
+		Timestamp:   timestamp,
+	}
+}
+
+func totalTokens(entries []types.CacheMetadata) []int {
+	tokens := make([]int, len(entries))
+	for i, entry := range entries {
+		tokens[i] = entry.TotalTokens
+	}
+	return tokens
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryStoreRingBuffer(t *testing.T) {
+	store := NewMemoryStore(Retention{MaxEntries: 3})
+	base := time.Now()
+
+	for i := 1; i <= 5; i++ {
+		if err := store.Append(entryAt(base.Add(time.Duration(i)*time.Second), i)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	entries, err := store.Query(Query{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := totalTokens(entries); !equalInts(got, []int{3, 4, 5}) {
+		t.Errorf("Expected the 3 most recent entries oldest first, got %v", got)
+	}
+}
+
+func TestMemoryStoreRetentionByAge(t *testing.T) {
+	now := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, maxEntries := range []int{0, 10} {
+		store := NewMemoryStore(Retention{MaxEntries: maxEntries, MaxAge: 24 * time.Hour})
+		store.now = func() time.Time { return now }
+
+		_ = store.Append(entryAt(now.Add(-48*time.Hour), 1))
+		_ = store.Append(entryAt(now.Add(-2*time.Hour), 2))
+		_ = store.Append(entryAt(now.Add(-time.Minute), 3))
+
+		entries, _ := store.Query(Query{})
+		if got := totalTokens(entries); !equalInts(got, []int{2, 3}) {
+			t.Errorf("MaxEntries=%d: expected expired entry to be dropped, got %v", maxEntries, got)
+		}
+	}
+}
+
+func TestQueryTimeRange(t *testing.T) {
+	base := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(Retention{MaxEntries: 10})
+	for i := 0; i < 5; i++ {
+		_ = store.Append(entryAt(base.Add(time.Duration(i)*time.Hour), i))
+	}
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected []int
+	}{
+		{"Unbounded", Query{}, []int{0, 1, 2, 3, 4}},
+		{"Since is inclusive", Query{Since: base.Add(2 * time.Hour)}, []int{2, 3, 4}},
+		{"Until is exclusive", Query{Until: base.Add(2 * time.Hour)}, []int{0, 1}},
+		{"Both bounds", Query{Since: base.Add(time.Hour), Until: base.Add(3 * time.Hour)}, []int{1, 2}},
+		{"Empty range", Query{Since: base.Add(10 * time.Hour)}, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := store.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := totalTokens(entries); !equalInts(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
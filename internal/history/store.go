@@ -0,0 +1,78 @@
+package history
+
+import (
+	"fmt"
+	"time"
+
+	"autocache/internal/config"
+	"autocache/internal/types"
+)
+
+// Store persists request metadata for the savings endpoint
+type Store interface {
+	// Append records the metadata of one request
+	Append(metadata types.CacheMetadata) error
+
+	// Query returns the retained entries within the time range, oldest first
+	Query(query Query) ([]types.CacheMetadata, error)
+
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// Query selects entries by timestamp. Zero times leave that side of the range open.
+type Query struct {
+	Since time.Time // Inclusive
+	Until time.Time // Exclusive
+}
+
+// Matches reports whether a timestamp falls within the query range
+func (q Query) Matches(timestamp time.Time) bool {
+	if !q.Since.IsZero() && timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !timestamp.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Retention limits how much history a store keeps. Zero values disable the limit.
+type Retention struct {
+	MaxEntries int           // Keep only the most recent N entries
+	MaxAge     time.Duration // Drop entries older than this
+}
+
+// expired reports whether an entry with the given timestamp is past the maximum age
+func (r Retention) expired(timestamp, now time.Time) bool {
+	return r.MaxAge > 0 && timestamp.Before(now.Add(-r.MaxAge))
+}
+
+// apply trims entries (oldest first) to the retention limits
+func (r Retention) apply(entries []types.CacheMetadata, now time.Time) []types.CacheMetadata {
+	start := 0
+	for start < len(entries) && r.expired(entries[start].Timestamp, now) {
+		start++
+	}
+	if r.MaxEntries > 0 && len(entries)-start > r.MaxEntries {
+		start = len(entries) - r.MaxEntries
+	}
+	return entries[start:]
+}
+
+// NewStore creates the history store selected by the configuration
+func NewStore(cfg *config.Config) (Store, error) {
+	retention := Retention{
+		MaxEntries: cfg.SavingsHistorySize,
+		MaxAge:     cfg.SavingsRetention,
+	}
+
+	switch cfg.SavingsStore {
+	case "", "memory":
+		return NewMemoryStore(retention), nil
+	case "file":
+		return OpenFileStore(cfg.SavingsStorePath, retention)
+	default:
+		return nil, fmt.Errorf("unknown savings store: %s", cfg.SavingsStore)
+	}
+}
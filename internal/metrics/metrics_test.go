@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("test_requests_total", "Requests.", "model", "status")
+
+	counter.Inc("sonnet", "200")
+	counter.Inc("sonnet", "200")
+	counter.Add(3, "haiku", "429")
+	counter.Add(-1, "haiku", "429") // Counters never decrease
+
+	if v := counter.Value("sonnet", "200"); v != 2 {
+		t.Errorf("Expected 2, got %f", v)
+	}
+
+	var sb strings.Builder
+	if err := registry.WriteText(&sb); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "# HELP test_requests_total Requests.\n" +
+		"# TYPE test_requests_total counter\n" +
+		`test_requests_total{model="haiku",status="429"} 3` + "\n" +
+		`test_requests_total{model="sonnet",status="200"} 2` + "\n"
+	if sb.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nexpected:\n%s", sb.String(), expected)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	registry := NewRegistry()
+	histogram := registry.NewHistogramVec("test_latency_seconds", "Latency.", []float64{1, 0.1}, "route")
+
+	histogram.Observe(0.05, "a")
+	histogram.Observe(0.1, "a") // Upper bounds are inclusive
+	histogram.Observe(0.5, "a")
+	histogram.Observe(5, "a")
+
+	if c := histogram.Count("a"); c != 4 {
+		t.Errorf("Expected 4 observations, got %d", c)
+	}
+
+	var sb strings.Builder
+	_ = registry.WriteText(&sb)
+
+	expected := "# HELP test_latency_seconds Latency.\n" +
+		"# TYPE test_latency_seconds histogram\n" +
+		`test_latency_seconds_bucket{route="a",le="0.1"} 2` + "\n" +
+		`test_latency_seconds_bucket{route="a",le="1"} 3` + "\n" +
+		`test_latency_seconds_bucket{route="a",le="+Inf"} 4` + "\n" +
+		`test_latency_seconds_sum{route="a"} 5.65` + "\n" +
+		`test_latency_seconds_count{route="a"} 4` + "\n"
+	if sb.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nexpected:\n%s", sb.String(), expected)
+	}
+}
+
+func TestCounterFuncAndEscaping(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterFunc("test_panics_total", "Panics with a \\ backslash.", func() float64 { return 7 })
+	counter := registry.NewCounterVec("test_labels_total", "Labels.", "value")
+	counter.Inc("quote \" and\nnewline")
+
+	var sb strings.Builder
+	_ = registry.WriteText(&sb)
+	output := sb.String()
+
+	for _, line := range []string{
+		`# HELP test_panics_total Panics with a \\ backslash.`,
+		"test_panics_total 7\n",
+		`test_labels_total{value="quote \" and\nnewline"} 1`,
+	} {
+		if !strings.Contains(output, line) {
+			t.Errorf("Expected output to contain %q, got:\n%s", line, output)
+		}
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	registry := NewRegistry()
+	open := 0.0
+	registry.NewGaugeFunc("test_open", "Whether it is open.", func() float64 { return open })
+
+	open = 1
+	var sb strings.Builder
+	_ = registry.WriteText(&sb)
+	if !strings.Contains(sb.String(), "# TYPE test_open gauge\ntest_open 1\n") {
+		t.Errorf("Expected the gauge read at write time, got:\n%s", sb.String())
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on label count mismatch")
+		}
+	}()
+
+	NewRegistry().NewCounterVec("test_total", "Test.", "a", "b").Inc("only-one")
+}
@@ -0,0 +1,386 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"autocache/internal/types"
+)
+
+// DefaultMaxTokens is the max_tokens sent to Anthropic, which requires one, when the client
+// sets neither max_tokens nor max_completion_tokens
+const DefaultMaxTokens = 4096
+
+// ToAnthropic converts a chat completion request into a Messages API request. System and
+// developer messages become the system prompt, tool calls and results become tool_use and
+// tool_result blocks, and consecutive messages of the same role are merged.
+func ToAnthropic(req *ChatCompletionRequest) (*types.AnthropicRequest, error) {
+	if req.N != nil && *req.N > 1 {
+		return nil, fmt.Errorf("n > 1 is not supported")
+	}
+
+	out := &types.AnthropicRequest{
+		Model:       req.Model,
+		MaxTokens:   DefaultMaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Extra:       make(map[string]json.RawMessage),
+	}
+	if req.MaxCompletionTokens != nil {
+		out.MaxTokens = *req.MaxCompletionTokens
+	} else if req.MaxTokens != nil {
+		out.MaxTokens = *req.MaxTokens
+	}
+	if req.Stream {
+		stream := true
+		out.Stream = &stream
+	}
+
+	stop, err := parseStop(req.Stop)
+	if err != nil {
+		return nil, err
+	}
+	out.StopSequences = stop
+
+	if err := convertMessages(req.Messages, out); err != nil {
+		return nil, err
+	}
+
+	// Tools, with legacy functions after them
+	for _, tool := range req.Tools {
+		if tool.Type != "" && tool.Type != "function" {
+			return nil, fmt.Errorf("unsupported tool type: %s", tool.Type)
+		}
+		out.Tools = append(out.Tools, convertFunction(tool.Function))
+	}
+	for _, function := range req.Functions {
+		out.Tools = append(out.Tools, convertFunction(function))
+	}
+
+	if len(out.Tools) > 0 {
+		choice := req.ToolChoice
+		if len(choice) == 0 {
+			choice = req.FunctionCall
+		}
+		toolChoice, err := convertToolChoice(choice, req.ParallelToolCalls)
+		if err != nil {
+			return nil, err
+		}
+		if toolChoice != nil {
+			out.Extra["tool_choice"] = toolChoice
+		}
+	}
+
+	if req.User != "" {
+		metadata, _ := json.Marshal(map[string]string{"user_id": req.User})
+		out.Extra["metadata"] = metadata
+	}
+
+	if len(out.Extra) == 0 {
+		out.Extra = nil
+	}
+	return out, nil
+}
+
+// convertMessages converts the messages of a chat completion request into the system prompt
+// and messages of out
+func convertMessages(messages []ChatMessage, out *types.AnthropicRequest) error {
+	// IDs given to legacy function calls, by function name, for the results that follow them
+	functionCallIDs := make(map[string]string)
+
+	for i, msg := range messages {
+		switch msg.Role {
+		case "system", "developer":
+			blocks, err := convertContent(msg.Content)
+			if err != nil {
+				return fmt.Errorf("message %d: %w", i, err)
+			}
+			for _, block := range blocks {
+				if block.Type != "text" {
+					return fmt.Errorf("message %d: %s messages can only contain text", i, msg.Role)
+				}
+			}
+			out.SystemBlocks = append(out.SystemBlocks, blocks...)
+
+		case "user":
+			blocks, err := convertContent(msg.Content)
+			if err != nil {
+				return fmt.Errorf("message %d: %w", i, err)
+			}
+			appendMessage(out, "user", blocks)
+
+		case "assistant":
+			blocks, err := convertContent(msg.Content)
+			if err != nil {
+				return fmt.Errorf("message %d: %w", i, err)
+			}
+			for _, call := range msg.ToolCalls {
+				block, err := toolUseBlock(call.ID, call.Function)
+				if err != nil {
+					return fmt.Errorf("message %d: %w", i, err)
+				}
+				blocks = append(blocks, block)
+			}
+			if msg.FunctionCall != nil {
+				id := fmt.Sprintf("call_%s_%d", msg.FunctionCall.Name, i)
+				functionCallIDs[msg.FunctionCall.Name] = id
+				block, err := toolUseBlock(id, *msg.FunctionCall)
+				if err != nil {
+					return fmt.Errorf("message %d: %w", i, err)
+				}
+				blocks = append(blocks, block)
+			}
+			appendMessage(out, "assistant", blocks)
+
+		case "tool", "function":
+			id := msg.ToolCallID
+			if msg.Role == "function" {
+				id = functionCallIDs[msg.Name]
+			}
+			if id == "" {
+				return fmt.Errorf("message %d: %s message does not answer a known call", i, msg.Role)
+			}
+			result, err := convertContent(msg.Content)
+			if err != nil {
+				return fmt.Errorf("message %d: %w", i, err)
+			}
+			block := types.ContentBlock{Type: "tool_result", ToolUseID: id}
+			if msg.Content.Parts == nil {
+				block.Content, _ = json.Marshal(contentText(msg.Content))
+			} else if err := block.SetNestedBlocks(result); err != nil {
+				return fmt.Errorf("message %d: %w", i, err)
+			}
+			appendMessage(out, "user", []types.ContentBlock{block})
+
+		default:
+			return fmt.Errorf("message %d: unsupported role: %s", i, msg.Role)
+		}
+	}
+
+	return nil
+}
+
+// appendMessage adds blocks to the last message when it has the same role, since Anthropic
+// expects roles to alternate and all results of a turn's tool calls in the next message
+func appendMessage(out *types.AnthropicRequest, role string, blocks []types.ContentBlock) {
+	if len(blocks) == 0 {
+		return
+	}
+	if n := len(out.Messages); n > 0 && out.Messages[n-1].Role == role {
+		out.Messages[n-1].Content = append(out.Messages[n-1].Content, blocks...)
+		return
+	}
+	out.Messages = append(out.Messages, types.Message{Role: role, Content: blocks})
+}
+
+// convertContent converts message content into content blocks. Empty text is dropped, as
+// Anthropic rejects empty text blocks.
+func convertContent(content MessageContent) ([]types.ContentBlock, error) {
+	if content.Parts == nil {
+		if text := contentText(content); text != "" {
+			return []types.ContentBlock{{Type: "text", Text: text}}, nil
+		}
+		return nil, nil
+	}
+
+	var blocks []types.ContentBlock
+	for _, part := range content.Parts {
+		switch part.Type {
+		case "text":
+			if part.Text != "" {
+				blocks = append(blocks, types.ContentBlock{Type: "text", Text: part.Text})
+			}
+		case "image_url":
+			if part.ImageURL == nil || part.ImageURL.URL == "" {
+				return nil, fmt.Errorf("image_url part without a url")
+			}
+			source, err := imageSource(part.ImageURL.URL)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, types.ContentBlock{Type: "image", Source: source})
+		default:
+			return nil, fmt.Errorf("unsupported content part type: %s", part.Type)
+		}
+	}
+	return blocks, nil
+}
+
+// contentText returns string content, or "" when there is none
+func contentText(content MessageContent) string {
+	if content.Text == nil {
+		return ""
+	}
+	return *content.Text
+}
+
+// imageSource converts an image URL into an image source: base64 data for data URLs,
+// a url source otherwise
+func imageSource(url string) (*types.ImageSource, error) {
+	if !strings.HasPrefix(url, "data:") {
+		raw, _ := json.Marshal(url)
+		return &types.ImageSource{Type: "url", Extra: map[string]json.RawMessage{"url": raw}}, nil
+	}
+
+	// data:<media type>;base64,<data>
+	header, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	mediaType, encoding, _ := strings.Cut(header, ";")
+	if !ok || encoding != "base64" || mediaType == "" {
+		return nil, fmt.Errorf("image data URLs must be base64-encoded with a media type")
+	}
+	return &types.ImageSource{Type: "base64", MediaType: mediaType, Data: data}, nil
+}
+
+// toolUseBlock converts a tool call into a tool_use block
+func toolUseBlock(id string, call FunctionCall) (types.ContentBlock, error) {
+	// The arguments are forwarded as sent once known to be an object
+	input := json.RawMessage("{}")
+	if strings.TrimSpace(call.Arguments) != "" {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(call.Arguments), &object); err != nil || object == nil {
+			return types.ContentBlock{}, fmt.Errorf("arguments of %s are not a JSON object: %v", call.Name, err)
+		}
+		input = json.RawMessage(call.Arguments)
+	}
+	return types.ContentBlock{Type: "tool_use", ID: id, Name: call.Name, Input: input}, nil
+}
+
+// convertFunction converts a function definition into a tool definition
+func convertFunction(function FunctionDefinition) types.ToolDefinition {
+	schema := function.Parameters
+	if len(schema) == 0 || string(schema) == "null" {
+		schema = json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	return types.ToolDefinition{Name: function.Name, Description: function.Description, InputSchema: schema}
+}
+
+// convertToolChoice converts tool_choice (or the legacy function_call) into Anthropic's
+// tool_choice, or returns nil to leave Anthropic's default
+func convertToolChoice(choice json.RawMessage, parallelToolCalls *bool) (json.RawMessage, error) {
+	toolChoice := map[string]interface{}{"type": "auto"}
+	unset := len(choice) == 0 || string(choice) == "null"
+
+	var mode string
+	var named struct {
+		Name     string `json:"name"` // Legacy function_call
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	switch {
+	case unset:
+	case json.Unmarshal(choice, &mode) == nil:
+		switch mode {
+		case "auto":
+		case "none":
+			toolChoice["type"] = "none"
+		case "required":
+			toolChoice["type"] = "any"
+		default:
+			return nil, fmt.Errorf("unsupported tool_choice: %s", mode)
+		}
+	case json.Unmarshal(choice, &named) == nil:
+		name := named.Function.Name
+		if name == "" {
+			name = named.Name
+		}
+		if name == "" {
+			return nil, fmt.Errorf("tool_choice must name a function")
+		}
+		toolChoice["type"] = "tool"
+		toolChoice["name"] = name
+	default:
+		return nil, fmt.Errorf("invalid tool_choice: %s", string(choice))
+	}
+
+	if parallelToolCalls != nil && !*parallelToolCalls && toolChoice["type"] != "none" {
+		toolChoice["disable_parallel_tool_use"] = true
+	} else if unset {
+		return nil, nil
+	}
+
+	return json.Marshal(toolChoice)
+}
+
+// parseStop reads stop, which may be a string or an array of strings
+func parseStop(stop json.RawMessage) ([]string, error) {
+	if len(stop) == 0 || string(stop) == "null" {
+		return nil, nil
+	}
+
+	var single string
+	if err := json.Unmarshal(stop, &single); err == nil {
+		return []string{single}, nil
+	}
+
+	var sequences []string
+	if err := json.Unmarshal(stop, &sequences); err != nil {
+		return nil, fmt.Errorf("stop must be a string or an array of strings")
+	}
+	return sequences, nil
+}
+
+// FromAnthropic converts a Messages API response into a chat completion response
+func FromAnthropic(resp *types.AnthropicResponse) *ChatCompletionResponse {
+	message := &ChatMessage{Role: "assistant"}
+
+	var text strings.Builder
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "tool_use":
+			arguments := string(block.Input)
+			if arguments == "" {
+				arguments = "{}"
+			}
+			message.ToolCalls = append(message.ToolCalls, ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: FunctionCall{Name: block.Name, Arguments: arguments},
+			})
+		}
+	}
+	if text.Len() > 0 || len(message.ToolCalls) == 0 {
+		content := text.String()
+		message.Content.Text = &content
+	}
+
+	finishReason := FinishReason(resp.StopReason)
+	return &ChatCompletionResponse{
+		ID:      resp.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   resp.Model,
+		Choices: []Choice{{Index: 0, Message: message, FinishReason: &finishReason}},
+		Usage:   ConvertUsage(resp.Usage),
+	}
+}
+
+// FinishReason converts an Anthropic stop reason into a finish reason
+func FinishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default: // end_turn, stop_sequence, pause_turn
+		return "stop"
+	}
+}
+
+// ConvertUsage converts Anthropic usage. Prompt tokens include the tokens written to and read
+// from cache, and the ones read are reported as cached tokens.
+func ConvertUsage(usage types.Usage) *Usage {
+	prompt := usage.TotalInputTokens()
+	return &Usage{
+		PromptTokens:        prompt,
+		CompletionTokens:    usage.OutputTokens,
+		TotalTokens:         prompt + usage.OutputTokens,
+		PromptTokensDetails: &PromptTokensDetails{CachedTokens: usage.CacheReadInputTokens},
+	}
+}
@@ -0,0 +1,235 @@
+package openai
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"autocache/internal/types"
+)
+
+func TestToAnthropic(t *testing.T) {
+	body := `{
+		"model": "claude-3-5-sonnet-20241022",
+		"max_completion_tokens": 512,
+		"temperature": 0.2,
+		"stop": "END",
+		"user": "user-1",
+		"stream": true,
+		"messages": [
+			{"role": "system", "content": "You are terse."},
+			{"role": "developer", "content": [{"type": "text", "text": "Answer in English."}]},
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is in these images?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo="}},
+				{"type": "image_url", "image_url": {"url": "https://example.com/cat.jpg", "detail": "high"}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "lookup", "arguments": "{\"q\":\"cat\"}"}},
+				{"id": "call_2", "type": "function", "function": {"name": "lookup", "arguments": ""}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "A cat"},
+			{"role": "tool", "tool_call_id": "call_2", "content": "Another cat"},
+			{"role": "user", "content": "Thanks"}
+		],
+		"tools": [{"type": "function", "function": {"name": "lookup", "description": "Looks things up", "parameters": {"type": "object", "properties": {"q": {"type": "string"}}}}}],
+		"tool_choice": "required",
+		"parallel_tool_calls": false
+	}`
+
+	var req ChatCompletionRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+	out, err := ToAnthropic(&req)
+	if err != nil {
+		t.Fatalf("ToAnthropic failed: %v", err)
+	}
+
+	if out.Model != "claude-3-5-sonnet-20241022" || out.MaxTokens != 512 || *out.Temperature != 0.2 {
+		t.Errorf("Unexpected parameters: %+v", out)
+	}
+	if out.Stream == nil || !*out.Stream {
+		t.Error("Expected a streaming request")
+	}
+	if !reflect.DeepEqual(out.StopSequences, []string{"END"}) {
+		t.Errorf("Expected the stop string as a stop sequence, got %v", out.StopSequences)
+	}
+
+	// System and developer messages make up the system prompt
+	if len(out.SystemBlocks) != 2 || out.SystemBlocks[0].Text != "You are terse." || out.SystemBlocks[1].Text != "Answer in English." {
+		t.Errorf("Expected both system messages in the system prompt, got %+v", out.SystemBlocks)
+	}
+
+	if len(out.Messages) != 3 {
+		t.Fatalf("Expected user, assistant and merged user messages, got %d", len(out.Messages))
+	}
+
+	user := out.Messages[0].Content
+	if len(user) != 3 || user[1].Type != "image" || user[1].Source.Type != "base64" || user[1].Source.MediaType != "image/png" || user[1].Source.Data != "iVBORw0KGgo=" {
+		t.Errorf("Expected a base64 image from the data URL, got %+v", user)
+	}
+	if user[2].Source.Type != "url" || string(user[2].Source.Extra["url"]) != `"https://example.com/cat.jpg"` {
+		t.Errorf("Expected a url image source, got %+v", user[2].Source)
+	}
+
+	assistant := out.Messages[1]
+	if assistant.Role != "assistant" || len(assistant.Content) != 2 || assistant.Content[0].Type != "tool_use" || assistant.Content[0].ID != "call_1" {
+		t.Fatalf("Expected the tool calls as tool_use blocks, got %+v", assistant)
+	}
+	if string(assistant.Content[0].Input) != `{"q":"cat"}` || string(assistant.Content[1].Input) != `{}` {
+		t.Errorf("Expected the arguments as input, got %s and %s", assistant.Content[0].Input, assistant.Content[1].Input)
+	}
+
+	// Both tool results and the next user turn share a message
+	results := out.Messages[2]
+	if results.Role != "user" || len(results.Content) != 3 {
+		t.Fatalf("Expected tool results merged with the user turn, got %+v", results)
+	}
+	if results.Content[0].Type != "tool_result" || results.Content[0].ToolUseID != "call_1" || string(results.Content[0].Content) != `"A cat"` || results.Content[1].ToolUseID != "call_2" {
+		t.Errorf("Unexpected tool results: %+v", results.Content[:2])
+	}
+
+	if len(out.Tools) != 1 || out.Tools[0].Name != "lookup" || out.Tools[0].Description != "Looks things up" || out.Tools[0].InputSchema == nil {
+		t.Errorf("Unexpected tools: %+v", out.Tools)
+	}
+	if string(out.Extra["tool_choice"]) != `{"disable_parallel_tool_use":true,"type":"any"}` {
+		t.Errorf("Unexpected tool_choice: %s", out.Extra["tool_choice"])
+	}
+	if string(out.Extra["metadata"]) != `{"user_id":"user-1"}` {
+		t.Errorf("Expected the user as metadata, got %s", out.Extra["metadata"])
+	}
+
+	// The converted request is valid Messages API JSON
+	data, err := json.Marshal(out)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"system":[{"type":"text","text":"You are terse."}`) {
+		t.Errorf("Unexpected request: %s", data)
+	}
+}
+
+func TestToAnthropicLegacyFunctions(t *testing.T) {
+	body := `{
+		"model": "claude-3-5-haiku-20241022",
+		"messages": [
+			{"role": "user", "content": "Weather?"},
+			{"role": "assistant", "content": "", "function_call": {"name": "weather", "arguments": "{\"city\":\"Paris\"}"}},
+			{"role": "function", "name": "weather", "content": "Sunny"}
+		],
+		"functions": [{"name": "weather"}],
+		"function_call": {"name": "weather"}
+	}`
+
+	var req ChatCompletionRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+	out, err := ToAnthropic(&req)
+	if err != nil {
+		t.Fatalf("ToAnthropic failed: %v", err)
+	}
+
+	if out.MaxTokens != DefaultMaxTokens {
+		t.Errorf("Expected the default max_tokens, got %d", out.MaxTokens)
+	}
+	call := out.Messages[1].Content[0]
+	result := out.Messages[2].Content[0]
+	if call.Type != "tool_use" || result.Type != "tool_result" || result.ToolUseID != call.ID || call.ID == "" {
+		t.Errorf("Expected the function result to answer the call, got %+v and %+v", call, result)
+	}
+	if len(out.Tools) != 1 || out.Tools[0].InputSchema == nil {
+		t.Errorf("Expected the function as a tool with an empty schema, got %+v", out.Tools)
+	}
+	if string(out.Extra["tool_choice"]) != `{"name":"weather","type":"tool"}` {
+		t.Errorf("Unexpected tool_choice: %s", out.Extra["tool_choice"])
+	}
+}
+
+func TestToAnthropicErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		errorContains string
+	}{
+		{"Several choices", `{"model":"m","n":2,"messages":[{"role":"user","content":"Hi"}]}`, "n > 1"},
+		{"Unknown role", `{"model":"m","messages":[{"role":"critic","content":"Hi"}]}`, "unsupported role"},
+		{"Unknown part", `{"model":"m","messages":[{"role":"user","content":[{"type":"input_audio"}]}]}`, "unsupported content part"},
+		{"Image in system prompt", `{"model":"m","messages":[{"role":"system","content":[{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}]}`, "only contain text"},
+		{"Bad data URL", `{"model":"m","messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"data:image/png,abc"}}]}]}`, "base64"},
+		{"Bad arguments", `{"model":"m","messages":[{"role":"assistant","tool_calls":[{"id":"c","type":"function","function":{"name":"f","arguments":"{"}}]}]}`, "not a JSON object"},
+		{"Orphan function result", `{"model":"m","messages":[{"role":"function","name":"f","content":"x"}]}`, "known call"},
+		{"Bad tool_choice", `{"model":"m","messages":[{"role":"user","content":"Hi"}],"tools":[{"type":"function","function":{"name":"f"}}],"tool_choice":"sometimes"}`, "tool_choice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req ChatCompletionRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatalf("Failed to parse request: %v", err)
+			}
+			_, err := ToAnthropic(&req)
+			if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("Expected an error containing %q, got %v", tt.errorContains, err)
+			}
+		})
+	}
+}
+
+func TestFromAnthropic(t *testing.T) {
+	resp := &types.AnthropicResponse{
+		ID:    "msg_1",
+		Model: "claude-3-5-sonnet-20241022",
+		Content: []types.ContentBlock{
+			{Type: "text", Text: "Let me look. "},
+			{Type: "tool_use", ID: "toolu_1", Name: "lookup", Input: json.RawMessage(`{"q":"cat"}`)},
+		},
+		StopReason: "tool_use",
+		Usage:      types.Usage{InputTokens: 10, CacheCreationInputTokens: 200, CacheReadInputTokens: 3000, OutputTokens: 25},
+	}
+
+	out := FromAnthropic(resp)
+	if out.ID != "msg_1" || out.Object != "chat.completion" || len(out.Choices) != 1 {
+		t.Fatalf("Unexpected response: %+v", out)
+	}
+
+	choice := out.Choices[0]
+	if *choice.FinishReason != "tool_calls" {
+		t.Errorf("Expected finish_reason tool_calls, got %s", *choice.FinishReason)
+	}
+	if choice.Message.Content.Text == nil || *choice.Message.Content.Text != "Let me look. " {
+		t.Errorf("Unexpected content: %+v", choice.Message.Content)
+	}
+	if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Arguments != `{"q":"cat"}` || choice.Message.ToolCalls[0].ID != "toolu_1" {
+		t.Errorf("Unexpected tool calls: %+v", choice.Message.ToolCalls)
+	}
+
+	expected := &Usage{PromptTokens: 3210, CompletionTokens: 25, TotalTokens: 3235, PromptTokensDetails: &PromptTokensDetails{CachedTokens: 3000}}
+	if !reflect.DeepEqual(out.Usage, expected) {
+		t.Errorf("Expected usage %+v, got %+v", expected, out.Usage)
+	}
+
+	// Tool calls without text have null content
+	resp.Content = resp.Content[1:]
+	data, _ := json.Marshal(FromAnthropic(resp))
+	if !strings.Contains(string(data), `"content":null`) {
+		t.Errorf("Expected null content, got %s", data)
+	}
+}
+
+func TestFinishReason(t *testing.T) {
+	tests := map[string]string{
+		"end_turn":      "stop",
+		"stop_sequence": "stop",
+		"max_tokens":    "length",
+		"tool_use":      "tool_calls",
+		"refusal":       "content_filter",
+	}
+	for stopReason, expected := range tests {
+		if got := FinishReason(stopReason); got != expected {
+			t.Errorf("FinishReason(%q) = %q, expected %q", stopReason, got, expected)
+		}
+	}
+}
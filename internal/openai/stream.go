@@ -0,0 +1,240 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"autocache/internal/types"
+)
+
+// streamEvent holds the parts of a Messages API streaming event that are translated
+type streamEvent struct {
+	Type    string `json:"type"`
+	Message *struct {
+		ID    string      `json:"id"`
+		Model string      `json:"model"`
+		Usage types.Usage `json:"usage"`
+	} `json:"message"`
+	Index        int `json:"index"`
+	ContentBlock *struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	Delta *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *types.Usage `json:"usage"`
+}
+
+// StreamTranslator is an http.ResponseWriter that takes a Messages API event stream and
+// writes it to the client as chat completion chunks, ending with "data: [DONE]". Error
+// responses are relayed as-is: their "error" object is what OpenAI clients expect.
+type StreamTranslator struct {
+	w            http.ResponseWriter
+	flusher      http.Flusher
+	includeUsage bool
+
+	statusCode  int
+	passthrough bool
+	pending     []byte // Incomplete line
+
+	id        string
+	model     string
+	created   int64
+	toolCalls map[int]int // Index of each tool call, by content block index
+	usage     types.Usage
+}
+
+// NewStreamTranslator creates a translator writing to w. With includeUsage, a final chunk
+// carries the usage, as requested by stream_options.include_usage.
+func NewStreamTranslator(w http.ResponseWriter, includeUsage bool) *StreamTranslator {
+	flusher, _ := w.(http.Flusher)
+	return &StreamTranslator{
+		w:            w,
+		flusher:      flusher,
+		includeUsage: includeUsage,
+		created:      time.Now().Unix(),
+		toolCalls:    make(map[int]int),
+	}
+}
+
+// Header returns the headers of the client response
+func (st *StreamTranslator) Header() http.Header {
+	return st.w.Header()
+}
+
+// WriteHeader writes the status of the client response
+func (st *StreamTranslator) WriteHeader(code int) {
+	if st.statusCode != 0 {
+		return
+	}
+	st.statusCode = code
+	st.passthrough = code != http.StatusOK
+	if !st.passthrough {
+		st.w.Header().Del("Content-Length")
+		st.w.Header().Set("Content-Type", "text/event-stream")
+	}
+	st.w.WriteHeader(code)
+}
+
+// Write translates the events completed by p
+func (st *StreamTranslator) Write(p []byte) (int, error) {
+	if st.statusCode == 0 {
+		st.WriteHeader(http.StatusOK)
+	}
+	if st.passthrough {
+		return st.w.Write(p)
+	}
+
+	st.pending = append(st.pending, p...)
+	for {
+		end := bytes.IndexByte(st.pending, '\n')
+		if end < 0 {
+			break
+		}
+		line := bytes.TrimRight(st.pending[:end], "\r")
+		st.pending = st.pending[end+1:]
+
+		if bytes.HasPrefix(line, []byte("data:")) {
+			if err := st.translate(bytes.TrimSpace(line[len("data:"):])); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Flush flushes the client response
+func (st *StreamTranslator) Flush() {
+	if st.flusher != nil {
+		st.flusher.Flush()
+	}
+}
+
+// translate writes the chunks of one event
+func (st *StreamTranslator) translate(data []byte) error {
+	var event streamEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil // Not an event we translate
+	}
+
+	switch event.Type {
+	case "message_start":
+		if event.Message != nil {
+			st.id = event.Message.ID
+			st.model = event.Message.Model
+			st.usage = event.Message.Usage
+		}
+		empty := ""
+		return st.writeDelta(&ChunkDelta{Role: "assistant", Content: &empty}, nil)
+
+	case "content_block_start":
+		if event.ContentBlock == nil || event.ContentBlock.Type != "tool_use" {
+			return nil
+		}
+		index := len(st.toolCalls)
+		st.toolCalls[event.Index] = index
+		return st.writeDelta(&ChunkDelta{ToolCalls: []ToolCall{{
+			Index:    &index,
+			ID:       event.ContentBlock.ID,
+			Type:     "function",
+			Function: FunctionCall{Name: event.ContentBlock.Name},
+		}}}, nil)
+
+	case "content_block_delta":
+		if event.Delta == nil {
+			return nil
+		}
+		switch event.Delta.Type {
+		case "text_delta":
+			return st.writeDelta(&ChunkDelta{Content: &event.Delta.Text}, nil)
+		case "input_json_delta":
+			index, ok := st.toolCalls[event.Index]
+			if !ok {
+				return nil
+			}
+			return st.writeDelta(&ChunkDelta{ToolCalls: []ToolCall{{
+				Index:    &index,
+				Function: FunctionCall{Arguments: event.Delta.PartialJSON},
+			}}}, nil)
+		}
+		return nil // Thinking and citations have no counterpart
+
+	case "message_delta":
+		if event.Usage != nil {
+			st.applyUsage(event.Usage)
+		}
+		if event.Delta == nil || event.Delta.StopReason == "" {
+			return nil
+		}
+		finishReason := FinishReason(event.Delta.StopReason)
+		return st.writeDelta(&ChunkDelta{}, &finishReason)
+
+	case "message_stop":
+		if st.includeUsage {
+			if err := st.writeChunk(&ChatCompletionChunk{Choices: []Choice{}, Usage: ConvertUsage(st.usage)}); err != nil {
+				return err
+			}
+		}
+		return st.writeData([]byte("[DONE]"))
+
+	case "error":
+		// {"type":"error","error":{...}} is relayed as the error event of a chat completion stream
+		return st.writeData(data)
+	}
+
+	return nil
+}
+
+// applyUsage updates the usage with a message_delta's cumulative counts. Input counts are
+// only replaced when reported.
+func (st *StreamTranslator) applyUsage(usage *types.Usage) {
+	st.usage.OutputTokens = usage.OutputTokens
+	if usage.InputTokens > 0 {
+		st.usage.InputTokens = usage.InputTokens
+	}
+	if usage.CacheCreationInputTokens > 0 {
+		st.usage.CacheCreationInputTokens = usage.CacheCreationInputTokens
+	}
+	if usage.CacheReadInputTokens > 0 {
+		st.usage.CacheReadInputTokens = usage.CacheReadInputTokens
+	}
+}
+
+// writeDelta writes a chunk with a single choice
+func (st *StreamTranslator) writeDelta(delta *ChunkDelta, finishReason *string) error {
+	return st.writeChunk(&ChatCompletionChunk{Choices: []Choice{{Index: 0, Delta: delta, FinishReason: finishReason}}})
+}
+
+// writeChunk writes a chunk as an event, filling in the message ID, model and creation time
+func (st *StreamTranslator) writeChunk(chunk *ChatCompletionChunk) error {
+	chunk.ID = st.id
+	chunk.Object = "chat.completion.chunk"
+	chunk.Created = st.created
+	chunk.Model = st.model
+
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chunk: %w", err)
+	}
+	return st.writeData(data)
+}
+
+// writeData writes a data-only event
+func (st *StreamTranslator) writeData(data []byte) error {
+	event := make([]byte, 0, len(data)+8)
+	event = append(event, "data: "...)
+	event = append(event, data...)
+	event = append(event, "\n\n"...)
+	if _, err := st.w.Write(event); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const sampleStream = "event: message_start\n" +
+	`data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude-sonnet-4-5-20250929","usage":{"input_tokens":12,"cache_creation_input_tokens":0,"cache_read_input_tokens":2048,"output_tokens":1}}}` + "\n\n" +
+	"event: content_block_start\n" +
+	`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Looking"}}` + "\n\n" +
+	"event: ping\n" +
+	`data: {"type": "ping"}` + "\n\n" +
+	"event: content_block_start\n" +
+	`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"lookup","input":{}}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"q\":"}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"cat\"}"}}` + "\n\n" +
+	"event: message_delta\n" +
+	`data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":42}}` + "\n\n" +
+	"event: message_stop\n" +
+	`data: {"type":"message_stop"}` + "\n\n"
+
+// readChunks splits a translated stream into its chunks, checking it ends with [DONE]
+func readChunks(t *testing.T, stream string) []ChatCompletionChunk {
+	t.Helper()
+	var chunks []ChatCompletionChunk
+	events := strings.Split(strings.TrimSuffix(stream, "\n\n"), "\n\n")
+	for i, event := range events {
+		data, ok := strings.CutPrefix(event, "data: ")
+		if !ok {
+			t.Fatalf("Expected data-only events, got %q", event)
+		}
+		if i == len(events)-1 {
+			if data != "[DONE]" {
+				t.Fatalf("Expected the stream to end with [DONE], got %q", data)
+			}
+			break
+		}
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("Failed to parse chunk %q: %v", data, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func TestStreamTranslator(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	translator := NewStreamTranslator(recorder, true)
+	translator.Header().Set("Content-Length", "1234")
+	translator.WriteHeader(http.StatusOK)
+
+	// Write in uneven pieces, as the upstream may split lines
+	for i := 0; i < len(sampleStream); i += 37 {
+		end := min(i+37, len(sampleStream))
+		if _, err := translator.Write([]byte(sampleStream[i:end])); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	if recorder.Header().Get("Content-Type") != "text/event-stream" || recorder.Header().Get("Content-Length") != "" {
+		t.Errorf("Unexpected headers: %v", recorder.Header())
+	}
+
+	chunks := readChunks(t, recorder.Body.String())
+	if len(chunks) != 7 {
+		t.Fatalf("Expected 7 chunks, got %d: %s", len(chunks), recorder.Body.String())
+	}
+	for _, chunk := range chunks {
+		if chunk.ID != "msg_1" || chunk.Model != "claude-sonnet-4-5-20250929" || chunk.Object != "chat.completion.chunk" {
+			t.Errorf("Unexpected chunk: %+v", chunk)
+		}
+	}
+
+	if delta := chunks[0].Choices[0].Delta; delta.Role != "assistant" {
+		t.Errorf("Expected the first chunk to carry the role, got %+v", delta)
+	}
+	if delta := chunks[1].Choices[0].Delta; delta.Content == nil || *delta.Content != "Looking" {
+		t.Errorf("Expected a content delta, got %+v", delta)
+	}
+
+	start := chunks[2].Choices[0].Delta.ToolCalls
+	if len(start) != 1 || *start[0].Index != 0 || start[0].ID != "toolu_1" || start[0].Function.Name != "lookup" {
+		t.Errorf("Expected the tool call to start, got %+v", start)
+	}
+	arguments := chunks[3].Choices[0].Delta.ToolCalls[0].Function.Arguments + chunks[4].Choices[0].Delta.ToolCalls[0].Function.Arguments
+	if arguments != `{"q":"cat"}` {
+		t.Errorf("Expected the arguments streamed in pieces, got %q", arguments)
+	}
+
+	if reason := chunks[5].Choices[0].FinishReason; reason == nil || *reason != "tool_calls" {
+		t.Errorf("Expected finish_reason tool_calls, got %v", reason)
+	}
+
+	usage := chunks[6].Usage
+	if len(chunks[6].Choices) != 0 || usage == nil {
+		t.Fatalf("Expected a final usage chunk, got %+v", chunks[6])
+	}
+	if usage.PromptTokens != 2060 || usage.CompletionTokens != 42 || usage.PromptTokensDetails.CachedTokens != 2048 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+}
+
+func TestStreamTranslatorWithoutUsage(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	translator := NewStreamTranslator(recorder, false)
+	_, _ = translator.Write([]byte(sampleStream))
+
+	for _, chunk := range readChunks(t, recorder.Body.String()) {
+		if chunk.Usage != nil {
+			t.Errorf("Expected no usage without include_usage, got %+v", chunk)
+		}
+	}
+}
+
+func TestStreamTranslatorErrors(t *testing.T) {
+	// Error responses are relayed as-is
+	recorder := httptest.NewRecorder()
+	translator := NewStreamTranslator(recorder, false)
+	body := `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`
+	translator.WriteHeader(http.StatusTooManyRequests)
+	_, _ = translator.Write([]byte(body))
+	if recorder.Code != http.StatusTooManyRequests || recorder.Body.String() != body {
+		t.Errorf("Expected the error response to be relayed, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Error events in the stream are relayed as data
+	recorder = httptest.NewRecorder()
+	translator = NewStreamTranslator(recorder, false)
+	_, _ = translator.Write([]byte("event: error\ndata: " + body + "\n\n"))
+	if recorder.Body.String() != "data: "+body+"\n\n" {
+		t.Errorf("Expected the error event to be relayed, got %q", recorder.Body.String())
+	}
+}
@@ -0,0 +1,168 @@
+// Package openai translates between the OpenAI Chat Completions API and the Anthropic
+// Messages API, so clients written for the former can use the proxy unchanged.
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ChatCompletionRequest is the body of a POST /v1/chat/completions request
+type ChatCompletionRequest struct {
+	Model               string          `json:"model"`
+	Messages            []ChatMessage   `json:"messages"`
+	MaxTokens           *int            `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64        `json:"temperature,omitempty"`
+	TopP                *float64        `json:"top_p,omitempty"`
+	N                   *int            `json:"n,omitempty"`
+	Stop                json.RawMessage `json:"stop,omitempty"` // A string or an array of strings
+	Stream              bool            `json:"stream,omitempty"`
+	StreamOptions       *StreamOptions  `json:"stream_options,omitempty"`
+	Tools               []Tool          `json:"tools,omitempty"`
+	ToolChoice          json.RawMessage `json:"tool_choice,omitempty"` // "none", "auto", "required" or a named function
+	ParallelToolCalls   *bool           `json:"parallel_tool_calls,omitempty"`
+	User                string          `json:"user,omitempty"`
+
+	// Legacy function calling, superseded by Tools and ToolChoice
+	Functions    []FunctionDefinition `json:"functions,omitempty"`
+	FunctionCall json.RawMessage      `json:"function_call,omitempty"`
+}
+
+// StreamOptions are the options of a streaming request
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// ChatMessage is a message of a chat completion request or response
+type ChatMessage struct {
+	Role       string         `json:"role"` // "system", "developer", "user", "assistant", "tool" or "function"
+	Content    MessageContent `json:"content"`
+	Name       string         `json:"name,omitempty"`
+	ToolCalls  []ToolCall     `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+
+	// Legacy function call of an assistant message
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+}
+
+// MessageContent is the content of a message: a string, or an array of content parts
+type MessageContent struct {
+	Text  *string
+	Parts []ContentPart
+}
+
+// UnmarshalJSON accepts the string and array forms of message content, and null
+func (c *MessageContent) UnmarshalJSON(data []byte) error {
+	c.Text, c.Parts = nil, nil
+	if string(data) == "null" {
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		c.Text = &text
+		return nil
+	}
+
+	if err := json.Unmarshal(data, &c.Parts); err != nil {
+		return fmt.Errorf("content must be a string or an array of content parts: %w", err)
+	}
+	return nil
+}
+
+// MarshalJSON writes the content back in the form it was given, or null when empty
+func (c MessageContent) MarshalJSON() ([]byte, error) {
+	if c.Parts != nil {
+		return json.Marshal(c.Parts)
+	}
+	return json.Marshal(c.Text)
+}
+
+// ContentPart is a part of array-form message content
+type ContentPart struct {
+	Type     string    `json:"type"` // "text" or "image_url"
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL is an image given by URL, either http(s) or a base64 data URL
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Tool is a tool the model may call
+type Tool struct {
+	Type     string             `json:"type"` // "function"
+	Function FunctionDefinition `json:"function"`
+}
+
+// FunctionDefinition describes a function the model may call
+type FunctionDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"` // JSON Schema of the arguments
+}
+
+// ToolCall is a call of a tool by the model
+type ToolCall struct {
+	Index    *int         `json:"index,omitempty"` // Only in streamed deltas
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"` // "function"
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall is the function and arguments of a tool call
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"` // JSON-encoded arguments
+}
+
+// ChatCompletionResponse is the body of a non-streaming chat completion response
+type ChatCompletionResponse struct {
+	ID      string   `json:"id"`
+	Object  string   `json:"object"` // "chat.completion"
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Choices []Choice `json:"choices"`
+	Usage   *Usage   `json:"usage,omitempty"`
+}
+
+// Choice is a completion choice. Anthropic returns a single one.
+type Choice struct {
+	Index        int          `json:"index"`
+	Message      *ChatMessage `json:"message,omitempty"`
+	Delta        *ChunkDelta  `json:"delta,omitempty"`
+	FinishReason *string      `json:"finish_reason"`
+}
+
+// ChatCompletionChunk is an event of a streaming chat completion response
+type ChatCompletionChunk struct {
+	ID      string   `json:"id"`
+	Object  string   `json:"object"` // "chat.completion.chunk"
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Choices []Choice `json:"choices"`
+	Usage   *Usage   `json:"usage,omitempty"`
+}
+
+// ChunkDelta is the part of the message carried by a chunk
+type ChunkDelta struct {
+	Role      string     `json:"role,omitempty"`
+	Content   *string    `json:"content,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+// Usage is the token usage of a completion
+type Usage struct {
+	PromptTokens        int                  `json:"prompt_tokens"`
+	CompletionTokens    int                  `json:"completion_tokens"`
+	TotalTokens         int                  `json:"total_tokens"`
+	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+// PromptTokensDetails breaks prompt tokens down
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"` // Prompt tokens read from cache
+}
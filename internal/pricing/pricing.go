@@ -266,6 +266,68 @@ func (pc *PricingCalculator) CalculateROI(model string, totalTokens, cachedToken
 	}, nil
 }
 
+// BatchDiscount is the share of the standard price billed for requests sent through the
+// Message Batches API, for every kind of token including cache writes and reads
+const BatchDiscount = 0.5
+
+// CalculateBatchROI calculates ROI metrics like CalculateROI for a request sent through the
+// Message Batches API. Costs and savings are discounted; break-even and percentages are not
+// affected, since every price is discounted alike.
+func (pc *PricingCalculator) CalculateBatchROI(model string, totalTokens, cachedTokens int, breakpoints []types.CacheBreakpoint) (types.ROIMetrics, error) {
+	roi, err := pc.CalculateROI(model, totalTokens, cachedTokens, breakpoints)
+	if err != nil {
+		return roi, err
+	}
+
+	roi.BaseInputCost *= BatchDiscount
+	roi.CacheWriteCost *= BatchDiscount
+	roi.CacheReadCost *= BatchDiscount
+	roi.FirstRequestCost *= BatchDiscount
+	roi.SubsequentSavings *= BatchDiscount
+	roi.SavingsAt10Requests *= BatchDiscount
+	roi.SavingsAt100Requests *= BatchDiscount
+	return roi, nil
+}
+
+// CalculateActualCost calculates the real cost of a request from the usage Anthropic billed.
+// Cache writes use the per-TTL breakdown when the usage carries one and fallbackTTL otherwise.
+func (pc *PricingCalculator) CalculateActualCost(model string, usage types.Usage, fallbackTTL string) (types.ActualCost, error) {
+	pricing, err := pc.GetModelPricing(model)
+	if err != nil {
+		return types.ActualCost{}, err
+	}
+
+	perToken := func(tokens int, price float64) float64 {
+		return (float64(tokens) / 1_000_000) * price
+	}
+
+	var cacheWriteCost float64
+	if usage.CacheCreation != nil {
+		cacheWriteCost = perToken(usage.CacheCreation.Ephemeral5mInputTokens, pricing.CacheWrite5m) +
+			perToken(usage.CacheCreation.Ephemeral1hInputTokens, pricing.CacheWrite1h)
+	} else {
+		cacheWriteCost, _ = pc.CalculateCacheWriteCost(model, usage.CacheCreationInputTokens, fallbackTTL)
+	}
+
+	inputCost := perToken(usage.InputTokens, pricing.InputTokens)
+	cacheReadCost := perToken(usage.CacheReadInputTokens, pricing.CacheRead)
+	outputCost := perToken(usage.OutputTokens, pricing.OutputTokens)
+	totalCost := inputCost + cacheWriteCost + cacheReadCost + outputCost
+
+	// The same request with every input token billed at the base rate
+	uncachedCost := perToken(usage.TotalInputTokens(), pricing.InputTokens) + outputCost
+
+	return types.ActualCost{
+		InputCost:      inputCost,
+		CacheWriteCost: cacheWriteCost,
+		CacheReadCost:  cacheReadCost,
+		OutputCost:     outputCost,
+		TotalCost:      totalCost,
+		UncachedCost:   uncachedCost,
+		Savings:        uncachedCost - totalCost,
+	}, nil
+}
+
 // calculateSavingsAtN calculates total savings after N requests
 func calculateSavingsAtN(baseCost, firstRequestCost, subsequentRequestCost float64, n int) float64 {
 	if n <= 0 {
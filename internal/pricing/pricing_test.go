@@ -497,4 +497,112 @@ func TestCalculateSavingsAtN(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestCalculateActualCost(t *testing.T) {
+	calc := NewPricingCalculator()
+	model := "claude-3-5-sonnet-20241022" // $3 input, $3.75 5m write, $6 1h write, $0.30 read, $15 output
+
+	tests := []struct {
+		name            string
+		usage           types.Usage
+		fallbackTTL     string
+		expectedTotal   float64
+		expectedUncache float64
+	}{
+		{
+			name:            "No caching",
+			usage:           types.Usage{InputTokens: 1_000_000, OutputTokens: 100_000},
+			fallbackTTL:     "5m",
+			expectedTotal:   3.00 + 1.50,
+			expectedUncache: 3.00 + 1.50,
+		},
+		{
+			name:            "Cache read",
+			usage:           types.Usage{InputTokens: 100_000, CacheReadInputTokens: 900_000},
+			fallbackTTL:     "5m",
+			expectedTotal:   0.30 + 0.27,
+			expectedUncache: 3.00,
+		},
+		{
+			name:            "Cache write priced with fallback TTL",
+			usage:           types.Usage{CacheCreationInputTokens: 1_000_000},
+			fallbackTTL:     "1h",
+			expectedTotal:   6.00,
+			expectedUncache: 3.00,
+		},
+		{
+			name: "Cache write breakdown overrides fallback TTL",
+			usage: types.Usage{
+				CacheCreationInputTokens: 2_000_000,
+				CacheCreation: &types.CacheCreation{
+					Ephemeral5mInputTokens: 1_000_000,
+					Ephemeral1hInputTokens: 1_000_000,
+				},
+			},
+			fallbackTTL:     "5m",
+			expectedTotal:   3.75 + 6.00,
+			expectedUncache: 6.00,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost, err := calc.CalculateActualCost(model, tt.usage, tt.fallbackTTL)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if math.Abs(cost.TotalCost-tt.expectedTotal) > 0.0001 {
+				t.Errorf("Expected total cost %.4f, got %.4f", tt.expectedTotal, cost.TotalCost)
+			}
+			if math.Abs(cost.UncachedCost-tt.expectedUncache) > 0.0001 {
+				t.Errorf("Expected uncached cost %.4f, got %.4f", tt.expectedUncache, cost.UncachedCost)
+			}
+			if math.Abs(cost.Savings-(cost.UncachedCost-cost.TotalCost)) > 0.0001 {
+				t.Errorf("Expected savings to be uncached minus total, got %.4f", cost.Savings)
+			}
+		})
+	}
+
+	if _, err := calc.CalculateActualCost("unknown-model-xyz", types.Usage{InputTokens: 10}, "5m"); err == nil {
+		t.Error("Expected error for unknown model")
+	}
+}
+
+func TestCalculateBatchROI(t *testing.T) {
+	calc := NewPricingCalculator()
+	model := "claude-3-5-sonnet-20241022"
+	breakpoints := []types.CacheBreakpoint{{Position: "system", Tokens: 100000, TTL: "5m"}}
+
+	standard, err := calc.CalculateROI(model, 120000, 100000, breakpoints)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	batch, err := calc.CalculateBatchROI(model, 120000, 100000, breakpoints)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 120K input tokens at $3/M, half price in a batch
+	if math.Abs(batch.BaseInputCost-0.18) > 0.0001 {
+		t.Errorf("Expected batch base cost $0.18, got %.4f", batch.BaseInputCost)
+	}
+	for name, pair := range map[string][2]float64{
+		"cache write":  {batch.CacheWriteCost, standard.CacheWriteCost},
+		"cache read":   {batch.CacheReadCost, standard.CacheReadCost},
+		"first cost":   {batch.FirstRequestCost, standard.FirstRequestCost},
+		"savings":      {batch.SubsequentSavings, standard.SubsequentSavings},
+		"savings @100": {batch.SavingsAt100Requests, standard.SavingsAt100Requests},
+	} {
+		if math.Abs(pair[0]-pair[1]*BatchDiscount) > 1e-12 {
+			t.Errorf("Expected %s to be discounted, got %.6f (standard %.6f)", name, pair[0], pair[1])
+		}
+	}
+	if batch.BreakEvenRequests != standard.BreakEvenRequests || batch.PercentSavings != standard.PercentSavings {
+		t.Errorf("Expected break-even and percentages to be unchanged, got %+v", batch)
+	}
+
+	if _, err := calc.CalculateBatchROI("unknown-model-xyz", 1000, 0, nil); err == nil {
+		t.Error("Expected error for unknown model")
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"autocache/internal/client"
+	"autocache/internal/pricing"
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HandleBatches handles /v1/messages/batches. Batch creation gets cache control injected into
+// every item, with the markers on a head shared by items placed identically so the items read
+// each other's cache; listing batches is passed through like the status, results and cancel
+// endpoints under it.
+func (ah *AutocacheHandler) HandleBatches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || ah.shouldBypassCaching(r) {
+		ah.HandlePassthrough(w, r)
+		return
+	}
+
+	wrapper := &responseWrapper{ResponseWriter: w, statusCode: http.StatusOK}
+	w = wrapper
+	strategy := ah.config.CacheStrategy
+	defer func() {
+		ah.metrics.observeRequest("", strategy, wrapper.statusCode)
+	}()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		ah.logger.WithError(err).Error("Failed to read request body")
+		ah.writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+
+	var batch types.BatchRequest
+	if err := json.Unmarshal(body, &batch); err != nil {
+		ah.logger.WithError(err).Error("Failed to parse batch request JSON")
+		ah.writeError(w, http.StatusBadRequest, "Invalid JSON in request body")
+		return
+	}
+
+	models := make([]string, len(batch.Requests))
+	for i, item := range batch.Requests {
+		models[i] = item.Params.Model
+	}
+	policy, err := ah.resolveCachePolicy(r, models...)
+	if err != nil {
+		ah.logger.WithError(err).Warn("Rejected cache policy overrides")
+		ah.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	strategy = policy.name
+
+	// Inject cache control into every item
+	items := make([]*types.AnthropicRequest, len(batch.Requests))
+	for i := range batch.Requests {
+		items[i] = &batch.Requests[i].Params
+	}
+	start := time.Now()
+	metadata, err := ah.cacheInjector.InjectBatchWithStrategy(items, types.CacheStrategy(policy.name), policy.config)
+	ah.metrics.observeInjection(policy.name, time.Since(start))
+	if err != nil {
+		ah.logger.WithError(err).Error("Failed to inject cache control into batch")
+		ah.writeError(w, http.StatusInternalServerError, "Failed to process cache injection")
+		return
+	}
+
+	// Extract credentials
+	headers := client.CreateHeadersMap(r.Header, ah.getCredentials(r), ah.logger)
+	for _, m := range metadata {
+		ah.addBetaFlags(headers, m)
+	}
+
+	// Forward the batch
+	upstreamStart := time.Now()
+	resp, err := ah.proxyClient.ForwardBatchRequest(r.Context(), &batch, headers)
+	if ah.writeUnsupportedEndpoint(w, err) {
+		return
+	}
+	if err != nil {
+		ah.metrics.observeUpstreamError("", false, time.Since(upstreamStart), err)
+		ah.logger.WithError(err).Error("Failed to forward batch request")
+		ah.writeUpstreamError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+	ah.metrics.observeUpstream("", false, time.Since(upstreamStart), resp.StatusCode)
+
+	ah.addBatchMetadataHeaders(w, metadata)
+	ah.addPolicyHeaders(w, policy)
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if err := copyFlushing(w, resp.Body); err != nil {
+		ah.logger.WithError(err).Error("Failed to relay batch response")
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return
+	}
+
+	// Store every item's metadata for the savings endpoint
+	for i, m := range metadata {
+		m.BatchCustomID = batch.Requests[i].CustomID
+		ah.metrics.observeMetadata(m)
+		ah.storeRequestMetadata(m)
+	}
+
+	ah.logger.WithFields(logrus.Fields{
+		"items":    len(metadata),
+		"strategy": policy.name,
+	}).Info("Successfully processed batch request")
+}
+
+// addBatchMetadataHeaders adds the cache metadata of a batch, summed over its items, to
+// response headers
+func (ah *AutocacheHandler) addBatchMetadataHeaders(w http.ResponseWriter, metadata []*types.CacheMetadata) {
+	injected := false
+	totalTokens, cachedTokens, breakpoints := 0, 0, 0
+	var firstCost, savings float64
+	for _, m := range metadata {
+		injected = injected || m.CacheInjected
+		totalTokens += m.TotalTokens
+		cachedTokens += m.CachedTokens
+		breakpoints += len(m.Breakpoints)
+		firstCost += m.ROI.FirstRequestCost
+		savings += m.ROI.SubsequentSavings
+	}
+
+	cacheRatio := 0.0
+	if totalTokens > 0 {
+		cacheRatio = float64(cachedTokens) / float64(totalTokens)
+	}
+
+	w.Header().Set("X-Autocache-Injected", strconv.FormatBool(injected))
+	w.Header().Set("X-Autocache-Batch-Items", strconv.Itoa(len(metadata)))
+	w.Header().Set("X-Autocache-Batch-Breakpoints", strconv.Itoa(breakpoints))
+	w.Header().Set("X-Autocache-Total-Tokens", strconv.Itoa(totalTokens))
+	w.Header().Set("X-Autocache-Cached-Tokens", strconv.Itoa(cachedTokens))
+	w.Header().Set("X-Autocache-Cache-Ratio", fmt.Sprintf("%.3f", cacheRatio))
+
+	// ROI headers, at the batch discount
+	w.Header().Set("X-Autocache-ROI-FirstCost", pricing.FormatCost(firstCost))
+	w.Header().Set("X-Autocache-ROI-Savings", pricing.FormatCost(savings))
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"autocache/internal/client"
+	"autocache/internal/config"
+	"autocache/internal/history"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHandleBatches(t *testing.T) {
+	var forwarded map[string]interface{}
+	var seen *http.Request
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+		forwarded = nil
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &forwarded)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"msgbatch_1","type":"message_batch","processing_status":"in_progress"}`)
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := &config.Config{
+		AnthropicURL:       mockServer.URL,
+		AnthropicAPIKey:    "sk-ant-api03-test",
+		CacheStrategy:      "moderate",
+		TokenizerMode:      "heuristic",
+		SavingsHistorySize: 10,
+	}
+	handler := NewAutocacheHandler(cfg, logger)
+	mux := handler.SetupRoutes()
+
+	system := strings.Repeat("You grade answers against a long rubric. ", 300)
+	var requests []map[string]interface{}
+	for _, id := range []string{"eval-1", "eval-2", "eval-3"} {
+		requests = append(requests, map[string]interface{}{
+			"custom_id": id,
+			"params": map[string]interface{}{
+				"model":      "claude-3-5-sonnet-20241022",
+				"max_tokens": 100,
+				"system":     system,
+				"metadata":   map[string]string{"user_id": id},
+				"messages":   []map[string]string{{"role": "user", "content": "Grade answer " + id}},
+			},
+		})
+	}
+	body, _ := json.Marshal(map[string]interface{}{"requests": requests})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/v1/messages/batches", strings.NewReader(string(body))))
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "msgbatch_1") {
+		t.Fatalf("Expected the batch response to be relayed, got %d: %s", w.Code, w.Body.String())
+	}
+	if seen.URL.Path != "/v1/messages/batches" {
+		t.Errorf("Expected the batch to be created upstream, got %s", seen.URL.Path)
+	}
+
+	items, _ := forwarded["requests"].([]interface{})
+	if len(items) != 3 {
+		t.Fatalf("Expected 3 forwarded items, got %v", forwarded)
+	}
+	var firstSystem string
+	for i, raw := range items {
+		item := raw.(map[string]interface{})
+		params := item["params"].(map[string]interface{})
+		if item["custom_id"] != requests[i]["custom_id"] {
+			t.Errorf("Expected custom_id %v, got %v", requests[i]["custom_id"], item["custom_id"])
+		}
+		if params["metadata"] == nil {
+			t.Errorf("Expected unmodeled params to be kept, got %v", params)
+		}
+
+		systemJSON, _ := json.Marshal(params["system"])
+		if !strings.Contains(string(systemJSON), "cache_control") {
+			t.Errorf("Expected item %d's system prompt to be marked, got %s", i, systemJSON)
+		}
+		if i == 0 {
+			firstSystem = string(systemJSON)
+		} else if string(systemJSON) != firstSystem {
+			t.Errorf("Expected identical system markers across items, got %s and %s", firstSystem, systemJSON)
+		}
+	}
+
+	if w.Header().Get("X-Autocache-Batch-Items") != "3" || w.Header().Get("X-Autocache-Injected") != "true" {
+		t.Errorf("Expected batch metadata headers, got %v", w.Header())
+	}
+	if got := seen.Header.Values("anthropic-beta"); len(got) != 1 || got[0] != client.ExtendedCacheTTLBeta {
+		t.Errorf("Expected the 1h TTL beta flag upstream, got %q", got)
+	}
+
+	entries, _ := handler.history.Query(history.Query{})
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 stored items, got %d", len(entries))
+	}
+	ids := map[string]bool{}
+	for _, entry := range entries {
+		ids[entry.BatchCustomID] = true
+	}
+	if !ids["eval-1"] || !ids["eval-2"] || !ids["eval-3"] {
+		t.Errorf("Expected stored metadata per custom_id, got %v", ids)
+	}
+}
+
+func TestHandleBatchesPassthrough(t *testing.T) {
+	var seen []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		seen = append(seen, r.Method+" "+r.URL.Path+" "+string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := &config.Config{AnthropicURL: mockServer.URL, CacheStrategy: "moderate", TokenizerMode: "heuristic"}
+	mux := NewAutocacheHandler(cfg, logger).SetupRoutes()
+
+	bypassed := `{"requests":[{"custom_id":"a","params":{"model":"claude-3-5-sonnet-20241022","max_tokens":10,"messages":[]}}]}`
+	requests := []*http.Request{
+		httptest.NewRequest("GET", "/v1/messages/batches?limit=2", nil),
+		httptest.NewRequest("GET", "/v1/messages/batches/msgbatch_1", nil),
+		httptest.NewRequest("GET", "/v1/messages/batches/msgbatch_1/results", nil),
+		httptest.NewRequest("POST", "/v1/messages/batches/msgbatch_1/cancel", nil),
+		httptest.NewRequest("POST", "/v1/messages/batches", strings.NewReader(bypassed)),
+	}
+	requests[4].Header.Set("X-Autocache-Bypass", "true")
+
+	for _, req := range requests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected %s %s to be passed through, got %d", req.Method, req.URL, w.Code)
+		}
+	}
+
+	expected := []string{
+		"GET /v1/messages/batches ",
+		"GET /v1/messages/batches/msgbatch_1 ",
+		"GET /v1/messages/batches/msgbatch_1/results ",
+		"POST /v1/messages/batches/msgbatch_1/cancel ",
+		"POST /v1/messages/batches " + bypassed,
+	}
+	if strings.Join(seen, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected upstream calls:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(seen, "\n"))
+	}
+}
+
+func TestHandleBatchesInvalidJSON(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	handler := NewAutocacheHandler(&config.Config{AnthropicURL: "http://127.0.0.1:1", CacheStrategy: "moderate", TokenizerMode: "heuristic"}, logger)
+
+	w := httptest.NewRecorder()
+	handler.HandleBatches(w, httptest.NewRequest("POST", "/v1/messages/batches", strings.NewReader("{not json")))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid JSON, got %d", w.Code)
+	}
+}
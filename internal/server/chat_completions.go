@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"autocache/internal/client"
+	"autocache/internal/openai"
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HandleChatCompletions handles POST /v1/chat/completions: OpenAI Chat Completions requests are
+// converted into Messages API requests, get cache control injected like on /v1/messages, and
+// the response or event stream is converted back, with cache reads reported as cached tokens
+func (ah *AutocacheHandler) HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ah.writeError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+
+	// Count the request with the status it was answered with
+	wrapper := &responseWrapper{ResponseWriter: w, statusCode: http.StatusOK}
+	w = wrapper
+	var model string
+	strategy := ah.config.CacheStrategy
+	defer func() {
+		ah.metrics.observeRequest(model, strategy, wrapper.statusCode)
+	}()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		ah.logger.WithError(err).Error("Failed to read request body")
+		ah.writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+
+	var chatReq openai.ChatCompletionRequest
+	if err := json.Unmarshal(body, &chatReq); err != nil {
+		ah.logger.WithError(err).Error("Failed to parse chat completion JSON")
+		ah.writeError(w, http.StatusBadRequest, "Invalid JSON in request body")
+		return
+	}
+	model = chatReq.Model
+
+	req, err := openai.ToAnthropic(&chatReq)
+	if err == nil {
+		err = ah.proxyClient.ValidateRequest(req)
+	}
+	if err != nil {
+		ah.logger.WithError(err).Warn("Chat completion request conversion failed")
+		ah.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	ah.proxyClient.LogRequestSummary(req)
+
+	// Inject cache control, unless bypassed
+	var policy *cachePolicy
+	metadata := &types.CacheMetadata{Model: req.Model}
+	if ah.shouldBypassCaching(r) {
+		ah.logger.Info("Bypassing cache injection due to header")
+		strategy = "bypass"
+		w.Header().Set("X-Autocache-Injected", "false")
+	} else {
+		if policy, err = ah.resolveCachePolicy(r, req.Model); err != nil {
+			ah.logger.WithError(err).Warn("Rejected cache policy overrides")
+			ah.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		strategy = policy.name
+
+		if metadata, err = ah.injectCacheControl(req, policy); err != nil {
+			ah.logger.WithError(err).Error("Failed to inject cache control")
+			ah.writeError(w, http.StatusInternalServerError, "Failed to process cache injection")
+			return
+		}
+		ah.addCacheMetadataHeaders(w, metadata)
+		ah.addPolicyHeaders(w, policy)
+	}
+
+	// Responses are translated, so they must arrive uncompressed
+	headers := client.CreateHeadersMap(r.Header, ah.getCredentials(r), ah.logger)
+	headers.Del("Accept-Encoding")
+	ah.addBetaFlags(headers, metadata)
+
+	var usage *types.Usage
+	if chatReq.Stream {
+		includeUsage := chatReq.StreamOptions != nil && chatReq.StreamOptions.IncludeUsage
+		usage, err = ah.forwardStreaming(r.Context(), req, headers, openai.NewStreamTranslator(w, includeUsage))
+		if err != nil {
+			ah.logger.WithError(err).Error("Failed to forward streaming chat completion")
+			return
+		}
+	} else if usage = ah.forwardChatCompletion(w, r, req, headers); usage == nil {
+		return
+	}
+
+	if policy == nil {
+		return
+	}
+
+	// Record what Anthropic actually billed
+	ah.recordUsage(metadata, usage)
+
+	// Store metadata for savings endpoint
+	ah.metrics.observeMetadata(metadata)
+	ah.storeRequestMetadata(metadata)
+
+	ah.logger.WithFields(logrus.Fields{
+		"cache_injected": metadata.CacheInjected,
+		"cache_ratio":    metadata.CacheRatio,
+		"breakpoints":    len(metadata.Breakpoints),
+		"streaming":      chatReq.Stream,
+	}).Info("Successfully processed chat completion request")
+}
+
+// forwardChatCompletion forwards a non-streaming request and writes the response as a chat
+// completion. It returns the billed usage, or nil when no completion was returned.
+func (ah *AutocacheHandler) forwardChatCompletion(w http.ResponseWriter, r *http.Request, req *types.AnthropicRequest, headers http.Header) *types.Usage {
+	upstreamStart := time.Now()
+	resp, err := ah.proxyClient.ForwardRequest(r.Context(), req, headers)
+	if err != nil {
+		ah.metrics.observeUpstreamError(req.Model, false, time.Since(upstreamStart), err)
+		ah.logger.WithError(err).Error("Failed to forward request")
+		ah.writeUpstreamError(w, err)
+		return nil
+	}
+
+	anthropicResp, responseBody, err := ah.proxyClient.ReadAndParseResponse(resp)
+	ah.metrics.observeUpstream(req.Model, false, time.Since(upstreamStart), resp.StatusCode)
+	if err != nil {
+		// Anthropic errors carry an "error" object with a type and message, like OpenAI's
+		ah.logger.WithError(err).Error("Failed to read response")
+		ah.writeRawResponse(w, resp.StatusCode, responseBody, resp.Header)
+		return nil
+	}
+
+	for key, values := range resp.Header {
+		if key == "Content-Encoding" || key == "Content-Length" {
+			continue // The body is rewritten
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(openai.FromAnthropic(anthropicResp)); err != nil {
+		ah.logger.WithError(err).Error("Failed to write chat completion response")
+	}
+
+	return &anthropicResp.Usage
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"autocache/internal/config"
+	"autocache/internal/history"
+	"autocache/internal/openai"
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHandleChatCompletions(t *testing.T) {
+	var forwarded map[string]interface{}
+	var seen *http.Request
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+		forwarded = nil
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &forwarded)
+
+		if forwarded["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "event: message_start\n"+
+				`data: {"type":"message_start","message":{"id":"msg_2","model":"claude-3-5-sonnet-20241022","usage":{"input_tokens":10,"cache_read_input_tokens":3000,"output_tokens":1}}}`+"\n\n"+
+				"event: content_block_delta\n"+
+				`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`+"\n\n"+
+				"event: message_delta\n"+
+				`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}`+"\n\n"+
+				"event: message_stop\n"+
+				`data: {"type":"message_stop"}`+"\n\n")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022",`+
+			`"content":[{"type":"text","text":"Hello"}],"stop_reason":"end_turn",`+
+			`"usage":{"input_tokens":10,"cache_creation_input_tokens":3000,"cache_read_input_tokens":0,"output_tokens":5}}`)
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := &config.Config{
+		AnthropicURL:       mockServer.URL,
+		CacheStrategy:      "moderate",
+		TokenizerMode:      "heuristic",
+		SavingsHistorySize: 10,
+	}
+	handler := NewAutocacheHandler(cfg, logger)
+	mux := handler.SetupRoutes()
+	lastEntry := func() *types.CacheMetadata {
+		entries, _ := handler.history.Query(history.Query{})
+		if len(entries) == 0 {
+			return nil
+		}
+		return &entries[len(entries)-1]
+	}
+
+	system := strings.Repeat("You answer questions about a long manual. ", 300)
+	request := func(stream bool) string {
+		body, _ := json.Marshal(map[string]interface{}{
+			"model":          "claude-3-5-sonnet-20241022",
+			"stream":         stream,
+			"stream_options": map[string]bool{"include_usage": true},
+			"messages": []map[string]string{
+				{"role": "system", "content": system},
+				{"role": "user", "content": "Hello"},
+			},
+		})
+		return string(body)
+	}
+
+	t.Run("Non-streaming", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(request(false)))
+		req.Header.Set("Authorization", "Bearer sk-ant-api03-client")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if seen.URL.Path != "/v1/messages" || seen.Header.Get("x-api-key") != "sk-ant-api03-client" {
+			t.Errorf("Expected a Messages API request with the API key, got %s %v", seen.URL.Path, seen.Header)
+		}
+
+		// The system prompt is marked for caching
+		systemBlocks, _ := forwarded["system"].([]interface{})
+		if len(systemBlocks) != 1 || systemBlocks[0].(map[string]interface{})["cache_control"] == nil {
+			t.Errorf("Expected cache_control on the system prompt, got %v", forwarded["system"])
+		}
+		if forwarded["max_tokens"] != float64(4096) {
+			t.Errorf("Expected the default max_tokens, got %v", forwarded["max_tokens"])
+		}
+		if w.Header().Get("X-Autocache-Injected") != "true" {
+			t.Error("Expected cache metadata headers")
+		}
+
+		var resp openai.ChatCompletionResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		if resp.Object != "chat.completion" || *resp.Choices[0].Message.Content.Text != "Hello" || *resp.Choices[0].FinishReason != "stop" {
+			t.Errorf("Unexpected response: %s", w.Body.String())
+		}
+		if resp.Usage.PromptTokens != 3010 || resp.Usage.PromptTokensDetails.CachedTokens != 0 {
+			t.Errorf("Unexpected usage: %+v", resp.Usage)
+		}
+
+		// The request is recorded for /savings with what Anthropic billed
+		if entry := lastEntry(); entry == nil || entry.Usage == nil || entry.Usage.CacheCreationInputTokens != 3000 {
+			t.Errorf("Expected the billed usage in the history, got %+v", entry)
+		}
+	})
+
+	t.Run("Streaming", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(request(true))))
+
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("Expected an event stream, got %d %v", w.Code, w.Header())
+		}
+		if !strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n") {
+			t.Errorf("Expected the stream to end with [DONE], got %s", w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), `"prompt_tokens_details":{"cached_tokens":3000}`) {
+			t.Errorf("Expected cache reads as cached tokens, got %s", w.Body.String())
+		}
+
+		if entry := lastEntry(); entry == nil || entry.Usage == nil || entry.Usage.CacheReadInputTokens != 3000 {
+			t.Errorf("Expected the streamed usage in the history, got %+v", entry)
+		}
+	})
+
+	t.Run("Invalid request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"claude-3-5-sonnet-20241022","n":3,"messages":[{"role":"user","content":"Hi"}]}`)))
+
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "is not supported") {
+			t.Errorf("Expected 400 for n > 1, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestHandleChatCompletionsUpstreamError(t *testing.T) {
+	body := `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: too large"}}`
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, body)
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	handler := NewAutocacheHandler(&config.Config{AnthropicURL: mockServer.URL, CacheStrategy: "moderate", TokenizerMode: "heuristic"}, logger)
+
+	for _, stream := range []string{"false", "true"} {
+		w := httptest.NewRecorder()
+		handler.HandleChatCompletions(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(
+			`{"model":"claude-3-5-sonnet-20241022","stream":`+stream+`,"messages":[{"role":"user","content":"Hi"}]}`)))
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != body {
+			t.Errorf("Expected the Anthropic error relayed (stream %s), got %d: %s", stream, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Modes of COUNT_TOKENS_MODE
+const (
+	countTokensUpstream = "upstream" // Pass the request through to Anthropic
+	countTokensLocal    = "local"    // Answer with the configured tokenizer's count
+	countTokensCompare  = "compare"  // Pass it through and log how far the local count was off
+)
+
+// countTokensResponse is the body of a count_tokens response
+type countTokensResponse struct {
+	InputTokens int `json:"input_tokens"`
+}
+
+// HandleCountTokens handles /v1/messages/count_tokens according to COUNT_TOKENS_MODE: passed
+// through to Anthropic, answered by the configured tokenizer without a round trip, or passed
+// through with the local count logged against Anthropic's.
+func (ah *AutocacheHandler) HandleCountTokens(w http.ResponseWriter, r *http.Request) {
+	mode := ah.config.CountTokensMode
+	if r.Method != http.MethodPost || (mode != countTokensLocal && mode != countTokensCompare) {
+		ah.HandlePassthrough(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		ah.logger.WithError(err).Error("Failed to read request body")
+		ah.writeError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+
+	var req types.AnthropicRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		ah.logger.WithError(err).Error("Failed to parse request JSON")
+		ah.writeError(w, http.StatusBadRequest, "Invalid JSON in request body")
+		return
+	}
+
+	localTokens := ah.cacheInjector.GetTokenizer().EstimateRequestTokens(&req)
+
+	if mode == countTokensCompare {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		ah.compareCountTokens(w, r, &req, localTokens)
+		return
+	}
+
+	wrapper := &responseWrapper{ResponseWriter: w, statusCode: http.StatusOK}
+	defer func() {
+		ah.metrics.observeRequest(req.Model, countTokensLocal, wrapper.statusCode)
+	}()
+
+	if req.Model == "" || len(req.Messages) == 0 {
+		ah.writeError(wrapper, http.StatusBadRequest, "Invalid request: model and messages are required")
+		return
+	}
+
+	wrapper.Header().Set("Content-Type", "application/json")
+	wrapper.Header().Set("X-Autocache-Token-Count", countTokensLocal)
+	wrapper.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(wrapper).Encode(countTokensResponse{InputTokens: localTokens}); err != nil {
+		ah.logger.WithError(err).Error("Failed to write count_tokens response")
+	}
+
+	ah.logger.WithFields(logrus.Fields{
+		"model":        req.Model,
+		"input_tokens": localTokens,
+	}).Debug("Counted tokens locally")
+}
+
+// compareCountTokens passes a count_tokens request through, returning Anthropic's response
+// with the local count in a header, and logs the difference between the two
+func (ah *AutocacheHandler) compareCountTokens(w http.ResponseWriter, r *http.Request, req *types.AnthropicRequest, localTokens int) {
+	w.Header().Set("X-Autocache-Token-Count", countTokensCompare)
+	w.Header().Set("X-Autocache-Local-Input-Tokens", strconv.Itoa(localTokens))
+
+	// Anthropic's response is parsed, so it must arrive uncompressed
+	r.Header.Del("Accept-Encoding")
+
+	recorder := &bodyRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+	ah.HandlePassthrough(recorder, r)
+
+	var upstream countTokensResponse
+	if recorder.statusCode != http.StatusOK || json.Unmarshal(recorder.body.Bytes(), &upstream) != nil {
+		ah.logger.WithField("status_code", recorder.statusCode).Debug("No token count from Anthropic to compare with")
+		return
+	}
+
+	estimationError := types.NewEstimationError(localTokens, types.Usage{InputTokens: upstream.InputTokens})
+	ah.metrics.countTokensDiff.Observe(math.Abs(estimationError.RelativeError), ah.metrics.modelLabel(req.Model), ah.config.TokenizerMode)
+	ah.logger.WithFields(logrus.Fields{
+		"model":           req.Model,
+		"tokenizer":       ah.config.TokenizerMode,
+		"local_tokens":    localTokens,
+		"upstream_tokens": upstream.InputTokens,
+		"delta":           estimationError.AbsoluteError,
+		"relative_error":  fmt.Sprintf("%.3f", estimationError.RelativeError),
+	}).Info("Compared local token count with Anthropic")
+}
+
+// bodyRecorder relays a response while keeping its status code and a copy of its body
+type bodyRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (br *bodyRecorder) WriteHeader(code int) {
+	br.statusCode = code
+	br.ResponseWriter.WriteHeader(code)
+}
+
+func (br *bodyRecorder) Write(b []byte) (int, error) {
+	br.body.Write(b)
+	return br.ResponseWriter.Write(b)
+}
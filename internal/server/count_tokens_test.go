@@ -0,0 +1,153 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"autocache/internal/config"
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHandleCountTokens(t *testing.T) {
+	var upstreamCalls int
+	var upstreamBody string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		body, _ := io.ReadAll(r.Body)
+		upstreamBody = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"input_tokens":1234}`)
+	}))
+	defer mockServer.Close()
+
+	body := `{"model":"claude-3-5-sonnet-20241022","system":"Be brief","messages":[{"role":"user","content":"Hello there"}]}`
+	var req types.AnthropicRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		mode           string
+		body           string
+		expectStatus   int
+		expectUpstream bool
+		expectLocal    bool // Expect the local count in the body rather than Anthropic's
+	}{
+		{name: "Default passes through", mode: "", body: body, expectStatus: http.StatusOK, expectUpstream: true},
+		{name: "Upstream passes through", mode: "upstream", body: body, expectStatus: http.StatusOK, expectUpstream: true},
+		{name: "Local answers without a round trip", mode: "local", body: body, expectStatus: http.StatusOK, expectLocal: true},
+		{name: "Compare relays Anthropic's count", mode: "compare", body: body, expectStatus: http.StatusOK, expectUpstream: true},
+		{name: "Local rejects invalid JSON", mode: "local", body: `{"model":`, expectStatus: http.StatusBadRequest},
+		{name: "Local requires messages", mode: "local", body: `{"model":"claude-3-5-sonnet-20241022"}`, expectStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamCalls, upstreamBody = 0, ""
+			logger := logrus.New()
+			logger.SetLevel(logrus.ErrorLevel)
+			cfg := &config.Config{
+				AnthropicURL:    mockServer.URL,
+				CacheStrategy:   "moderate",
+				TokenizerMode:   "heuristic",
+				CountTokensMode: tt.mode,
+			}
+			handler := NewAutocacheHandler(cfg, logger)
+			localTokens := handler.cacheInjector.GetTokenizer().EstimateRequestTokens(&req)
+
+			w := httptest.NewRecorder()
+			handler.SetupRoutes().ServeHTTP(w, httptest.NewRequest("POST", "/v1/messages/count_tokens", strings.NewReader(tt.body)))
+
+			if w.Code != tt.expectStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectStatus, w.Code, w.Body.String())
+			}
+			if got := upstreamCalls > 0; got != tt.expectUpstream {
+				t.Errorf("Expected upstream call %v, got %d calls", tt.expectUpstream, upstreamCalls)
+			}
+			if tt.expectUpstream && upstreamBody != tt.body {
+				t.Errorf("Expected the body to be forwarded unchanged, got %s", upstreamBody)
+			}
+			if tt.expectStatus != http.StatusOK {
+				return
+			}
+
+			var resp countTokensResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to parse response %s: %v", w.Body.String(), err)
+			}
+			expected := 1234
+			if tt.expectLocal {
+				expected = localTokens
+			}
+			if resp.InputTokens != expected {
+				t.Errorf("Expected input_tokens %d, got %d", expected, resp.InputTokens)
+			}
+
+			if tt.mode == "compare" {
+				if got := w.Header().Get("X-Autocache-Local-Input-Tokens"); got != strconv.Itoa(localTokens) {
+					t.Errorf("Expected the local count %d in a header, got %q", localTokens, got)
+				}
+				var metricsText strings.Builder
+				_ = handler.metrics.registry.WriteText(&metricsText)
+				if !strings.Contains(metricsText.String(), `autocache_count_tokens_relative_error_count{model="claude-3-5-sonnet-20241022",tokenizer="heuristic"} 1`) {
+					t.Error("Expected the comparison to be recorded")
+				}
+			}
+		})
+	}
+}
+
+func TestCountTokensCompareGzip(t *testing.T) {
+	// Anthropic compresses responses for clients that accept gzip
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			_, _ = io.WriteString(w, `{"input_tokens":1234}`)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		_, _ = io.WriteString(gz, `{"input_tokens":1234}`)
+		_ = gz.Close()
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := &config.Config{
+		AnthropicURL:    mockServer.URL,
+		CacheStrategy:   "moderate",
+		TokenizerMode:   "heuristic",
+		CountTokensMode: "compare",
+	}
+	handler := NewAutocacheHandler(cfg, logger)
+
+	body := `{"model":"claude-3-5-sonnet-20241022","messages":[{"role":"user","content":"Hello there"}]}`
+	req := httptest.NewRequest("POST", "/v1/messages/count_tokens", strings.NewReader(body))
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.SetupRoutes().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp countTokensResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.InputTokens != 1234 {
+		t.Errorf("Expected Anthropic's count in an uncompressed body, got %q (%v)", w.Body.String(), err)
+	}
+
+	var metricsText strings.Builder
+	_ = handler.metrics.registry.WriteText(&metricsText)
+	if !strings.Contains(metricsText.String(), `autocache_count_tokens_relative_error_count{model="claude-3-5-sonnet-20241022",tokenizer="heuristic"} 1`) {
+		t.Error("Expected the comparison to be recorded despite the client accepting gzip")
+	}
+}
@@ -1,22 +1,25 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"runtime/debug"
 	"strconv"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 
 	"autocache/internal/cache"
 	"autocache/internal/client"
 	"autocache/internal/config"
+	"autocache/internal/history"
+	"autocache/internal/metrics"
 	"autocache/internal/pricing"
-	"autocache/internal/tokenizer"
 	"autocache/internal/types"
 
 	"github.com/sirupsen/logrus"
@@ -28,40 +31,47 @@ type AutocacheHandler struct {
 	proxyClient    *client.ProxyClient
 	config         *config.Config
 	logger         *logrus.Logger
-	requestHistory []types.CacheMetadata
-	historyMutex   sync.RWMutex
+	history        history.Store
+	metrics        *proxyMetrics
 	panicCount     atomic.Uint64
 	lastPanicTime  atomic.Int64
 }
 
-// NewAutocacheHandler creates a new handler
+// NewAutocacheHandler creates a new handler that keeps savings history in memory
 func NewAutocacheHandler(cfg *config.Config, logger *logrus.Logger) *AutocacheHandler {
+	store := history.NewMemoryStore(history.Retention{
+		MaxEntries: cfg.SavingsHistorySize,
+		MaxAge:     cfg.SavingsRetention,
+	})
+
+	return NewAutocacheHandlerWithStore(cfg, logger, store)
+}
+
+// NewAutocacheHandlerWithStore creates a new handler that records savings history in the given store
+func NewAutocacheHandlerWithStore(cfg *config.Config, logger *logrus.Logger, store history.Store) *AutocacheHandler {
 	strategy := types.CacheStrategy(cfg.CacheStrategy)
 
-	return &AutocacheHandler{
-		cacheInjector:  cache.NewCacheInjectorWithConfig(strategy, cfg, logger),
-		proxyClient:    client.NewProxyClient(cfg.AnthropicURL, logger),
-		config:         cfg,
-		logger:         logger,
-		requestHistory: make([]types.CacheMetadata, 0, cfg.SavingsHistorySize),
+	ah := &AutocacheHandler{
+		cacheInjector: cache.NewCacheInjectorWithConfig(strategy, cfg, logger),
+		proxyClient:   client.NewProxyClientWithConfig(cfg, logger),
+		config:        cfg,
+		logger:        logger,
+		history:       store,
 	}
+	ah.metrics = newProxyMetrics(ah)
+	ah.proxyClient.SetRetryObserver(ah.metrics.observeRetry)
+
+	return ah
 }
 
-// storeRequestMetadata stores metadata for the savings endpoint (thread-safe)
+// storeRequestMetadata stores metadata for the savings endpoint
 func (ah *AutocacheHandler) storeRequestMetadata(metadata *types.CacheMetadata) {
-	if ah.config.SavingsHistorySize == 0 {
+	if !ah.config.SavingsHistoryEnabled() {
 		return // History disabled
 	}
 
-	ah.historyMutex.Lock()
-	defer ah.historyMutex.Unlock()
-
-	// Add to history
-	ah.requestHistory = append(ah.requestHistory, *metadata)
-
-	// Keep only the last N requests
-	if len(ah.requestHistory) > ah.config.SavingsHistorySize {
-		ah.requestHistory = ah.requestHistory[len(ah.requestHistory)-ah.config.SavingsHistorySize:]
+	if err := ah.history.Append(*metadata); err != nil {
+		ah.logger.WithError(err).Warn("Failed to store request metadata")
 	}
 }
 
@@ -72,6 +82,15 @@ func (ah *AutocacheHandler) HandleMessages(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Count the request with the status it was answered with
+	wrapper := &responseWrapper{ResponseWriter: w, statusCode: http.StatusOK}
+	w = wrapper
+	var req types.AnthropicRequest
+	strategy := ah.config.CacheStrategy
+	defer func() {
+		ah.metrics.observeRequest(req.Model, strategy, wrapper.statusCode)
+	}()
+
 	// Read and parse the request
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -80,7 +99,6 @@ func (ah *AutocacheHandler) HandleMessages(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var req types.AnthropicRequest
 	if err := json.Unmarshal(body, &req); err != nil {
 		ah.logger.WithError(err).Error("Failed to parse request JSON")
 		ah.writeError(w, http.StatusBadRequest, "Invalid JSON in request body")
@@ -100,42 +118,55 @@ func (ah *AutocacheHandler) HandleMessages(w http.ResponseWriter, r *http.Reques
 	// Check if caching should be bypassed
 	if ah.shouldBypassCaching(r) {
 		ah.logger.Info("Bypassing cache injection due to header")
+		strategy = "bypass"
 		ah.forwardWithoutCaching(w, r, &req)
 		return
 	}
 
+	// Apply per-request cache policy overrides
+	policy, err := ah.resolveCachePolicy(r, req.Model)
+	if err != nil {
+		ah.logger.WithError(err).Warn("Rejected cache policy overrides")
+		ah.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	strategy = policy.name
+
 	// Handle streaming vs non-streaming
 	if client.IsStreamingRequest(&req) {
-		ah.handleStreamingRequest(w, r, &req)
+		ah.handleStreamingRequest(w, r, &req, policy)
 	} else {
-		ah.handleNonStreamingRequest(w, r, &req)
+		ah.handleNonStreamingRequest(w, r, &req, policy)
 	}
 }
 
 // handleNonStreamingRequest handles non-streaming requests with cache injection and metadata
-func (ah *AutocacheHandler) handleNonStreamingRequest(w http.ResponseWriter, r *http.Request, req *types.AnthropicRequest) {
+func (ah *AutocacheHandler) handleNonStreamingRequest(w http.ResponseWriter, r *http.Request, req *types.AnthropicRequest, policy *cachePolicy) {
 	// Inject cache control
-	metadata, err := ah.cacheInjector.InjectCacheControl(req)
+	metadata, err := ah.injectCacheControl(req, policy)
 	if err != nil {
 		ah.logger.WithError(err).Error("Failed to inject cache control")
 		ah.writeError(w, http.StatusInternalServerError, "Failed to process cache injection")
 		return
 	}
 
-	// Extract API key
-	apiKey := ah.getAPIKey(r)
-	headers := client.CreateHeadersMap(r.Header, apiKey, ah.logger)
+	// Extract credentials
+	headers := client.CreateHeadersMap(r.Header, ah.getCredentials(r), ah.logger)
+	ah.addBetaFlags(headers, metadata)
 
 	// Forward the request
-	resp, err := ah.proxyClient.ForwardRequest(req, headers)
+	upstreamStart := time.Now()
+	resp, err := ah.proxyClient.ForwardRequest(r.Context(), req, headers)
 	if err != nil {
+		ah.metrics.observeUpstreamError(req.Model, false, time.Since(upstreamStart), err)
 		ah.logger.WithError(err).Error("Failed to forward request")
-		ah.writeError(w, http.StatusBadGateway, "Failed to forward request to Anthropic API")
+		ah.writeUpstreamError(w, err)
 		return
 	}
 
 	// Read and parse response
-	_, responseBody, err := ah.proxyClient.ReadAndParseResponse(resp)
+	anthropicResp, responseBody, err := ah.proxyClient.ReadAndParseResponse(resp)
+	ah.metrics.observeUpstream(req.Model, false, time.Since(upstreamStart), resp.StatusCode)
 	if err != nil {
 		ah.logger.WithError(err).Error("Failed to read response")
 		// Forward the error response as-is
@@ -143,8 +174,12 @@ func (ah *AutocacheHandler) handleNonStreamingRequest(w http.ResponseWriter, r *
 		return
 	}
 
+	// Record what Anthropic actually billed
+	ah.recordUsage(metadata, &anthropicResp.Usage)
+
 	// Add cache metadata headers
 	ah.addCacheMetadataHeaders(w, metadata)
+	ah.addPolicyHeaders(w, policy)
 
 	// Copy response headers from Anthropic (skip Content-Encoding as we may have decompressed)
 	for key, values := range resp.Header {
@@ -162,6 +197,7 @@ func (ah *AutocacheHandler) handleNonStreamingRequest(w http.ResponseWriter, r *
 	_, _ = w.Write(responseBody)
 
 	// Store metadata for savings endpoint
+	ah.metrics.observeMetadata(metadata)
 	ah.storeRequestMetadata(metadata)
 
 	ah.logger.WithFields(logrus.Fields{
@@ -173,9 +209,9 @@ func (ah *AutocacheHandler) handleNonStreamingRequest(w http.ResponseWriter, r *
 }
 
 // handleStreamingRequest handles streaming requests with cache injection
-func (ah *AutocacheHandler) handleStreamingRequest(w http.ResponseWriter, r *http.Request, req *types.AnthropicRequest) {
+func (ah *AutocacheHandler) handleStreamingRequest(w http.ResponseWriter, r *http.Request, req *types.AnthropicRequest, policy *cachePolicy) {
 	// Inject cache control
-	metadata, err := ah.cacheInjector.InjectCacheControl(req)
+	metadata, err := ah.injectCacheControl(req, policy)
 	if err != nil {
 		ah.logger.WithError(err).Error("Failed to inject cache control")
 		ah.writeError(w, http.StatusInternalServerError, "Failed to process cache injection")
@@ -184,52 +220,158 @@ func (ah *AutocacheHandler) handleStreamingRequest(w http.ResponseWriter, r *htt
 
 	// Add cache metadata headers before streaming starts
 	ah.addCacheMetadataHeaders(w, metadata)
+	ah.addPolicyHeaders(w, policy)
 
-	// Extract API key
-	apiKey := ah.getAPIKey(r)
-	headers := client.CreateHeadersMap(r.Header, apiKey, ah.logger)
+	// Extract credentials
+	headers := client.CreateHeadersMap(r.Header, ah.getCredentials(r), ah.logger)
+	ah.addBetaFlags(headers, metadata)
 
 	// Forward the streaming request
-	err = ah.proxyClient.ForwardStreamingRequest(req, headers, w)
+	usage, err := ah.forwardStreaming(r.Context(), req, headers, w)
 	if err != nil {
 		ah.logger.WithError(err).Error("Failed to forward streaming request")
 		// For streaming, we can't send a proper error response if streaming already started
 		return
 	}
 
+	// Record what Anthropic actually billed, as reported in the stream
+	ah.recordUsage(metadata, usage)
+
 	// Store metadata for savings endpoint
+	ah.metrics.observeMetadata(metadata)
 	ah.storeRequestMetadata(metadata)
 
 	ah.logger.WithFields(logrus.Fields{
 		"cache_injected": metadata.CacheInjected,
 		"cache_ratio":    metadata.CacheRatio,
 		"breakpoints":    len(metadata.Breakpoints),
+		"usage_captured": usage != nil,
 		"streaming":      true,
 	}).Info("Successfully processed streaming request")
 }
 
+// injectCacheControl runs cache injection with the request's policy and records how long it took
+func (ah *AutocacheHandler) injectCacheControl(req *types.AnthropicRequest, policy *cachePolicy) (*types.CacheMetadata, error) {
+	start := time.Now()
+	metadata, err := ah.cacheInjector.InjectCacheControlWithStrategy(req, types.CacheStrategy(policy.name), policy.config)
+	ah.metrics.observeInjection(policy.name, time.Since(start))
+	return metadata, err
+}
+
+// forwardStreaming relays a streaming request and records its latency and upstream status
+func (ah *AutocacheHandler) forwardStreaming(ctx context.Context, req *types.AnthropicRequest, headers http.Header, w http.ResponseWriter) (*types.Usage, error) {
+	// Capture the status code the upstream answered with (0 if it never answered)
+	wrapper := &responseWrapper{ResponseWriter: w}
+
+	start := time.Now()
+	usage, err := ah.proxyClient.ForwardStreamingRequest(ctx, req, headers, wrapper)
+	if err != nil && wrapper.statusCode == 0 {
+		// Nothing was relayed, so the client can still get an error response
+		ah.metrics.observeUpstreamError(req.Model, true, time.Since(start), err)
+		ah.writeUpstreamError(w, err)
+		return usage, err
+	}
+	ah.metrics.observeUpstream(req.Model, true, time.Since(start), wrapper.statusCode)
+
+	return usage, err
+}
+
+// writeUpstreamError answers a request whose upstream call failed: 503 with Retry-After while
+// the circuit breaker fails fast, 502 otherwise
+func (ah *AutocacheHandler) writeUpstreamError(w http.ResponseWriter, err error) {
+	var circuitOpen *client.CircuitOpenError
+	if errors.As(err, &circuitOpen) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(circuitOpen.RetryAfter.Seconds()))))
+		ah.writeError(w, http.StatusServiceUnavailable, "Anthropic API is failing, requests are rejected until it recovers")
+		return
+	}
+	ah.writeError(w, http.StatusBadGateway, "Failed to forward request to Anthropic API")
+}
+
+// addBetaFlags adds the beta flags the injected breakpoints need to the forwarded headers
+func (ah *AutocacheHandler) addBetaFlags(headers http.Header, metadata *types.CacheMetadata) {
+	if !client.RequiresExtendedTTLBeta(metadata.Model) {
+		return
+	}
+	for _, bp := range metadata.Breakpoints {
+		if bp.TTL == "1h" {
+			if client.AddBetaFlag(headers, client.ExtendedCacheTTLBeta) {
+				ah.logger.WithFields(logrus.Fields{
+					"model": metadata.Model,
+					"beta":  client.ExtendedCacheTTLBeta,
+				}).Debug("Added beta flag for 1h cache TTL")
+			}
+			return
+		}
+	}
+}
+
+// recordUsage stores the billed usage on the metadata along with the actual cost
+// and how far the tokenizer's estimate was from the billed input tokens
+func (ah *AutocacheHandler) recordUsage(metadata *types.CacheMetadata, usage *types.Usage) {
+	if usage == nil {
+		return
+	}
+
+	billed := *usage
+	metadata.Usage = &billed
+
+	actualCost, err := ah.cacheInjector.GetPricing().CalculateActualCost(metadata.Model, billed, writeTTLForMetadata(metadata))
+	if err != nil {
+		ah.logger.WithError(err).Debug("Failed to calculate actual cost")
+	} else {
+		metadata.ActualCost = &actualCost
+	}
+
+	estimationError := types.NewEstimationError(metadata.TotalTokens, billed)
+	metadata.EstimationError = &estimationError
+
+	ah.logger.WithFields(logrus.Fields{
+		"predicted_input_tokens": estimationError.PredictedInputTokens,
+		"actual_input_tokens":    estimationError.ActualInputTokens,
+		"relative_error":         fmt.Sprintf("%.3f", estimationError.RelativeError),
+		"actual_savings":         pricing.FormatCost(actualCost.Savings),
+	}).Debug("Recorded billed usage")
+}
+
+// writeTTLForMetadata returns the TTL used to price cache writes when the usage has no per-TTL breakdown.
+// Writes are priced at the 1h rate only if every injected breakpoint used it.
+func writeTTLForMetadata(metadata *types.CacheMetadata) string {
+	if len(metadata.Breakpoints) == 0 {
+		return "5m"
+	}
+	for _, bp := range metadata.Breakpoints {
+		if bp.TTL != "1h" {
+			return "5m"
+		}
+	}
+	return "1h"
+}
+
 // forwardWithoutCaching forwards the request without any cache injection
 func (ah *AutocacheHandler) forwardWithoutCaching(w http.ResponseWriter, r *http.Request, req *types.AnthropicRequest) {
 	// Set header to indicate caching was bypassed
 	w.Header().Set("X-Autocache-Injected", "false")
 
-	apiKey := ah.getAPIKey(r)
-	headers := client.CreateHeadersMap(r.Header, apiKey, ah.logger)
+	headers := client.CreateHeadersMap(r.Header, ah.getCredentials(r), ah.logger)
 
 	if client.IsStreamingRequest(req) {
-		err := ah.proxyClient.ForwardStreamingRequest(req, headers, w)
+		_, err := ah.forwardStreaming(r.Context(), req, headers, w)
 		if err != nil {
 			ah.logger.WithError(err).Error("Failed to forward request without caching")
 		}
 	} else {
-		resp, err := ah.proxyClient.ForwardRequest(req, headers)
+		upstreamStart := time.Now()
+		resp, err := ah.proxyClient.ForwardRequest(r.Context(), req, headers)
 		if err != nil {
+			ah.metrics.observeUpstreamError(req.Model, false, time.Since(upstreamStart), err)
 			ah.logger.WithError(err).Error("Failed to forward request without caching")
-			ah.writeError(w, http.StatusBadGateway, "Failed to forward request")
+			ah.writeUpstreamError(w, err)
 			return
 		}
 
 		_, responseBody, err := ah.proxyClient.ReadAndParseResponse(resp)
+		ah.metrics.observeUpstream(req.Model, false, time.Since(upstreamStart), resp.StatusCode)
 		if err != nil {
 			ah.writeRawResponse(w, resp.StatusCode, responseBody, resp.Header)
 			return
@@ -267,14 +409,13 @@ func (ah *AutocacheHandler) addCacheMetadataHeaders(w http.ResponseWriter, metad
 
 	// Breakpoints header (compact format)
 	if len(metadata.Breakpoints) > 0 {
-		breakpointsStr := ""
-		for i, bp := range metadata.Breakpoints {
-			if i > 0 {
-				breakpointsStr += ","
-			}
-			breakpointsStr += fmt.Sprintf("%s:%d:%s", bp.Position, bp.Tokens, bp.TTL)
-		}
-		w.Header().Set("X-Autocache-Breakpoints", breakpointsStr)
+		w.Header().Set("X-Autocache-Breakpoints", formatBreakpoints(metadata.Breakpoints))
+	}
+
+	// Markers the client set itself, in the same format
+	if len(metadata.ExistingBreakpoints) > 0 {
+		w.Header().Set("X-Autocache-Existing-Breakpoints", formatBreakpoints(metadata.ExistingBreakpoints))
+		w.Header().Set("X-Autocache-Client-Cache-Control", metadata.ClientCacheControl)
 	}
 
 	// Savings projections
@@ -282,6 +423,18 @@ func (ah *AutocacheHandler) addCacheMetadataHeaders(w http.ResponseWriter, metad
 	w.Header().Set("X-Autocache-Savings-100req", pricing.FormatCost(metadata.ROI.SavingsAt100Requests))
 }
 
+// formatBreakpoints renders breakpoints as position:tokens:ttl, comma separated
+func formatBreakpoints(breakpoints []types.CacheBreakpoint) string {
+	breakpointsStr := ""
+	for i, bp := range breakpoints {
+		if i > 0 {
+			breakpointsStr += ","
+		}
+		breakpointsStr += fmt.Sprintf("%s:%d:%s", bp.Position, bp.Tokens, bp.TTL)
+	}
+	return breakpointsStr
+}
+
 // shouldBypassCaching checks if caching should be bypassed based on headers
 func (ah *AutocacheHandler) shouldBypassCaching(r *http.Request) bool {
 	// Check for bypass header
@@ -299,16 +452,18 @@ func (ah *AutocacheHandler) shouldBypassCaching(r *http.Request) bool {
 	return false
 }
 
-// getAPIKey extracts API key from request or config
-func (ah *AutocacheHandler) getAPIKey(r *http.Request) string {
+// getCredentials extracts the credential from the request or config, with the scheme
+// AUTH_MODE selects for it
+func (ah *AutocacheHandler) getCredentials(r *http.Request) client.Credentials {
 	// First try to get from request headers
-	apiKey := client.ExtractAPIKey(r.Header, ah.logger)
-	if apiKey != "" {
-		return apiKey
+	creds := client.ExtractCredentials(r.Header, ah.config.AuthMode, ah.logger)
+	if creds.Token != "" {
+		return creds
 	}
 
 	// Fall back to configured API key
-	return ah.config.AnthropicAPIKey
+	token := ah.config.AnthropicAPIKey
+	return client.Credentials{Token: token, Scheme: client.DetectAuthScheme(token, false, ah.config.AuthMode)}
 }
 
 // writeError writes an error response
@@ -356,21 +511,29 @@ func (ah *AutocacheHandler) HandleHealth(w http.ResponseWriter, r *http.Request)
 	_ = json.NewEncoder(w).Encode(health)
 }
 
-// HandleMetrics handles metrics endpoint
+// HandleMetrics serves Prometheus metrics, or the JSON summary with ?format=json
 func (ah *AutocacheHandler) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("format") == "json" {
+		ah.HandleMetricsJSON(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.WriteHeader(http.StatusOK)
+
+	if err := ah.metrics.registry.WriteText(w); err != nil {
+		ah.logger.WithError(err).Debug("Failed to write metrics")
+	}
+}
+
+// HandleMetricsJSON handles the JSON metrics and configuration summary
+func (ah *AutocacheHandler) HandleMetricsJSON(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
 	// Get tokenizer panic stats if available
-	tokenizerPanics := uint64(0)
-	tokenizerFallbacks := uint64(0)
-	if offlineTokenizer, ok := ah.cacheInjector.GetTokenizer().(*tokenizer.OfflineTokenizer); ok {
-		stats := offlineTokenizer.GetPanicStats()
-		if stats != nil {
-			tokenizerPanics = stats["panic_count"]
-			tokenizerFallbacks = stats["fallback_count"]
-		}
-	}
+	tokenizerPanics := tokenizerStat(ah, "panic_count")
+	tokenizerFallbacks := tokenizerStat(ah, "fallback_count")
 
 	// Get last panic time
 	lastPanic := ah.lastPanicTime.Load()
@@ -383,7 +546,7 @@ func (ah *AutocacheHandler) HandleMetrics(w http.ResponseWriter, r *http.Request
 
 	metrics := map[string]interface{}{
 		"supported_models": ah.cacheInjector.GetPricing().GetSupportedModels(),
-		"strategies":       []string{"conservative", "moderate", "aggressive"},
+		"strategies":       ah.config.StrategyNames(),
 		"cache_limits": map[string]interface{}{
 			"max_breakpoints":     4,
 			"min_tokens_default":  1024,
@@ -405,25 +568,61 @@ func (ah *AutocacheHandler) HandleMetrics(w http.ResponseWriter, r *http.Request
 	_ = json.NewEncoder(w).Encode(metrics)
 }
 
-// HandleSavings handles the savings analytics endpoint
+// defaultRecentRequests is how many requests /savings lists when no limit is given
+const defaultRecentRequests = 100
+
+// HandleSavings handles the savings analytics endpoint.
+// Optional query parameters: since and until (RFC 3339 timestamps, or durations such as 24h
+// meaning that long ago) select the time range, limit caps the number of recent requests listed.
 func (ah *AutocacheHandler) HandleSavings(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	params := r.URL.Query()
+
+	query := history.Query{}
+	var err error
+	if query.Since, err = parseTimeParam(params.Get("since"), now); err != nil {
+		ah.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid since parameter: %s", err.Error()))
+		return
+	}
+	if query.Until, err = parseTimeParam(params.Get("until"), now); err != nil {
+		ah.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid until parameter: %s", err.Error()))
+		return
+	}
+
+	limit := defaultRecentRequests
+	if value := params.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			ah.writeError(w, http.StatusBadRequest, "Invalid limit parameter: must be a non-negative integer")
+			return
+		}
+	}
+
+	entries, err := ah.history.Query(query)
+	if err != nil {
+		ah.logger.WithError(err).Error("Failed to query savings history")
+		ah.writeError(w, http.StatusInternalServerError, "Failed to query savings history")
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	// Get copy of history (thread-safe)
-	ah.historyMutex.RLock()
-	history := make([]types.CacheMetadata, len(ah.requestHistory))
-	copy(history, ah.requestHistory)
-	ah.historyMutex.RUnlock()
-
 	// Calculate aggregated statistics
-	totalRequests := len(history)
+	totalRequests := len(entries)
 	requestsWithCache := 0
 	totalTokensProcessed := 0
 	totalTokensCached := 0
 	totalSavingsAt10 := 0.0
 	totalSavingsAt100 := 0.0
 
+	// Realized figures from billed usage
+	requestsWithUsage := 0
+	totalActualCost := 0.0
+	totalUncachedCost := 0.0
+	totalCacheReadTokens := 0
+	totalCacheWriteTokens := 0
+	totalAbsRelativeError := 0.0
+
 	// Debug info: breakpoints by type
 	breakpointsByType := map[string]int{
 		"system":  0,
@@ -436,7 +635,7 @@ func (ah *AutocacheHandler) HandleSavings(w http.ResponseWriter, r *http.Request
 		"content": {},
 	}
 
-	for _, meta := range history {
+	for _, meta := range entries {
 		totalTokensProcessed += meta.TotalTokens
 		totalTokensCached += meta.CachedTokens
 
@@ -446,6 +645,19 @@ func (ah *AutocacheHandler) HandleSavings(w http.ResponseWriter, r *http.Request
 			totalSavingsAt100 += meta.ROI.SavingsAt100Requests
 		}
 
+		if meta.Usage != nil {
+			requestsWithUsage++
+			totalCacheReadTokens += meta.Usage.CacheReadInputTokens
+			totalCacheWriteTokens += meta.Usage.CacheCreationInputTokens
+		}
+		if meta.ActualCost != nil {
+			totalActualCost += meta.ActualCost.TotalCost
+			totalUncachedCost += meta.ActualCost.UncachedCost
+		}
+		if meta.EstimationError != nil {
+			totalAbsRelativeError += math.Abs(meta.EstimationError.RelativeError)
+		}
+
 		// Count breakpoints by type
 		for _, bp := range meta.Breakpoints {
 			breakpointsByType[bp.Type]++
@@ -473,9 +685,24 @@ func (ah *AutocacheHandler) HandleSavings(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	meanEstimationError := 0.0
+	if requestsWithUsage > 0 {
+		meanEstimationError = totalAbsRelativeError / float64(requestsWithUsage)
+	}
+
+	recentRequests := entries
+	if len(recentRequests) > limit {
+		recentRequests = recentRequests[len(recentRequests)-limit:]
+	}
+
 	// Build response
 	response := map[string]interface{}{
-		"recent_requests": history,
+		"recent_requests": recentRequests,
+		"query": map[string]interface{}{
+			"since": formatTimeParam(query.Since),
+			"until": formatTimeParam(query.Until),
+			"limit": limit,
+		},
 		"aggregated_stats": map[string]interface{}{
 			"total_requests":         totalRequests,
 			"requests_with_cache":    requestsWithCache,
@@ -485,12 +712,23 @@ func (ah *AutocacheHandler) HandleSavings(w http.ResponseWriter, r *http.Request
 			"total_savings_after_10_reqs":  pricing.FormatCost(totalSavingsAt10),
 			"total_savings_after_100_reqs": pricing.FormatCost(totalSavingsAt100),
 		},
+		"realized_stats": map[string]interface{}{
+			"requests_with_usage":         requestsWithUsage,
+			"total_cache_read_tokens":     totalCacheReadTokens,
+			"total_cache_write_tokens":    totalCacheWriteTokens,
+			"total_actual_cost":           pricing.FormatCost(totalActualCost),
+			"total_uncached_cost":         pricing.FormatCost(totalUncachedCost),
+			"total_realized_savings":      pricing.FormatCost(totalUncachedCost - totalActualCost),
+			"mean_token_estimation_error": meanEstimationError,
+		},
 		"debug_info": map[string]interface{}{
 			"breakpoints_by_type":   breakpointsByType,
 			"average_tokens_by_type": avgTokensByType,
 		},
 		"config": map[string]interface{}{
 			"history_size": ah.config.SavingsHistorySize,
+			"store":        ah.config.SavingsStore,
+			"retention":    ah.config.SavingsRetention.String(),
 			"strategy":     ah.config.CacheStrategy,
 		},
 	}
@@ -498,21 +736,49 @@ func (ah *AutocacheHandler) HandleSavings(w http.ResponseWriter, r *http.Request
 	_ = json.NewEncoder(w).Encode(response)
 }
 
+// parseTimeParam parses an RFC 3339 timestamp or a duration relative to now. Empty means unbounded.
+func parseTimeParam(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+		return timestamp, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	return time.Time{}, fmt.Errorf("expected an RFC 3339 timestamp or a duration, got %q", value)
+}
+
+// formatTimeParam formats a query bound for the response, leaving unbounded sides empty
+func formatTimeParam(timestamp time.Time) string {
+	if timestamp.IsZero() {
+		return ""
+	}
+	return timestamp.UTC().Format(time.RFC3339)
+}
+
 // SetupRoutes sets up HTTP routes
 func (ah *AutocacheHandler) SetupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
 
 	// Main API endpoint
 	mux.HandleFunc("/v1/messages", ah.HandleMessages)
+	mux.HandleFunc("/v1/messages/batches", ah.HandleBatches)
+	mux.HandleFunc("/v1/messages/count_tokens", ah.HandleCountTokens)
+	mux.HandleFunc("/v1/chat/completions", ah.HandleChatCompletions)
 
 	// Health check
 	mux.HandleFunc("/health", ah.HandleHealth)
-	mux.HandleFunc("/", ah.HandleHealth) // Root also serves health
 
 	// Metrics and analytics
 	mux.HandleFunc("/metrics", ah.HandleMetrics)
+	mux.HandleFunc("/metrics/json", ah.HandleMetricsJSON)
 	mux.HandleFunc("/savings", ah.HandleSavings)
 
+	// Every other path is passed through to Anthropic
+	mux.HandleFunc("/", ah.HandlePassthrough)
+
 	return mux
 }
 
@@ -600,6 +866,13 @@ func (rw *responseWrapper) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush forwards flushes to the wrapped writer so streamed events are not buffered
+func (rw *responseWrapper) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // getClientIP gets the client IP address from request
 func getClientIP(r *http.Request) string {
 	// Check X-Forwarded-For header
@@ -3,12 +3,21 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"autocache/internal/client"
 	"autocache/internal/config"
+	"autocache/internal/history"
 	"autocache/internal/types"
 
 	"github.com/sirupsen/logrus"
@@ -59,10 +68,12 @@ func createMockAnthropicServer() *httptest.Server {
 		hasCacheControl := false
 		cacheTokens := 0
 
-		// Check system message for cache control
-		if req.System != "" {
-			cacheTokens += 500 // Mock cached system tokens
-			hasCacheControl = true
+		// Check system blocks for cache control
+		for _, block := range req.SystemBlocks {
+			if block.CacheControl != nil {
+				cacheTokens += 500 // Mock cached system tokens
+				hasCacheControl = true
+			}
 		}
 
 		// Check tools for cache control
@@ -455,7 +466,7 @@ func TestHandleMetrics(t *testing.T) {
 
 	handler := NewAutocacheHandler(cfg, logger)
 
-	req := httptest.NewRequest("GET", "/metrics", nil)
+	req := httptest.NewRequest("GET", "/metrics?format=json", nil)
 	rr := httptest.NewRecorder()
 
 	handler.HandleMetrics(rr, req)
@@ -495,7 +506,17 @@ func TestHandleMetrics(t *testing.T) {
 }
 
 func TestSetupRoutes(t *testing.T) {
-	cfg := &config.Config{CacheStrategy: "moderate"}
+	// Stands in for Anthropic on passed-through routes
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{CacheStrategy: "moderate", AnthropicURL: mockServer.URL}
 	logger := logrus.New()
 	logger.SetLevel(logrus.ErrorLevel)
 
@@ -513,10 +534,12 @@ func TestSetupRoutes(t *testing.T) {
 		expectNotFound bool
 	}{
 		{"/health", http.StatusOK, false},
-		{"/", http.StatusOK, false}, // Root should serve health
+		{"/", http.StatusNotFound, true}, // Passed through; health is only served on /health
 		{"/metrics", http.StatusOK, false},
+		{"/metrics/json", http.StatusOK, false},
 		{"/v1/messages", http.StatusMethodNotAllowed, false}, // POST only
-		{"/nonexistent", http.StatusOK, false}, // Caught by "/" handler (serves health)
+		{"/v1/models", http.StatusOK, false},                 // Passed through to Anthropic
+		{"/nonexistent", http.StatusNotFound, true},          // Passed through, unknown upstream too
 	}
 
 	for _, tt := range testRoutes {
@@ -807,7 +830,7 @@ func TestPanicRecoveryInMetricsEndpoint(t *testing.T) {
 	wrapped.ServeHTTP(rr, req)
 
 	// Now check metrics endpoint
-	req = httptest.NewRequest("GET", "/metrics", nil)
+	req = httptest.NewRequest("GET", "/metrics?format=json", nil)
 	rr = httptest.NewRecorder()
 
 	handler.HandleMetrics(rr, req)
@@ -909,4 +932,758 @@ func TestPanicRecoveryLogging(t *testing.T) {
 			t.Errorf("Expected log to contain %q, log output: %s", expected, logStr)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// stripCacheControl removes every cache_control key from a decoded JSON value
+func stripCacheControl(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		delete(value, "cache_control")
+		for key, child := range value {
+			value[key] = stripCacheControl(child)
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = stripCacheControl(child)
+		}
+	}
+	return v
+}
+
+// collapseSystemBlock turns a single plain text system block back into the string form,
+// which is the only rewrite the proxy makes when it places a breakpoint on a string system prompt
+func collapseSystemBlock(v interface{}) interface{} {
+	request, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	blocks, ok := request["system"].([]interface{})
+	if !ok || len(blocks) != 1 {
+		return v
+	}
+	block, ok := blocks[0].(map[string]interface{})
+	if !ok || len(block) != 2 || block["type"] != "text" {
+		return v
+	}
+	if text, ok := block["text"].(string); ok {
+		request["system"] = text
+	}
+	return v
+}
+
+// decodeJSONForDiff decodes JSON keeping numbers exact so round trips can be compared
+func decodeJSONForDiff(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		t.Fatalf("Failed to decode JSON: %v", err)
+	}
+	return v
+}
+
+// TestForwardedRequestRoundTrip verifies the proxy only adds cache_control markers to what it forwards
+func TestForwardedRequestRoundTrip(t *testing.T) {
+	scenarioDirs, err := filepath.Glob("../../test_data/scenarios/*")
+	if err != nil || len(scenarioDirs) == 0 {
+		t.Skipf("Skipping test: scenario data not found: %v", err)
+	}
+
+	// Fields autocache does not model; they must survive the proxy untouched
+	unknownFields := map[string]interface{}{
+		"thinking":     map[string]interface{}{"type": "enabled", "budget_tokens": 1024},
+		"tool_choice":  map[string]interface{}{"type": "auto"},
+		"metadata":     map[string]interface{}{"user_id": "agent-7"},
+		"service_tier": "auto",
+	}
+
+	var forwarded []byte
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(types.AnthropicResponse{ID: "msg_roundtrip", Type: "message", Role: "assistant"})
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	for _, dir := range scenarioDirs {
+		inputData, err := os.ReadFile(filepath.Join(dir, "input.json"))
+		if err != nil {
+			continue
+		}
+
+		for _, withUnknown := range []bool{false, true} {
+			name := filepath.Base(dir)
+			if withUnknown {
+				name += "_with_unknown_fields"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				body := inputData
+				if withUnknown {
+					var input map[string]interface{}
+					if err := json.Unmarshal(inputData, &input); err != nil {
+						t.Fatalf("Failed to parse scenario input: %v", err)
+					}
+					for key, value := range unknownFields {
+						input[key] = value
+					}
+					body, _ = json.Marshal(input)
+				}
+
+				cfg := &config.Config{
+					AnthropicURL:    mockServer.URL,
+					AnthropicAPIKey: "sk-ant-test",
+					CacheStrategy:   "aggressive",
+					TokenizerMode:   "heuristic",
+				}
+				handler := NewAutocacheHandler(cfg, logger)
+
+				forwarded = nil
+				req := httptest.NewRequest("POST", "/v1/messages", bytes.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				handler.HandleMessages(w, req)
+
+				if forwarded == nil {
+					t.Fatalf("Request was not forwarded (status %d): %s", w.Code, w.Body.String())
+				}
+
+				expected := collapseSystemBlock(stripCacheControl(decodeJSONForDiff(t, body)))
+				actual := collapseSystemBlock(stripCacheControl(decodeJSONForDiff(t, forwarded)))
+				if !reflect.DeepEqual(expected, actual) {
+					t.Errorf("Forwarded request differs from input beyond cache_control:\ninput:     %s\nforwarded: %s", body, forwarded)
+				}
+			})
+		}
+	}
+}
+
+// TestSystemBreakpointOnTheWire verifies system breakpoints are serialized in the forwarded body
+func TestSystemBreakpointOnTheWire(t *testing.T) {
+	var forwarded []byte
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(types.AnthropicResponse{ID: "msg_system", Type: "message", Role: "assistant"})
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	largeSystem := strings.Repeat("You are a meticulous reviewer. ", 150)
+	tests := []struct {
+		name          string
+		system        interface{}
+		expectBlocks  int
+		expectMarkers []bool
+	}{
+		{
+			name:          "String system is converted to a marked block",
+			system:        largeSystem,
+			expectBlocks:  1,
+			expectMarkers: []bool{true},
+		},
+		{
+			name: "Array system gets a marker on its last block",
+			system: []map[string]interface{}{
+				{"type": "text", "text": "Header block."},
+				{"type": "text", "text": largeSystem},
+			},
+			expectBlocks:  2,
+			expectMarkers: []bool{false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				AnthropicURL:    mockServer.URL,
+				AnthropicAPIKey: "sk-ant-test",
+				CacheStrategy:   "moderate",
+				TokenizerMode:   "heuristic",
+			}
+			handler := NewAutocacheHandler(cfg, logger)
+
+			body, _ := json.Marshal(map[string]interface{}{
+				"model":      "claude-3-5-sonnet-20241022",
+				"max_tokens": 100,
+				"system":     tt.system,
+				"messages":   []map[string]interface{}{{"role": "user", "content": "Hi"}},
+			})
+
+			forwarded = nil
+			req := httptest.NewRequest("POST", "/v1/messages", bytes.NewReader(body))
+			w := httptest.NewRecorder()
+			handler.HandleMessages(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var wire struct {
+				System []struct {
+					Type         string              `json:"type"`
+					Text         string              `json:"text"`
+					CacheControl *types.CacheControl `json:"cache_control"`
+				} `json:"system"`
+			}
+			if err := json.Unmarshal(forwarded, &wire); err != nil {
+				t.Fatalf("Expected forwarded system to be an array of blocks: %v", err)
+			}
+
+			if len(wire.System) != tt.expectBlocks {
+				t.Fatalf("Expected %d system blocks, got %d", tt.expectBlocks, len(wire.System))
+			}
+			for i, block := range wire.System {
+				if (block.CacheControl != nil) != tt.expectMarkers[i] {
+					t.Errorf("System block %d: expected marker=%v, got %+v", i, tt.expectMarkers[i], block.CacheControl)
+				}
+				if block.CacheControl != nil && block.CacheControl.Type != "ephemeral" {
+					t.Errorf("Expected ephemeral cache control, got %s", block.CacheControl.Type)
+				}
+			}
+			if wire.System[len(wire.System)-1].Text != largeSystem {
+				t.Error("System text was changed while forwarding")
+			}
+		})
+	}
+}
+
+// TestStreamingRequestRecordsUsage verifies usage parsed from the event stream reaches the savings history
+func TestStreamingRequestRecordsUsage(t *testing.T) {
+	stream := "event: message_start\n" +
+		`data: {"type":"message_start","message":{"id":"msg_s","usage":{"input_tokens":20,"cache_creation_input_tokens":0,"cache_read_input_tokens":1500,"output_tokens":1}}}` + "\n\n" +
+		"event: message_delta\n" +
+		`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":30}}` + "\n\n" +
+		"event: message_stop\n" +
+		`data: {"type":"message_stop"}` + "\n\n"
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, stream)
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := &config.Config{
+		AnthropicURL:       mockServer.URL,
+		AnthropicAPIKey:    "sk-ant-test",
+		CacheStrategy:      "moderate",
+		TokenizerMode:      "heuristic",
+		SavingsHistorySize: 10,
+	}
+	handler := NewAutocacheHandler(cfg, logger)
+
+	body := `{"model":"claude-3-5-sonnet-20241022","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"Hi"}]}`
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.HandleMessages(w, req)
+
+	if w.Body.String() != stream {
+		t.Errorf("Expected stream to be forwarded unchanged, got:\n%s", w.Body.String())
+	}
+
+	entries, _ := handler.history.Query(history.Query{})
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 stored request, got %d", len(entries))
+	}
+
+	usage := entries[0].Usage
+	if usage == nil {
+		t.Fatal("Expected streaming usage to be stored in metadata")
+	}
+	if usage.CacheReadInputTokens != 1500 || usage.InputTokens != 20 || usage.OutputTokens != 30 {
+		t.Errorf("Unexpected stored usage: %+v", *usage)
+	}
+}
+
+func TestSavingsReportsRealizedUsage(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"msg_r","type":"message","role":"assistant","model":"claude-3-5-sonnet-20241022",`+
+			`"content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn",`+
+			`"usage":{"input_tokens":100,"output_tokens":10,"cache_read_input_tokens":900}}`)
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := &config.Config{
+		AnthropicURL:       mockServer.URL,
+		AnthropicAPIKey:    "sk-ant-test",
+		CacheStrategy:      "moderate",
+		TokenizerMode:      "heuristic",
+		SavingsHistorySize: 10,
+	}
+	handler := NewAutocacheHandler(cfg, logger)
+
+	body := `{"model":"claude-3-5-sonnet-20241022","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`
+	w := httptest.NewRecorder()
+	handler.HandleMessages(w, httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	entries, _ := handler.history.Query(history.Query{})
+	meta := entries[0]
+
+	if meta.ActualCost == nil {
+		t.Fatal("Expected actual cost to be recorded")
+	}
+	if meta.ActualCost.Savings <= 0 {
+		t.Errorf("Expected positive realized savings from cache reads, got %f", meta.ActualCost.Savings)
+	}
+	if meta.EstimationError == nil || meta.EstimationError.ActualInputTokens != 1000 {
+		t.Errorf("Expected estimation error against 1000 billed input tokens, got %+v", meta.EstimationError)
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleSavings(w, httptest.NewRequest("GET", "/savings", nil))
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse savings response: %v", err)
+	}
+
+	realized, ok := response["realized_stats"].(map[string]interface{})
+	if !ok {
+		t.Fatal("Expected realized_stats in savings response")
+	}
+	if realized["requests_with_usage"] != float64(1) {
+		t.Errorf("Expected 1 request with usage, got %v", realized["requests_with_usage"])
+	}
+	if realized["total_cache_read_tokens"] != float64(900) {
+		t.Errorf("Expected 900 cache read tokens, got %v", realized["total_cache_read_tokens"])
+	}
+	if _, ok := response["aggregated_stats"].(map[string]interface{})["total_savings_after_10_reqs"]; !ok {
+		t.Error("Expected projections to still be reported")
+	}
+}
+
+func TestHandleSavingsTimeRange(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := &config.Config{
+		AnthropicURL:       "http://localhost:0",
+		CacheStrategy:      "moderate",
+		TokenizerMode:      "heuristic",
+		SavingsHistorySize: 10,
+	}
+
+	store := history.NewMemoryStore(history.Retention{MaxEntries: 10})
+	handler := NewAutocacheHandlerWithStore(cfg, logger, store)
+
+	now := time.Now().UTC()
+	for _, age := range []time.Duration{72 * time.Hour, 36 * time.Hour, time.Hour} {
+		handler.storeRequestMetadata(&types.CacheMetadata{
+			TotalTokens: 100,
+			Model:       "claude-3-5-sonnet-20241022",
+			Timestamp:   now.Add(-age),
+		})
+	}
+
+	tests := []struct {
+		name         string
+		query        string
+		expectStatus int
+		expectTotal  int
+		expectRecent int
+	}{
+		{"All history", "", http.StatusOK, 3, 3},
+		{"Relative since", "?since=48h", http.StatusOK, 2, 2},
+		{"Absolute range", "?since=" + now.Add(-80*time.Hour).Format(time.RFC3339) + "&until=" + now.Add(-24*time.Hour).Format(time.RFC3339), http.StatusOK, 2, 2},
+		{"Limit only trims recent requests", "?limit=1", http.StatusOK, 3, 1},
+		{"Invalid since", "?since=yesterday", http.StatusBadRequest, 0, 0},
+		{"Invalid limit", "?limit=-1", http.StatusBadRequest, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.HandleSavings(w, httptest.NewRequest("GET", "/savings"+tt.query, nil))
+
+			if w.Code != tt.expectStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectStatus, w.Code, w.Body.String())
+			}
+			if tt.expectStatus != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				RecentRequests  []types.CacheMetadata  `json:"recent_requests"`
+				AggregatedStats map[string]interface{} `json:"aggregated_stats"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse savings response: %v", err)
+			}
+
+			if response.AggregatedStats["total_requests"] != float64(tt.expectTotal) {
+				t.Errorf("Expected %d total requests, got %v", tt.expectTotal, response.AggregatedStats["total_requests"])
+			}
+			if len(response.RecentRequests) != tt.expectRecent {
+				t.Errorf("Expected %d recent requests, got %d", tt.expectRecent, len(response.RecentRequests))
+			}
+		})
+	}
+}
+
+func TestHandleMetricsPrometheus(t *testing.T) {
+	mockServer := createMockAnthropicServer()
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := &config.Config{
+		AnthropicURL:       mockServer.URL,
+		AnthropicAPIKey:    "sk-ant-test",
+		CacheStrategy:      "moderate",
+		TokenizerMode:      "heuristic",
+		SavingsHistorySize: 10,
+	}
+	handler := NewAutocacheHandler(cfg, logger)
+
+	body := `{"model":"claude-3-5-sonnet-20241022","max_tokens":100,"system":"` + strings.Repeat("You are a helpful assistant. ", 300) + `","messages":[{"role":"user","content":"Hi"}]}`
+	w := httptest.NewRecorder()
+	handler.HandleMessages(w, httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// An unreachable upstream counts as a connection error
+	down := NewAutocacheHandler(&config.Config{
+		AnthropicURL:  "http://127.0.0.1:1",
+		CacheStrategy: "moderate",
+		TokenizerMode: "heuristic",
+	}, logger)
+	w = httptest.NewRecorder()
+	down.HandleMessages(w, httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body)))
+
+	rr := httptest.NewRecorder()
+	handler.HandleMetrics(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Expected Prometheus text content type, got %s", contentType)
+	}
+
+	output := rr.Body.String()
+	expected := []string{
+		"# TYPE autocache_requests_total counter",
+		`autocache_requests_total{model="claude-3-5-sonnet-20241022",strategy="moderate",status="200"} 1`,
+		`autocache_breakpoints_total{type="system"} 1`,
+		`autocache_cached_tokens_total{model="claude-3-5-sonnet-20241022"}`,
+		`autocache_billed_tokens_total{model="claude-3-5-sonnet-20241022",type="input"}`,
+		"# TYPE autocache_injection_duration_seconds histogram",
+		`autocache_injection_duration_seconds_count{strategy="moderate"} 1`,
+		`autocache_upstream_duration_seconds_bucket{model="claude-3-5-sonnet-20241022",streaming="false",le="+Inf"} 1`,
+		"autocache_http_panics_total 0",
+	}
+	for _, line := range expected {
+		if !strings.Contains(output, line) {
+			t.Errorf("Expected metrics output to contain %q", line)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	down.HandleMetrics(rr, httptest.NewRequest("GET", "/metrics", nil))
+	downOutput := rr.Body.String()
+	for _, line := range []string{
+		`autocache_upstream_errors_total{model="claude-3-5-sonnet-20241022",reason="connection"} 1`,
+		`autocache_requests_total{model="claude-3-5-sonnet-20241022",strategy="moderate",status="502"} 1`,
+	} {
+		if !strings.Contains(downOutput, line) {
+			t.Errorf("Expected metrics output to contain %q, got:\n%s", line, downOutput)
+		}
+	}
+}
+
+func TestHandleMetricsUnknownModelLabel(t *testing.T) {
+	mockServer := createMockAnthropicServer()
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	handler := NewAutocacheHandler(&config.Config{
+		AnthropicURL:    mockServer.URL,
+		AnthropicAPIKey: "sk-ant-test",
+		CacheStrategy:   "moderate",
+		TokenizerMode:   "heuristic",
+	}, logger)
+
+	for _, model := range []string{"made-up-model-1", "made-up-model-2"} {
+		body := `{"model":"` + model + `","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`
+		w := httptest.NewRecorder()
+		handler.HandleMessages(w, httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body)))
+	}
+
+	rr := httptest.NewRecorder()
+	handler.HandleMetrics(rr, httptest.NewRequest("GET", "/metrics", nil))
+	output := rr.Body.String()
+
+	if strings.Contains(output, "made-up-model") {
+		t.Errorf("Expected unknown models not to be used as labels, got:\n%s", output)
+	}
+	if !strings.Contains(output, `autocache_requests_total{model="other",strategy="moderate",status="200"} 2`) {
+		t.Errorf("Expected unknown models to be counted as other, got:\n%s", output)
+	}
+}
+
+func TestOAuthBearerPassthrough(t *testing.T) {
+	var seen http.Header
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(types.AnthropicResponse{ID: "msg_auth", Type: "message", Role: "assistant"})
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	const oauthToken = "sk-ant-oat01-test-token"
+	tests := []struct {
+		name         string
+		configKey    string
+		headers      map[string]string
+		body         string
+		expectBearer string
+		expectAPIKey string
+	}{
+		{
+			name:         "OAuth token on the caching path",
+			headers:      map[string]string{"Authorization": "Bearer " + oauthToken, "anthropic-beta": "oauth-2025-04-20"},
+			body:         `{"model":"claude-3-5-sonnet-20241022","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`,
+			expectBearer: oauthToken,
+		},
+		{
+			name:         "OAuth token on the streaming path",
+			headers:      map[string]string{"Authorization": "Bearer " + oauthToken, "anthropic-beta": "oauth-2025-04-20"},
+			body:         `{"model":"claude-3-5-sonnet-20241022","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"Hi"}]}`,
+			expectBearer: oauthToken,
+		},
+		{
+			name:         "OAuth token on the bypass path",
+			headers:      map[string]string{"Authorization": "Bearer " + oauthToken, "anthropic-beta": "oauth-2025-04-20", "X-Autocache-Bypass": "true"},
+			body:         `{"model":"claude-3-5-sonnet-20241022","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`,
+			expectBearer: oauthToken,
+		},
+		{
+			name:         "Configured OAuth token",
+			configKey:    oauthToken,
+			body:         `{"model":"claude-3-5-sonnet-20241022","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`,
+			expectBearer: oauthToken,
+		},
+		{
+			name:         "Configured API key",
+			configKey:    "sk-ant-api03-configured",
+			body:         `{"model":"claude-3-5-sonnet-20241022","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`,
+			expectAPIKey: "sk-ant-api03-configured",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				AnthropicURL:    mockServer.URL,
+				AnthropicAPIKey: tt.configKey,
+				CacheStrategy:   "moderate",
+				TokenizerMode:   "heuristic",
+			}
+			handler := NewAutocacheHandler(cfg, logger)
+
+			seen = nil
+			req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(tt.body))
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			handler.HandleMessages(w, req)
+
+			if seen == nil {
+				t.Fatalf("Request was not forwarded (status %d): %s", w.Code, w.Body.String())
+			}
+			expectAuthorization := ""
+			if tt.expectBearer != "" {
+				expectAuthorization = "Bearer " + tt.expectBearer
+			}
+			if got := seen.Get("Authorization"); got != expectAuthorization {
+				t.Errorf("Expected Authorization %q upstream, got %q", expectAuthorization, got)
+			}
+			if got := seen.Get("x-api-key"); got != tt.expectAPIKey {
+				t.Errorf("Expected x-api-key %q upstream, got %q", tt.expectAPIKey, got)
+			}
+			if beta := tt.headers["anthropic-beta"]; beta != "" && seen.Get("anthropic-beta") != beta {
+				t.Errorf("Expected anthropic-beta %q upstream, got %q", beta, seen.Get("anthropic-beta"))
+			}
+		})
+	}
+}
+
+func TestExtendedTTLBetaFlag(t *testing.T) {
+	var seen http.Header
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(types.AnthropicResponse{ID: "msg_beta", Type: "message", Role: "assistant"})
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := &config.Config{
+		AnthropicURL:    mockServer.URL,
+		AnthropicAPIKey: "sk-ant-api03-test",
+		CacheStrategy:   "moderate",
+		TokenizerMode:   "heuristic",
+	}
+
+	system := strings.Repeat("You are a meticulous assistant with a long list of instructions. ", 300)
+	tests := []struct {
+		name       string
+		model      string
+		system     string
+		clientBeta string
+		expectBeta []string
+	}{
+		{
+			name:       "1h system breakpoint adds the beta flag",
+			model:      "claude-3-5-sonnet-20241022",
+			system:     system,
+			expectBeta: []string{client.ExtendedCacheTTLBeta},
+		},
+		{
+			name:       "Client beta flags are kept",
+			model:      "claude-3-5-sonnet-20241022",
+			system:     system,
+			clientBeta: "oauth-2025-04-20",
+			expectBeta: []string{"oauth-2025-04-20", client.ExtendedCacheTTLBeta},
+		},
+		{
+			name:   "Models with 1h TTLs do not need it",
+			model:  "claude-sonnet-4-5-20250929",
+			system: system,
+		},
+		{
+			name:   "No 1h breakpoint, no beta flag",
+			model:  "claude-3-5-sonnet-20241022",
+			system: "Short system prompt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewAutocacheHandler(cfg, logger)
+			body, _ := json.Marshal(map[string]interface{}{
+				"model":      tt.model,
+				"max_tokens": 100,
+				"system":     tt.system,
+				"messages":   []map[string]string{{"role": "user", "content": "Hi"}},
+			})
+
+			seen = nil
+			req := httptest.NewRequest("POST", "/v1/messages", bytes.NewReader(body))
+			if tt.clientBeta != "" {
+				req.Header.Set("anthropic-beta", tt.clientBeta)
+			}
+			w := httptest.NewRecorder()
+			handler.HandleMessages(w, req)
+
+			if seen == nil {
+				t.Fatalf("Request was not forwarded (status %d): %s", w.Code, w.Body.String())
+			}
+			if got := seen.Values("anthropic-beta"); !reflect.DeepEqual(got, tt.expectBeta) {
+				t.Errorf("Expected anthropic-beta %q upstream, got %q", tt.expectBeta, got)
+			}
+		})
+	}
+}
+
+func TestUpstreamRetriesAndCircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	var attempts atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(529)
+			return
+		}
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, `{"type":"error","error":{"type":"api_error","message":"Internal server error"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"msg_1","type":"message","usage":{"input_tokens":10,"output_tokens":5}}`)
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	handler := NewAutocacheHandler(&config.Config{
+		AnthropicURL:            mockServer.URL,
+		CacheStrategy:           "moderate",
+		TokenizerMode:           "heuristic",
+		UpstreamMaxRetries:      1,
+		UpstreamRetryBaseDelay:  time.Millisecond,
+		UpstreamRetryMaxDelay:   10 * time.Millisecond,
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  time.Minute,
+	}, logger)
+	send := func(stream bool) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"model":"claude-3-5-sonnet-20241022","max_tokens":100,"stream":%v,"messages":[{"role":"user","content":"Hi"}]}`, stream)
+		w := httptest.NewRecorder()
+		handler.HandleMessages(w, httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body)))
+		return w
+	}
+
+	// An overloaded response is retried
+	w := send(false)
+	if w.Code != http.StatusOK || w.Header().Get("X-Autocache-Retries") != "1" {
+		t.Errorf("Expected success after a retry, got %d %v", w.Code, w.Header())
+	}
+
+	// Two failures open the breaker; the last response is relayed
+	failing.Store(true)
+	w = send(false)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("X-Autocache-Retries") != "1" {
+		t.Errorf("Expected the upstream error after a retry, got %d %v", w.Code, w.Header())
+	}
+
+	// Then requests fail fast, streaming or not
+	count := attempts.Load()
+	for _, stream := range []bool{false, true} {
+		w = send(stream)
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "60" {
+			t.Errorf("Expected 503 with Retry-After while the breaker is open (stream %v), got %d %v", stream, w.Code, w.Header())
+		}
+	}
+	if attempts.Load() != count {
+		t.Error("Expected no upstream call while the breaker is open")
+	}
+
+	rr := httptest.NewRecorder()
+	handler.HandleMetrics(rr, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`autocache_upstream_retries_total{model="claude-3-5-sonnet-20241022",reason="529"} 1`,
+		`autocache_upstream_retries_total{model="claude-3-5-sonnet-20241022",reason="500"} 1`,
+		`autocache_upstream_errors_total{model="claude-3-5-sonnet-20241022",reason="circuit_open"} 2`,
+		"autocache_circuit_breaker_open 1",
+		"autocache_circuit_breaker_rejections_total 2",
+	} {
+		if !strings.Contains(rr.Body.String(), line) {
+			t.Errorf("Expected metrics output to contain %q, got:\n%s", line, rr.Body.String())
+		}
+	}
+}
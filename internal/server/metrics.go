@@ -0,0 +1,192 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"autocache/internal/client"
+	"autocache/internal/metrics"
+	"autocache/internal/tokenizer"
+	"autocache/internal/types"
+)
+
+// proxyMetrics holds the Prometheus metrics exposed on /metrics
+type proxyMetrics struct {
+	registry *metrics.Registry
+
+	// models are the model names used as labels; others are counted as "other" so
+	// clients cannot create an unbounded number of series
+	models map[string]bool
+
+	requests        *metrics.CounterVec
+	tokens          *metrics.CounterVec
+	cachedTokens    *metrics.CounterVec
+	billedTokens    *metrics.CounterVec
+	breakpoints     *metrics.CounterVec
+	skipped         *metrics.CounterVec
+	upstreamErrors  *metrics.CounterVec
+	retries         *metrics.CounterVec
+	injectionTime   *metrics.HistogramVec
+	upstreamLatency *metrics.HistogramVec
+	countTokensDiff *metrics.HistogramVec
+}
+
+// countTokensErrorBuckets are histogram buckets for the relative error of local token counts
+var countTokensErrorBuckets = []float64{0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1}
+
+// newProxyMetrics registers the proxy metrics, including counters read from the handler at scrape time
+func newProxyMetrics(ah *AutocacheHandler) *proxyMetrics {
+	registry := metrics.NewRegistry()
+
+	models := make(map[string]bool)
+	for _, model := range ah.cacheInjector.GetPricing().GetSupportedModels() {
+		models[model] = true
+	}
+
+	pm := &proxyMetrics{
+		registry: registry,
+		models:   models,
+		requests: registry.NewCounterVec("autocache_requests_total",
+			"Messages requests handled, by model, cache strategy and response status.",
+			"model", "strategy", "status"),
+		tokens: registry.NewCounterVec("autocache_tokens_total",
+			"Estimated input tokens in processed requests.",
+			"model"),
+		cachedTokens: registry.NewCounterVec("autocache_cached_tokens_total",
+			"Estimated input tokens covered by injected cache breakpoints.",
+			"model"),
+		billedTokens: registry.NewCounterVec("autocache_billed_tokens_total",
+			"Tokens billed by Anthropic, by type (input, cache_creation, cache_read, output).",
+			"model", "type"),
+		breakpoints: registry.NewCounterVec("autocache_breakpoints_total",
+			"Cache breakpoints injected, by content type.",
+			"type"),
+		skipped: registry.NewCounterVec("autocache_breakpoints_skipped_total",
+			"Cache breakpoints not injected because their prefix is rarely reused before expiring, by content type.",
+			"type"),
+		upstreamErrors: registry.NewCounterVec("autocache_upstream_errors_total",
+			"Failed upstream calls, by model and reason (connection or HTTP status code).",
+			"model", "reason"),
+		retries: registry.NewCounterVec("autocache_upstream_retries_total",
+			"Upstream calls retried, by model and reason of the failed attempt (connection or HTTP status code).",
+			"model", "reason"),
+		injectionTime: registry.NewHistogramVec("autocache_injection_duration_seconds",
+			"Time spent analyzing requests and injecting cache control.",
+			metrics.DefaultLatencyBuckets, "strategy"),
+		upstreamLatency: registry.NewHistogramVec("autocache_upstream_duration_seconds",
+			"Time until the upstream response was fully relayed.",
+			metrics.DefaultLatencyBuckets, "model", "streaming"),
+		countTokensDiff: registry.NewHistogramVec("autocache_count_tokens_relative_error",
+			"Relative difference between the local and Anthropic's count_tokens result, in compare mode.",
+			countTokensErrorBuckets, "model", "tokenizer"),
+	}
+
+	registry.NewCounterFunc("autocache_http_panics_total",
+		"Panics recovered in HTTP handlers.",
+		func() float64 { return float64(ah.panicCount.Load()) })
+	registry.NewGaugeFunc("autocache_circuit_breaker_open",
+		"Whether the upstream circuit breaker is open (1) or closed (0).",
+		func() float64 {
+			if open, _ := ah.proxyClient.CircuitStats(); open {
+				return 1
+			}
+			return 0
+		})
+	registry.NewCounterFunc("autocache_circuit_breaker_rejections_total",
+		"Upstream calls rejected without being sent while the circuit breaker was open.",
+		func() float64 {
+			_, rejections := ah.proxyClient.CircuitStats()
+			return float64(rejections)
+		})
+	registry.NewCounterFunc("autocache_tokenizer_panics_total",
+		"Panics recovered in the offline tokenizer.",
+		func() float64 { return float64(tokenizerStat(ah, "panic_count")) })
+	registry.NewCounterFunc("autocache_tokenizer_fallbacks_total",
+		"Token counts that fell back to the heuristic tokenizer.",
+		func() float64 { return float64(tokenizerStat(ah, "fallback_count")) })
+
+	return pm
+}
+
+// tokenizerStat reads a panic statistic from the offline tokenizer, if it is the one in use
+func tokenizerStat(ah *AutocacheHandler, name string) uint64 {
+	if offlineTokenizer, ok := ah.cacheInjector.GetTokenizer().(*tokenizer.OfflineTokenizer); ok {
+		if stats := offlineTokenizer.GetPanicStats(); stats != nil {
+			return stats[name]
+		}
+	}
+	return 0
+}
+
+// modelLabel returns the label value for a model: "unknown" when the request has none,
+// "other" when it is not in the pricing table
+func (pm *proxyMetrics) modelLabel(model string) string {
+	switch {
+	case model == "":
+		return "unknown"
+	case !pm.models[model]:
+		return "other"
+	}
+	return model
+}
+
+// observeRequest counts a handled messages request
+func (pm *proxyMetrics) observeRequest(model, strategy string, status int) {
+	pm.requests.Inc(pm.modelLabel(model), strategy, strconv.Itoa(status))
+}
+
+// observeInjection records the time taken by cache injection
+func (pm *proxyMetrics) observeInjection(strategy string, duration time.Duration) {
+	pm.injectionTime.Observe(duration.Seconds(), strategy)
+}
+
+// observeUpstream records an upstream call. status is 0 when no response was received.
+func (pm *proxyMetrics) observeUpstream(model string, streaming bool, duration time.Duration, status int) {
+	model = pm.modelLabel(model)
+	pm.upstreamLatency.Observe(duration.Seconds(), model, strconv.FormatBool(streaming))
+
+	switch {
+	case status == 0:
+		pm.upstreamErrors.Inc(model, "connection")
+	case status >= 400:
+		pm.upstreamErrors.Inc(model, strconv.Itoa(status))
+	}
+}
+
+// observeUpstreamError records an upstream call that got no response, because the connection
+// failed or the circuit breaker rejected it
+func (pm *proxyMetrics) observeUpstreamError(model string, streaming bool, duration time.Duration, err error) {
+	var circuitOpen *client.CircuitOpenError
+	if errors.As(err, &circuitOpen) {
+		pm.upstreamErrors.Inc(pm.modelLabel(model), "circuit_open")
+		return
+	}
+	pm.observeUpstream(model, streaming, duration, 0)
+}
+
+// observeRetry counts a retried upstream call
+func (pm *proxyMetrics) observeRetry(model, reason string) {
+	pm.retries.Inc(pm.modelLabel(model), reason)
+}
+
+// observeMetadata counts tokens and breakpoints of a processed request
+func (pm *proxyMetrics) observeMetadata(metadata *types.CacheMetadata) {
+	model := pm.modelLabel(metadata.Model)
+	pm.tokens.Add(float64(metadata.TotalTokens), model)
+	pm.cachedTokens.Add(float64(metadata.CachedTokens), model)
+
+	for _, bp := range metadata.Breakpoints {
+		pm.breakpoints.Inc(bp.Type)
+	}
+	for _, bp := range metadata.SkippedBreakpoints {
+		pm.skipped.Inc(bp.Type)
+	}
+
+	if usage := metadata.Usage; usage != nil {
+		pm.billedTokens.Add(float64(usage.InputTokens), model, "input")
+		pm.billedTokens.Add(float64(usage.CacheCreationInputTokens), model, "cache_creation")
+		pm.billedTokens.Add(float64(usage.CacheReadInputTokens), model, "cache_read")
+		pm.billedTokens.Add(float64(usage.OutputTokens), model, "output")
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"autocache/internal/config"
+	"autocache/internal/types"
+)
+
+// Request headers that override the cache policy for a single call. The overrides in
+// effect are echoed under the same names in the response.
+const (
+	headerStrategy       = "X-Autocache-Strategy"
+	headerMaxBreakpoints = "X-Autocache-Max-Breakpoints"
+	headerTTLSystem      = "X-Autocache-TTL-System"
+	headerTTLTools       = "X-Autocache-TTL-Tools"
+	headerTTLContent     = "X-Autocache-TTL-Content"
+	headerMinTokens      = "X-Autocache-Min-Tokens"
+)
+
+// cachePolicy is the strategy used for one request
+type cachePolicy struct {
+	name      string
+	config    types.StrategyConfig
+	overrides http.Header // Override headers that were applied, as echoed in the response
+}
+
+// resolveCachePolicy returns the configured strategy with any per-request overrides
+// applied. Overrides are checked against the same rules as the configuration, and a minimum
+// token override against the minimum cacheable length of the request's models.
+func (ah *AutocacheHandler) resolveCachePolicy(r *http.Request, models ...string) (*cachePolicy, error) {
+	policy := &cachePolicy{
+		name:      ah.config.CacheStrategy,
+		config:    ah.cacheInjector.GetStrategyConfig(),
+		overrides: make(http.Header),
+	}
+
+	if name := strings.TrimSpace(r.Header.Get(headerStrategy)); name != "" {
+		strategy, ok := ah.config.ResolveStrategy(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s header: unknown cache strategy %s (must be one of: %s)",
+				headerStrategy, name, strings.Join(ah.config.StrategyNames(), ", "))
+		}
+		policy.name = name
+		policy.config = strategy
+		policy.overrides.Set(headerStrategy, name)
+	}
+
+	if value := strings.TrimSpace(r.Header.Get(headerMaxBreakpoints)); value != "" {
+		breakpoints, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header: %q is not an integer", headerMaxBreakpoints, value)
+		}
+		// The header cannot raise the operator's MAX_CACHE_BREAKPOINTS cap
+		limit := 4
+		if ah.config.MaxCacheBreakpoints > 0 && ah.config.MaxCacheBreakpoints < limit {
+			limit = ah.config.MaxCacheBreakpoints
+		}
+		if breakpoints < 1 || breakpoints > limit {
+			return nil, fmt.Errorf("invalid %s header: max cache breakpoints must be between 1 and %d, got: %d", headerMaxBreakpoints, limit, breakpoints)
+		}
+		policy.config.MaxBreakpoints = breakpoints
+		policy.overrides.Set(headerMaxBreakpoints, strconv.Itoa(breakpoints))
+	}
+
+	for _, override := range []struct {
+		header      string
+		contentType string
+		ttl         *string
+	}{
+		{headerTTLSystem, "system", &policy.config.SystemTTL},
+		{headerTTLTools, "tools", &policy.config.ToolsTTL},
+		{headerTTLContent, "content", &policy.config.ContentTTL},
+	} {
+		value := strings.TrimSpace(r.Header.Get(override.header))
+		if value == "" {
+			continue
+		}
+		if value != "5m" && value != "1h" {
+			return nil, fmt.Errorf("invalid %s header: TTL must be 5m or 1h, got: %q", override.header, value)
+		}
+		*override.ttl = value
+		// An explicit TTL is not second-guessed from arrival times; other types stay adaptive
+		policy.config.FixedTTLTypes = append(policy.config.FixedTTLTypes, override.contentType)
+		policy.overrides.Set(override.header, value)
+	}
+
+	if value := strings.TrimSpace(r.Header.Get(headerMinTokens)); value != "" {
+		minTokens, err := strconv.Atoi(value)
+		if err != nil || minTokens < 1 {
+			return nil, fmt.Errorf("invalid %s header: minimum tokens must be a positive integer, got: %q", headerMinTokens, value)
+		}
+		for _, model := range models {
+			if minimum := ah.cacheInjector.GetTokenizer().GetModelMinimumTokens(model); minTokens < minimum {
+				return nil, fmt.Errorf("invalid %s header: %d is below the minimum cacheable length of %s (%d tokens)", headerMinTokens, minTokens, model, minimum)
+			}
+		}
+		policy.config.MinTokens = minTokens
+		policy.overrides.Set(headerMinTokens, strconv.Itoa(minTokens))
+	}
+
+	if len(policy.overrides) > 0 {
+		if err := config.ValidateStrategy(policy.config); err != nil {
+			return nil, fmt.Errorf("invalid cache policy overrides: %w", err)
+		}
+	}
+
+	return policy, nil
+}
+
+// addPolicyHeaders echoes the overrides that were applied to the request
+func (ah *AutocacheHandler) addPolicyHeaders(w http.ResponseWriter, policy *cachePolicy) {
+	for header, values := range policy.overrides {
+		w.Header()[header] = values
+	}
+}
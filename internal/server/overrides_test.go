@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"autocache/internal/config"
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCachePolicyOverrides(t *testing.T) {
+	var forwarded []byte
+	var forwardedHeaders http.Header
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded, _ = io.ReadAll(r.Body)
+		forwardedHeaders = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(types.AnthropicResponse{ID: "msg_policy", Type: "message", Role: "assistant"})
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	// About 1250 tokens: below the conservative threshold (2 x 1024) unless overridden
+	system := strings.Repeat("You are a meticulous reviewer. ", 60)
+
+	tests := []struct {
+		name            string
+		headers         map[string]string
+		expectMarker    bool
+		expectTTL       string
+		expectStrategy  string
+		expectEchoed    map[string]string
+		expectNotEchoed []string
+	}{
+		{
+			name:            "No overrides uses the configured strategy",
+			expectMarker:    false,
+			expectStrategy:  "conservative",
+			expectNotEchoed: []string{headerMinTokens, headerTTLSystem},
+		},
+		{
+			name: "Minimum tokens and system TTL overrides",
+			headers: map[string]string{
+				headerMinTokens: "1024",
+				headerTTLSystem: "5m",
+			},
+			expectMarker:    true,
+			expectTTL:       "5m",
+			expectStrategy:  "conservative",
+			expectEchoed:    map[string]string{headerMinTokens: "1024", headerTTLSystem: "5m"},
+			expectNotEchoed: []string{headerMaxBreakpoints},
+		},
+		{
+			name: "Strategy override keeps its own TTLs",
+			headers: map[string]string{
+				headerStrategy:       "aggressive",
+				headerMaxBreakpoints: "2",
+				headerMinTokens:      "1100",
+			},
+			expectMarker:   true,
+			expectTTL:      "1h",
+			expectStrategy: "aggressive",
+			expectEchoed:   map[string]string{headerStrategy: "aggressive", headerMaxBreakpoints: "2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				AnthropicURL:    mockServer.URL,
+				AnthropicAPIKey: "sk-ant-test",
+				CacheStrategy:   "conservative",
+				TokenizerMode:   "heuristic",
+			}
+			handler := NewAutocacheHandler(cfg, logger)
+
+			body, _ := json.Marshal(map[string]interface{}{
+				"model":      "claude-3-5-sonnet-20241022",
+				"max_tokens": 100,
+				"system":     system,
+				"messages":   []map[string]interface{}{{"role": "user", "content": "Hi"}},
+			})
+
+			forwarded = nil
+			req := httptest.NewRequest("POST", "/v1/messages", bytes.NewReader(body))
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			handler.HandleMessages(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var wire struct {
+				System json.RawMessage `json:"system"`
+			}
+			if err := json.Unmarshal(forwarded, &wire); err != nil {
+				t.Fatalf("Failed to parse forwarded request: %v", err)
+			}
+
+			// An unmarked system prompt is forwarded as the original string
+			var blocks []struct {
+				CacheControl *types.CacheControl `json:"cache_control"`
+			}
+			var marker *types.CacheControl
+			if json.Unmarshal(wire.System, &blocks) == nil && len(blocks) > 0 {
+				marker = blocks[len(blocks)-1].CacheControl
+			}
+			if (marker != nil) != tt.expectMarker {
+				t.Fatalf("Expected system marker=%v, got %+v", tt.expectMarker, marker)
+			}
+			if marker != nil && marker.TTL != tt.expectTTL {
+				t.Errorf("Expected system TTL %s, got %s", tt.expectTTL, marker.TTL)
+			}
+
+			if got := w.Header().Get(headerStrategy); got != tt.expectStrategy {
+				t.Errorf("Expected strategy header %s, got %s", tt.expectStrategy, got)
+			}
+			for header, value := range tt.expectEchoed {
+				if got := w.Header().Get(header); got != value {
+					t.Errorf("Expected %s to be echoed as %s, got %q", header, value, got)
+				}
+			}
+			for _, header := range tt.expectNotEchoed {
+				if got := w.Header().Get(header); got != "" {
+					t.Errorf("Expected %s not to be echoed, got %q", header, got)
+				}
+			}
+
+			// Control headers stay on the proxy
+			for key := range forwardedHeaders {
+				if strings.HasPrefix(strings.ToLower(key), "x-autocache-") {
+					t.Errorf("Expected %s not to be forwarded upstream", key)
+				}
+			}
+		})
+	}
+}
+
+func TestCachePolicyOverridesInvalid(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	cfg := &config.Config{
+		AnthropicURL:    "http://127.0.0.1:0",
+		AnthropicAPIKey: "sk-ant-test",
+		CacheStrategy:   "moderate",
+		TokenizerMode:   "heuristic",
+	}
+	handler := NewAutocacheHandler(cfg, logger)
+
+	tests := []struct {
+		header        string
+		value         string
+		errorContains string
+	}{
+		{headerStrategy, "reckless", "unknown cache strategy reckless"},
+		{headerMaxBreakpoints, "5", "between 1 and 4"},
+		{headerMaxBreakpoints, "two", "not an integer"},
+		{headerTTLSystem, "2h", "TTL must be 5m or 1h"},
+		{headerTTLContent, "10m", "TTL must be 5m or 1h"},
+		{headerMinTokens, "0", "positive integer"},
+		{headerMinTokens, "many", "positive integer"},
+		{headerMinTokens, "512", "below the minimum cacheable length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header+"="+tt.value, func(t *testing.T) {
+			body := `{"model":"claude-3-5-sonnet-20241022","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`
+			req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body))
+			req.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+			handler.HandleMessages(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status 400, got %d", w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tt.header) || !strings.Contains(w.Body.String(), tt.errorContains) {
+				t.Errorf("Expected error naming %s and containing %q, got %s", tt.header, tt.errorContains, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCachePolicyMaxBreakpointsCap(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	cfg := &config.Config{
+		AnthropicURL:        "http://127.0.0.1:0",
+		AnthropicAPIKey:     "sk-ant-test",
+		CacheStrategy:       "moderate",
+		TokenizerMode:       "heuristic",
+		MaxCacheBreakpoints: 2,
+	}
+	handler := NewAutocacheHandler(cfg, logger)
+
+	req := httptest.NewRequest("POST", "/v1/messages", nil)
+	req.Header.Set(headerMaxBreakpoints, "2")
+	policy, err := handler.resolveCachePolicy(req)
+	if err != nil {
+		t.Fatalf("Expected a header at the configured cap to be accepted, got %v", err)
+	}
+	if policy.config.MaxBreakpoints != 2 {
+		t.Errorf("Expected 2 max breakpoints, got %d", policy.config.MaxBreakpoints)
+	}
+
+	req.Header.Set(headerMaxBreakpoints, "3")
+	if _, err := handler.resolveCachePolicy(req); err == nil || !strings.Contains(err.Error(), "between 1 and 2") {
+		t.Errorf("Expected a header above MAX_CACHE_BREAKPOINTS to be rejected, got %v", err)
+	}
+}
+
+func TestCachePolicyTTLOverridePinsOneType(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	cfg := &config.Config{
+		AnthropicURL:    "http://127.0.0.1:0",
+		AnthropicAPIKey: "sk-ant-test",
+		CacheStrategy:   "moderate",
+		TokenizerMode:   "heuristic",
+	}
+	handler := NewAutocacheHandler(cfg, logger)
+
+	req := httptest.NewRequest("POST", "/v1/messages", nil)
+	req.Header.Set(headerTTLContent, "1h")
+	policy, err := handler.resolveCachePolicy(req, "claude-3-5-sonnet-20241022")
+	if err != nil {
+		t.Fatalf("resolveCachePolicy failed: %v", err)
+	}
+
+	if policy.config.ContentTTL != "1h" || !policy.config.TTLFixed("content") {
+		t.Errorf("Expected the content TTL to be pinned at 1h, got %+v", policy.config)
+	}
+	// Types without a header keep adaptive and cold-start TTLs
+	for _, contentType := range []string{"system", "tools"} {
+		if policy.config.TTLFixed(contentType) {
+			t.Errorf("Expected the %s TTL to stay adaptive", contentType)
+		}
+	}
+}
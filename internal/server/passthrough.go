@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"autocache/internal/client"
+
+	"github.com/sirupsen/logrus"
+)
+
+// passthroughStrategy is the strategy label of requests forwarded without cache injection
+// because their endpoint has no prompt to cache, e.g. /v1/models or /v1/files
+const passthroughStrategy = "passthrough"
+
+// HandlePassthrough forwards requests for Anthropic endpoints autocache does not handle itself
+// (/v1/models, /v1/files, ...) to the same path upstream. Bodies are streamed in both
+// directions and headers are forwarded like on /v1/messages, with the request's credentials;
+// nothing is injected.
+func (ah *AutocacheHandler) HandlePassthrough(w http.ResponseWriter, r *http.Request) {
+	wrapper := &responseWrapper{ResponseWriter: w, statusCode: http.StatusOK}
+	w = wrapper
+	defer func() {
+		ah.metrics.observeRequest("", passthroughStrategy, wrapper.statusCode)
+	}()
+
+	headers := client.CreateHeadersMap(r.Header, ah.getCredentials(r), ah.logger)
+
+	upstreamStart := time.Now()
+	resp, err := ah.proxyClient.ForwardRaw(r, headers)
+	if ah.writeUnsupportedEndpoint(w, err) {
+		return
+	}
+	if err != nil {
+		ah.metrics.observeUpstreamError("", false, time.Since(upstreamStart), err)
+		ah.logger.WithError(err).WithField("path", r.URL.Path).Error("Failed to pass request through")
+		ah.writeUpstreamError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	// The body is relayed untouched, so Content-Encoding stays accurate
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	if err := copyFlushing(w, resp.Body); err != nil {
+		ah.logger.WithError(err).WithField("path", r.URL.Path).Error("Failed to relay passthrough response")
+	}
+	ah.metrics.observeUpstream("", false, time.Since(upstreamStart), resp.StatusCode)
+
+	ah.logger.WithFields(logrus.Fields{
+		"method":      r.Method,
+		"path":        r.URL.Path,
+		"status_code": resp.StatusCode,
+	}).Debug("Passed request through to Anthropic API")
+}
+
+// copyFlushing copies body to w, flushing after every read so streamed responses are not
+// held back
+func copyFlushing(w http.ResponseWriter, body io.Reader) error {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeUnsupportedEndpoint answers 501 when err is the upstream dialect not serving the
+// endpoint (Bedrock and Vertex AI only serve the Messages API), and reports whether it did
+func (ah *AutocacheHandler) writeUnsupportedEndpoint(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, client.ErrUnsupportedEndpoint) {
+		return false
+	}
+	ah.writeError(w, http.StatusNotImplemented, fmt.Sprintf("This endpoint is not available with the %s upstream dialect", ah.proxyClient.DialectName()))
+	return true
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"autocache/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHandlePassthrough(t *testing.T) {
+	type upstreamRequest struct {
+		method string
+		uri    string
+		body   string
+		header http.Header
+	}
+	var seen *upstreamRequest
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		seen = &upstreamRequest{method: r.Method, uri: r.URL.RequestURI(), body: string(body), header: r.Header.Clone()}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("X-Upstream", "one")
+		w.Header().Add("X-Upstream", "two")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, `{"upstream":"`+r.URL.Path+`"}`)
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	cfg := &config.Config{
+		AnthropicURL:    mockServer.URL,
+		AnthropicAPIKey: "sk-ant-api03-configured",
+		CacheStrategy:   "moderate",
+		TokenizerMode:   "heuristic",
+	}
+	mux := NewAutocacheHandler(cfg, logger).SetupRoutes()
+
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		body         string
+		headers      map[string]string
+		expectAPIKey string
+		expectBearer string
+	}{
+		{
+			name:         "count_tokens body is forwarded unchanged",
+			method:       "POST",
+			target:       "/v1/messages/count_tokens",
+			body:         `{"model":"claude-3-5-sonnet-20241022","system":"Be brief","messages":[{"role":"user","content":"Hi"}]}`,
+			headers:      map[string]string{"Content-Type": "application/json", "x-api-key": "sk-ant-api03-client"},
+			expectAPIKey: "sk-ant-api03-client",
+		},
+		{
+			name:         "Query strings are kept",
+			method:       "GET",
+			target:       "/v1/models?limit=5&after_id=claude-3",
+			expectAPIKey: "sk-ant-api03-configured",
+		},
+		{
+			name:         "Multipart uploads keep their content type",
+			method:       "POST",
+			target:       "/v1/files",
+			body:         "--boundary\r\nContent-Disposition: form-data; name=\"file\"\r\n\r\ndata\r\n--boundary--\r\n",
+			headers:      map[string]string{"Content-Type": "multipart/form-data; boundary=boundary", "anthropic-beta": "files-api-2025-04-14"},
+			expectAPIKey: "sk-ant-api03-configured",
+		},
+		{
+			name:         "OAuth tokens stay bearer tokens",
+			method:       "DELETE",
+			target:       "/v1/files/file_123",
+			headers:      map[string]string{"Authorization": "Bearer sk-ant-oat01-token"},
+			expectBearer: "sk-ant-oat01-token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			req.Header.Set("X-Autocache-Strategy", "aggressive")
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if seen == nil {
+				t.Fatalf("Request was not passed through (status %d): %s", w.Code, w.Body.String())
+			}
+			if seen.method != tt.method || seen.uri != tt.target {
+				t.Errorf("Expected %s %s upstream, got %s %s", tt.method, tt.target, seen.method, seen.uri)
+			}
+			if seen.body != tt.body {
+				t.Errorf("Expected body %q upstream, got %q", tt.body, seen.body)
+			}
+			for key, value := range tt.headers {
+				if strings.EqualFold(key, "x-api-key") || strings.EqualFold(key, "Authorization") {
+					continue
+				}
+				if got := seen.header.Get(key); got != value {
+					t.Errorf("Expected %s %q upstream, got %q", key, value, got)
+				}
+			}
+			if got := seen.header.Get("x-api-key"); got != tt.expectAPIKey {
+				t.Errorf("Expected x-api-key %q upstream, got %q", tt.expectAPIKey, got)
+			}
+			if got := seen.header.Get("Authorization"); tt.expectBearer != "" && got != "Bearer "+tt.expectBearer {
+				t.Errorf("Expected bearer %q upstream, got %q", tt.expectBearer, got)
+			}
+			if seen.header.Get("anthropic-version") == "" {
+				t.Error("Expected a default anthropic-version upstream")
+			}
+			if seen.header.Get("X-Autocache-Strategy") != "" {
+				t.Error("Expected X-Autocache-* headers not to be forwarded")
+			}
+
+			if w.Code != http.StatusCreated {
+				t.Errorf("Expected the upstream status 201, got %d", w.Code)
+			}
+			if got := w.Header().Values("X-Upstream"); strings.Join(got, ",") != "one,two" {
+				t.Errorf("Expected upstream response headers, got %q", got)
+			}
+			path := strings.SplitN(tt.target, "?", 2)[0]
+			if w.Body.String() != `{"upstream":"`+path+`"}` {
+				t.Errorf("Expected the upstream body, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandlePassthroughStreams(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for _, event := range []string{"event: one\n\n", "event: two\n\n"} {
+			_, _ = io.WriteString(w, event)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer mockServer.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	handler := NewAutocacheHandler(&config.Config{AnthropicURL: mockServer.URL, CacheStrategy: "moderate", TokenizerMode: "heuristic"}, logger)
+
+	w := httptest.NewRecorder()
+	handler.HandlePassthrough(w, httptest.NewRequest("GET", "/v1/messages/batches/msgbatch_1/results", nil))
+
+	if w.Body.String() != "event: one\n\nevent: two\n\n" {
+		t.Errorf("Expected the stream to be relayed, got %q", w.Body.String())
+	}
+	if !w.Flushed {
+		t.Error("Expected the stream to be flushed while relayed")
+	}
+}
+
+func TestHandlePassthroughUnreachable(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	handler := NewAutocacheHandler(&config.Config{AnthropicURL: "http://127.0.0.1:1", CacheStrategy: "moderate", TokenizerMode: "heuristic"}, logger)
+
+	w := httptest.NewRecorder()
+	handler.HandlePassthrough(w, httptest.NewRequest("GET", "/v1/models", nil))
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 when Anthropic is unreachable, got %d", w.Code)
+	}
+}
+
+func TestHandlePassthroughUnsupportedDialect(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	handler := NewAutocacheHandler(&config.Config{
+		AnthropicURL:    "http://127.0.0.1:1",
+		UpstreamDialect: "vertex",
+		VertexProjectID: "my-project",
+		VertexRegion:    "us-east5",
+		CacheStrategy:   "moderate",
+		TokenizerMode:   "heuristic",
+	}, logger)
+
+	w := httptest.NewRecorder()
+	handler.HandlePassthrough(w, httptest.NewRequest("GET", "/v1/models", nil))
+
+	if w.Code != http.StatusNotImplemented || !strings.Contains(w.Body.String(), "vertex upstream dialect") {
+		t.Errorf("Expected 501 on Vertex AI, got %d: %s", w.Code, w.Body.String())
+	}
+}
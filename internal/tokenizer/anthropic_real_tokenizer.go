@@ -1,7 +1,6 @@
 package tokenizer
 
 import (
-	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -83,9 +82,14 @@ func (art *AnthropicRealTokenizer) CountContentBlockTokens(block types.ContentBl
 	case "text":
 		total += art.CountTokens(block.Text)
 	case "image":
-		// Images have a fixed token cost
-		// Anthropic's image token calculation is complex, using conservative estimate
-		total += 85
+		// Images cost tokens by size, read from the image header when available
+		total += imageTokens(block.Source)
+	case "tool_use", "tool_result", "document":
+		text, nested := structuredBlockText(block)
+		total += art.CountTokens(text) + documentFileTokens(block)
+		for _, nestedBlock := range nested {
+			total += art.CountContentBlockTokens(nestedBlock)
+		}
 	}
 
 	return total
@@ -102,16 +106,9 @@ func (art *AnthropicRealTokenizer) CountToolTokens(tool types.ToolDefinition) in
 	total += art.CountTokens(tool.Name)
 	total += art.CountTokens(tool.Description)
 
-	// Input schema (serialize to JSON and count)
-	if tool.InputSchema != nil {
-		schemaJSON, err := json.Marshal(tool.InputSchema)
-		if err != nil {
-			// Fallback to string representation if marshal fails
-			schemaStr := fmt.Sprintf("%v", tool.InputSchema)
-			total += art.CountTokens(schemaStr)
-		} else {
-			total += art.CountTokens(string(schemaJSON))
-		}
+	// Input schema (as JSON)
+	if len(tool.InputSchema) > 0 {
+		total += art.CountTokens(jsonText(tool.InputSchema))
 	}
 
 	return total
@@ -0,0 +1,69 @@
+package tokenizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"autocache/internal/types"
+)
+
+// structuredBlockText returns the text a tool_use, tool_result or document block puts in the
+// prompt, together with any nested content blocks, which callers count with their own
+// CountContentBlockTokens. Other block types contribute nothing here.
+func structuredBlockText(block types.ContentBlock) (string, []types.ContentBlock) {
+	switch block.Type {
+	case "tool_use":
+		// The model sees the tool name and the JSON input it produced
+		return block.Name + " " + jsonText(block.Input), nil
+
+	case "tool_result":
+		// Content is either a string or an array of text/image/document blocks
+		return nestedContent(block.Content)
+
+	case "document":
+		var parts []string
+		for _, field := range []string{"title", "context"} {
+			if raw, ok := block.Extra[field]; ok {
+				var value string
+				if json.Unmarshal(raw, &value) == nil {
+					parts = append(parts, value)
+				}
+			}
+		}
+
+		var nested []types.ContentBlock
+		if source := block.Source; source != nil {
+			switch source.Type {
+			case "text":
+				parts = append(parts, source.Data)
+			case "content":
+				var text string
+				text, nested = nestedContent(source.Extra["content"])
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, "\n"), nested
+	}
+
+	return "", nil
+}
+
+// nestedContent splits tool_result or document content into plain text and content blocks
+func nestedContent(content json.RawMessage) (string, []types.ContentBlock) {
+	text, blocks, err := types.DecodeNestedContent(content)
+	if err != nil {
+		// Neither a string nor blocks: count the JSON as it is
+		return jsonText(content), nil
+	}
+	return text, blocks
+}
+
+// jsonText renders raw JSON compactly, as the model sees it
+func jsonText(value json.RawMessage) string {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, value); err != nil {
+		return string(value)
+	}
+	return compact.String()
+}
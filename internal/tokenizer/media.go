@@ -0,0 +1,143 @@
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	_ "image/gif"  // Registers GIF for image.DecodeConfig
+	_ "image/jpeg" // Registers JPEG for image.DecodeConfig
+	_ "image/png"  // Registers PNG for image.DecodeConfig
+	"io"
+	"math"
+	"regexp"
+	"strings"
+
+	"autocache/internal/types"
+)
+
+const (
+	// Anthropic downscales images whose long edge exceeds 1568px or whose area exceeds
+	// about 1.2 megapixels, preserving the aspect ratio. 784x1568 is the largest size in
+	// the documented table of images that are not resized.
+	maxImageLongEdge = 1568
+	maxImagePixels   = 784 * 1568
+
+	// pixelsPerImageToken is Anthropic's documented cost: tokens = width * height / 750
+	pixelsPerImageToken = 750
+
+	// defaultImageTokens is used when the dimensions are unknown (URL or file sources,
+	// unreadable data). Most screenshots and photos are downscaled to about this size.
+	defaultImageTokens = 1590
+
+	// pdfTokensPerPage estimates one PDF page: its extracted text (1,500-3,000 tokens
+	// for a typical page) plus the rendered page image
+	pdfTokensPerPage = 3000
+)
+
+// imageTokens estimates the tokens of an image from its dimensions, read from the
+// header of base64 PNG, JPEG, GIF or WebP data
+func imageTokens(source *types.ImageSource) int {
+	if source == nil || source.Type != "base64" || source.Data == "" {
+		return defaultImageTokens
+	}
+
+	width, height, ok := imageDimensions(source.Data)
+	if !ok {
+		return defaultImageTokens
+	}
+	return imageTokensForSize(width, height)
+}
+
+// imageTokensForSize applies Anthropic's resizing rules and per-pixel cost
+func imageTokensForSize(width, height int) int {
+	if width <= 0 || height <= 0 {
+		return defaultImageTokens
+	}
+
+	w, h := float64(width), float64(height)
+	if longEdge := math.Max(w, h); longEdge > maxImageLongEdge {
+		scale := maxImageLongEdge / longEdge
+		w, h = w*scale, h*scale
+	}
+	if area := w * h; area > maxImagePixels {
+		scale := math.Sqrt(maxImagePixels / area)
+		w, h = w*scale, h*scale
+	}
+
+	return int(math.Ceil(w * h / pixelsPerImageToken))
+}
+
+// imageDimensions decodes only as much of the base64 data as the image header needs
+func imageDimensions(data string) (int, int, bool) {
+	reader := bufio.NewReader(base64.NewDecoder(base64.StdEncoding, strings.NewReader(data)))
+
+	if header, err := reader.Peek(30); err == nil && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WEBP" {
+		return webpDimensions(header)
+	}
+
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return 0, 0, false
+	}
+	return config.Width, config.Height, true
+}
+
+// webpDimensions reads the canvas size from the first chunk of a WebP file (30 bytes)
+func webpDimensions(header []byte) (int, int, bool) {
+	switch string(header[12:16]) {
+	case "VP8 ": // Lossy: 14-bit sizes after the frame tag and start code
+		if !bytes.Equal(header[23:26], []byte{0x9d, 0x01, 0x2a}) {
+			return 0, 0, false
+		}
+		width := int(binary.LittleEndian.Uint16(header[26:28]) & 0x3fff)
+		height := int(binary.LittleEndian.Uint16(header[28:30]) & 0x3fff)
+		return width, height, true
+
+	case "VP8L": // Lossless: signature byte, then 14-bit width-1 and height-1
+		if header[20] != 0x2f {
+			return 0, 0, false
+		}
+		bits := binary.LittleEndian.Uint32(header[21:25])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, true
+
+	case "VP8X": // Extended: 24-bit canvas width-1 and height-1 after the flags
+		width := int(header[24]) | int(header[25])<<8 | int(header[26])<<16
+		height := int(header[27]) | int(header[28])<<8 | int(header[29])<<16
+		return width + 1, height + 1, true
+	}
+
+	return 0, 0, false
+}
+
+// pdfPagePattern matches page objects but not the /Pages tree nodes
+var pdfPagePattern = regexp.MustCompile(`/Type\s*/Page\b`)
+
+// documentFileTokens estimates the tokens of a document block with a PDF source (base64,
+// URL or file). Text and content sources are counted as text and return 0 here.
+func documentFileTokens(block types.ContentBlock) int {
+	if block.Type != "document" || block.Source == nil {
+		return 0
+	}
+
+	switch block.Source.Type {
+	case "text", "content":
+		return 0
+	case "base64":
+		return pdfPageCount(block.Source.Data) * pdfTokensPerPage
+	}
+
+	// URL and file sources: the page count is unknown, assume a single page
+	return pdfTokensPerPage
+}
+
+// pdfPageCount counts the page objects in base64 PDF data, at least one. Pages stored
+// in compressed object streams are not visible, so such files count as one page.
+func pdfPageCount(data string) int {
+	decoded, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, strings.NewReader(data)))
+	if err != nil {
+		return 1
+	}
+	return max(len(pdfPagePattern.FindAll(decoded, -1)), 1)
+}
@@ -0,0 +1,180 @@
+package tokenizer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+func encodeImage(t *testing.T, format string, width, height int) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("Failed to encode %s: %v", format, err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// webpHeader builds the first 30 bytes of a WebP file with the given first chunk payload
+func webpHeader(chunk string, payload []byte) string {
+	header := append([]byte("RIFF\x00\x00\x00\x00WEBP"+chunk+"\x00\x00\x00\x00"), payload...)
+	for len(header) < 30 {
+		header = append(header, 0)
+	}
+	return base64.StdEncoding.EncodeToString(header)
+}
+
+func TestImageTokensForSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		width    int
+		height   int
+		expected int
+	}{
+		{"small", 200, 200, 54},
+		{"documented square maximum", 1092, 1092, 1590},
+		{"documented 1:2 maximum", 784, 1568, 1640},
+		{"long edge downscaled", 3136, 200, 210},
+		{"area downscaled", 2000, 2000, 1640},
+		{"unknown size", 0, 0, defaultImageTokens},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := imageTokensForSize(tt.width, tt.height)
+			// Allow rounding differences from the downscaling arithmetic
+			if tokens < tt.expected-1 || tokens > tt.expected+1 {
+				t.Errorf("imageTokensForSize(%d, %d) = %d, expected %d", tt.width, tt.height, tokens, tt.expected)
+			}
+		})
+	}
+}
+
+func TestImageDimensions(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		width  int
+		height int
+		ok     bool
+	}{
+		{"png", encodeImage(t, "png", 640, 480), 640, 480, true},
+		{"jpeg", encodeImage(t, "jpeg", 320, 200), 320, 200, true},
+		{"gif", encodeImage(t, "gif", 64, 32), 64, 32, true},
+		{"webp lossy", webpHeader("VP8 ", []byte{0, 0, 0, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0xe0, 0x01}), 640, 480, true},
+		{"webp lossless", webpHeader("VP8L", []byte{0x2f, 0x7f, 0xc2, 0x77, 0x00}), 640, 480, true},
+		{"webp extended", webpHeader("VP8X", []byte{0, 0, 0, 0, 0x7f, 0x02, 0x00, 0xdf, 0x01, 0x00}), 640, 480, true},
+		{"not an image", base64.StdEncoding.EncodeToString([]byte("plain text, not an image at all")), 0, 0, false},
+		{"invalid base64", "!!!not base64!!!", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height, ok := imageDimensions(tt.data)
+			if ok != tt.ok || width != tt.width || height != tt.height {
+				t.Errorf("imageDimensions() = %d, %d, %v; expected %d, %d, %v", width, height, ok, tt.width, tt.height, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPdfPageCount(t *testing.T) {
+	pdf := "%PDF-1.4\n1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n" +
+		"2 0 obj << /Type /Pages /Kids [3 0 R 4 0 R 5 0 R] /Count 3 >> endobj\n" +
+		"3 0 obj << /Type /Page /Parent 2 0 R >> endobj\n" +
+		"4 0 obj << /Type/Page /Parent 2 0 R >> endobj\n" +
+		"5 0 obj << /Type /Page\n/Parent 2 0 R >> endobj\n%%EOF"
+
+	if pages := pdfPageCount(base64.StdEncoding.EncodeToString([]byte(pdf))); pages != 3 {
+		t.Errorf("Expected 3 pages, got %d", pages)
+	}
+	if pages := pdfPageCount("!!!"); pages != 1 {
+		t.Errorf("Expected undecodable data to count as one page, got %d", pages)
+	}
+}
+
+func TestCountMediaBlockTokens(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	tokenizers := map[string]Tokenizer{"heuristic": NewAnthropicTokenizer()}
+	if offline, err := NewOfflineTokenizerWithLogger(logger); err == nil {
+		tokenizers["offline"] = offline
+	}
+	if anthropic, err := NewAnthropicRealTokenizerWithLogger(logger); err == nil {
+		tokenizers["anthropic"] = anthropic
+	}
+
+	screenshot := types.ContentBlock{Type: "image", Source: &types.ImageSource{
+		Type: "base64", MediaType: "image/png", Data: encodeImage(t, "png", 1092, 1092),
+	}}
+	thumbnail := types.ContentBlock{Type: "image", Source: &types.ImageSource{
+		Type: "base64", MediaType: "image/png", Data: encodeImage(t, "png", 100, 100),
+	}}
+	pdf := "%PDF-1.4\n" + strings.Repeat("<< /Type /Page >>\n", 4)
+	document := types.ContentBlock{Type: "document", Source: &types.ImageSource{
+		Type: "base64", MediaType: "application/pdf", Data: base64.StdEncoding.EncodeToString([]byte(pdf)),
+	}}
+	nestedContent, _ := json.Marshal([]types.ContentBlock{screenshot})
+	nested := types.ContentBlock{Type: "tool_result", ToolUseID: "toolu_1", Content: nestedContent}
+
+	for name, tok := range tokenizers {
+		t.Run(name, func(t *testing.T) {
+			if tokens := tok.CountContentBlockTokens(screenshot); tokens < 1590 || tokens > 1600 {
+				t.Errorf("Expected about 1590 tokens for a 1092x1092 image, got %d", tokens)
+			}
+			if tokens := tok.CountContentBlockTokens(thumbnail); tokens < 14 || tokens > 20 {
+				t.Errorf("Expected about 14 tokens for a 100x100 image, got %d", tokens)
+			}
+			if tokens := tok.CountContentBlockTokens(document); tokens < 4*pdfTokensPerPage {
+				t.Errorf("Expected at least %d tokens for a 4-page PDF, got %d", 4*pdfTokensPerPage, tokens)
+			}
+			if tokens := tok.CountContentBlockTokens(nested); tokens < 1590 {
+				t.Errorf("Expected images nested in tool results to be counted, got %d", tokens)
+			}
+		})
+	}
+}
+
+func TestCountUnknownBlockTokens(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	tokenizers := map[string]Tokenizer{
+		"heuristic": NewAnthropicTokenizer(),
+		"real":      NewRealTokenizer("", "", logger),
+	}
+	if offline, err := NewOfflineTokenizerWithLogger(logger); err == nil {
+		tokenizers["offline"] = offline
+	}
+
+	thinking := types.ContentBlock{Type: "thinking"}
+	for name, tok := range tokenizers {
+		t.Run(name, func(t *testing.T) {
+			if tokens := tok.CountContentBlockTokens(thinking); tokens > 10 {
+				t.Errorf("Expected blocks of unknown types not to be counted as images, got %d tokens", tokens)
+			}
+		})
+	}
+}
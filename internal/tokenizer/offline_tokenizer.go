@@ -2,7 +2,6 @@ package tokenizer
 
 import (
 	_ "embed"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -203,8 +202,15 @@ func (ot *OfflineTokenizer) CountContentBlockTokens(block types.ContentBlock) in
 			return ot.CountTokens(block.Text) + 2 // Add structure overhead
 		}
 	case "image":
-		// Image tokens are approximately 85 per image (from Claude docs)
-		return 85
+		// Images cost tokens by size, read from the image header when available
+		return imageTokens(block.Source)
+	case "tool_use", "tool_result", "document":
+		text, nested := structuredBlockText(block)
+		total := ot.CountTokens(text) + documentFileTokens(block) + 2 // Add structure overhead
+		for _, nestedBlock := range nested {
+			total += ot.CountContentBlockTokens(nestedBlock)
+		}
+		return total
 	}
 	return 0
 }
@@ -224,11 +230,8 @@ func (ot *OfflineTokenizer) CountToolTokens(tool types.ToolDefinition) int {
 	}
 
 	// Count input schema (convert to JSON and count)
-	if tool.InputSchema != nil {
-		schemaJSON, err := json.Marshal(tool.InputSchema)
-		if err == nil {
-			total += ot.CountTokens(string(schemaJSON))
-		}
+	if len(tool.InputSchema) > 0 {
+		total += ot.CountTokens(jsonText(tool.InputSchema))
 	}
 
 	// Add overhead for tool structure (approximately 20 tokens)
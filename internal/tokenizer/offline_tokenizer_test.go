@@ -1,6 +1,7 @@
 package tokenizer
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
@@ -286,15 +287,7 @@ func TestOfflineTokenizerToolTokens(t *testing.T) {
 	tool := types.ToolDefinition{
 		Name:        "test_tool",
 		Description: "A test tool for validation",
-		InputSchema: map[string]interface{}{
-			"type": "object",
-			"properties": map[string]interface{}{
-				"param1": map[string]interface{}{
-					"type":        "string",
-					"description": "First parameter",
-				},
-			},
-		},
+		InputSchema: json.RawMessage(`{"type":"object","properties":{"param1":{"type":"string","description":"First parameter"}}}`),
 	}
 
 	tokens := tokenizer.CountToolTokens(tool)
@@ -203,8 +203,14 @@ func (rt *RealTokenizer) fallbackTokenCount(req TokenCountRequest) int {
 	// Count message tokens
 	for _, msg := range req.Messages {
 		for _, block := range msg.Content {
-			if block.Type == "text" {
+			switch block.Type {
+			case "text":
 				total += len(block.Text) / 4
+			case "tool_use", "tool_result", "document":
+				// Serialized size covers nested content blocks too
+				if data, err := json.Marshal(block); err == nil {
+					total += len(data) / 4
+				}
 			}
 		}
 		total += 5 // Message structure overhead
@@ -231,11 +237,22 @@ func (rt *RealTokenizer) CountMessageTokens(message types.Message) int {
 }
 
 func (rt *RealTokenizer) CountContentBlockTokens(block types.ContentBlock) int {
-	if block.Type == "text" {
+	switch block.Type {
+	case "text":
 		return rt.CountTokens(block.Text)
+	case "image":
+		// Images cost tokens by size, read from the image header when available
+		return imageTokens(block.Source)
+	case "tool_use", "tool_result", "document":
+		// Counted as text: a lone tool_result is not a valid request for the API
+		text, nested := structuredBlockText(block)
+		total := rt.CountTokens(text) + documentFileTokens(block)
+		for _, nestedBlock := range nested {
+			total += rt.CountContentBlockTokens(nestedBlock)
+		}
+		return total
 	}
-	// For images, use a conservative estimate
-	return 85
+	return 0
 }
 
 func (rt *RealTokenizer) CountToolTokens(tool types.ToolDefinition) int {
@@ -1,7 +1,6 @@
 package tokenizer
 
 import (
-	"fmt"
 	"regexp"
 	"strings"
 	"sync"
@@ -127,9 +126,14 @@ func (t *AnthropicTokenizer) CountContentBlockTokens(block types.ContentBlock) i
 	case "text":
 		total += t.CountTokens(block.Text)
 	case "image":
-		// Images have a fixed token cost based on size/resolution
-		// For now, we'll use a conservative estimate
-		total += 85 // Base cost for image processing
+		// Images cost tokens by size, read from the image header when available
+		total += imageTokens(block.Source)
+	case "tool_use", "tool_result", "document":
+		text, nested := structuredBlockText(block)
+		total += t.CountTokens(text) + documentFileTokens(block)
+		for _, nestedBlock := range nested {
+			total += t.CountContentBlockTokens(nestedBlock)
+		}
 	}
 
 	return total
@@ -147,10 +151,9 @@ func (t *AnthropicTokenizer) CountToolTokens(tool types.ToolDefinition) int {
 	total += t.CountTokens(tool.Description)
 
 	// Input schema (serialize and count)
-	if tool.InputSchema != nil {
+	if len(tool.InputSchema) > 0 {
 		// Rough estimate for JSON schema - this could be more precise
-		schemaStr := fmt.Sprintf("%v", tool.InputSchema)
-		total += t.CountTokens(schemaStr)
+		total += t.CountTokens(jsonText(tool.InputSchema))
 	}
 
 	return total
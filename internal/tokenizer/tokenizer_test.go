@@ -1,10 +1,13 @@
 package tokenizer
 
 import (
+	"encoding/json"
 	"strings"
 	"testing"
 
 	"autocache/internal/types"
+
+	"github.com/sirupsen/logrus"
 )
 
 func TestNewAnthropicTokenizer(t *testing.T) {
@@ -162,15 +165,7 @@ func TestCountToolTokens(t *testing.T) {
 	tool := types.ToolDefinition{
 		Name:        "get_weather",
 		Description: "Get the current weather for a location",
-		InputSchema: map[string]interface{}{
-			"type": "object",
-			"properties": map[string]interface{}{
-				"location": map[string]interface{}{
-					"type":        "string",
-					"description": "The city and state",
-				},
-			},
-		},
+		InputSchema: json.RawMessage(`{"type":"object","properties":{"location":{"type":"string","description":"The city and state"}}}`),
 	}
 
 	tokens := tok.CountToolTokens(tool)
@@ -323,3 +318,55 @@ func TestGetTokenCountForCaching(t *testing.T) {
 		})
 	}
 }
+
+func TestCountStructuredBlockTokens(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+
+	tokenizers := map[string]Tokenizer{"heuristic": NewAnthropicTokenizer()}
+	if offline, err := NewOfflineTokenizerWithLogger(logger); err == nil {
+		tokenizers["offline"] = offline
+	}
+	if anthropic, err := NewAnthropicRealTokenizerWithLogger(logger); err == nil {
+		tokenizers["anthropic"] = anthropic
+	}
+
+	payload := strings.Repeat("The build failed because the integration tests timed out. ", 20)
+
+	var documentSource types.ImageSource
+	if err := json.Unmarshal([]byte(`{"type": "content", "content": [{"type": "text", "text": "`+payload+`"}]}`), &documentSource); err != nil {
+		t.Fatalf("Failed to parse document source: %v", err)
+	}
+
+	raw := func(v interface{}) json.RawMessage {
+		data, _ := json.Marshal(v)
+		return data
+	}
+	blocks := map[string]types.ContentBlock{
+		"tool_result string": {Type: "tool_result", ToolUseID: "toolu_1", Content: raw(payload)},
+		"tool_result blocks": {Type: "tool_result", ToolUseID: "toolu_1", Content: raw([]types.ContentBlock{
+			{Type: "text", Text: payload},
+		})},
+		"tool_use":         {Type: "tool_use", ID: "toolu_1", Name: "report", Input: raw(map[string]string{"summary": payload})},
+		"document text":    {Type: "document", Source: &types.ImageSource{Type: "text", MediaType: "text/plain", Data: payload}},
+		"document content": {Type: "document", Source: &documentSource},
+	}
+
+	for tokenizerName, tok := range tokenizers {
+		for blockName, block := range blocks {
+			t.Run(tokenizerName+"/"+blockName, func(t *testing.T) {
+				// Close to the count of the payload itself, plus structure
+				textTokens := tok.CountTokens(payload)
+				tokens := tok.CountContentBlockTokens(block)
+				if tokens < textTokens || tokens > textTokens*3/2 {
+					t.Errorf("Expected roughly %d tokens, got %d", textTokens, tokens)
+				}
+			})
+		}
+	}
+
+	empty := types.ContentBlock{Type: "tool_result", ToolUseID: "toolu_1"}
+	if tokens := NewAnthropicTokenizer().CountContentBlockTokens(empty); tokens != 2 {
+		t.Errorf("Expected only block overhead for an empty tool_result, got %d", tokens)
+	}
+}
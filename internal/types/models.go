@@ -2,13 +2,14 @@ package types
 
 import (
 	"encoding/json"
+	"slices"
 	"time"
 )
 
 // CacheControl represents the cache control configuration
 type CacheControl struct {
-	Type string `json:"type"` // "ephemeral"
-	TTL  string `json:"ttl"`  // "5m" or "1h"
+	Type string `json:"type"`          // "ephemeral"
+	TTL  string `json:"ttl,omitempty"` // "5m" or "1h"
 }
 
 // ContentBlock represents a content block in a message
@@ -18,28 +19,127 @@ type ContentBlock struct {
 	Source       *ImageSource  `json:"source,omitempty"`
 	CacheControl *CacheControl `json:"cache_control,omitempty"`
 
-	// For tool_use blocks (assistant messages)
-	ID    string      `json:"id,omitempty"`
-	Name  string      `json:"name,omitempty"`
-	Input interface{} `json:"input,omitempty"`
+	// For tool_use blocks (assistant messages). The input is kept as sent, so numbers and key
+	// order survive re-encoding.
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
 
-	// For tool_result blocks (user messages)
-	ToolUseID string      `json:"tool_use_id,omitempty"`
-	Content   interface{} `json:"content,omitempty"`
-	IsError   *bool       `json:"is_error,omitempty"`
+	// For tool_result blocks (user messages): a string or an array of content blocks, kept as
+	// sent and decoded with NestedContent when needed
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	IsError   *bool           `json:"is_error,omitempty"`
+
+	// Fields not modeled above (citations, thinking signatures, ...), forwarded untouched
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON implements custom unmarshaling for ContentBlock to keep unknown fields
+func (b *ContentBlock) UnmarshalJSON(data []byte) error {
+	type Alias ContentBlock
+	if err := json.Unmarshal(data, (*Alias)(b)); err != nil {
+		return err
+	}
+
+	extra, err := collectUnknownFields(data, contentBlockFields)
+	if err != nil {
+		return err
+	}
+	b.Extra = extra
+
+	return nil
+}
+
+// MarshalJSON implements custom marshaling for ContentBlock to restore unknown fields
+func (b ContentBlock) MarshalJSON() ([]byte, error) {
+	type Alias ContentBlock
+	data, err := json.Marshal(Alias(b))
+	if err != nil {
+		return nil, err
+	}
+	return appendUnknownFields(data, b.Extra)
+}
+
+// NestedContent decodes tool_result content: the text of the string form, or the blocks of
+// the array form
+func (b ContentBlock) NestedContent() (string, []ContentBlock, error) {
+	return DecodeNestedContent(b.Content)
+}
+
+// SetNestedBlocks replaces tool_result content with the array form of blocks
+func (b *ContentBlock) SetNestedBlocks(blocks []ContentBlock) error {
+	data, err := json.Marshal(blocks)
+	if err != nil {
+		return err
+	}
+	b.Content = data
+	return nil
+}
+
+// DecodeNestedContent decodes content that is either a string or an array of content blocks,
+// as in tool_result blocks and content document sources
+func DecodeNestedContent(content json.RawMessage) (string, []ContentBlock, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return "", nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text, nil, nil
+	}
+	var blocks []ContentBlock
+	if err := json.Unmarshal(content, &blocks); err != nil {
+		return "", nil, err
+	}
+	return "", blocks, nil
 }
 
 // ImageSource represents an image source
 type ImageSource struct {
 	Type      string `json:"type"`
-	MediaType string `json:"media_type"`
-	Data      string `json:"data"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+
+	// Fields not modeled above (url, file_id, ...), forwarded untouched
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON implements custom unmarshaling for ImageSource to keep unknown fields
+func (s *ImageSource) UnmarshalJSON(data []byte) error {
+	type Alias ImageSource
+	if err := json.Unmarshal(data, (*Alias)(s)); err != nil {
+		return err
+	}
+
+	extra, err := collectUnknownFields(data, imageSourceFields)
+	if err != nil {
+		return err
+	}
+	s.Extra = extra
+
+	return nil
+}
+
+// MarshalJSON implements custom marshaling for ImageSource to restore unknown fields
+func (s ImageSource) MarshalJSON() ([]byte, error) {
+	type Alias ImageSource
+	data, err := json.Marshal(Alias(s))
+	if err != nil {
+		return nil, err
+	}
+	return appendUnknownFields(data, s.Extra)
 }
 
 // Message represents a message in the conversation
 type Message struct {
 	Role    string         `json:"role"`
 	Content []ContentBlock `json:"content"`
+
+	// Fields not modeled above, forwarded untouched
+	Extra map[string]json.RawMessage `json:"-"`
+
+	// stringContent records that content arrived in the string shorthand format
+	stringContent bool
 }
 
 // UnmarshalJSON implements custom unmarshaling for Message to support both string and array content formats
@@ -57,6 +157,12 @@ func (m *Message) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	extra, err := collectUnknownFields(data, messageFields)
+	if err != nil {
+		return err
+	}
+	m.Extra = extra
+
 	// Check if content is a string (shorthand format)
 	var contentStr string
 	if err := json.Unmarshal(aux.Content, &contentStr); err == nil {
@@ -67,6 +173,7 @@ func (m *Message) UnmarshalJSON(data []byte) error {
 				Text: contentStr,
 			},
 		}
+		m.stringContent = true
 		return nil
 	}
 
@@ -76,16 +183,80 @@ func (m *Message) UnmarshalJSON(data []byte) error {
 		return err
 	}
 	m.Content = contentBlocks
+	m.stringContent = false
 
 	return nil
 }
 
+// MarshalJSON implements custom marshaling for Message. Content that arrived as a string is
+// written back as a string unless a cache_control marker has been added to it since.
+func (m Message) MarshalJSON() ([]byte, error) {
+	type Alias Message
+
+	var data []byte
+	var err error
+	if m.hasStringContent() {
+		data, err = json.Marshal(struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		}{
+			Role:    m.Role,
+			Content: m.Content[0].Text,
+		})
+	} else {
+		data, err = json.Marshal(Alias(m))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return appendUnknownFields(data, m.Extra)
+}
+
+// hasStringContent reports whether the message can still be written in the string shorthand format
+func (m Message) hasStringContent() bool {
+	if !m.stringContent || len(m.Content) != 1 {
+		return false
+	}
+	block := m.Content[0]
+	return block.Type == "text" && block.CacheControl == nil && len(block.Extra) == 0
+}
+
 // ToolDefinition represents a tool definition
 type ToolDefinition struct {
-	Name         string        `json:"name"`
-	Description  string        `json:"description"`
-	InputSchema  interface{}   `json:"input_schema"`
-	CacheControl *CacheControl `json:"cache_control,omitempty"`
+	Name         string          `json:"name"`
+	Description  string          `json:"description,omitempty"`
+	InputSchema  json.RawMessage `json:"input_schema,omitempty"` // Kept as sent: key order and numbers survive proxying
+	CacheControl *CacheControl   `json:"cache_control,omitempty"`
+
+	// Fields not modeled above (type, max_uses, display_width_px, ...), forwarded untouched
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON implements custom unmarshaling for ToolDefinition to keep unknown fields
+func (t *ToolDefinition) UnmarshalJSON(data []byte) error {
+	type Alias ToolDefinition
+	if err := json.Unmarshal(data, (*Alias)(t)); err != nil {
+		return err
+	}
+
+	extra, err := collectUnknownFields(data, toolDefinitionFields)
+	if err != nil {
+		return err
+	}
+	t.Extra = extra
+
+	return nil
+}
+
+// MarshalJSON implements custom marshaling for ToolDefinition to restore unknown fields
+func (t ToolDefinition) MarshalJSON() ([]byte, error) {
+	type Alias ToolDefinition
+	data, err := json.Marshal(Alias(t))
+	if err != nil {
+		return nil, err
+	}
+	return appendUnknownFields(data, t.Extra)
 }
 
 // AnthropicRequest represents the complete request to Anthropic API
@@ -94,13 +265,103 @@ type AnthropicRequest struct {
 	MaxTokens     int              `json:"max_tokens"`
 	Messages      []Message        `json:"messages"`
 	System        string           `json:"system,omitempty"`
-	SystemBlocks  []ContentBlock   `json:"-"` // Handle system blocks through custom parsing
+	SystemBlocks  []ContentBlock   `json:"-"` // Array-form "system", see UnmarshalJSON/MarshalJSON
 	Tools         []ToolDefinition `json:"tools,omitempty"`
 	Temperature   *float64         `json:"temperature,omitempty"`
 	TopP          *float64         `json:"top_p,omitempty"`
 	TopK          *int             `json:"top_k,omitempty"`
 	Stream        *bool            `json:"stream,omitempty"`
 	StopSequences []string         `json:"stop_sequences,omitempty"`
+
+	// Fields not modeled above (thinking, tool_choice, metadata, service_tier, ...).
+	// They are kept as raw JSON so the proxy forwards them exactly as the client sent them.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON implements custom unmarshaling for AnthropicRequest.
+// "system" may be a string (System) or an array of text blocks (SystemBlocks),
+// and fields that are not modeled are kept in Extra.
+func (r *AnthropicRequest) UnmarshalJSON(data []byte) error {
+	type Alias AnthropicRequest
+	aux := &struct {
+		System json.RawMessage `json:"system,omitempty"`
+		*Alias
+	}{
+		Alias: (*Alias)(r),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	r.System = ""
+	r.SystemBlocks = nil
+	if len(aux.System) > 0 && string(aux.System) != "null" {
+		var systemStr string
+		if err := json.Unmarshal(aux.System, &systemStr); err == nil {
+			r.System = systemStr
+		} else {
+			var systemBlocks []ContentBlock
+			if err := json.Unmarshal(aux.System, &systemBlocks); err != nil {
+				return err
+			}
+			r.SystemBlocks = systemBlocks
+		}
+	}
+
+	extra, err := collectUnknownFields(data, anthropicRequestFields)
+	if err != nil {
+		return err
+	}
+	r.Extra = extra
+
+	return nil
+}
+
+// MarshalJSON implements custom marshaling for AnthropicRequest.
+// SystemBlocks take precedence over System, and unknown fields are restored from Extra.
+func (r AnthropicRequest) MarshalJSON() ([]byte, error) {
+	type Alias AnthropicRequest
+	aux := struct {
+		System interface{} `json:"system,omitempty"`
+		Alias
+	}{
+		Alias: Alias(r),
+	}
+
+	if len(r.SystemBlocks) > 0 {
+		aux.System = r.SystemBlocks
+	} else if r.System != "" {
+		aux.System = r.System
+	}
+
+	data, err := json.Marshal(aux)
+	if err != nil {
+		return nil, err
+	}
+	return appendUnknownFields(data, r.Extra)
+}
+
+// ConvertSystemToBlocks turns a string system prompt into a single text block so a
+// cache_control marker can be attached to it. It is a no-op when there is no string system.
+func (r *AnthropicRequest) ConvertSystemToBlocks() {
+	if r.System == "" {
+		return
+	}
+	r.SystemBlocks = append([]ContentBlock{{Type: "text", Text: r.System}}, r.SystemBlocks...)
+	r.System = ""
+}
+
+// BatchRequest is the body of a Message Batches create request (POST /v1/messages/batches)
+type BatchRequest struct {
+	Requests []BatchRequestItem `json:"requests"`
+}
+
+// BatchRequestItem is one request of a batch: a Messages API request and the ID its result is
+// reported under
+type BatchRequestItem struct {
+	CustomID string           `json:"custom_id"`
+	Params   AnthropicRequest `json:"params"`
 }
 
 // AnthropicResponse represents the response from Anthropic API
@@ -117,21 +378,41 @@ type AnthropicResponse struct {
 
 // Usage represents token usage information
 type Usage struct {
-	InputTokens              int `json:"input_tokens"`
-	OutputTokens             int `json:"output_tokens"`
-	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
-	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+	InputTokens              int            `json:"input_tokens"`
+	OutputTokens             int            `json:"output_tokens"`
+	CacheCreationInputTokens int            `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int            `json:"cache_read_input_tokens,omitempty"`
+	CacheCreation            *CacheCreation `json:"cache_creation,omitempty"` // Cache writes split by TTL, when reported
+}
+
+// CacheCreation breaks cache write tokens down by TTL
+type CacheCreation struct {
+	Ephemeral5mInputTokens int `json:"ephemeral_5m_input_tokens"`
+	Ephemeral1hInputTokens int `json:"ephemeral_1h_input_tokens"`
+}
+
+// TotalInputTokens returns all input tokens billed, whether uncached, written to or read from cache
+func (u Usage) TotalInputTokens() int {
+	return u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
 }
 
 // CacheBreakpoint represents a cache breakpoint decision
 type CacheBreakpoint struct {
-	Position    string    `json:"position"`     // "system", "tools", "message_0_block_1"
-	Tokens      int       `json:"tokens"`       // Number of tokens cached
-	TTL         string    `json:"ttl"`          // "5m" or "1h"
-	Type        string    `json:"type"`         // "system", "tools", "content"
-	WritePrice  float64   `json:"write_price"`  // Cost to write this cache
-	ReadSavings float64   `json:"read_savings"` // Savings per read
+	Position    string    `json:"position"`                    // "system", "tools", "message_0_block_1"
+	Tokens      int       `json:"tokens"`                      // Tokens of the segment since the previous breakpoint
+	Cumulative  int       `json:"cumulative_tokens,omitempty"` // Tokens from the start of the prompt up to this breakpoint
+	TTL         string    `json:"ttl"`                         // "5m" or "1h"
+	Type        string    `json:"type"`                        // "system", "tools", "content"
+	WritePrice  float64   `json:"write_price"`                 // Cost to write this cache
+	ReadSavings float64   `json:"read_savings"`                // Savings per read
 	Timestamp   time.Time `json:"timestamp"`
+
+	// Cross-request history of this prefix, when prefix tracking is enabled
+	Warm            bool     `json:"warm,omitempty"`              // Expected to be a cache read rather than a write
+	ObservedHitRate *float64 `json:"observed_hit_rate,omitempty"` // Share of sightings reused within the TTL
+
+	// Expected cost per request of the segment under each TTL ("5m", "1h"), from observed inter-arrival times
+	ExpectedCost map[string]float64 `json:"expected_cost,omitempty"`
 }
 
 // ROIMetrics represents return on investment calculations
@@ -147,19 +428,99 @@ type ROIMetrics struct {
 	PercentSavings       float64 `json:"percent_savings"`         // Percentage savings at scale
 }
 
+// ActualCost represents the cost of a request computed from the usage Anthropic billed
+type ActualCost struct {
+	InputCost      float64 `json:"input_cost"`       // Uncached input tokens
+	CacheWriteCost float64 `json:"cache_write_cost"` // Tokens written to cache
+	CacheReadCost  float64 `json:"cache_read_cost"`  // Tokens read from cache
+	OutputCost     float64 `json:"output_cost"`      // Output tokens
+	TotalCost      float64 `json:"total_cost"`       // Everything above
+	UncachedCost   float64 `json:"uncached_cost"`    // Same tokens billed without any caching
+	Savings        float64 `json:"savings"`          // UncachedCost - TotalCost (negative while writes are not amortized)
+}
+
+// EstimationError compares the tokenizer's prediction with the input tokens Anthropic billed
+type EstimationError struct {
+	PredictedInputTokens int     `json:"predicted_input_tokens"`
+	ActualInputTokens    int     `json:"actual_input_tokens"`
+	AbsoluteError        int     `json:"absolute_error"` // Predicted - actual
+	RelativeError        float64 `json:"relative_error"` // (Predicted - actual) / actual
+}
+
+// NewEstimationError builds an EstimationError from a prediction and the billed usage
+func NewEstimationError(predicted int, usage Usage) EstimationError {
+	actual := usage.TotalInputTokens()
+	relative := 0.0
+	if actual > 0 {
+		relative = float64(predicted-actual) / float64(actual)
+	}
+
+	return EstimationError{
+		PredictedInputTokens: predicted,
+		ActualInputTokens:    actual,
+		AbsoluteError:        predicted - actual,
+		RelativeError:        relative,
+	}
+}
+
 // CacheMetadata represents metadata about caching decisions
 type CacheMetadata struct {
-	CacheInjected bool               `json:"cache_injected"`
-	TotalTokens   int                `json:"total_tokens"`
-	CachedTokens  int                `json:"cached_tokens"`
-	CacheRatio    float64            `json:"cache_ratio"` // Percentage of tokens cached
-	Breakpoints   []CacheBreakpoint  `json:"breakpoints"`
-	ROI           ROIMetrics         `json:"roi"`
-	Strategy      string             `json:"strategy"` // "aggressive", "moderate", "conservative"
-	Model         string             `json:"model"`
-	Timestamp     time.Time          `json:"timestamp"`
+	CacheInjected bool              `json:"cache_injected"`
+	TotalTokens   int               `json:"total_tokens"`
+	CachedTokens  int               `json:"cached_tokens"`
+	CacheRatio    float64           `json:"cache_ratio"` // Percentage of tokens cached
+	Breakpoints   []CacheBreakpoint `json:"breakpoints"`
+	ROI           ROIMetrics        `json:"roi"`
+	Strategy      string            `json:"strategy"` // "aggressive", "moderate", "conservative", "conversation"
+	Model         string            `json:"model"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Usage         *Usage            `json:"usage,omitempty"` // Usage billed by Anthropic, when the response reported it
+
+	// custom_id of the item, for requests sent through the Message Batches API
+	BatchCustomID string `json:"batch_custom_id,omitempty"`
+
+	// Candidates not marked because their prefix is rarely reused before the cache expires
+	SkippedBreakpoints []CacheBreakpoint `json:"skipped_breakpoints,omitempty"`
+
+	// cache_control markers the client set itself, and how they were handled
+	ExistingBreakpoints []CacheBreakpoint `json:"existing_breakpoints,omitempty"`
+	ClientCacheControl  string            `json:"client_cache_control,omitempty"` // "respect", "augment" or "override"
+
+	// Adjustments made so the breakpoints follow Anthropic's TTL ordering and lookback rules
+	PlacementDecisions []PlacementDecision `json:"placement_decisions,omitempty"`
+
+	// Realized figures, available once Usage is known
+	ActualCost      *ActualCost      `json:"actual_cost,omitempty"`
+	EstimationError *EstimationError `json:"estimation_error,omitempty"`
+}
+
+// PlacementDecision records one adjustment made to comply with Anthropic's placement rules
+type PlacementDecision struct {
+	Position string `json:"position"`
+	Rule     string `json:"rule"`   // "ttl_order" or "lookback"
+	Action   string `json:"action"` // "ttl_downgraded", "ttl_upgraded", "breakpoint_added" or "unreachable"
+	Detail   string `json:"detail"`
 }
 
+// ClientCacheControlMode decides what happens when a request already carries cache_control markers
+type ClientCacheControlMode string
+
+const (
+	ClientCacheControlRespect  ClientCacheControlMode = "respect"  // Leave the request untouched
+	ClientCacheControlAugment  ClientCacheControlMode = "augment"  // Keep them and fill the remaining breakpoints
+	ClientCacheControlOverride ClientCacheControlMode = "override" // Remove them and plan from scratch
+)
+
+// TTLColdStart decides the TTL of a prefix whose inter-arrival times are not known yet
+type TTLColdStart string
+
+const (
+	TTLColdStartStrategy  TTLColdStart = "strategy"  // The strategy's TTL for the content type
+	TTLColdStartHeuristic TTLColdStart = "heuristic" // 1h for long message text that reads like instructions, else the strategy's TTL
+	TTLColdStart5m        TTLColdStart = "5m"
+	TTLColdStart1h        TTLColdStart = "1h"
+)
+
 // CacheStrategy represents different caching strategies
 type CacheStrategy string
 
@@ -167,18 +528,67 @@ const (
 	StrategyConservative CacheStrategy = "conservative"
 	StrategyModerate     CacheStrategy = "moderate"
 	StrategyAggressive   CacheStrategy = "aggressive"
+	StrategyConversation CacheStrategy = "conversation"
+)
+
+// Breakpoint placements
+const (
+	PlacementBlocks       = "blocks"       // Large individual blocks, in render order
+	PlacementConversation = "conversation" // Stable head plus the latest and previous user turns
 )
 
 // StrategyConfig represents configuration for each strategy
 type StrategyConfig struct {
-	MaxBreakpoints      int      `json:"max_breakpoints"`
-	MinTokensMultiplier float64  `json:"min_tokens_multiplier"` // Multiplier for base minimum tokens
-	SystemTTL           string   `json:"system_ttl"`
-	ToolsTTL            string   `json:"tools_ttl"`
-	ContentTTL          string   `json:"content_ttl"`
-	Priority            []string `json:"priority"` // Order of content types to prioritize
+	MaxBreakpoints      int          `json:"max_breakpoints" yaml:"max_breakpoints"`
+	MinTokensMultiplier float64      `json:"min_tokens_multiplier" yaml:"min_tokens_multiplier"` // Multiplier for base minimum tokens
+	MinTokens           int          `json:"min_tokens,omitempty" yaml:"min_tokens"`             // Absolute minimum, replaces the scaled model minimum when set
+	SystemTTL           string       `json:"system_ttl" yaml:"system_ttl"`
+	ToolsTTL            string       `json:"tools_ttl" yaml:"tools_ttl"`
+	ContentTTL          string       `json:"content_ttl" yaml:"content_ttl"`
+	Priority            []string     `json:"priority" yaml:"priority"`             // Order of content types to prioritize
+	Placement           string       `json:"placement,omitempty" yaml:"placement"` // "blocks" (default) or "conversation"
+	Weights             ScoreWeights `json:"weights" yaml:"weights"`               // Multipliers on the expected reads of each content type
+	FixedTTL            bool         `json:"fixed_ttl,omitempty" yaml:"fixed_ttl"` // Keep the TTLs above instead of choosing them from observed inter-arrival times
+	FixedTTLTypes       []string     `json:"-" yaml:"-"`                           // Content types whose TTL alone is kept, e.g. set by a request header
+}
+
+// TTL returns the configured TTL of a content type ("system", "tools" or "content")
+func (sc StrategyConfig) TTL(contentType string) string {
+	switch contentType {
+	case "system":
+		return sc.SystemTTL
+	case "tools":
+		return sc.ToolsTTL
+	}
+	return sc.ContentTTL
+}
+
+// TTLFixed reports whether the configured TTL of a content type is kept instead of being
+// chosen from observed inter-arrival times
+func (sc StrategyConfig) TTLFixed(contentType string) bool {
+	return sc.FixedTTL || slices.Contains(sc.FixedTTLTypes, contentType)
+}
+
+// ScoreWeights are the multipliers applied to the expected reads of a segment, by the content
+// type it ends on, when breakpoints are selected. A weight of 0 turns its factor off.
+type ScoreWeights struct {
+	System  float64 `json:"system" yaml:"system"`   // System prompts
+	Tools   float64 `json:"tools" yaml:"tools"`     // Tool definitions
+	Content float64 `json:"content" yaml:"content"` // Message content
 }
 
+// DefaultScoreWeights returns the weights used by the built-in strategies
+func DefaultScoreWeights() ScoreWeights {
+	return ScoreWeights{
+		System:  2.0, // Highest priority - very stable
+		Tools:   1.5, // High priority - fairly stable
+		Content: 1.0,
+	}
+}
+
+// BuiltinStrategies lists the strategies available without a strategies file
+var BuiltinStrategies = []CacheStrategy{StrategyConservative, StrategyModerate, StrategyAggressive, StrategyConversation}
+
 // GetStrategyConfig returns configuration for a given strategy
 func GetStrategyConfig(strategy CacheStrategy) StrategyConfig {
 	configs := map[CacheStrategy]StrategyConfig{
@@ -204,10 +614,28 @@ func GetStrategyConfig(strategy CacheStrategy) StrategyConfig {
 			SystemTTL:           "1h",
 			ToolsTTL:            "1h",
 			ContentTTL:          "5m",
-			Priority:            []string{"system", "tools", "content", "large_content"},
+			Priority:            []string{"system", "tools", "content"},
 		},
+		StrategyConversation: {
+			MaxBreakpoints:      4,
+			MinTokensMultiplier: 1.0, // Thresholds apply to the cumulative prefix, not single blocks
+			SystemTTL:           "1h",
+			ToolsTTL:            "1h",
+			ContentTTL:          "5m",
+			Priority:            []string{"system", "tools", "content"},
+			Placement:           PlacementConversation,
+		},
+	}
+
+	config, ok := configs[strategy]
+	if !ok {
+		return StrategyConfig{}
+	}
+	if config.Placement == "" {
+		config.Placement = PlacementBlocks
 	}
-	return configs[strategy]
+	config.Weights = DefaultScoreWeights()
+	return config
 }
 
 // ToHeaderValue converts a struct to a compact string for headers
@@ -2,6 +2,8 @@ package types
 
 import (
 	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -234,3 +236,202 @@ func TestMessageMarshalJSON_OutputsArray(t *testing.T) {
 		t.Errorf("Expected 1 content block in output, got %d", len(content))
 	}
 }
+
+func TestAnthropicRequest_PreservesUnknownFields(t *testing.T) {
+	jsonData := []byte(`{
+		"model": "claude-sonnet-4-5-20250929",
+		"max_tokens": 2048,
+		"thinking": {"type": "enabled", "budget_tokens": 1024},
+		"tool_choice": {"type": "auto", "disable_parallel_tool_use": true},
+		"metadata": {"user_id": "agent-42"},
+		"service_tier": "standard_only",
+		"tools": [
+			{"type": "web_search_20250305", "name": "web_search", "max_uses": 5}
+		],
+		"messages": [
+			{
+				"role": "user",
+				"content": [
+					{
+						"type": "document",
+						"source": {"type": "url", "url": "https://example.com/spec.pdf"},
+						"citations": {"enabled": true}
+					},
+					{"type": "text", "text": "Summarize this"}
+				]
+			}
+		]
+	}`)
+
+	var req AnthropicRequest
+	if err := json.Unmarshal(jsonData, &req); err != nil {
+		t.Fatalf("Failed to unmarshal request: %v", err)
+	}
+
+	for _, key := range []string{"thinking", "tool_choice", "metadata", "service_tier"} {
+		if _, ok := req.Extra[key]; !ok {
+			t.Errorf("Expected unknown field %q to be kept in Extra", key)
+		}
+	}
+
+	data, err := json.Marshal(&req)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	var expected, actual interface{}
+	if err := json.Unmarshal(jsonData, &expected); err != nil {
+		t.Fatalf("Failed to parse input: %v", err)
+	}
+	if err := json.Unmarshal(data, &actual); err != nil {
+		t.Fatalf("Failed to parse output: %v", err)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Round trip changed the request:\ninput:  %s\noutput: %s", jsonData, data)
+	}
+}
+
+func TestAnthropicRequest_UnknownFieldsKeepRawBytes(t *testing.T) {
+	jsonData := []byte(`{"model":"claude-3-5-sonnet-20241022","max_tokens":10,"messages":[],"metadata":{"user_id":"u-1","n":12345678901234567890},` +
+		`"tools":[{"name":"lookup","input_schema":{"type":"object","required":["q"],"properties":{"q":{"type":"string"},"limit":{"type":"integer","maximum":1.0e3}}}}]}`)
+
+	var req AnthropicRequest
+	if err := json.Unmarshal(jsonData, &req); err != nil {
+		t.Fatalf("Failed to unmarshal request: %v", err)
+	}
+
+	data, err := json.Marshal(&req)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	// Large integers must not be rewritten through float64
+	if !strings.Contains(string(data), `"metadata":{"user_id":"u-1","n":12345678901234567890}`) {
+		t.Errorf("Expected metadata bytes to be forwarded unchanged, got %s", data)
+	}
+
+	// Tool schemas keep their key order and number formatting
+	if !strings.Contains(string(data), `"input_schema":{"type":"object","required":["q"],"properties":{"q":{"type":"string"},"limit":{"type":"integer","maximum":1.0e3}}}`) {
+		t.Errorf("Expected the tool schema bytes to be forwarded unchanged, got %s", data)
+	}
+}
+
+func TestContentBlock_ToolPayloadsKeepRawBytes(t *testing.T) {
+	jsonData := []byte(`{"role":"user","content":[` +
+		`{"type":"tool_use","id":"toolu_1","name":"lookup","input":{"z":1.50,"a":12345678901234567890}},` +
+		`{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"ok","cache_control":{"type":"ephemeral"}}]}]}`)
+
+	var msg Message
+	if err := json.Unmarshal(jsonData, &msg); err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+
+	data, err := json.Marshal(&msg)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+
+	// Key order, number formatting and large integers survive
+	if !strings.Contains(string(data), `"input":{"z":1.50,"a":12345678901234567890}`) {
+		t.Errorf("Expected the tool input bytes to be forwarded unchanged, got %s", data)
+	}
+
+	// Nested content decodes into blocks
+	text, blocks, err := msg.Content[1].NestedContent()
+	if err != nil || text != "" || len(blocks) != 1 || blocks[0].CacheControl == nil {
+		t.Errorf("Expected the nested block with its marker, got %q %+v: %v", text, blocks, err)
+	}
+}
+
+func TestMessageMarshalJSON_KeepsStringShorthand(t *testing.T) {
+	var msg Message
+	if err := json.Unmarshal([]byte(`{"role":"user","content":"Hello"}`), &msg); err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	if string(data) != `{"role":"user","content":"Hello"}` {
+		t.Errorf("Expected string content to be preserved, got %s", data)
+	}
+
+	// Once a cache marker is added the block form is required
+	msg.Content[0].CacheControl = &CacheControl{Type: "ephemeral", TTL: "5m"}
+	data, err = json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	if !strings.Contains(string(data), `"content":[{"type":"text","text":"Hello","cache_control":{"type":"ephemeral","ttl":"5m"}}]`) {
+		t.Errorf("Expected array content with cache_control, got %s", data)
+	}
+}
+
+func TestToolDefinition_ServerToolRoundTrip(t *testing.T) {
+	jsonData := `{"name":"computer","type":"computer_20250124","display_width_px":1024,"display_height_px":768}`
+
+	var tool ToolDefinition
+	if err := json.Unmarshal([]byte(jsonData), &tool); err != nil {
+		t.Fatalf("Failed to unmarshal tool: %v", err)
+	}
+
+	data, err := json.Marshal(tool)
+	if err != nil {
+		t.Fatalf("Failed to marshal tool: %v", err)
+	}
+
+	// No description or input_schema should be invented for server tools
+	expected := `{"name":"computer","display_height_px":768,"display_width_px":1024,"type":"computer_20250124"}`
+	if string(data) != expected {
+		t.Errorf("Expected %s, got %s", expected, data)
+	}
+}
+
+func TestAnthropicRequest_SystemFormats(t *testing.T) {
+	var stringReq AnthropicRequest
+	if err := json.Unmarshal([]byte(`{"model":"m","max_tokens":1,"messages":[],"system":"Be brief."}`), &stringReq); err != nil {
+		t.Fatalf("Failed to unmarshal string system: %v", err)
+	}
+	if stringReq.System != "Be brief." || len(stringReq.SystemBlocks) != 0 {
+		t.Errorf("Expected string system, got System=%q SystemBlocks=%d", stringReq.System, len(stringReq.SystemBlocks))
+	}
+
+	var blocksReq AnthropicRequest
+	body := `{"model":"m","max_tokens":1,"messages":[],"system":[{"type":"text","text":"A"},{"type":"text","text":"B","cache_control":{"type":"ephemeral"}}]}`
+	if err := json.Unmarshal([]byte(body), &blocksReq); err != nil {
+		t.Fatalf("Failed to unmarshal array system: %v", err)
+	}
+	if blocksReq.System != "" || len(blocksReq.SystemBlocks) != 2 {
+		t.Fatalf("Expected 2 system blocks, got System=%q SystemBlocks=%d", blocksReq.System, len(blocksReq.SystemBlocks))
+	}
+	if blocksReq.SystemBlocks[1].CacheControl == nil {
+		t.Error("Expected client cache_control on the second system block to be parsed")
+	}
+
+	data, err := json.Marshal(&blocksReq)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	if !strings.Contains(string(data), `"system":[{"type":"text","text":"A"},{"type":"text","text":"B","cache_control":{"type":"ephemeral"}}]`) {
+		t.Errorf("Expected system blocks to be serialized as an array, got %s", data)
+	}
+}
+
+func TestAnthropicRequest_ConvertSystemToBlocks(t *testing.T) {
+	req := AnthropicRequest{Model: "m", MaxTokens: 1, System: "Be brief."}
+	req.ConvertSystemToBlocks()
+
+	if req.System != "" || len(req.SystemBlocks) != 1 || req.SystemBlocks[0].Text != "Be brief." {
+		t.Fatalf("Unexpected conversion result: System=%q SystemBlocks=%+v", req.System, req.SystemBlocks)
+	}
+
+	data, err := json.Marshal(&req)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	if !strings.Contains(string(data), `"system":[{"type":"text","text":"Be brief."}]`) {
+		t.Errorf("Expected converted system to be serialized as blocks, got %s", data)
+	}
+}
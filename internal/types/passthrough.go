@@ -0,0 +1,100 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Field sets for the types that carry unknown JSON fields through a round trip
+var (
+	anthropicRequestFields = jsonFieldNames(reflect.TypeOf(AnthropicRequest{}))
+	messageFields          = jsonFieldNames(reflect.TypeOf(Message{}))
+	contentBlockFields     = jsonFieldNames(reflect.TypeOf(ContentBlock{}))
+	imageSourceFields      = jsonFieldNames(reflect.TypeOf(ImageSource{}))
+	toolDefinitionFields   = jsonFieldNames(reflect.TypeOf(ToolDefinition{}))
+)
+
+// jsonFieldNames returns the JSON keys handled by the exported fields of a struct type
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = true
+	}
+	return fields
+}
+
+// collectUnknownFields returns the raw values of every key in a JSON object that is not in known.
+// It returns nil when the object has no unknown keys.
+func collectUnknownFields(data []byte, known map[string]bool) (map[string]json.RawMessage, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	var extra map[string]json.RawMessage
+	for key, value := range raw {
+		if known[key] {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]json.RawMessage)
+		}
+		extra[key] = value
+	}
+
+	return extra, nil
+}
+
+// appendUnknownFields appends the raw unknown fields to a marshaled JSON object.
+// Values are written exactly as they were received and keys are sorted so output is deterministic.
+func appendUnknownFields(data []byte, extra map[string]json.RawMessage) ([]byte, error) {
+	if len(extra) == 0 {
+		return data, nil
+	}
+
+	keys := make([]string, 0, len(extra))
+	for key := range extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	trimmed := bytes.TrimSpace(data)
+	empty := len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) == 0
+
+	var buf bytes.Buffer
+	buf.Write(trimmed[:len(trimmed)-1])
+	for _, key := range keys {
+		if !empty {
+			buf.WriteByte(',')
+		}
+		empty = false
+
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		buf.Write(extra[key])
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}